@@ -19,6 +19,7 @@ const (
 	joinCommand            = "join"
 	membersCommand         = "members"
 	membersFilteredCommand = "members-filtered"
+	membersWaitCommand     = "members-wait"
 	streamCommand          = "stream"
 	stopCommand            = "stop"
 	monitorCommand         = "monitor"
@@ -27,12 +28,16 @@ const (
 	useKeyCommand          = "use-key"
 	removeKeyCommand       = "remove-key"
 	listKeysCommand        = "list-keys"
+	rotateKeyCommand       = "rotate-key"
+	repairKeysCommand      = "repair-keys"
 	tagsCommand            = "tags"
 	queryCommand           = "query"
 	respondCommand         = "respond"
 	authCommand            = "auth"
 	statsCommand           = "stats"
 	getCoordinateCommand   = "get-coordinate"
+	checksCommand          = "checks"
+	maintCommand           = "maint"
 )
 
 const (
@@ -46,6 +51,7 @@ const (
 	invalidQueryID        = "No pending queries matching ID"
 	authRequired          = "Authentication required"
 	invalidAuthToken      = "Invalid authentication token"
+	permissionDenied      = "Permission denied"
 )
 
 const (
@@ -83,6 +89,10 @@ type coordinateResponse struct {
 	Ok    bool
 }
 
+type checksResponse struct {
+	Checks []Check
+}
+
 type eventRequest struct {
 	Name     string
 	Payload  []byte
@@ -113,16 +123,43 @@ type membersResponse struct {
 	Members []Member
 }
 
+type membersWaitRequest struct {
+	Index   uint64
+	Timeout time.Duration
+}
+
+type membersWaitResponse struct {
+	Members []Member
+	Index   uint64
+}
+
 type keyRequest struct {
 	Key string
 }
 
 type keyResponse struct {
-	Messages map[string]string
-	Keys     map[string]int
-	NumNodes int
-	NumErr   int
-	NumResp  int
+	Messages        map[string]string
+	Keys            map[string]int
+	PrimaryKeys     map[string]int
+	NodeKeys        map[string][]string
+	NodePrimaryKeys map[string]string
+	NumNodes        int
+	NumErr          int
+	NumResp         int
+}
+
+// serfResponse converts the response into a serf.KeyResponse
+func (r *keyResponse) serfResponse() *serf.KeyResponse {
+	return &serf.KeyResponse{
+		Messages:        r.Messages,
+		NumNodes:        r.NumNodes,
+		NumResp:         r.NumResp,
+		NumErr:          r.NumErr,
+		Keys:            r.Keys,
+		PrimaryKeys:     r.PrimaryKeys,
+		NodeKeys:        r.NodeKeys,
+		NodePrimaryKeys: r.NodePrimaryKeys,
+	}
 }
 
 type monitorRequest struct {
@@ -150,6 +187,13 @@ type queryRequest struct {
 	Timeout     time.Duration
 	Name        string
 	Payload     []byte
+
+	IncludeMaintenance bool
+}
+
+type maintRequest struct {
+	Enable bool
+	Reason string
 }
 
 type respondRequest struct {
@@ -158,15 +202,18 @@ type respondRequest struct {
 }
 
 type queryRecord struct {
-	Type    string
-	From    string
-	Payload []byte
+	Type     string
+	From     string
+	Payload  []byte
+	Verified bool
 }
 
-// NodeResponse is used to return the response of a query
+// NodeResponse is used to return the response of a query. Verified is
+// true if the response was signed by the responding node.
 type NodeResponse struct {
-	From    string
-	Payload []byte
+	From     string
+	Payload  []byte
+	Verified bool
 }
 
 type logRecord struct {
@@ -174,11 +221,13 @@ type logRecord struct {
 }
 
 type userEventRecord struct {
-	Event    string
-	LTime    serf.LamportTime
-	Name     string
-	Payload  []byte
-	Coalesce bool
+	Event      string
+	LTime      serf.LamportTime
+	Name       string
+	Payload    []byte
+	Coalesce   bool
+	SourceNode string
+	Verified   bool
 }
 
 // Member is used to represent a single member of the
@@ -195,6 +244,15 @@ type Member struct {
 	DelegateMin uint8 // Minimum supported Serf protocol
 	DelegateMax uint8 // Maximum supported Serf protocol
 	DelegateCur uint8 // Currently set Serf protocol
+	FlapCount   int   // Times the node rejoined shortly after failing
+}
+
+// Check is the state of one of an agent's local health checks
+type Check struct {
+	Name   string
+	Status string // "passing", "warning" or "critical"
+	Output string
+	Since  time.Time // When the check last changed status
 }
 
 type memberEventRecord struct {
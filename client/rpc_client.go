@@ -2,9 +2,11 @@ package client
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"log"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,17 +14,25 @@ import (
 	"github.com/hashicorp/go-msgpack/codec"
 	"github.com/hashicorp/logutils"
 	"github.com/hashicorp/serf/coordinate"
+	"github.com/hashicorp/serf/serf"
 )
 
 const (
 	// This is the default IO timeout for the client
 	DefaultTimeout = 10 * time.Second
+
+	// unixSocketPrefix marks an address as the path of a Unix domain socket
+	unixSocketPrefix = "unix://"
 )
 
 var (
 	errClientClosed   = errors.New("client closed")
 	errStreamClosed   = errors.New("stream closed")
 	errRequestTimeout = errors.New("request timeout")
+
+	// ErrPermissionDenied is returned when the agent rejects a command
+	// because the client's ACL token does not allow it.
+	ErrPermissionDenied = errors.New(permissionDenied)
 )
 
 type seqCallback struct {
@@ -43,12 +53,18 @@ type seqHandler interface {
 // Config is provided to ClientFromConfig to make
 // a new RPCClient from the given configuration
 type Config struct {
-	// Addr must be the RPC address to contact
+	// Addr must be the RPC address to contact. Addresses of the form
+	// unix:///path/to/socket connect over a Unix domain socket.
 	Addr string
 
 	// If provided, the client will perform key based auth
 	AuthKey string
 
+	// If provided, the connection to the agent is wrapped in TLS using
+	// this configuration. Client certificates should be included if the
+	// agent requires them.
+	TLSConfig *tls.Config
+
 	// If provided, overrides the DefaultTimeout used for
 	// IO deadlines
 	Timeout time.Duration
@@ -66,7 +82,7 @@ type RPCClient struct {
 	seq uint64
 
 	timeout   time.Duration
-	conn      *net.TCPConn
+	conn      net.Conn
 	reader    *bufio.Reader
 	writer    *bufio.Writer
 	dec       *codec.Decoder
@@ -134,7 +150,18 @@ func ClientFromConfig(c *Config) (*RPCClient, error) {
 	}
 
 	// Try to dial to serf
-	conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+	network, addr := "tcp", c.Addr
+	if strings.HasPrefix(addr, unixSocketPrefix) {
+		network, addr = "unix", strings.TrimPrefix(addr, unixSocketPrefix)
+	}
+	var conn net.Conn
+	var err error
+	if c.TLSConfig != nil {
+		dialer := &net.Dialer{Timeout: c.Timeout}
+		conn, err = tls.DialWithDialer(dialer, network, addr, c.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout(network, addr, c.Timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -143,7 +170,7 @@ func ClientFromConfig(c *Config) (*RPCClient, error) {
 	client := &RPCClient{
 		seq:        0,
 		timeout:    c.Timeout,
-		conn:       conn.(*net.TCPConn),
+		conn:       conn,
 		reader:     bufio.NewReader(conn),
 		writer:     bufio.NewWriter(conn),
 		dispatch:   make(map[uint64]seqHandler),
@@ -271,6 +298,26 @@ func (c *RPCClient) MembersFiltered(tags map[string]string, status string,
 	return resp.Members, err
 }
 
+// MembersWait blocks until the membership changes after the given index,
+// or the timeout elapses, and then returns the members along with the
+// current index. Pass zero to return immediately with the current index,
+// and then the returned index to wait for the next change. The agent caps
+// the timeout, and uses its maximum if the timeout is zero.
+func (c *RPCClient) MembersWait(index uint64, timeout time.Duration) ([]Member, uint64, error) {
+	header := requestHeader{
+		Command: membersWaitCommand,
+		Seq:     c.getSeq(),
+	}
+	req := membersWaitRequest{
+		Index:   index,
+		Timeout: timeout,
+	}
+	var resp membersWaitResponse
+
+	err := c.genericRPC(&header, &req, &resp)
+	return resp.Members, resp.Index, err
+}
+
 // UserEvent is used to trigger sending an event
 func (c *RPCClient) UserEvent(name string, payload []byte, coalesce bool) error {
 	header := requestHeader{
@@ -369,6 +416,24 @@ func (c *RPCClient) RemoveKey(key string) (map[string]string, error) {
 	return resp.Messages, err
 }
 
+// RotateKey replaces the keyring on each member of the cluster with the new
+// key, removing the old keys after the agent's grace period. Rotating to the
+// same key again resumes a rotation that didn't complete.
+func (c *RPCClient) RotateKey(key string) (map[string]string, error) {
+	header := requestHeader{
+		Command: rotateKeyCommand,
+		Seq:     c.getSeq(),
+	}
+	req := keyRequest{
+		Key: key,
+	}
+
+	resp := keyResponse{}
+	err := c.genericRPC(&header, &req, &resp)
+
+	return resp.Messages, err
+}
+
 // ListKeys returns all of the active keys on each member of the cluster
 func (c *RPCClient) ListKeys() (map[string]int, int, map[string]string, error) {
 	header := requestHeader{
@@ -382,6 +447,35 @@ func (c *RPCClient) ListKeys() (map[string]int, int, map[string]string, error) {
 	return resp.Keys, resp.NumNodes, resp.Messages, err
 }
 
+// ListKeysDetailed is like ListKeys, but also returns the keys and primary
+// key of each member, so that KeyResponse.Report can find the members whose
+// keyring differs
+func (c *RPCClient) ListKeysDetailed() (*serf.KeyResponse, error) {
+	header := requestHeader{
+		Command: listKeysCommand,
+		Seq:     c.getSeq(),
+	}
+
+	resp := keyResponse{}
+	err := c.genericRPC(&header, nil, &resp)
+
+	return resp.serfResponse(), err
+}
+
+// RepairKeys installs the keys most members have onto the members that are
+// missing them, and returns the keys of each member after the repair
+func (c *RPCClient) RepairKeys() (*serf.KeyResponse, error) {
+	header := requestHeader{
+		Command: repairKeysCommand,
+		Seq:     c.getSeq(),
+	}
+
+	resp := keyResponse{}
+	err := c.genericRPC(&header, nil, &resp)
+
+	return resp.serfResponse(), err
+}
+
 // Stats is used to get debugging state information
 func (c *RPCClient) Stats() (map[string]map[string]string, error) {
 	header := requestHeader{
@@ -414,6 +508,32 @@ func (c *RPCClient) GetCoordinate(node string) (*coordinate.Coordinate, error) {
 	return nil, nil
 }
 
+// Checks is used to list the state of the agent's local health checks
+func (c *RPCClient) Checks() ([]Check, error) {
+	header := requestHeader{
+		Command: checksCommand,
+		Seq:     c.getSeq(),
+	}
+	var resp checksResponse
+
+	err := c.genericRPC(&header, nil, &resp)
+	return resp.Checks, err
+}
+
+// Maintenance puts the agent's node into or out of maintenance mode, with
+// a reason that is shown to the other members
+func (c *RPCClient) Maintenance(enable bool, reason string) error {
+	header := requestHeader{
+		Command: maintCommand,
+		Seq:     c.getSeq(),
+	}
+	req := maintRequest{
+		Enable: enable,
+		Reason: reason,
+	}
+	return c.genericRPC(&header, &req, nil)
+}
+
 type monitorHandler struct {
 	// These fields are constant
 	client *RPCClient
@@ -707,7 +827,7 @@ func (qh *queryHandler) Handle(resp *responseHeader) {
 
 	case queryRecordResponse:
 		select {
-		case qh.respCh <- NodeResponse{rec.From, rec.Payload}:
+		case qh.respCh <- NodeResponse{rec.From, rec.Payload, rec.Verified}:
 		default:
 			qh.client.logger.Printf("[ERR] Dropping query response, channel full")
 		}
@@ -757,6 +877,8 @@ type QueryParam struct {
 	Payload     []byte              // Opaque query payload
 	AckCh       chan<- string       // Channel to send Ack replies on
 	RespCh      chan<- NodeResponse // Channel to send responses on
+
+	IncludeMaintenance bool // Should nodes in maintenance mode handle the query
 }
 
 // Query initiates a new query message using the given parameters, and streams
@@ -778,6 +900,8 @@ func (c *RPCClient) Query(params *QueryParam) error {
 		Timeout:     params.Timeout,
 		Name:        params.Name,
 		Payload:     params.Payload,
+
+		IncludeMaintenance: params.IncludeMaintenance,
 	}
 
 	// Create a query handler
@@ -862,8 +986,9 @@ func (c *RPCClient) genericRPC(header *requestHeader, req interface{}, resp inte
 	// Setup a response handler
 	errCh := make(chan error, 1)
 	handler := func(respHeader *responseHeader) {
-		// If we get an auth error, we should not wait for a request body
-		if respHeader.Error == authRequired {
+		// If we get an auth or permission error, we should not wait for a
+		// request body
+		if respHeader.Error == authRequired || respHeader.Error == permissionDenied {
 			goto SEND_ERR
 		}
 		if resp != nil {
@@ -895,6 +1020,9 @@ func (c *RPCClient) genericRPC(header *requestHeader, req interface{}, resp inte
 
 // strToError converts a string to an error if not blank
 func strToError(s string) error {
+	if s == permissionDenied {
+		return ErrPermissionDenied
+	}
 	if s != "" {
 		return errors.New(s)
 	}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// AdmissionCommand is a Command implementation that groups the commands
+// used to create admission CA keys and tokens.
+type AdmissionCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &AdmissionCommand{}
+
+func (c *AdmissionCommand) Run(_ []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *AdmissionCommand) Synopsis() string {
+	return "Creates admission CA keys and tokens"
+}
+
+func (c *AdmissionCommand) Help() string {
+	helpText := `
+Usage: serf admission <subcommand> [options]
+
+  Creates the keys and tokens used when admission is enabled. Each agent
+  is given the CA public key in admission_ca_keys, its own signing_key and
+  its own token in admission_token, and nodes that don't present a token
+  signed by the CA for their name, signing key and tags are refused.
+
+  Generate a CA key pair, and a signing key pair for each node:
+
+      $ serf admission keygen
+
+  Create a token for a node:
+
+      $ serf admission token -ca-key=<CA private key> -node=db-primary \
+          -node-key=<node public key> -tag=role=db
+`
+	return strings.TrimSpace(helpText)
+}
@@ -0,0 +1,48 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/serf/cmd/serf/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+// AdmissionKeygenCommand is a Command implementation that generates an
+// admission CA key pair.
+type AdmissionKeygenCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &AdmissionKeygenCommand{}
+
+func (c *AdmissionKeygenCommand) Run(_ []string) int {
+	pub, priv, err := agent.GenerateAdmissionKey()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error generating key: %s", err))
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Public key:  %s", pub))
+	c.Ui.Output(fmt.Sprintf("Private key: %s", priv))
+	return 0
+}
+
+func (c *AdmissionKeygenCommand) Synopsis() string {
+	return "Generates a new admission CA key pair"
+}
+
+func (c *AdmissionKeygenCommand) Help() string {
+	helpText := `
+Usage: serf admission keygen
+
+  Generates a new admission CA key pair. The public key is given to every
+  agent in admission_ca_keys, and the private key is used to create tokens
+  with "serf admission token". Keep the private key off the cluster nodes.
+
+  A node's signing key pair is created the same way. Its private key is
+  the agent's signing_key, and its public key is given to
+  "serf admission token" with -node-key.
+`
+	return strings.TrimSpace(helpText)
+}
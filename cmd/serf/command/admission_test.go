@@ -0,0 +1,74 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/cmd/serf/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+func TestAdmissionKeygenCommand(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &AdmissionKeygenCommand{Ui: ui}
+	if code := c.Run(nil); code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Public key:") ||
+		!strings.HasPrefix(lines[1], "Private key:") {
+		t.Fatalf("bad: %#v", lines)
+	}
+	if _, err := agent.ParseAdmissionKeys(strings.Fields(lines[0])[2:]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestAdmissionTokenCommand(t *testing.T) {
+	pub, priv, err := agent.GenerateAdmissionKey()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys, err := agent.ParseAdmissionKeys([]string{pub})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	nodeKey, _, err := agent.GenerateAdmissionKey()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &AdmissionTokenCommand{Ui: ui}
+	args := []string{"-ca-key=" + priv, "-node=db-primary", "-node-key=" + nodeKey,
+		"-tag=role=db", "-tag=dc=us-*", "-ttl=1h"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	token, err := agent.ParseAdmissionToken(strings.TrimSpace(ui.OutputWriter.String()), keys)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if token.Node != "db-primary" || token.SigningKey != nodeKey ||
+		token.Tags["role"] != "db" || token.Tags["dc"] != "us-*" {
+		t.Fatalf("bad: %#v", token)
+	}
+	if exp := time.Unix(token.Expires, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("bad: %v", exp)
+	}
+}
+
+func TestAdmissionTokenCommand_requireArgs(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &AdmissionTokenCommand{Ui: ui}
+	if code := c.Run([]string{"-node=foo"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "CA key") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/serf/cmd/serf/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+// AdmissionTokenCommand is a Command implementation that creates an
+// admission token for a node.
+type AdmissionTokenCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &AdmissionTokenCommand{}
+
+func (c *AdmissionTokenCommand) Help() string {
+	helpText := `
+Usage: serf admission token [options]
+
+  Creates an admission token that binds a node name and signing key to the
+  tags the node may set, signed by the admission CA. The token is given to
+  the node's agent in admission_token, and is only usable by an agent that
+  has the matching signing_key.
+
+Options:
+
+  -ca-key=<key>             The CA private key from "serf admission keygen".
+                            Defaults to the SERF_ADMISSION_CA_KEY environment
+                            variable.
+  -node=<name>              The name of the node the token is issued to.
+  -node-key=<key>           The public key of the node's signing_key. A key
+                            pair for a node can be created with
+                            "serf admission keygen".
+  -tag key=value            A tag the node may set. The value may contain "*"
+                            wildcards. This can be specified multiple times.
+  -ttl=<duration>           How long the token is valid for, such as "720h".
+                            The token doesn't expire if this is not set.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AdmissionTokenCommand) Run(args []string) int {
+	var caKey, node, nodeKey string
+	var tagPairs []string
+	var ttl time.Duration
+	cmdFlags := flag.NewFlagSet("admission token", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&caKey, "ca-key", os.Getenv("SERF_ADMISSION_CA_KEY"), "CA private key")
+	cmdFlags.StringVar(&node, "node", "", "node name")
+	cmdFlags.StringVar(&nodeKey, "node-key", "", "node signing public key")
+	cmdFlags.Var((*agent.AppendSliceValue)(&tagPairs), "tag",
+		"allowed tags, specified as key=value")
+	cmdFlags.DurationVar(&ttl, "ttl", 0, "token lifetime")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if caKey == "" || node == "" || nodeKey == "" {
+		c.Ui.Error("A CA key, node name and node key must be given")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	if ttl < 0 {
+		c.Ui.Error("The TTL can't be negative")
+		return 1
+	}
+
+	if _, err := agent.ParseAdmissionKeys([]string{nodeKey}); err != nil {
+		c.Ui.Error(fmt.Sprintf("Invalid node key: %s", nodeKey))
+		return 1
+	}
+
+	tags, err := agent.UnmarshalTags(tagPairs)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error: %s", err))
+		return 1
+	}
+
+	token := &agent.AdmissionToken{Node: node, SigningKey: nodeKey, Tags: tags}
+	if ttl > 0 {
+		token.Expires = time.Now().Add(ttl).Unix()
+	}
+	raw, err := agent.SignAdmissionToken(caKey, token)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error creating token: %s", err))
+		return 1
+	}
+
+	c.Ui.Output(raw)
+	return 0
+}
+
+func (c *AdmissionTokenCommand) Synopsis() string {
+	return "Creates an admission token for a node"
+}
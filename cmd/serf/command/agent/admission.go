@@ -0,0 +1,274 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+)
+
+// AdmissionTag is the reserved tag a node's admission token is published
+// under
+const AdmissionTag = "admission"
+
+// admissionExemptTags are the reserved tags that are set by Serf or the
+// agent rather than the operator, so a token doesn't need to allow them.
+// The signing key tag is checked against the token's key instead.
+var admissionExemptTags = []string{
+	AdmissionTag,
+	HealthTag,
+	serf.MaintenanceTag,
+	serf.ClusterNameTag,
+	serf.SigningKeyTag,
+}
+
+// AdmissionToken binds a node name and signing key to the tags the node
+// may set. It is signed by a cluster CA key, and nodes that don't present
+// a valid token are refused when admission is enabled.
+type AdmissionToken struct {
+	// Node is the name the token was issued to
+	Node string `json:"node"`
+
+	// SigningKey is the base64 encoded public key of the node's
+	// signing_key. The node proves that it holds the private key by
+	// publishing a signature of its name and address with the token, so
+	// a copied token can't be used by another machine.
+	SigningKey string `json:"sigkey"`
+
+	// Tags are the tags the node may set, with each value a pattern that
+	// may contain "*" wildcards. The node may leave any of them unset.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Expires is the Unix time after which the token is refused, or zero
+	// if it doesn't expire
+	Expires int64 `json:"exp,omitempty"`
+}
+
+// GenerateAdmissionKey returns a new base64 encoded CA key pair. The
+// private key signs tokens, and the public key is given to the agents in
+// admission_ca_keys to verify them.
+func GenerateAdmissionKey() (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub),
+		base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// ParseAdmissionKeys decodes the base64 encoded CA public keys
+func ParseAdmissionKeys(keys []string) ([]ed25519.PublicKey, error) {
+	result := make([]ed25519.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid admission CA key '%s'", key)
+		}
+		result = append(result, ed25519.PublicKey(raw))
+	}
+	return result, nil
+}
+
+// SignAdmissionToken signs a token with a base64 encoded CA private key,
+// and returns it in the form the agent's admission_token expects
+func SignAdmissionToken(key string, token *AdmissionToken) (string, error) {
+	seed, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("Invalid admission CA private key")
+	}
+
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(ed25519.NewKeyFromSeed(seed), []byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ParseAdmissionToken decodes a token and verifies that it was signed by
+// one of the CA keys
+func ParseAdmissionToken(raw string, keys []ed25519.PublicKey) (*AdmissionToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed admission token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed admission token signature: %v", err)
+	}
+
+	verified := false
+	for _, key := range keys {
+		if ed25519.Verify(key, []byte(parts[0]), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("Admission token isn't signed by a trusted CA key")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed admission token: %v", err)
+	}
+	var token AdmissionToken
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&token); err != nil {
+		return nil, fmt.Errorf("Malformed admission token: %v", err)
+	}
+	return &token, nil
+}
+
+// PublicKey returns the node signing key the token was issued for
+func (t *AdmissionToken) PublicKey() (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(t.SigningKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Admission token for '%s' has no valid signing key", t.Node)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Allows returns an error unless the token was issued to the node, hasn't
+// expired, and allows all of the tags
+func (t *AdmissionToken) Allows(name string, tags map[string]string, now time.Time) error {
+	if t.Node != name {
+		return fmt.Errorf("Admission token was issued to '%s', not '%s'", t.Node, name)
+	}
+	if t.Expires != 0 && now.Unix() > t.Expires {
+		return fmt.Errorf("Admission token for '%s' expired at %s", name,
+			time.Unix(t.Expires, 0).UTC().Format(time.RFC3339))
+	}
+	for key, val := range tags {
+		if containsKey(admissionExemptTags, key) {
+			continue
+		}
+		pattern, ok := t.Tags[key]
+		if !ok {
+			return fmt.Errorf("Admission token for '%s' doesn't allow the '%s' tag", name, key)
+		}
+		if !globMatch(pattern, val) {
+			return fmt.Errorf("Admission token for '%s' doesn't allow '%s' for the '%s' tag",
+				name, val, key)
+		}
+	}
+	return nil
+}
+
+// AdmissionDelegate is a serf.MergeDelegate that refuses nodes that don't
+// present a valid admission token for their name and tags. A join is
+// refused if any of the nodes being merged isn't admitted.
+type AdmissionDelegate struct {
+	Keys   []ed25519.PublicKey
+	Logger *log.Logger
+}
+
+// NotifyMerge is used to meet the serf.MergeDelegate interface
+func (d *AdmissionDelegate) NotifyMerge(members []*serf.Member) error {
+	for _, m := range members {
+		if err := d.Admit(m); err != nil {
+			metrics.IncrCounter([]string{"agent", "admission", "rejected"}, 1)
+			d.Logger.Printf("[WARN] agent: Rejecting node '%s' (%s): %v", m.Name, m.Addr, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Admit returns an error unless the member's admission token is valid and
+// the member proved that it holds the token's signing key
+func (d *AdmissionDelegate) Admit(m *serf.Member) error {
+	raw, ok := m.Tags[AdmissionTag]
+	if !ok {
+		return fmt.Errorf("Node '%s' has no admission token", m.Name)
+	}
+	idx := strings.LastIndex(raw, ".")
+	if idx < 0 {
+		return fmt.Errorf("Malformed admission tag")
+	}
+	token, err := ParseAdmissionToken(raw[:idx], d.Keys)
+	if err != nil {
+		return err
+	}
+	if err := token.Allows(m.Name, m.Tags, time.Now()); err != nil {
+		return err
+	}
+
+	key, err := token.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	// The published signing key must be the one the token was issued for,
+	// or a node could have its events verified with a key of its choosing
+	published, _ := base64.StdEncoding.DecodeString(m.Tags[serf.SigningKeyTag])
+	if !bytes.Equal(published, key) {
+		return fmt.Errorf("Node '%s' publishes a signing key its admission token wasn't issued for", m.Name)
+	}
+	proof, err := base64.RawURLEncoding.DecodeString(raw[idx+1:])
+	if err != nil {
+		return fmt.Errorf("Malformed admission proof: %v", err)
+	}
+	addr := net.JoinHostPort(m.Addr.String(), strconv.Itoa(int(m.Port)))
+	if !ed25519.Verify(key, admissionProofPayload(m.Name, addr), proof) {
+		return fmt.Errorf("Node '%s' at %s didn't prove that it holds the admission token's signing key",
+			m.Name, addr)
+	}
+	return nil
+}
+
+// admissionProofPayload returns what a node signs to prove that it holds
+// the signing key its admission token was issued for
+func admissionProofPayload(name, addr string) []byte {
+	return []byte("serf-admission\x00" + name + "\x00" + addr)
+}
+
+// admissionTag returns the value of the admission tag, which is the token
+// followed by the proof that the node holds the token's signing key
+func admissionTag(token string, key ed25519.PrivateKey, name, addr string) string {
+	proof := ed25519.Sign(key, admissionProofPayload(name, addr))
+	return token + "." + base64.RawURLEncoding.EncodeToString(proof)
+}
+
+// admissionAddr returns the address the node will advertise, which its
+// admission proof is bound to. Memberlist picks a private IP when it is
+// bound to all interfaces, so the advertise address must be given then.
+func admissionAddr(conf *memberlist.Config) (string, error) {
+	addr, port := conf.AdvertiseAddr, conf.AdvertisePort
+	if addr == "" {
+		addr, port = conf.BindAddr, conf.BindPort
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsUnspecified() || port == 0 {
+		return "", fmt.Errorf("An advertise address is required for admission when not bound to a specific address and port")
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
+
+// publicTags returns the tags with the admission tag removed, so that the
+// token isn't shown in member listings or passed to handlers
+func publicTags(tags map[string]string) map[string]string {
+	if _, ok := tags[AdmissionTag]; !ok {
+		return tags
+	}
+	result := make(map[string]string, len(tags)-1)
+	for key, val := range tags {
+		if key != AdmissionTag {
+			result[key] = val
+		}
+	}
+	return result
+}
@@ -0,0 +1,357 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+	"github.com/hashicorp/serf/testutil/retry"
+)
+
+// testAdmissionKeys returns a CA key pair and the parsed public key
+func testAdmissionKeys(t *testing.T) (string, string, []ed25519.PublicKey) {
+	pub, priv, err := GenerateAdmissionKey()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys, err := ParseAdmissionKeys([]string{pub})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return pub, priv, keys
+}
+
+// testNodeKey returns a node signing key and its base64 encoded public key
+func testNodeKey(t *testing.T) (ed25519.PrivateKey, string) {
+	pub, priv, err := GenerateAdmissionKey()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(priv)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return ed25519.NewKeyFromSeed(seed), pub
+}
+
+func testAdmissionToken(t *testing.T, priv string, token *AdmissionToken) string {
+	raw, err := SignAdmissionToken(priv, token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return raw
+}
+
+func TestAdmissionToken(t *testing.T) {
+	_, priv, keys := testAdmissionKeys(t)
+	raw := testAdmissionToken(t, priv, &AdmissionToken{
+		Node: "db-primary",
+		Tags: map[string]string{"role": "db", "dc": "us-*"},
+	})
+
+	token, err := ParseAdmissionToken(raw, keys)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if token.Node != "db-primary" || token.Tags["dc"] != "us-*" {
+		t.Fatalf("bad: %#v", token)
+	}
+
+	now := time.Now()
+	cases := []struct {
+		name  string
+		tags  map[string]string
+		allow bool
+	}{
+		{"db-primary", nil, true},
+		{"db-primary", map[string]string{"role": "db", "dc": "us-east"}, true},
+		{"db-primary", map[string]string{"role": "db", HealthTag: "passing", AdmissionTag: raw}, true},
+		{"db-primary", map[string]string{"role": "web"}, false},
+		{"db-primary", map[string]string{"dc": "eu-west"}, false},
+		{"db-primary", map[string]string{"rack": "1"}, false},
+		{"db-replica", map[string]string{"role": "db"}, false},
+	}
+	for _, tc := range cases {
+		err := token.Allows(tc.name, tc.tags, now)
+		if (err == nil) != tc.allow {
+			t.Fatalf("%s %v: %v", tc.name, tc.tags, err)
+		}
+	}
+}
+
+func TestAdmissionToken_expired(t *testing.T) {
+	_, priv, keys := testAdmissionKeys(t)
+	raw := testAdmissionToken(t, priv, &AdmissionToken{
+		Node:    "foo",
+		Expires: time.Now().Add(time.Hour).Unix(),
+	})
+
+	token, err := ParseAdmissionToken(raw, keys)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := token.Allows("foo", nil, time.Now()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err = token.Allows("foo", nil, time.Now().Add(2*time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestParseAdmissionToken_invalid(t *testing.T) {
+	_, priv, keys := testAdmissionKeys(t)
+	_, _, otherKeys := testAdmissionKeys(t)
+	raw := testAdmissionToken(t, priv, &AdmissionToken{Node: "foo"})
+
+	if _, err := ParseAdmissionToken(raw, otherKeys); err == nil {
+		t.Fatalf("should fail")
+	}
+	if _, err := ParseAdmissionToken(raw, append(otherKeys, keys...)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Swap in a payload for another node
+	forged := testAdmissionToken(t, priv, &AdmissionToken{Node: "db-primary"})
+	tampered := strings.Split(forged, ".")[0] + "." + strings.Split(raw, ".")[1]
+	if _, err := ParseAdmissionToken(tampered, keys); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	for _, bad := range []string{"", "foo", "a.b.c", raw + "x"} {
+		if _, err := ParseAdmissionToken(bad, keys); err == nil {
+			t.Fatalf("should fail: %s", bad)
+		}
+	}
+}
+
+func TestAgent_admission(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	ip3, returnFn3 := testutil.TakeIP()
+	defer returnFn3()
+
+	pub, priv, _ := testAdmissionKeys(t)
+	admitted := func(ip net.IP) (*Config, *serf.Config) {
+		key, keyPub := testNodeKey(t)
+		agentConfig := DefaultConfig()
+		agentConfig.AdmissionCAKeys = []string{pub}
+		agentConfig.AdmissionToken = testAdmissionToken(t, priv, &AdmissionToken{
+			Node:       ip.String(),
+			SigningKey: keyPub,
+			Tags:       map[string]string{"role": "web"},
+		})
+		serfConfig := serf.DefaultConfig()
+		serfConfig.SigningKey = key
+		return agentConfig, serfConfig
+	}
+
+	a1Config, serfConfig := admitted(ip1)
+	a1 := testAgentWithConfig(t, ip1, a1Config, serfConfig, nil)
+	defer a1.Shutdown()
+	a2Config, serfConfig := admitted(ip2)
+	a2 := testAgentWithConfig(t, ip2, a2Config, serfConfig, nil)
+	defer a2.Shutdown()
+
+	// The third node has a token, but for another node's name
+	a3Config, serfConfig := admitted(ip3)
+	a3Config.AdmissionToken = a1.agentConf.AdmissionToken
+	serfConfig.MemberlistConfig.BindAddr = ip3.String()
+	serfConfig.NodeName = ip3.String()
+	if _, err := Create(a3Config, serfConfig, testutil.TestWriter(t)); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// It can't use its own token without the signing key it was issued for
+	a3Config, serfConfig = admitted(ip3)
+	serfConfig.SigningKey, _ = testNodeKey(t)
+	serfConfig.MemberlistConfig.BindAddr = ip3.String()
+	serfConfig.NodeName = ip3.String()
+	_, err := Create(a3Config, serfConfig, testutil.TestWriter(t))
+	if err == nil || !strings.Contains(err.Error(), "another signing key") {
+		t.Fatalf("err: %v", err)
+	}
+	a3 := testAgent(t, ip3, nil)
+	defer a3.Shutdown()
+
+	for _, a := range []*Agent{a1, a2, a3} {
+		if err := a.Start(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	_, err = a1.Join([]string{a3.conf.NodeName + "/" + a3.conf.MemberlistConfig.BindAddr}, false)
+	if err == nil || !strings.Contains(err.Error(), "has no admission token") {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, err = a1.Join([]string{a2.conf.NodeName + "/" + a2.conf.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	if n := len(a1.Serf().Members()); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Tags must be allowed by the token, which is kept when they change
+	if err := a1.SetTags(map[string]string{"role": "db"}); err == nil {
+		t.Fatalf("should fail")
+	}
+	if err := a1.SetTags(map[string]string{"role": "web"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tags := a1.Serf().LocalMember().Tags
+	if tags["role"] != "web" || !strings.HasPrefix(tags[AdmissionTag], a1.agentConf.AdmissionToken+".") {
+		t.Fatalf("bad: %#v", tags)
+	}
+
+	// The token isn't shown in member listings
+	for _, m := range a1.Serf().Members() {
+		if _, ok := ipcMember(m).Tags[AdmissionTag]; ok {
+			t.Fatalf("bad: %#v", m)
+		}
+	}
+}
+
+func TestAdmissionDelegate_proof(t *testing.T) {
+	_, priv, keys := testAdmissionKeys(t)
+	key, keyPub := testNodeKey(t)
+	token := testAdmissionToken(t, priv, &AdmissionToken{Node: "foo", SigningKey: keyPub})
+	d := &AdmissionDelegate{Keys: keys, Logger: testutil.TestLogger(t)}
+
+	member := func(name, ip string, tag string) *serf.Member {
+		return &serf.Member{
+			Name: name,
+			Addr: net.ParseIP(ip),
+			Port: 7946,
+			Tags: map[string]string{AdmissionTag: tag, serf.SigningKeyTag: keyPub},
+		}
+	}
+	tag := admissionTag(token, key, "foo", "127.0.0.1:7946")
+	if err := d.Admit(member("foo", "127.0.0.1", tag)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A copied tag doesn't work from another address
+	if err := d.Admit(member("foo", "127.0.0.2", tag)); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// Nor does a proof made with another key
+	other, _ := testNodeKey(t)
+	forged := admissionTag(token, other, "foo", "127.0.0.2:7946")
+	if err := d.Admit(member("foo", "127.0.0.2", forged)); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// The published signing key must match the token
+	m := member("foo", "127.0.0.1", tag)
+	m.Tags[serf.SigningKeyTag] = base64.StdEncoding.EncodeToString(other.Public().(ed25519.PublicKey))
+	if err := d.Admit(m); err == nil || !strings.Contains(err.Error(), "signing key") {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The bare token isn't enough
+	if err := d.Admit(member("foo", "127.0.0.1", token)); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// A token without a signing key is refused
+	keyless := testAdmissionToken(t, priv, &AdmissionToken{Node: "foo"})
+	if err := d.Admit(member("foo", "127.0.0.1", admissionTag(keyless, key, "foo", "127.0.0.1:7946"))); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestAgent_admissionSigningKey(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	ip3, returnFn3 := testutil.TakeIP()
+	defer returnFn3()
+
+	pub, priv, _ := testAdmissionKeys(t)
+	var agents []*Agent
+	for _, ip := range []net.IP{ip1, ip2} {
+		key, keyPub := testNodeKey(t)
+		agentConfig := DefaultConfig()
+		agentConfig.AdmissionCAKeys = []string{pub}
+		agentConfig.AdmissionToken = testAdmissionToken(t, priv,
+			&AdmissionToken{Node: ip.String(), SigningKey: keyPub})
+		serfConfig := serf.DefaultConfig()
+		serfConfig.SigningKey = key
+		a := testAgentWithConfig(t, ip, agentConfig, serfConfig, nil)
+		defer a.Shutdown()
+		if err := a.Start(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		agents = append(agents, a)
+	}
+	a1, a2 := agents[0], agents[1]
+	handler := new(MockEventHandler)
+	a1.RegisterEventHandler(handler)
+
+	_, err := a1.Join([]string{a2.conf.NodeName + "/" + a2.conf.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	// Another machine with the gossip key claims the second node's name,
+	// copying its admission tag but publishing its own signing key
+	serfConfig := serf.DefaultConfig()
+	serfConfig.NodeName = a2.conf.NodeName
+	serfConfig.MemberlistConfig.BindAddr = ip3.String()
+	serfConfig.MemberlistConfig.ProbeInterval = 100 * time.Millisecond
+	serfConfig.SigningKey, _ = testNodeKey(t)
+	serfConfig.Tags = map[string]string{AdmissionTag: a2.Serf().LocalMember().Tags[AdmissionTag]}
+	serfConfig.LogOutput = testutil.TestWriter(t)
+	serfConfig.MemberlistConfig.LogOutput = serfConfig.LogOutput
+	impostor, err := serf.Create(serfConfig)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer impostor.Shutdown()
+
+	// The first node refuses it, but it still learns the first node's
+	// address from the exchange
+	impostor.Join([]string{a1.conf.NodeName + "/" + a1.conf.MemberlistConfig.BindAddr}, false)
+	if err := impostor.UserEvent("deploy", []byte("forged"), false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The event still reaches the first node over gossip, but isn't
+	// verified since the second node's key wasn't replaced
+	retry.Run(t, func(r *retry.R) {
+		handler.Lock()
+		defer handler.Unlock()
+		for _, e := range handler.Events {
+			if ue, ok := e.(serf.UserEvent); ok && ue.Name == "deploy" {
+				if ue.SourceNode != a2.conf.NodeName || ue.Verified {
+					t.Fatalf("bad: %#v", ue)
+				}
+				return
+			}
+		}
+		r.Fatal("no event")
+	})
+	for _, m := range a1.Serf().Members() {
+		if m.Name == a2.conf.NodeName && m.Tags[serf.SigningKeyTag] != a2.Serf().LocalMember().Tags[serf.SigningKeyTag] {
+			t.Fatalf("bad: %#v", m.Tags)
+		}
+	}
+}
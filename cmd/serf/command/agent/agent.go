@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -8,8 +10,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/serf/serf"
@@ -28,9 +32,10 @@ type Agent struct {
 	// eventCh is used for Serf to deliver events on
 	eventCh chan serf.Event
 
-	// eventHandlers is the registered handlers for events
-	eventHandlers     map[EventHandler]struct{}
-	eventHandlerList  []EventHandler
+	// eventHandlers is the registered handlers for events, each with
+	// its own queue
+	eventHandlers     map[EventHandler]*eventQueue
+	eventHandlerList  []*eventQueue
 	eventHandlersLock sync.Mutex
 
 	// logger instance wraps the logOutput
@@ -39,6 +44,22 @@ type Agent struct {
 	// This is the underlying Serf we are wrapping
 	serf *serf.Serf
 
+	// checks are the local health checks, and health is their aggregated
+	// status that is published in the HealthTag. healthLock serializes
+	// the tag updates, so a health change can't be overwritten by tags
+	// set with a stale health.
+	checks     []*check
+	health     string
+	checksLock sync.Mutex
+	healthLock sync.Mutex
+	leaveOnce  sync.Once
+
+	// admission is the local node's admission token, which the tags must
+	// be allowed by. It is nil unless admission is enabled, and
+	// admissionTag is the token with the proof that we hold its key.
+	admission    *AdmissionToken
+	admissionTag string
+
 	// shutdownCh is used for shutdowns
 	shutdown     bool
 	shutdownCh   chan struct{}
@@ -66,7 +87,7 @@ func Create(agentConf *Config, conf *serf.Config, logOutput io.Writer) (*Agent,
 		conf:          conf,
 		agentConf:     agentConf,
 		eventCh:       eventCh,
-		eventHandlers: make(map[EventHandler]struct{}),
+		eventHandlers: make(map[EventHandler]*eventQueue),
 		logger:        log.New(logOutput, "", log.LstdFlags),
 		shutdownCh:    make(chan struct{}),
 	}
@@ -78,6 +99,27 @@ func Create(agentConf *Config, conf *serf.Config, logOutput io.Writer) (*Agent,
 		}
 	}
 
+	// Refuse nodes that aren't in the allow list or don't have a valid
+	// admission token
+	var merge mergeDelegates
+	if len(agentConf.AllowedMembers) > 0 {
+		allowList, err := NewAllowListDelegate(conf.NodeName, agentConf.AllowedMembers, agent.logger)
+		if err != nil {
+			return nil, err
+		}
+		merge = append(merge, allowList)
+	}
+	if len(agentConf.AdmissionCAKeys) > 0 {
+		admission, err := agent.setupAdmission()
+		if err != nil {
+			return nil, err
+		}
+		merge = append(merge, admission)
+	}
+	if len(merge) > 0 {
+		conf.Merge = merge
+	}
+
 	// Load in a keyring file if provided
 	if agentConf.KeyringFile != "" {
 		if err := agent.loadKeyringFile(agentConf.KeyringFile); err != nil {
@@ -103,6 +145,10 @@ func (a *Agent) Start() error {
 
 	// Start event loop
 	go a.eventLoop()
+
+	if a.agentConf.KeyRotationInterval > 0 {
+		go a.keyRotationLoop()
+	}
 	return nil
 }
 
@@ -136,6 +182,22 @@ func (a *Agent) Shutdown() error {
 	}
 
 EXIT:
+	// Stop the checks, without waiting for the runs in progress
+	a.checksLock.Lock()
+	for _, c := range a.checks {
+		c.Stop()
+	}
+	a.checks = nil
+	a.checksLock.Unlock()
+
+	// Stop handing events to the handlers, without waiting for the events
+	// they are handling
+	a.eventHandlersLock.Lock()
+	for _, q := range a.eventHandlers {
+		q.Stop()
+	}
+	a.eventHandlersLock.Unlock()
+
 	a.logger.Println("[INFO] agent: shutdown complete")
 	a.shutdown = true
 	close(a.shutdownCh)
@@ -222,27 +284,50 @@ func (a *Agent) Query(name string, payload []byte, params *serf.QueryParam) (*se
 	return resp, err
 }
 
-// RegisterEventHandler adds an event handler to receive event notifications
+// RegisterEventHandler adds an event handler to receive event notifications.
+// The handler's queue uses the settings from the agent's configuration.
 func (a *Agent) RegisterEventHandler(eh EventHandler) {
+	a.RegisterEventHandlerWithQueue(eh, EventQueueConfig{
+		Size:        a.agentConf.EventQueueSize,
+		Concurrency: a.agentConf.EventHandlerConcurrency,
+		Overflow:    a.agentConf.EventQueueOverflow,
+	})
+}
+
+// RegisterEventHandlerWithQueue adds an event handler to receive event
+// notifications, which are queued for it with the given settings.
+func (a *Agent) RegisterEventHandlerWithQueue(eh EventHandler, conf EventQueueConfig) {
 	a.eventHandlersLock.Lock()
 	defer a.eventHandlersLock.Unlock()
 
-	a.eventHandlers[eh] = struct{}{}
-	a.eventHandlerList = nil
-	for eh := range a.eventHandlers {
-		a.eventHandlerList = append(a.eventHandlerList, eh)
+	if _, ok := a.eventHandlers[eh]; ok {
+		return
 	}
+	a.eventHandlers[eh] = newEventQueue(eventHandlerName(eh), eh, conf, a.logger)
+	a.updateEventHandlerList()
 }
 
-// DeregisterEventHandler removes an EventHandler and prevents more invocations
+// DeregisterEventHandler removes an EventHandler and prevents more invocations.
+// It waits for the handler to finish any events it is handling.
 func (a *Agent) DeregisterEventHandler(eh EventHandler) {
 	a.eventHandlersLock.Lock()
-	defer a.eventHandlersLock.Unlock()
-
+	q, ok := a.eventHandlers[eh]
 	delete(a.eventHandlers, eh)
+	a.updateEventHandlerList()
+	a.eventHandlersLock.Unlock()
+
+	if ok {
+		q.Stop()
+		q.Wait()
+	}
+}
+
+// updateEventHandlerList rebuilds the list of queues the event loop
+// dispatches to. The eventHandlersLock must be held.
+func (a *Agent) updateEventHandlerList() {
 	a.eventHandlerList = nil
-	for eh := range a.eventHandlers {
-		a.eventHandlerList = append(a.eventHandlerList, eh)
+	for _, q := range a.eventHandlers {
+		a.eventHandlerList = append(a.eventHandlerList, q)
 	}
 }
 
@@ -254,10 +339,10 @@ func (a *Agent) eventLoop() {
 		case e := <-a.eventCh:
 			a.logger.Printf("[INFO] agent: Received event: %s", e.String())
 			a.eventHandlersLock.Lock()
-			handlers := a.eventHandlerList
+			queues := a.eventHandlerList
 			a.eventHandlersLock.Unlock()
-			for _, eh := range handlers {
-				eh.HandleEvent(e)
+			for _, q := range queues {
+				q.Enqueue(e)
 			}
 
 		case <-serfShutdownCh:
@@ -299,19 +384,241 @@ func (a *Agent) ListKeys() (*serf.KeyResponse, error) {
 	return manager.ListKeys()
 }
 
+// RotateKey sends queries to all members to replace their keyring with the
+// new key, removing the old keys after the grace period
+func (a *Agent) RotateKey(key string) (*serf.KeyResponse, error) {
+	a.logger.Print("[INFO] agent: Initiating key rotation")
+	manager := a.serf.KeyManager()
+	return manager.Rotate(key, &serf.RotateOptions{
+		GracePeriod: a.agentConf.KeyRotationGracePeriod,
+	})
+}
+
+// RepairKeys sends queries to install the keys most members have onto the
+// members that are missing them
+func (a *Agent) RepairKeys() (*serf.KeyResponse, error) {
+	a.logger.Print("[INFO] agent: Initiating keyring repair")
+	manager := a.serf.KeyManager()
+	return manager.RepairKeys()
+}
+
+// setupAdmission verifies the local node's admission token and adds it to
+// the tags along with the proof that we hold its signing key. It returns
+// the delegate that verifies the other nodes' tokens.
+func (a *Agent) setupAdmission() (*AdmissionDelegate, error) {
+	keys, err := ParseAdmissionKeys(a.agentConf.AdmissionCAKeys)
+	if err != nil {
+		return nil, err
+	}
+	if a.agentConf.AdmissionToken == "" {
+		return nil, fmt.Errorf("An admission token is required when admission CA keys are set")
+	}
+	token, err := ParseAdmissionToken(a.agentConf.AdmissionToken, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.Allows(a.conf.NodeName, a.conf.Tags, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// The token is only usable with the signing key it was issued for
+	key, err := token.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if a.conf.SigningKey == nil {
+		return nil, fmt.Errorf("A signing key is required when admission CA keys are set")
+	}
+	if !bytes.Equal(key, a.conf.SigningKey.Public().(ed25519.PublicKey)) {
+		return nil, fmt.Errorf("Admission token was issued for another signing key")
+	}
+	addr, err := admissionAddr(a.conf.MemberlistConfig)
+	if err != nil {
+		return nil, err
+	}
+	a.admission = token
+	a.admissionTag = admissionTag(a.agentConf.AdmissionToken, a.conf.SigningKey, a.conf.NodeName, addr)
+
+	tags := make(map[string]string, len(a.conf.Tags)+1)
+	for key, val := range a.conf.Tags {
+		tags[key] = val
+	}
+	tags[AdmissionTag] = a.admissionTag
+	a.conf.Tags = tags
+
+	return &AdmissionDelegate{Keys: keys, Logger: a.logger}, nil
+}
+
 // SetTags is used to update the tags. The agent will make sure to
 // persist tags if necessary before gossiping to the cluster.
 func (a *Agent) SetTags(tags map[string]string) error {
+	a.healthLock.Lock()
+	defer a.healthLock.Unlock()
+	return a.setTags(tags)
+}
+
+// setTags implements SetTags, and must be called with the healthLock held.
+func (a *Agent) setTags(tags map[string]string) error {
+	// The health tag belongs to the checks if there are any, and the
+	// admission tag to the admission token, so they aren't persisted and
+	// are kept when the other tags change
+	a.checksLock.Lock()
+	hasChecks := len(a.checks) > 0
+	health := a.health
+	a.checksLock.Unlock()
+
+	result := make(map[string]string, len(tags)+2)
+	for key, val := range tags {
+		if key == AdmissionTag || (key == HealthTag && hasChecks) {
+			continue
+		}
+		result[key] = val
+	}
+
+	// The admission token must allow the new tags, or the other nodes
+	// would refuse us
+	if a.admission != nil {
+		if err := a.admission.Allows(a.conf.NodeName, result, time.Now()); err != nil {
+			return err
+		}
+	}
+
 	// Update the tags file if we have one
 	if a.agentConf.TagsFile != "" {
-		if err := a.writeTagsFile(tags); err != nil {
+		if err := a.writeTagsFile(result); err != nil {
 			a.logger.Printf("[ERR] agent: %s", err)
 			return err
 		}
 	}
 
+	if hasChecks {
+		result[HealthTag] = health
+	}
+	if a.admission != nil {
+		result[AdmissionTag] = a.admissionTag
+	}
+
 	// Set the tags in Serf, start gossiping out
-	return a.serf.SetTags(tags)
+	return a.serf.SetTags(result)
+}
+
+// UpdateTags merges the given tags into the current tags of the local
+// node, removing any keys listed in deleteTags, and applies the result
+// with SetTags.
+func (a *Agent) UpdateTags(tags map[string]string, deleteTags []string) error {
+	a.healthLock.Lock()
+	defer a.healthLock.Unlock()
+	return a.updateTags(tags, deleteTags)
+}
+
+// updateTags implements UpdateTags, and must be called with the healthLock
+// held.
+func (a *Agent) updateTags(tags map[string]string, deleteTags []string) error {
+	result := make(map[string]string)
+	for key, val := range a.conf.Tags {
+		if !containsKey(deleteTags, key) {
+			result[key] = val
+		}
+	}
+	for key, val := range tags {
+		result[key] = val
+	}
+	return a.setTags(result)
+}
+
+// SetMaintenance puts the local node into or out of maintenance mode. If
+// the node drops out of the cluster while in maintenance, other agents
+// fire member-maint instead of member-failed.
+func (a *Agent) SetMaintenance(enable bool, reason string) error {
+	a.logger.Printf("[DEBUG] agent: Setting maintenance mode: %v. Reason: %s", enable, reason)
+	err := a.serf.SetMaintenance(enable, reason)
+	if err != nil {
+		a.logger.Printf("[WARN] agent: failed to set maintenance mode: %v", err)
+	}
+	return err
+}
+
+// StartChecks replaces the local health checks with the given ones, and
+// starts running them. It must be called after Start.
+func (a *Agent) StartChecks(confs []CheckConfig) {
+	a.checksLock.Lock()
+	for _, c := range a.checks {
+		c.Stop()
+	}
+	a.checks = nil
+	for _, conf := range confs {
+		c := newCheck(conf)
+		a.checks = append(a.checks, c)
+		go c.run(a.checkUpdated)
+	}
+	a.checksLock.Unlock()
+
+	// Clear the health tag if there are no checks left
+	a.updateHealth()
+}
+
+// Checks returns the state of the local health checks
+func (a *Agent) Checks() []CheckState {
+	a.checksLock.Lock()
+	defer a.checksLock.Unlock()
+
+	states := make([]CheckState, 0, len(a.checks))
+	for _, c := range a.checks {
+		states = append(states, c.State())
+	}
+	return states
+}
+
+// checkUpdated is called after every run of a check. It publishes the
+// aggregated health, and leaves the cluster if the check has been critical
+// for too long.
+func (a *Agent) checkUpdated(c *check) {
+	a.updateHealth()
+
+	if c.conf.LeaveAfter <= 0 || c.criticalFor() < c.conf.LeaveAfter {
+		return
+	}
+	a.leaveOnce.Do(func() {
+		a.logger.Printf("[ERR] agent: Check '%s' has been critical for over %v, leaving the cluster",
+			c.conf.Name, c.conf.LeaveAfter)
+		go func() {
+			if err := a.Leave(); err != nil {
+				a.logger.Printf("[ERR] agent: Failed to leave: %v", err)
+			}
+			a.Shutdown()
+		}()
+	})
+}
+
+// updateHealth publishes the aggregated health of the checks in the
+// HealthTag if it changed
+func (a *Agent) updateHealth() {
+	a.healthLock.Lock()
+	defer a.healthLock.Unlock()
+
+	a.checksLock.Lock()
+	states := make([]CheckState, 0, len(a.checks))
+	for _, c := range a.checks {
+		states = append(states, c.State())
+	}
+	health := aggregateHealth(states)
+	changed := health != a.health
+	a.health = health
+	a.checksLock.Unlock()
+
+	if !changed {
+		return
+	}
+	a.logger.Printf("[INFO] agent: Health is now '%s'", health)
+
+	// The tag is removed along with the last check
+	var deleteTags []string
+	if health == "" {
+		deleteTags = []string{HealthTag}
+	}
+	if err := a.updateTags(nil, deleteTags); err != nil {
+		a.logger.Printf("[ERR] agent: Failed to update the health tag: %v", err)
+	}
 }
 
 // loadTagsFile will load agent tags out of a file and set them in the
@@ -440,14 +747,32 @@ func (a *Agent) Stats() map[string]map[string]string {
 		event_handlers[script_filter] = script.Script
 	}
 
+	// Report the depth and drops of each handler's queue, adding up the
+	// queues that share a name such as the RPC event streams
+	depths := make(map[string]int)
+	drops := make(map[string]uint64)
+	a.eventHandlersLock.Lock()
+	for _, q := range a.eventHandlerList {
+		depth, dropped := q.Stats()
+		depths[q.name] += depth
+		drops[q.name] += dropped
+	}
+	a.eventHandlersLock.Unlock()
+	event_queues := make(map[string]string)
+	for name, depth := range depths {
+		event_queues[name+".depth"] = strconv.Itoa(depth)
+		event_queues[name+".dropped"] = strconv.FormatUint(drops[name], 10)
+	}
+
 	output := map[string]map[string]string{
 		"agent": map[string]string{
 			"name": local.Name,
 		},
 		"runtime":        runtimeStats(),
 		"serf":           a.serf.Stats(),
-		"tags":           local.Tags,
+		"tags":           publicTags(local.Tags),
 		"event_handlers": event_handlers,
+		"event_queues":   event_queues,
 	}
 	return output
 }
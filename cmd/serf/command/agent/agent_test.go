@@ -8,9 +8,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/serf/serf"
 	"github.com/hashicorp/serf/testutil"
+	"github.com/hashicorp/serf/testutil/retry"
 )
 
 func TestAgent_eventHandler(t *testing.T) {
@@ -284,6 +286,63 @@ func TestAgentKeyringFile(t *testing.T) {
 	}
 }
 
+func TestAgentKeyRotation(t *testing.T) {
+	oldKey := "HvY8ubRZMgafUOWvrOadwOckVa1wN3QWAo46FVKbVN8="
+
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	keyringFile := filepath.Join(td, "keyring.json")
+	if err := ioutil.WriteFile(keyringFile, []byte(`["`+oldKey+`"]`), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	serfConfig := serf.DefaultConfig()
+	serfConfig.KeyringFile = keyringFile
+	agentConfig := DefaultConfig()
+	agentConfig.KeyringFile = keyringFile
+	agentConfig.KeyRotationInterval = 100 * time.Millisecond
+	agentConfig.KeyRotationGracePeriod = 10 * time.Millisecond
+
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgentWithConfig(t, ip1, agentConfig, serfConfig, nil)
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer a1.Shutdown()
+
+	retry.Run(t, func(r *retry.R) {
+		resp, err := a1.ListKeys()
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if len(resp.Keys) != 1 || resp.Keys[oldKey] != 0 {
+			r.Fatalf("bad: %v", resp.Keys)
+		}
+	})
+
+	// The new key is persisted in the keyring file
+	raw, err := ioutil.ReadFile(keyringFile)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if strings.Contains(string(raw), oldKey) {
+		t.Fatalf("bad: %s", raw)
+	}
+
+	// The finished rotation isn't kept
+	retry.Run(t, func(r *retry.R) {
+		if _, err := os.Stat(keyringFile + ".rotation"); !os.IsNotExist(err) {
+			r.Fatalf("err: %v", err)
+		}
+	})
+}
+
 func TestAgentKeyringFile_BadOptions(t *testing.T) {
 	agentConfig := DefaultConfig()
 	agentConfig.KeyringFile = "/some/path"
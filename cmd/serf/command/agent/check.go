@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/armon/circbuf"
+	"github.com/armon/go-metrics"
+)
+
+const (
+	// HealthTag is the reserved tag the aggregated status of the local
+	// checks is published under
+	HealthTag = "health"
+
+	// HealthPassing, HealthWarning and HealthCritical are the statuses of
+	// a check. The aggregated status is the worst of the checks.
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+
+	// defaultCheckInterval and defaultCheckTimeout are used for checks
+	// that don't set them
+	defaultCheckInterval = 10 * time.Second
+	defaultCheckTimeout  = 5 * time.Second
+
+	// maxCheckOutput limits how much of a check's output is kept
+	maxCheckOutput = 4 * 1024
+)
+
+// CheckConfig configures a local health check, which runs a script or
+// probes a TCP or HTTP endpoint on an interval.
+type CheckConfig struct {
+	// Name identifies the check, and must be unique
+	Name string `mapstructure:"name"`
+
+	// Script is run in a shell. An exit code of 0 is passing, 1 is a
+	// warning, and anything else is critical.
+	Script string `mapstructure:"script"`
+
+	// TCP is an address that must accept a connection, such as
+	// "localhost:6379"
+	TCP string `mapstructure:"tcp"`
+
+	// HTTP is a URL that must respond to a GET with a 2xx status. A 429
+	// is a warning, and anything else is critical.
+	HTTP string `mapstructure:"http"`
+
+	// IntervalRaw is the string time between runs, and Interval is the
+	// parsed value.
+	IntervalRaw string        `mapstructure:"interval"`
+	Interval    time.Duration `mapstructure:"-"`
+
+	// TimeoutRaw is the string time a run may take before it is critical,
+	// and Timeout is the parsed value.
+	TimeoutRaw string        `mapstructure:"timeout"`
+	Timeout    time.Duration `mapstructure:"-"`
+
+	// LeaveAfterRaw is the string time a check may be critical before the
+	// agent leaves the cluster and shuts down, and LeaveAfter is the
+	// parsed value. The agent never leaves if it is zero.
+	LeaveAfterRaw string        `mapstructure:"leave_after"`
+	LeaveAfter    time.Duration `mapstructure:"-"`
+}
+
+// CheckState is the latest result of a check
+type CheckState struct {
+	Name   string
+	Status string
+	Output string
+
+	// Since is when the check last changed status
+	Since time.Time
+}
+
+// healthRank orders the statuses from best to worst
+var healthRank = map[string]int{
+	HealthPassing:  0,
+	HealthWarning:  1,
+	HealthCritical: 2,
+}
+
+// aggregateHealth returns the worst status of the checks, or an empty
+// string if there are none
+func aggregateHealth(states []CheckState) string {
+	health := ""
+	for _, state := range states {
+		if health == "" || healthRank[state.Status] > healthRank[health] {
+			health = state.Status
+		}
+	}
+	return health
+}
+
+// check runs a single health check until it is stopped
+type check struct {
+	conf CheckConfig
+
+	l     sync.Mutex
+	state CheckState
+
+	stopCh chan struct{}
+}
+
+// newCheck returns a check that is critical until it first runs
+func newCheck(conf CheckConfig) *check {
+	if conf.Interval <= 0 {
+		conf.Interval = defaultCheckInterval
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultCheckTimeout
+	}
+	return &check{
+		conf: conf,
+		state: CheckState{
+			Name:   conf.Name,
+			Status: HealthCritical,
+			Output: "Check has not run yet",
+			Since:  time.Now(),
+		},
+		stopCh: make(chan struct{}),
+	}
+}
+
+// State returns the latest result of the check
+func (c *check) State() CheckState {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.state
+}
+
+// run probes on the interval, calling updateFn after every run
+func (c *check) run(updateFn func(*check)) {
+	for {
+		status, output := c.probe()
+
+		// Discard the result if we were stopped during the run
+		select {
+		case <-c.stopCh:
+			return
+		default:
+		}
+
+		c.update(status, output)
+		updateFn(c)
+
+		select {
+		case <-time.After(c.conf.Interval):
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// update records the result of a run
+func (c *check) update(status, output string) {
+	metrics.IncrCounterWithLabels([]string{"agent", "check", status}, 1,
+		[]metrics.Label{{Name: "check", Value: c.conf.Name}})
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if status != c.state.Status {
+		c.state.Since = time.Now()
+	}
+	c.state.Status = status
+	c.state.Output = output
+}
+
+// criticalFor returns how long the check has been critical
+func (c *check) criticalFor() time.Duration {
+	state := c.State()
+	if state.Status != HealthCritical {
+		return 0
+	}
+	return time.Since(state.Since)
+}
+
+// probe runs the check once
+func (c *check) probe() (string, string) {
+	switch {
+	case c.conf.Script != "":
+		return c.probeScript()
+	case c.conf.TCP != "":
+		return c.probeTCP()
+	default:
+		return c.probeHTTP()
+	}
+}
+
+// probeScript runs the script, killing it if it runs past the timeout
+func (c *check) probeScript() (string, string) {
+	output, _ := circbuf.NewBuffer(maxCheckOutput)
+	cmd := shellCommand(c.conf.Script)
+	setProcessGroup(cmd)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return HealthCritical, err.Error()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(c.conf.Timeout):
+		killProcessGroup(cmd)
+		if !waitKilled(errCh) {
+			return HealthCritical, fmt.Sprintf("Timed out after %v, and its output was still open %v after it was killed",
+				c.conf.Timeout, killWaitTimeout)
+		}
+		return HealthCritical, fmt.Sprintf("Timed out after %v", c.conf.Timeout)
+	}
+
+	if err == nil {
+		return HealthPassing, output.String()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return HealthWarning, output.String()
+	}
+	return HealthCritical, fmt.Sprintf("%s%v", output.String(), err)
+}
+
+// probeTCP connects to the address
+func (c *check) probeTCP() (string, string) {
+	conn, err := net.DialTimeout("tcp", c.conf.TCP, c.conf.Timeout)
+	if err != nil {
+		return HealthCritical, err.Error()
+	}
+	conn.Close()
+	return HealthPassing, fmt.Sprintf("TCP connect %s: Success", c.conf.TCP)
+}
+
+// probeHTTP makes a GET request to the URL
+func (c *check) probeHTTP() (string, string) {
+	client := &http.Client{Timeout: c.conf.Timeout}
+	resp, err := client.Get(c.conf.HTTP)
+	if err != nil {
+		return HealthCritical, err.Error()
+	}
+	resp.Body.Close()
+
+	output := fmt.Sprintf("HTTP GET %s: %s", c.conf.HTTP, resp.Status)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return HealthPassing, output
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return HealthWarning, output
+	default:
+		return HealthCritical, output
+	}
+}
+
+// Stop stops the check after any run in progress
+func (c *check) Stop() {
+	close(c.stopCh)
+}
@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/testutil"
+)
+
+func TestCheck_script(t *testing.T) {
+	testCases := []struct {
+		script string
+		status string
+		output string
+	}{
+		{"echo ok", HealthPassing, "ok\n"},
+		{"echo slow; exit 1", HealthWarning, "slow\n"},
+		{"echo down; exit 2", HealthCritical, "down\nexit status 2"},
+	}
+
+	for _, tc := range testCases {
+		c := newCheck(CheckConfig{Name: "test", Script: tc.script})
+		status, output := c.probe()
+		if status != tc.status || output != tc.output {
+			t.Errorf("bad: %s: %s %q", tc.script, status, output)
+		}
+	}
+}
+
+func TestCheck_scriptTimeout(t *testing.T) {
+	c := newCheck(CheckConfig{Name: "test", Script: "sleep 10", Timeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	status, output := c.probe()
+	if status != HealthCritical || !strings.Contains(output, "Timed out") {
+		t.Fatalf("bad: %s %q", status, output)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("should have been killed")
+	}
+}
+
+func TestCheck_scriptTimeoutHeldOutput(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not found")
+	}
+	defer func(d time.Duration) { killWaitTimeout = d }(killWaitTimeout)
+	killWaitTimeout = 200 * time.Millisecond
+
+	// The sleep started with setsid isn't killed with the script, and
+	// holds its output open
+	c := newCheck(CheckConfig{Name: "test", Script: "setsid sleep 5 & sleep 10", Timeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	status, output := c.probe()
+	if status != HealthCritical || !strings.Contains(output, "still open") {
+		t.Fatalf("bad: %s %q", status, output)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("should have given up waiting")
+	}
+}
+
+func TestCheck_tcp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	addr := l.Addr().String()
+
+	c := newCheck(CheckConfig{Name: "test", TCP: addr})
+	if status, output := c.probe(); status != HealthPassing {
+		t.Fatalf("bad: %s %q", status, output)
+	}
+
+	l.Close()
+	if status, output := c.probe(); status != HealthCritical {
+		t.Fatalf("bad: %s %q", status, output)
+	}
+}
+
+func TestCheck_http(t *testing.T) {
+	code := http.StatusOK
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		code   int
+		status string
+	}{
+		{http.StatusOK, HealthPassing},
+		{http.StatusNoContent, HealthPassing},
+		{http.StatusTooManyRequests, HealthWarning},
+		{http.StatusServiceUnavailable, HealthCritical},
+	}
+
+	c := newCheck(CheckConfig{Name: "test", HTTP: s.URL})
+	for _, tc := range testCases {
+		code = tc.code
+		if status, output := c.probe(); status != tc.status {
+			t.Errorf("bad: %d: %s %q", tc.code, status, output)
+		}
+	}
+}
+
+func TestAggregateHealth(t *testing.T) {
+	testCases := []struct {
+		statuses []string
+		health   string
+	}{
+		{nil, ""},
+		{[]string{HealthPassing}, HealthPassing},
+		{[]string{HealthPassing, HealthWarning}, HealthWarning},
+		{[]string{HealthCritical, HealthWarning, HealthPassing}, HealthCritical},
+	}
+
+	for _, tc := range testCases {
+		var states []CheckState
+		for _, status := range tc.statuses {
+			states = append(states, CheckState{Status: status})
+		}
+		if health := aggregateHealth(states); health != tc.health {
+			t.Errorf("bad: %v: %s", tc.statuses, health)
+		}
+	}
+}
+
+// waitForHealth waits for the agent to publish the health tag
+func waitForHealth(t *testing.T, a *Agent, health string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tags := a.Serf().LocalMember().Tags
+		if tags[HealthTag] == health {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %v", tags)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAgent_checks(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The script passes until the file is removed
+	fh, err := ioutil.TempFile("", "serf-check")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	// Without checks the health tag is an ordinary tag
+	if err := a1.SetTags(map[string]string{HealthTag: "custom"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	waitForHealth(t, a1, "custom")
+
+	a1.StartChecks([]CheckConfig{
+		{Name: "ok", Script: "true", Interval: 50 * time.Millisecond},
+		{Name: "file", Script: "test -f " + fh.Name() + " || exit 1", Interval: 50 * time.Millisecond},
+	})
+	waitForHealth(t, a1, HealthPassing)
+
+	// Changing the other tags keeps the health tag, which can't be set
+	if err := a1.SetTags(map[string]string{"role": "web", HealthTag: "custom"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tags := a1.Serf().LocalMember().Tags
+	if tags["role"] != "web" || tags[HealthTag] != HealthPassing {
+		t.Fatalf("bad: %v", tags)
+	}
+
+	os.Remove(fh.Name())
+	waitForHealth(t, a1, HealthWarning)
+
+	checks := a1.Checks()
+	if len(checks) != 2 || checks[0].Name != "ok" || checks[0].Status != HealthPassing ||
+		checks[1].Name != "file" || checks[1].Status != HealthWarning {
+		t.Fatalf("bad: %#v", checks)
+	}
+
+	// Removing the checks removes the tag
+	a1.StartChecks(nil)
+	waitForHealth(t, a1, "")
+	if tags := a1.Serf().LocalMember().Tags; tags["role"] != "web" {
+		t.Fatalf("bad: %v", tags)
+	}
+}
+
+func TestAgent_checkLeaveAfter(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	a1.StartChecks([]CheckConfig{
+		{
+			Name:       "down",
+			Script:     "exit 2",
+			Interval:   50 * time.Millisecond,
+			LeaveAfter: 200 * time.Millisecond,
+		},
+	})
+
+	select {
+	case <-a1.ShutdownCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("should have shut down")
+	}
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
@@ -8,12 +9,14 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"reflect"
 	"runtime"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
 	gsyslog "github.com/hashicorp/go-syslog"
 	"github.com/hashicorp/logutils"
 	"github.com/hashicorp/memberlist"
@@ -37,12 +40,17 @@ const (
 // ShutdownCh. If two messages are sent on the ShutdownCh it will forcibly
 // exit.
 type Command struct {
-	Ui            cli.Ui
-	ShutdownCh    <-chan struct{}
-	args          []string
-	scriptHandler *ScriptEventHandler
-	logFilter     *logutils.LevelFilter
-	logger        *log.Logger
+	Ui          cli.Ui
+	ShutdownCh  <-chan struct{}
+	args        []string
+	scripts     []*ScriptEventHandler
+	coprocesses []*CoprocessEventHandler
+	templates   []*TemplateEventHandler
+	httpAPI     *AgentHTTP
+	prometheus  *AgentPrometheus
+	logFilter   *logutils.LevelFilter
+	logger      *log.Logger
+	logOutput   io.Writer
 }
 
 var _ cli.Command = &Command{}
@@ -54,6 +62,7 @@ func (c *Command) readConfig() *Config {
 	var configFiles []string
 	var tags []string
 	var retryInterval string
+	var eventHandlerTimeout string
 	var broadcastTimeout string
 	var disableCompression bool
 
@@ -79,6 +88,22 @@ func (c *Command) readConfig() *Config {
 	cmdFlags.StringVar(&cmdConfig.Role, "role", "", "role name")
 	cmdFlags.StringVar(&cmdConfig.RPCAddr, "rpc-addr", "",
 		"address to bind RPC listener to")
+	cmdFlags.StringVar(&cmdConfig.RPCSocketMode, "rpc-socket-mode", "",
+		"file mode of the RPC Unix socket")
+	cmdFlags.StringVar(&cmdConfig.RPCSocketUser, "rpc-socket-user", "",
+		"owner of the RPC Unix socket")
+	cmdFlags.StringVar(&cmdConfig.RPCSocketGroup, "rpc-socket-group", "",
+		"group of the RPC Unix socket")
+	cmdFlags.StringVar(&cmdConfig.RPCTLSCert, "rpc-tls-cert", "",
+		"certificate for the RPC listener")
+	cmdFlags.StringVar(&cmdConfig.RPCTLSKey, "rpc-tls-key", "",
+		"private key for the RPC listener")
+	cmdFlags.StringVar(&cmdConfig.RPCTLSCA, "rpc-tls-ca", "",
+		"CA used to verify RPC client certificates")
+	cmdFlags.BoolVar(&cmdConfig.RPCTLSVerifyClient, "rpc-tls-verify-client", false,
+		"require RPC clients to present a certificate")
+	cmdFlags.StringVar(&cmdConfig.HTTPAddr, "http-addr", "",
+		"address to bind HTTP API listener to")
 	cmdFlags.StringVar(&cmdConfig.Profile, "profile", "", "timing profile to use (lan, wan, local)")
 	cmdFlags.StringVar(&cmdConfig.SnapshotPath, "snapshot", "", "path to the snapshot file")
 	cmdFlags.Var((*AppendSliceValue)(&tags), "tag",
@@ -92,6 +117,8 @@ func (c *Command) readConfig() *Config {
 		"address of agent to join on startup with retry")
 	cmdFlags.IntVar(&cmdConfig.RetryMaxAttempts, "retry-max", 0, "maximum retry join attempts")
 	cmdFlags.StringVar(&retryInterval, "retry-interval", "", "retry join interval")
+	cmdFlags.StringVar(&eventHandlerTimeout, "event-handler-timeout", "",
+		"timeout for event handler scripts")
 	cmdFlags.BoolVar(&cmdConfig.RejoinAfterLeave, "rejoin", false,
 		"enable re-joining after a previous leave")
 
@@ -127,6 +154,16 @@ func (c *Command) readConfig() *Config {
 		cmdConfig.RetryInterval = dur
 	}
 
+	// Decode the event handler timeout if given
+	if eventHandlerTimeout != "" {
+		dur, err := time.ParseDuration(eventHandlerTimeout)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error: %s", err))
+			return nil
+		}
+		cmdConfig.EventHandlerTimeout = dur
+	}
+
 	// Decode the broadcast timeout if given
 	if broadcastTimeout != "" {
 		dur, err := time.ParseDuration(broadcastTimeout)
@@ -167,6 +204,138 @@ func (c *Command) readConfig() *Config {
 		}
 	}
 
+	if !validEventQueueOverflow(config.EventQueueOverflow) {
+		c.Ui.Error(fmt.Sprintf("Invalid event queue overflow policy: '%s'", config.EventQueueOverflow))
+		return nil
+	}
+
+	for _, handler := range config.ScriptHandlers {
+		if handler.Script == "" {
+			c.Ui.Error("Script handler has no script set")
+			return nil
+		}
+		if !validScriptFormat(handler.Format) {
+			c.Ui.Error(fmt.Sprintf("Invalid format for script '%s': '%s'",
+				handler.Script, handler.Format))
+			return nil
+		}
+		if !validEventQueueOverflow(handler.QueueOverflow) {
+			c.Ui.Error(fmt.Sprintf("Invalid event queue overflow policy for script '%s': '%s'",
+				handler.Script, handler.QueueOverflow))
+			return nil
+		}
+	}
+
+	for _, coproc := range config.Coprocesses {
+		if coproc.Command == "" {
+			c.Ui.Error("Coprocess handler has no command set")
+			return nil
+		}
+		for _, filter := range ParseEventFilter(coproc.Events) {
+			if !filter.Valid() {
+				c.Ui.Error(fmt.Sprintf("Invalid event filter for coprocess '%s': %s",
+					coproc.Command, coproc.Events))
+				return nil
+			}
+		}
+	}
+
+	checkNames := make(map[string]bool)
+	for _, check := range config.Checks {
+		if check.Name == "" {
+			c.Ui.Error("Check has no name set")
+			return nil
+		}
+		if checkNames[check.Name] {
+			c.Ui.Error(fmt.Sprintf("Duplicate check name: '%s'", check.Name))
+			return nil
+		}
+		checkNames[check.Name] = true
+
+		probes := 0
+		for _, probe := range []string{check.Script, check.TCP, check.HTTP} {
+			if probe != "" {
+				probes++
+			}
+		}
+		if probes != 1 {
+			c.Ui.Error(fmt.Sprintf("Check '%s' must set exactly one of script, tcp or http",
+				check.Name))
+			return nil
+		}
+		if check.HTTP != "" && !isWebhookURL(check.HTTP) {
+			c.Ui.Error(fmt.Sprintf("Invalid URL for check '%s': '%s'", check.Name, check.HTTP))
+			return nil
+		}
+		if check.Interval < 0 || check.Timeout < 0 || check.LeaveAfter < 0 {
+			c.Ui.Error(fmt.Sprintf("Check '%s' has a negative interval, timeout or leave_after",
+				check.Name))
+			return nil
+		}
+	}
+	if _, ok := config.Tags[serf.MaintenanceTag]; ok {
+		c.Ui.Error(fmt.Sprintf("The '%s' tag is reserved for maintenance mode", serf.MaintenanceTag))
+		return nil
+	}
+	if _, ok := config.Tags[serf.ClusterNameTag]; ok {
+		c.Ui.Error(fmt.Sprintf("The '%s' tag is reserved for the cluster name", serf.ClusterNameTag))
+		return nil
+	}
+	if _, err := NewAllowListDelegate(config.NodeName, config.AllowedMembers, nil); err != nil {
+		c.Ui.Error(err.Error())
+		return nil
+	}
+	if _, err := ParseAdmissionKeys(config.AdmissionCAKeys); err != nil {
+		c.Ui.Error(err.Error())
+		return nil
+	}
+	if len(config.AdmissionCAKeys) > 0 && config.AdmissionToken == "" {
+		c.Ui.Error("An admission token is required when admission CA keys are set")
+		return nil
+	}
+	if len(config.AdmissionCAKeys) > 0 && config.SigningKey == "" {
+		c.Ui.Error("A signing key is required when admission CA keys are set")
+		return nil
+	}
+	if _, ok := config.Tags[serf.SigningKeyTag]; ok {
+		c.Ui.Error(fmt.Sprintf("The '%s' tag is reserved for the signing key", serf.SigningKeyTag))
+		return nil
+	}
+	if _, ok := config.Tags[AdmissionTag]; ok {
+		c.Ui.Error(fmt.Sprintf("The '%s' tag is reserved for the admission token", AdmissionTag))
+		return nil
+	}
+	if _, ok := config.Tags[HealthTag]; ok && len(config.Checks) > 0 {
+		c.Ui.Error(fmt.Sprintf("The '%s' tag is reserved for the result of the checks", HealthTag))
+		return nil
+	}
+
+	for _, tmpl := range config.Templates {
+		if tmpl.Source == "" || tmpl.Destination == "" {
+			c.Ui.Error("Template must have a source and destination set")
+			return nil
+		}
+	}
+
+	for _, hook := range config.Webhooks {
+		if !isWebhookURL(hook.URL) {
+			c.Ui.Error(fmt.Sprintf("Invalid webhook URL: '%s'", hook.URL))
+			return nil
+		}
+	}
+
+	for _, acl := range config.RPCACLTokens {
+		if acl.Token == "" {
+			c.Ui.Error(fmt.Sprintf("RPC ACL token '%s' has no token set", acl.Name))
+			return nil
+		}
+	}
+
+	if config.KeyRotationInterval != 0 && config.KeyringFile == "" {
+		c.Ui.Error("Key rotation requires a keyring file to persist the new keys")
+		return nil
+	}
+
 	// Check for a valid interface
 	if _, err := config.NetworkInterface(); err != nil {
 		c.Ui.Error(fmt.Sprintf("Invalid network interface: %s", err))
@@ -301,6 +470,12 @@ func (c *Command) setupAgent(config *Config, logOutput io.Writer) *Agent {
 		return nil
 	}
 
+	signingKey, err := config.SigningPrivateKey()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Invalid signing key: %s", err))
+		return nil
+	}
+
 	serfConfig := serf.DefaultConfig()
 	switch config.Profile {
 	case "lan":
@@ -321,6 +496,8 @@ func (c *Command) setupAgent(config *Config, logOutput io.Writer) *Agent {
 	serfConfig.MemberlistConfig.SecretKey = encryptKey
 	serfConfig.NodeName = config.NodeName
 	serfConfig.Tags = config.Tags
+	serfConfig.ClusterName = config.ClusterName
+	serfConfig.SigningKey = signingKey
 	serfConfig.SnapshotPath = config.SnapshotPath
 	serfConfig.ProtocolVersion = uint8(config.Protocol)
 	serfConfig.CoalescePeriod = 3 * time.Second
@@ -399,25 +576,75 @@ func (c *Command) setupLoggers(config *Config) (*GatedWriter, *logWriter, io.Wri
 
 	// Create a logger
 	c.logger = log.New(logOutput, "", log.LstdFlags)
+	c.logOutput = logOutput
 	return logGate, logWriter, logOutput
 }
 
+// setupMetrics returns the telemetry configuration and the sink that the
+// metrics are sent to, which includes the given in-memory sink. The sink is
+// nil if one of the configured sinks couldn't be started.
+func (c *Command) setupMetrics(config *Config, inm *metrics.InmemSink) (*metrics.Config, metrics.MetricSink) {
+	metricsConf := metrics.DefaultConfig("serf-agent")
+
+	// Configure the statsite sink
+	var fanout metrics.FanoutSink
+	if config.StatsiteAddr != "" {
+		sink, err := metrics.NewStatsiteSink(config.StatsiteAddr)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to start statsite sink. Got: %s", err))
+			return nil, nil
+		}
+		fanout = append(fanout, sink)
+	}
+
+	// Configure the statsd sink
+	if config.StatsdAddr != "" {
+		sink, err := metrics.NewStatsdSink(config.StatsdAddr)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to start statsd sink. Got: %s", err))
+			return nil, nil
+		}
+		fanout = append(fanout, sink)
+	}
+
+	// Configure the Prometheus sink. Prometheus identifies the instance
+	// with a label, so the hostname is kept out of its gauge names without
+	// changing the names sent to the other sinks.
+	if config.PrometheusAddr != "" {
+		sink, err := prometheus.NewPrometheusSinkFrom(prometheus.PrometheusOpts{
+			Expiration: config.PrometheusRetention,
+		})
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to start Prometheus sink. Got: %s", err))
+			return nil, nil
+		}
+		if len(fanout) == 0 {
+			metricsConf.EnableHostname = false
+		}
+		fanout = append(fanout, &noHostnameSink{MetricSink: sink, conf: metricsConf})
+	}
+
+	if len(fanout) == 0 {
+		metricsConf.EnableHostname = false
+		return metricsConf, inm
+	}
+	return metricsConf, append(fanout, inm)
+}
+
 // startAgent is used to start the agent and IPC
 func (c *Command) startAgent(config *Config, agent *Agent,
 	logWriter *logWriter, logOutput io.Writer) *AgentIPC {
 	// Add the script event handlers
-	c.scriptHandler = &ScriptEventHandler{
-		SelfFunc: func() serf.Member { return agent.Serf().LocalMember() },
-		Scripts:  config.EventScripts(),
-		Logger:   log.New(logOutput, "", log.LstdFlags),
-	}
-	agent.RegisterEventHandler(c.scriptHandler)
+	c.startScripts(config, agent, logOutput)
+	c.startCoprocesses(config, agent, logOutput)
+	c.startTemplates(config, agent, logOutput)
 
-	// Start the agent after the handler is registered
+	// Start the agent after the handlers are registered
 	if err := agent.Start(); err != nil {
 		c.Ui.Error(fmt.Sprintf("Failed to start the Serf agent: %v", err))
 		return nil
 	}
+	agent.StartChecks(config.Checks)
 
 	// Parse the bind address information
 	bindIP, bindPort, err := config.AddrParts(config.BindAddr)
@@ -441,15 +668,49 @@ func (c *Command) startAgent(config *Config, agent *Agent,
 	}
 
 	// Setup the RPC listener
-	rpcListener, err := net.Listen("tcp", config.RPCAddr)
+	rpcTLS, err := config.RPCTLSConfig()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error setting up RPC TLS: %s", err))
+		return nil
+	}
+	rpcListener, err := listenRPC(config)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error starting RPC listener: %s", err))
 		return nil
 	}
+	if rpcTLS != nil {
+		rpcListener = tls.NewListener(rpcListener, rpcTLS)
+	}
 
 	// Start the IPC layer
 	c.Ui.Output("Starting Serf agent RPC...")
-	ipc := NewAgentIPC(agent, config.RPCAuthKey, rpcListener, logOutput, logWriter)
+	ipc := NewAgentIPC(agent, config.RPCAuthKey, config.RPCACLTokens, rpcListener, logOutput, logWriter)
+
+	// Setup the HTTP API if enabled
+	if config.HTTPAddr != "" {
+		httpListener, err := net.Listen("tcp", config.HTTPAddr)
+		if err != nil {
+			ipc.Shutdown()
+			c.Ui.Error(fmt.Sprintf("Error starting HTTP listener: %s", err))
+			return nil
+		}
+		c.Ui.Output("Starting Serf agent HTTP API...")
+		c.httpAPI = NewAgentHTTP(agent, config.RPCAuthKey, config.RPCACLTokens, httpListener, logOutput)
+	}
+
+	// Setup the Prometheus endpoint if enabled
+	if config.PrometheusAddr != "" {
+		promListener, err := net.Listen("tcp", config.PrometheusAddr)
+		if err != nil {
+			ipc.Shutdown()
+			if c.httpAPI != nil {
+				c.httpAPI.Shutdown()
+			}
+			c.Ui.Error(fmt.Sprintf("Error starting Prometheus listener: %s", err))
+			return nil
+		}
+		c.prometheus = NewAgentPrometheus(agent, promListener, logOutput)
+	}
 
 	c.Ui.Output("Serf agent running!")
 	c.Ui.Info(fmt.Sprintf("                  Node name: '%s'", config.NodeName))
@@ -462,6 +723,13 @@ func (c *Command) startAgent(config *Config, agent *Agent,
 	}
 
 	c.Ui.Info(fmt.Sprintf("                   RPC addr: '%s'", config.RPCAddr))
+	c.Ui.Info(fmt.Sprintf("                    RPC TLS: %v", rpcTLS != nil))
+	if config.HTTPAddr != "" {
+		c.Ui.Info(fmt.Sprintf("                  HTTP addr: '%s'", config.HTTPAddr))
+	}
+	if config.PrometheusAddr != "" {
+		c.Ui.Info(fmt.Sprintf("            Prometheus addr: '%s'", config.PrometheusAddr))
+	}
 	c.Ui.Info(fmt.Sprintf("                  Encrypted: %#v", agent.serf.EncryptionEnabled()))
 	c.Ui.Info(fmt.Sprintf("                   Snapshot: %v", config.SnapshotPath != ""))
 	c.Ui.Info(fmt.Sprintf("                    Profile: %s", config.Profile))
@@ -473,6 +741,72 @@ func (c *Command) startAgent(config *Config, agent *Agent,
 	return ipc
 }
 
+// startScripts registers a script event handler for each event handler
+// entry, so that each has its own queue
+func (c *Command) startScripts(config *Config, agent *Agent, logOutput io.Writer) {
+	for _, group := range config.EventScriptGroups() {
+		handler := &ScriptEventHandler{
+			SelfFunc: func() serf.Member { return agent.Serf().LocalMember() },
+			Scripts:  group.Scripts,
+			Logger:   log.New(logOutput, "", log.LstdFlags),
+			Name:     group.Name,
+		}
+		agent.RegisterEventHandlerWithQueue(handler, group.Queue)
+		c.scripts = append(c.scripts, handler)
+	}
+}
+
+// stopScripts deregisters the script event handlers
+func (c *Command) stopScripts(agent *Agent) {
+	for _, handler := range c.scripts {
+		agent.DeregisterEventHandler(handler)
+	}
+	c.scripts = nil
+}
+
+// startCoprocesses starts and registers the coprocess event handlers
+func (c *Command) startCoprocesses(config *Config, agent *Agent, logOutput io.Writer) {
+	for _, coproc := range config.Coprocesses {
+		handler := NewCoprocessEventHandler(coproc,
+			func() serf.Member { return agent.Serf().LocalMember() },
+			log.New(logOutput, "", log.LstdFlags))
+		handler.Start()
+		agent.RegisterEventHandler(handler)
+		c.coprocesses = append(c.coprocesses, handler)
+	}
+}
+
+// stopCoprocesses deregisters and stops the coprocess event handlers
+func (c *Command) stopCoprocesses(agent *Agent) {
+	for _, handler := range c.coprocesses {
+		agent.DeregisterEventHandler(handler)
+		handler.Stop()
+	}
+	c.coprocesses = nil
+}
+
+// startTemplates starts and registers the template event handlers
+func (c *Command) startTemplates(config *Config, agent *Agent, logOutput io.Writer) {
+	for _, tmpl := range config.Templates {
+		handler := NewTemplateEventHandler(tmpl,
+			func() serf.Member { return agent.Serf().LocalMember() },
+			func() []serf.Member { return agent.Serf().Members() },
+			log.New(logOutput, "", log.LstdFlags))
+		handler.Start()
+		agent.RegisterEventHandler(handler)
+		c.templates = append(c.templates, handler)
+	}
+}
+
+// stopTemplates deregisters and stops the template event handlers
+func (c *Command) stopTemplates(agent *Agent) {
+	for _, handler := range c.templates {
+		agent.DeregisterEventHandler(handler)
+		handler.Stop()
+	}
+	c.templates = nil
+}
+
 // startupJoin is invoked to handle any joins specified to take place at start time
 func (c *Command) startupJoin(config *Config, agent *Agent) error {
 	if len(config.StartJoin) == 0 {
@@ -550,37 +884,11 @@ func (c *Command) Run(args []string) int {
 	*/
 	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
 	metrics.DefaultInmemSignal(inm)
-	metricsConf := metrics.DefaultConfig("serf-agent")
-
-	// Configure the statsite sink
-	var fanout metrics.FanoutSink
-	if config.StatsiteAddr != "" {
-		sink, err := metrics.NewStatsiteSink(config.StatsiteAddr)
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Failed to start statsite sink. Got: %s", err))
-			return 1
-		}
-		fanout = append(fanout, sink)
-	}
-
-	// Configure the statsd sink
-	if config.StatsdAddr != "" {
-		sink, err := metrics.NewStatsdSink(config.StatsdAddr)
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Failed to start statsd sink. Got: %s", err))
-			return 1
-		}
-		fanout = append(fanout, sink)
-	}
-
-	// Initialize the global sink
-	if len(fanout) > 0 {
-		fanout = append(fanout, inm)
-		metrics.NewGlobal(metricsConf, fanout)
-	} else {
-		metricsConf.EnableHostname = false
-		metrics.NewGlobal(metricsConf, inm)
+	metricsConf, metricsSink := c.setupMetrics(config, inm)
+	if metricsSink == nil {
+		return 1
 	}
+	metrics.NewGlobal(metricsConf, metricsSink)
 
 	// Setup serf
 	agent := c.setupAgent(config, logOutput)
@@ -589,12 +897,24 @@ func (c *Command) Run(args []string) int {
 	}
 	defer agent.Shutdown()
 
+	// The handlers are started before the agent, so they must also be
+	// stopped if it fails to start
+	defer c.stopScripts(agent)
+	defer c.stopCoprocesses(agent)
+	defer c.stopTemplates(agent)
+
 	// Start the agent
 	ipc := c.startAgent(config, agent, logWriter, logOutput)
 	if ipc == nil {
 		return 1
 	}
 	defer ipc.Shutdown()
+	if c.httpAPI != nil {
+		defer c.httpAPI.Shutdown()
+	}
+	if c.prometheus != nil {
+		defer c.prometheus.Shutdown()
+	}
 
 	// Join startup nodes if specified
 	if err := c.startupJoin(config, agent); err != nil {
@@ -700,8 +1020,28 @@ func (c *Command) handleReload(config *Config, agent *Agent) *Config {
 		newConf.LogLevel = config.LogLevel
 	}
 
-	// Change the event handlers
-	c.scriptHandler.UpdateScripts(newConf.EventScripts())
+	// Replace the event handlers if they changed
+	if !reflect.DeepEqual(config.EventScriptGroups(), newConf.EventScriptGroups()) {
+		c.stopScripts(agent)
+		c.startScripts(newConf, agent, c.logOutput)
+	}
+
+	// Restart the coprocesses if they changed
+	if !reflect.DeepEqual(config.Coprocesses, newConf.Coprocesses) {
+		c.stopCoprocesses(agent)
+		c.startCoprocesses(newConf, agent, c.logOutput)
+	}
+
+	// Restart the templates if they changed
+	if !reflect.DeepEqual(config.Templates, newConf.Templates) {
+		c.stopTemplates(agent)
+		c.startTemplates(newConf, agent, c.logOutput)
+	}
+
+	// Restart the checks if they changed
+	if !reflect.DeepEqual(config.Checks, newConf.Checks) {
+		agent.StartChecks(newConf.Checks)
+	}
 
 	// Update the tags in serf
 	if err := agent.SetTags(newConf.Tags); err != nil {
@@ -748,9 +1088,15 @@ Options:
                            by Serf. As encryption keys are changed, the content of
                            this file is updated so that the same keys may be used
                            during later agent starts.
-  -event-handler=foo       Script to execute when events occur. This can
-                           be specified multiple times. See the event scripts
-                           section below for more info.
+  -http-addr=addr          Address to bind the HTTP API listener. The HTTP
+                           API is disabled unless this is provided.
+  -event-handler=foo       Script to execute when events occur, or an http(s)
+                           URL to POST them to. This can be specified multiple
+                           times. See the event scripts section below for more
+                           info.
+  -event-handler-timeout=30s
+                           Kills event handler scripts that run longer than
+                           this, along with any processes they started.
   -join=addr               An initial agent to join with. This flag can be
                            specified multiple times.
   -log-level=info          Log level of the agent.
@@ -771,7 +1117,16 @@ Options:
                            by event scripts to differentiate different types
                            of nodes that may be part of the same cluster.
                            '-role' is deprecated in favor of '-tag role=foo'.
-  -rpc-addr=127.0.0.1:7373 Address to bind the RPC listener.
+  -rpc-addr=127.0.0.1:7373 Address to bind the RPC listener. Use the form
+                           unix:///path/to/socket to listen on a Unix socket.
+  -rpc-socket-mode=0700    File mode of the RPC Unix socket, in octal.
+  -rpc-socket-user=user    Owner of the RPC Unix socket, by name or uid.
+  -rpc-socket-group=group  Group of the RPC Unix socket, by name or gid.
+  -rpc-tls-cert=path       Certificate used to serve the RPC listener over TLS.
+  -rpc-tls-key=path        Private key for -rpc-tls-cert.
+  -rpc-tls-ca=path         CA certificate used to verify RPC client certificates.
+  -rpc-tls-verify-client   Require RPC clients to present a certificate signed
+                           by -rpc-tls-ca.
   -snapshot=path/to/file   The snapshot file is used to store alive nodes and
                            event information so that Serf can rejoin a cluster
                            and avoid event replay on restart.
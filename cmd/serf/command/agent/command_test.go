@@ -2,8 +2,13 @@ package agent
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -418,3 +423,61 @@ func TestCommandRun_retry_joinFail(t *testing.T) {
 		t.Fatal("should fail")
 	}
 }
+
+func TestCommandRun_startFail(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	// The RPC address is taken, so the agent fails to start after its
+	// handlers have been started
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "members.tmpl")
+	if err := ioutil.WriteFile(source, []byte(testTemplate), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	config := fmt.Sprintf(`{"event_handlers": ["true"], "coprocess_handlers": [{"command": "sleep 60"}],
+		"templates": [{"source": %q, "destination": %q}]}`, source, filepath.Join(dir, "members"))
+	configFile := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	shutdownCh := make(chan struct{})
+	defer close(shutdownCh)
+
+	ui := new(cli.MockUi)
+	c := &Command{
+		ShutdownCh: shutdownCh,
+		Ui:         ui,
+	}
+
+	args := []string{
+		"-bind", ip1.String(),
+		"-rpc-addr", l.Addr().String(),
+		"-config-file", configFile,
+	}
+
+	code := c.Run(args)
+	if code == 0 {
+		t.Fatal("should fail")
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "RPC listener") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+
+	// The handlers were stopped
+	if len(c.scripts) != 0 || len(c.coprocesses) != 0 || len(c.templates) != 0 {
+		t.Fatalf("bad: %v %v %v", c.scripts, c.coprocesses, c.templates)
+	}
+}
@@ -1,10 +1,14 @@
 package agent
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
@@ -22,21 +26,26 @@ const DefaultBindPort int = 7946
 // DefaultConfig contains the defaults for configurations.
 func DefaultConfig() *Config {
 	return &Config{
-		DisableCoordinates:     false,
-		Tags:                   make(map[string]string),
-		BindAddr:               "0.0.0.0",
-		AdvertiseAddr:          "",
-		LogLevel:               "INFO",
-		RPCAddr:                "127.0.0.1:7373",
-		Protocol:               serf.ProtocolVersionMax,
-		ReplayOnJoin:           false,
-		Profile:                "lan",
-		RetryInterval:          30 * time.Second,
-		SyslogFacility:         "LOCAL0",
-		QueryResponseSizeLimit: 1024,
-		QuerySizeLimit:         1024,
-		UserEventSizeLimit:     512,
-		BroadcastTimeout:       5 * time.Second,
+		DisableCoordinates:      false,
+		Tags:                    make(map[string]string),
+		BindAddr:                "0.0.0.0",
+		AdvertiseAddr:           "",
+		LogLevel:                "INFO",
+		RPCAddr:                 "127.0.0.1:7373",
+		Protocol:                serf.ProtocolVersionMax,
+		ReplayOnJoin:            false,
+		Profile:                 "lan",
+		RetryInterval:           30 * time.Second,
+		SyslogFacility:          "LOCAL0",
+		QueryResponseSizeLimit:  1024,
+		QuerySizeLimit:          1024,
+		UserEventSizeLimit:      512,
+		BroadcastTimeout:        5 * time.Second,
+		KeyRotationGracePeriod:  30 * time.Second,
+		PrometheusRetention:     60 * time.Second,
+		EventQueueSize:          defaultEventQueueSize,
+		EventHandlerConcurrency: 1,
+		EventQueueOverflow:      OverflowBlock,
 	}
 }
 
@@ -64,6 +73,36 @@ type Config struct {
 	// agent is running. Tags can be reloaded from this file on later starts.
 	TagsFile string `mapstructure:"tags_file"`
 
+	// ClusterName is published in the reserved "serf.cluster" tag, and peers
+	// with a different cluster name are refused. See the documentation for
+	// Serf.Config.ClusterName.
+	ClusterName string `mapstructure:"cluster_name"`
+
+	// AllowedMembers restricts the cluster to nodes whose name matches one
+	// of the entries, which may be globs such as "web-*", or whose address
+	// is within one of the entries in CIDR notation. Any node may join if
+	// it is empty.
+	AllowedMembers []string `mapstructure:"allowed_members"`
+
+	// AdmissionCAKeys are the base64 encoded public keys of the cluster CA.
+	// When set, only nodes presenting an admission token signed by one of
+	// the keys, for their name and tags, are admitted to the cluster. More
+	// than one key can be given while the CA is being rotated.
+	AdmissionCAKeys []string `mapstructure:"admission_ca_keys"`
+
+	// AdmissionToken is this node's admission token, created with
+	// "serf admission token" for the public key of SigningKey. It is
+	// published in the reserved "admission" tag along with a signature of
+	// the node's name and advertise address, and is required when
+	// AdmissionCAKeys is set.
+	AdmissionToken string `mapstructure:"admission_token"`
+
+	// SigningKey is a base64 encoded 32 byte seed for this node's Ed25519
+	// signing key, such as one from "serf keygen". When set, the user
+	// events, queries and query responses sent by this node are signed,
+	// and the public key is published in the reserved "serf.sigkey" tag.
+	SigningKey string `mapstructure:"signing_key"`
+
 	// BindAddr is the address that the Serf agent's communication ports
 	// will bind to. Serf will use this address to bind to for both TCP
 	// and UDP connections. If no port is present in the address, the default
@@ -87,19 +126,66 @@ type Config struct {
 	// keyring will not be persisted to a file.
 	KeyringFile string `mapstructure:"keyring_file"`
 
+	// KeyRotationIntervalRaw is the string interval at which this agent
+	// rotates the cluster's encryption key to a newly generated one. It
+	// requires a keyring file, next to which the rotation in progress is
+	// kept. Rotation is disabled by default.
+	KeyRotationIntervalRaw string        `mapstructure:"key_rotation_interval"`
+	KeyRotationInterval    time.Duration `mapstructure:"-"`
+
+	// KeyRotationGracePeriodRaw is the string time a key rotation waits
+	// after every node has switched to the new key before the old keys are
+	// removed. This defaults to 30 seconds.
+	KeyRotationGracePeriodRaw string        `mapstructure:"key_rotation_grace_period"`
+	KeyRotationGracePeriod    time.Duration `mapstructure:"-"`
+
 	// LogLevel is the level of the logs to output.
 	// This can be updated during a reload.
 	LogLevel string `mapstructure:"log_level"`
 
 	// RPCAddr is the address and port to listen on for the agent's RPC
-	// interface.
+	// interface. An address of the form unix:///path/to/socket listens
+	// on a Unix domain socket instead.
 	RPCAddr string `mapstructure:"rpc_addr"`
 
+	// RPCSocketMode, RPCSocketUser and RPCSocketGroup control the
+	// permissions of the Unix domain socket when RPCAddr uses the unix://
+	// scheme. The mode is given in octal, and the user and group may be
+	// either names or numeric IDs.
+	RPCSocketMode  string `mapstructure:"rpc_socket_mode"`
+	RPCSocketUser  string `mapstructure:"rpc_socket_user"`
+	RPCSocketGroup string `mapstructure:"rpc_socket_group"`
+
 	// RPCAuthKey is a key that can be set to optionally require that
 	// RPC's provide an authentication key. This is meant to be
 	// a very simple authentication control
 	RPCAuthKey string `mapstructure:"rpc_auth"`
 
+	// RPCACLTokens are additional RPC tokens that are each limited to a
+	// set of IPC commands. They can be used alongside or instead of the
+	// RPCAuthKey, which always allows every command.
+	RPCACLTokens []RPCACLToken `mapstructure:"rpc_acl_tokens"`
+
+	// RPCTLSCert and RPCTLSKey are the paths to a PEM encoded certificate
+	// and private key. If provided, the RPC listener only accepts TLS
+	// connections.
+	RPCTLSCert string `mapstructure:"rpc_tls_cert"`
+	RPCTLSKey  string `mapstructure:"rpc_tls_key"`
+
+	// RPCTLSCA is the path to a PEM encoded CA certificate used to verify
+	// RPC client certificates. Clients presenting a certificate not signed
+	// by this CA are rejected.
+	RPCTLSCA string `mapstructure:"rpc_tls_ca"`
+
+	// RPCTLSVerifyClient requires every RPC client to present a certificate
+	// signed by RPCTLSCA, enabling mutual TLS.
+	RPCTLSVerifyClient bool `mapstructure:"rpc_tls_verify_client"`
+
+	// HTTPAddr is the address and port to listen on for the agent's
+	// HTTP API. The HTTP API is disabled if this is empty. Requests are
+	// authenticated using the RPCAuthKey if one is set.
+	HTTPAddr string `mapstructure:"http_addr"`
+
 	// Protocol is the Serf protocol version to use.
 	Protocol int `mapstructure:"protocol"`
 
@@ -128,6 +214,47 @@ type Config struct {
 	// These can be updated during a reload.
 	EventHandlers []string `mapstructure:"event_handlers"`
 
+	// EventHandlerTimeoutRaw is the string timeout for the scripts in
+	// EventHandlers, and EventHandlerTimeout is the parsed value. Scripts
+	// that run longer are killed along with any processes they started.
+	// There is no timeout by default, but scripts handling a query are
+	// always killed at the query's deadline.
+	EventHandlerTimeoutRaw string        `mapstructure:"event_handler_timeout"`
+	EventHandlerTimeout    time.Duration `mapstructure:"-"`
+
+	// ScriptHandlers is a list of event scripts with their own settings,
+	// in addition to the EventHandlers.
+	ScriptHandlers []ScriptHandlerConfig `mapstructure:"script_handlers"`
+
+	// Webhooks is a list of webhooks that events are POSTed to. Like the
+	// EventHandlers, these can be updated during a reload.
+	Webhooks []WebhookConfig `mapstructure:"webhooks"`
+
+	// Coprocesses is a list of long-running processes that events are
+	// streamed to. They are restarted during a reload if they changed.
+	Coprocesses []CoprocessConfig `mapstructure:"coprocess_handlers"`
+
+	// Templates is a list of templates that are rendered with the members
+	// when the membership changes. They are restarted during a reload if
+	// they changed.
+	Templates []TemplateConfig `mapstructure:"templates"`
+
+	// Checks is a list of local health checks. The worst of their statuses
+	// is published in the reserved "health" tag. They are restarted during
+	// a reload if they changed.
+	Checks []CheckConfig `mapstructure:"checks"`
+
+	// EventQueueSize, EventHandlerConcurrency and EventQueueOverflow control
+	// how events are dispatched to each event handler, including the event
+	// scripts and RPC event streams. Every handler has its own queue of up
+	// to EventQueueSize events, drained by EventHandlerConcurrency workers.
+	// When a queue is full, EventQueueOverflow decides whether the agent
+	// waits for room ("block"), discards the oldest queued event
+	// ("drop-oldest") or discards the new event ("drop-newest").
+	EventQueueSize          int    `mapstructure:"event_queue_size"`
+	EventHandlerConcurrency int    `mapstructure:"event_handler_concurrency"`
+	EventQueueOverflow      string `mapstructure:"event_queue_overflow"`
+
 	// Profile is used to select a timing profile for Serf. The supported choices
 	// are "wan", "lan", and "local". The default is "lan"
 	Profile string `mapstructure:"profile"`
@@ -222,6 +349,16 @@ type Config struct {
 	// metrics will be sent to that instance.
 	StatsdAddr string `mapstructure:"statsd_addr"`
 
+	// PrometheusAddr is the address to serve Prometheus metrics on. If
+	// provided, every metric is exposed at /metrics on that address.
+	PrometheusAddr string `mapstructure:"prometheus_addr"`
+
+	// PrometheusRetentionRaw is the string retention time for Prometheus
+	// metrics. Metrics that haven't been updated for this long are no
+	// longer reported. This defaults to 60 seconds.
+	PrometheusRetentionRaw string        `mapstructure:"prometheus_retention"`
+	PrometheusRetention    time.Duration `mapstructure:"-"`
+
 	// BroadcastTimeoutRaw is the string retry interval. This interval
 	// controls the timeout for broadcast events. This defaults to
 	// 5 seconds.
@@ -263,17 +400,167 @@ func (c *Config) EncryptBytes() ([]byte, error) {
 	return base64.StdEncoding.DecodeString(c.EncryptKey)
 }
 
+// SigningPrivateKey returns the signing key configured, or nil if there
+// isn't one
+func (c *Config) SigningPrivateKey() (ed25519.PrivateKey, error) {
+	if c.SigningKey == "" {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(c.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
 // EventScripts returns the list of EventScripts associated with this
-// configuration and specified by the "event_handlers" configuration.
+// configuration and specified by the "event_handlers", "script_handlers"
+// and "webhooks" configuration.
 func (c *Config) EventScripts() []EventScript {
-	result := make([]EventScript, 0, len(c.EventHandlers))
+	var result []EventScript
+	for _, group := range c.EventScriptGroups() {
+		result = append(result, group.Scripts...)
+	}
+	return result
+}
+
+// EventScriptGroup is the scripts configured by a single event handler
+// entry, which share an event queue of their own
+type EventScriptGroup struct {
+	// Name is the entry as it was configured, and is what its queue is
+	// reported under
+	Name    string
+	Scripts []EventScript
+	Queue   EventQueueConfig
+}
+
+// EventScriptGroups returns the EventScripts grouped by the entry they were
+// configured in. Each entry is given its own queue, which uses the agent's
+// queue settings unless a script handler overrides them.
+func (c *Config) EventScriptGroups() []EventScriptGroup {
+	queue := EventQueueConfig{
+		Size:        c.EventQueueSize,
+		Concurrency: c.EventHandlerConcurrency,
+		Overflow:    c.EventQueueOverflow,
+	}
+
+	result := make([]EventScriptGroup, 0,
+		len(c.EventHandlers)+len(c.ScriptHandlers)+len(c.Webhooks))
+	names := make(map[string]int)
+	add := func(name string, scripts []EventScript, queue EventQueueConfig) {
+		// Entries that are configured the same way are told apart by a
+		// counter, so each still gets its own queue
+		names[name]++
+		if n := names[name]; n > 1 {
+			name = fmt.Sprintf("%s (%d)", name, n)
+		}
+		result = append(result, EventScriptGroup{Name: name, Scripts: scripts, Queue: queue})
+	}
+
 	for _, v := range c.EventHandlers {
 		part := ParseEventScript(v)
-		result = append(result, part...)
+		for i := range part {
+			if part[i].Webhook == nil {
+				part[i].Timeout = c.EventHandlerTimeout
+			}
+		}
+		add(v, part, queue)
+	}
+	for _, handler := range c.ScriptHandlers {
+		timeout := handler.Timeout
+		if timeout == 0 {
+			timeout = c.EventHandlerTimeout
+		}
+		var part []EventScript
+		for _, filt := range ParseEventFilter(handler.Events) {
+			part = append(part, EventScript{
+				EventFilter: filt,
+				Script:      handler.Script,
+				Timeout:     timeout,
+				Format:      handler.Format,
+			})
+		}
+
+		handlerQueue := queue
+		if handler.QueueSize != 0 {
+			handlerQueue.Size = handler.QueueSize
+		}
+		if handler.Concurrency != 0 {
+			handlerQueue.Concurrency = handler.Concurrency
+		}
+		if handler.QueueOverflow != "" {
+			handlerQueue.Overflow = handler.QueueOverflow
+		}
+		add(eventHandlerEntry(handler.Events, handler.Script), part, handlerQueue)
+	}
+	for i := range c.Webhooks {
+		hook := &c.Webhooks[i]
+		var part []EventScript
+		for _, filt := range ParseEventFilter(hook.Events) {
+			part = append(part, EventScript{
+				EventFilter: filt,
+				Script:      hook.URL,
+				Webhook:     hook,
+			})
+		}
+		add(eventHandlerEntry(hook.Events, hook.URL), part, queue)
 	}
 	return result
 }
 
+// eventHandlerEntry formats a handler the way it would be written in
+// event_handlers
+func eventHandlerEntry(events, script string) string {
+	if events == "" {
+		return script
+	}
+	return events + "=" + script
+}
+
+// RPCTLSConfig returns the TLS configuration for the RPC listener, or
+// nil if TLS is not enabled.
+func (c *Config) RPCTLSConfig() (*tls.Config, error) {
+	if c.RPCTLSCert == "" && c.RPCTLSKey == "" {
+		if c.RPCTLSCA != "" || c.RPCTLSVerifyClient {
+			return nil, fmt.Errorf("RPC TLS requires a certificate and key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.RPCTLSCert, c.RPCTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load RPC TLS certificate: %s", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.RPCTLSCA != "" {
+		pem, err := ioutil.ReadFile(c.RPCTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read RPC TLS CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Failed to parse RPC TLS CA: no certificates found")
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if c.RPCTLSVerifyClient {
+		if c.RPCTLSCA == "" {
+			return nil, fmt.Errorf("RPC TLS client verification requires a CA")
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
 // Networkinterface is used to get the associated network
 // interface from the configured value
 func (c *Config) NetworkInterface() (*net.Interface, error) {
@@ -349,6 +636,105 @@ func DecodeConfig(r io.Reader) (*Config, error) {
 		result.BroadcastTimeout = dur
 	}
 
+	if result.KeyRotationIntervalRaw != "" {
+		dur, err := time.ParseDuration(result.KeyRotationIntervalRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.KeyRotationInterval = dur
+	}
+
+	if result.KeyRotationGracePeriodRaw != "" {
+		dur, err := time.ParseDuration(result.KeyRotationGracePeriodRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.KeyRotationGracePeriod = dur
+	}
+
+	if result.PrometheusRetentionRaw != "" {
+		dur, err := time.ParseDuration(result.PrometheusRetentionRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.PrometheusRetention = dur
+	}
+
+	if result.EventHandlerTimeoutRaw != "" {
+		dur, err := time.ParseDuration(result.EventHandlerTimeoutRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.EventHandlerTimeout = dur
+	}
+
+	for i := range result.ScriptHandlers {
+		handler := &result.ScriptHandlers[i]
+		if handler.TimeoutRaw == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(handler.TimeoutRaw)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing timeout for script '%s': %s", handler.Script, err)
+		}
+		handler.Timeout = dur
+	}
+
+	for i := range result.Webhooks {
+		hook := &result.Webhooks[i]
+		if hook.TimeoutRaw == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(hook.TimeoutRaw)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing timeout for webhook '%s': %s", hook.URL, err)
+		}
+		hook.Timeout = dur
+	}
+
+	for i := range result.Templates {
+		tmpl := &result.Templates[i]
+		if tmpl.DebounceRaw != "" {
+			dur, err := time.ParseDuration(tmpl.DebounceRaw)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing debounce for template '%s': %s", tmpl.Source, err)
+			}
+			tmpl.Debounce = dur
+		}
+		if tmpl.TimeoutRaw != "" {
+			dur, err := time.ParseDuration(tmpl.TimeoutRaw)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing timeout for template '%s': %s", tmpl.Source, err)
+			}
+			tmpl.Timeout = dur
+		}
+	}
+
+	for i := range result.Checks {
+		check := &result.Checks[i]
+		if check.IntervalRaw != "" {
+			dur, err := time.ParseDuration(check.IntervalRaw)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing interval for check '%s': %s", check.Name, err)
+			}
+			check.Interval = dur
+		}
+		if check.TimeoutRaw != "" {
+			dur, err := time.ParseDuration(check.TimeoutRaw)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing timeout for check '%s': %s", check.Name, err)
+			}
+			check.Timeout = dur
+		}
+		if check.LeaveAfterRaw != "" {
+			dur, err := time.ParseDuration(check.LeaveAfterRaw)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing leave_after for check '%s': %s", check.Name, err)
+			}
+			check.LeaveAfter = dur
+		}
+	}
+
 	return &result, nil
 }
 
@@ -385,6 +771,15 @@ func MergeConfig(a, b *Config) *Config {
 			result.Tags[name] = value
 		}
 	}
+	if b.ClusterName != "" {
+		result.ClusterName = b.ClusterName
+	}
+	if b.AdmissionToken != "" {
+		result.AdmissionToken = b.AdmissionToken
+	}
+	if b.SigningKey != "" {
+		result.SigningKey = b.SigningKey
+	}
 	if b.BindAddr != "" {
 		result.BindAddr = b.BindAddr
 	}
@@ -403,9 +798,33 @@ func MergeConfig(a, b *Config) *Config {
 	if b.RPCAddr != "" {
 		result.RPCAddr = b.RPCAddr
 	}
+	if b.RPCSocketMode != "" {
+		result.RPCSocketMode = b.RPCSocketMode
+	}
+	if b.RPCSocketUser != "" {
+		result.RPCSocketUser = b.RPCSocketUser
+	}
+	if b.RPCSocketGroup != "" {
+		result.RPCSocketGroup = b.RPCSocketGroup
+	}
 	if b.RPCAuthKey != "" {
 		result.RPCAuthKey = b.RPCAuthKey
 	}
+	if b.RPCTLSCert != "" {
+		result.RPCTLSCert = b.RPCTLSCert
+	}
+	if b.RPCTLSKey != "" {
+		result.RPCTLSKey = b.RPCTLSKey
+	}
+	if b.RPCTLSCA != "" {
+		result.RPCTLSCA = b.RPCTLSCA
+	}
+	if b.RPCTLSVerifyClient {
+		result.RPCTLSVerifyClient = true
+	}
+	if b.HTTPAddr != "" {
+		result.HTTPAddr = b.HTTPAddr
+	}
 	if b.ReplayOnJoin != false {
 		result.ReplayOnJoin = b.ReplayOnJoin
 	}
@@ -445,6 +864,12 @@ func MergeConfig(a, b *Config) *Config {
 	if b.KeyringFile != "" {
 		result.KeyringFile = b.KeyringFile
 	}
+	if b.KeyRotationInterval != 0 {
+		result.KeyRotationInterval = b.KeyRotationInterval
+	}
+	if b.KeyRotationGracePeriod != 0 {
+		result.KeyRotationGracePeriod = b.KeyRotationGracePeriod
+	}
 	if b.EnableSyslog {
 		result.EnableSyslog = true
 	}
@@ -454,6 +879,18 @@ func MergeConfig(a, b *Config) *Config {
 	if b.RetryInterval != 0 {
 		result.RetryInterval = b.RetryInterval
 	}
+	if b.EventHandlerTimeout != 0 {
+		result.EventHandlerTimeout = b.EventHandlerTimeout
+	}
+	if b.EventQueueSize != 0 {
+		result.EventQueueSize = b.EventQueueSize
+	}
+	if b.EventHandlerConcurrency != 0 {
+		result.EventHandlerConcurrency = b.EventHandlerConcurrency
+	}
+	if b.EventQueueOverflow != "" {
+		result.EventQueueOverflow = b.EventQueueOverflow
+	}
 	if b.RejoinAfterLeave {
 		result.RejoinAfterLeave = true
 	}
@@ -466,6 +903,12 @@ func MergeConfig(a, b *Config) *Config {
 	if b.StatsdAddr != "" {
 		result.StatsdAddr = b.StatsdAddr
 	}
+	if b.PrometheusAddr != "" {
+		result.PrometheusAddr = b.PrometheusAddr
+	}
+	if b.PrometheusRetention != 0 {
+		result.PrometheusRetention = b.PrometheusRetention
+	}
 	if b.QueryResponseSizeLimit != 0 {
 		result.QueryResponseSizeLimit = b.QueryResponseSizeLimit
 	}
@@ -485,6 +928,46 @@ func MergeConfig(a, b *Config) *Config {
 	result.EventHandlers = append(result.EventHandlers, a.EventHandlers...)
 	result.EventHandlers = append(result.EventHandlers, b.EventHandlers...)
 
+	// Copy the script handlers
+	result.ScriptHandlers = make([]ScriptHandlerConfig, 0, len(a.ScriptHandlers)+len(b.ScriptHandlers))
+	result.ScriptHandlers = append(result.ScriptHandlers, a.ScriptHandlers...)
+	result.ScriptHandlers = append(result.ScriptHandlers, b.ScriptHandlers...)
+
+	// Copy the webhooks
+	result.Webhooks = make([]WebhookConfig, 0, len(a.Webhooks)+len(b.Webhooks))
+	result.Webhooks = append(result.Webhooks, a.Webhooks...)
+	result.Webhooks = append(result.Webhooks, b.Webhooks...)
+
+	// Copy the coprocesses
+	result.Coprocesses = make([]CoprocessConfig, 0, len(a.Coprocesses)+len(b.Coprocesses))
+	result.Coprocesses = append(result.Coprocesses, a.Coprocesses...)
+	result.Coprocesses = append(result.Coprocesses, b.Coprocesses...)
+
+	// Copy the templates
+	result.Templates = make([]TemplateConfig, 0, len(a.Templates)+len(b.Templates))
+	result.Templates = append(result.Templates, a.Templates...)
+	result.Templates = append(result.Templates, b.Templates...)
+
+	// Copy the allowed members
+	result.AllowedMembers = make([]string, 0, len(a.AllowedMembers)+len(b.AllowedMembers))
+	result.AllowedMembers = append(result.AllowedMembers, a.AllowedMembers...)
+	result.AllowedMembers = append(result.AllowedMembers, b.AllowedMembers...)
+
+	// Copy the admission CA keys
+	result.AdmissionCAKeys = make([]string, 0, len(a.AdmissionCAKeys)+len(b.AdmissionCAKeys))
+	result.AdmissionCAKeys = append(result.AdmissionCAKeys, a.AdmissionCAKeys...)
+	result.AdmissionCAKeys = append(result.AdmissionCAKeys, b.AdmissionCAKeys...)
+
+	// Copy the checks
+	result.Checks = make([]CheckConfig, 0, len(a.Checks)+len(b.Checks))
+	result.Checks = append(result.Checks, a.Checks...)
+	result.Checks = append(result.Checks, b.Checks...)
+
+	// Copy the RPC ACL tokens
+	result.RPCACLTokens = make([]RPCACLToken, 0, len(a.RPCACLTokens)+len(b.RPCACLTokens))
+	result.RPCACLTokens = append(result.RPCACLTokens, a.RPCACLTokens...)
+	result.RPCACLTokens = append(result.RPCACLTokens, b.RPCACLTokens...)
+
 	// Copy the start join addresses
 	result.StartJoin = make([]string, 0, len(a.StartJoin)+len(b.StartJoin))
 	result.StartJoin = append(result.StartJoin, a.StartJoin...)
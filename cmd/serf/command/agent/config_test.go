@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"io/ioutil"
 	"os"
@@ -84,8 +85,8 @@ func TestConfigEventScripts(t *testing.T) {
 	}
 
 	expected := []EventScript{
-		{EventFilter{"*", ""}, "foo.sh"},
-		{EventFilter{"bar", ""}, "blah.sh"},
+		{EventFilter{"*", "", nil}, "foo.sh", nil, 0, ""},
+		{EventFilter{"bar", "", nil}, "blah.sh", nil, 0, ""},
 	}
 
 	if !reflect.DeepEqual(result, expected) {
@@ -311,6 +312,17 @@ func TestDecodeConfig(t *testing.T) {
 		t.Fatalf("bad: %#v", config)
 	}
 
+	// Key rotation
+	input = `{"key_rotation_interval": "24h", "key_rotation_grace_period": "1m"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.KeyRotationInterval != 24*time.Hour || config.KeyRotationGracePeriod != time.Minute {
+		t.Fatalf("bad: %#v", config)
+	}
+
 	// Retry configs
 	input = `{"retry_join": ["127.0.0.1", "127.0.0.2"]}`
 	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
@@ -366,6 +378,273 @@ func TestDecodeConfig(t *testing.T) {
 	if config.QueryResponseSizeLimit != 123 || config.QuerySizeLimit != 456 {
 		t.Fatalf("bad: %#v", config)
 	}
+
+	// RPC unix socket
+	input = `{"rpc_addr": "unix:///var/run/serf.sock", "rpc_socket_mode": "0660", "rpc_socket_user": "serf", "rpc_socket_group": "admin"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.RPCAddr != "unix:///var/run/serf.sock" || config.RPCSocketMode != "0660" ||
+		config.RPCSocketUser != "serf" || config.RPCSocketGroup != "admin" {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// RPC ACL tokens
+	input = `{"rpc_acl_tokens": [{"name": "deploy", "token": "abc", "commands": ["event", "members"], "event_prefix": "deploy-"}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expectedACL := RPCACLToken{
+		Name:        "deploy",
+		Token:       "abc",
+		Commands:    []string{"event", "members"},
+		EventPrefix: "deploy-",
+	}
+	if len(config.RPCACLTokens) != 1 || !reflect.DeepEqual(config.RPCACLTokens[0], expectedACL) {
+		t.Fatalf("bad: %#v", config.RPCACLTokens)
+	}
+
+	// Prometheus
+	input = `{"prometheus_addr": "127.0.0.1:9100", "prometheus_retention": "2m"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.PrometheusAddr != "127.0.0.1:9100" || config.PrometheusRetention != 2*time.Minute {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// RPC TLS
+	input = `{"rpc_tls_cert": "cert.pem", "rpc_tls_key": "key.pem", "rpc_tls_ca": "ca.pem", "rpc_tls_verify_client": true}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.RPCTLSCert != "cert.pem" || config.RPCTLSKey != "key.pem" ||
+		config.RPCTLSCA != "ca.pem" || !config.RPCTLSVerifyClient {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Webhooks
+	input = `{"webhooks": [{"url": "http://127.0.0.1:9000/hook", "events": "member-join,user:deploy",
+		"headers": {"Authorization": "Bearer secret"}, "timeout": "3s", "retries": 2}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(config.Webhooks) != 1 {
+		t.Fatalf("bad: %#v", config)
+	}
+	hook := config.Webhooks[0]
+	if hook.URL != "http://127.0.0.1:9000/hook" || hook.Events != "member-join,user:deploy" ||
+		hook.Headers["Authorization"] != "Bearer secret" || hook.Timeout != 3*time.Second ||
+		hook.Retries != 2 {
+		t.Fatalf("bad: %#v", hook)
+	}
+
+	scripts := config.EventScripts()
+	if len(scripts) != 2 || scripts[0].Webhook != &config.Webhooks[0] ||
+		scripts[1].Name != "deploy" || scripts[1].Script != hook.URL {
+		t.Fatalf("bad: %#v", scripts)
+	}
+
+	input = `{"webhooks": [{"url": "http://127.0.0.1:9000/hook", "timeout": "soon"}]}`
+	if _, err := DecodeConfig(bytes.NewReader([]byte(input))); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// Script handlers
+	input = `{"event_handlers": ["foo.sh", "http://127.0.0.1:9000/hook"], "event_handler_timeout": "30s",
+		"script_handlers": [{"script": "bar.sh", "events": "user:deploy"},
+		{"script": "baz.sh", "timeout": "5m", "format": "json"}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.EventHandlerTimeout != 30*time.Second || len(config.ScriptHandlers) != 2 ||
+		config.ScriptHandlers[1].Timeout != 5*time.Minute {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	scripts = config.EventScripts()
+	expected := []EventScript{
+		{EventFilter{"*", "", nil}, "foo.sh", nil, 30 * time.Second, ""},
+		{EventFilter{"*", "", nil}, "http://127.0.0.1:9000/hook", &WebhookConfig{URL: "http://127.0.0.1:9000/hook"}, 0, ""},
+		{EventFilter{"user", "deploy", nil}, "bar.sh", nil, 30 * time.Second, ""},
+		{EventFilter{"*", "", nil}, "baz.sh", nil, 5 * time.Minute, "json"},
+	}
+	if !reflect.DeepEqual(scripts, expected) {
+		t.Fatalf("bad: %#v", scripts)
+	}
+
+	input = `{"script_handlers": [{"script": "bar.sh", "timeout": "soon"}]}`
+	if _, err := DecodeConfig(bytes.NewReader([]byte(input))); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// Coprocesses
+	input = `{"coprocess_handlers": [{"command": "handler.py", "events": "member-join,query:load"}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(config.Coprocesses) != 1 || config.Coprocesses[0].Command != "handler.py" ||
+		config.Coprocesses[0].Events != "member-join,query:load" {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Templates
+	input = `{"templates": [{"source": "haproxy.cfg.tmpl", "destination": "/etc/haproxy/haproxy.cfg",
+		"command": "service haproxy reload", "debounce": "5s", "timeout": "1m"}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(config.Templates) != 1 {
+		t.Fatalf("bad: %#v", config)
+	}
+	tmpl := config.Templates[0]
+	if tmpl.Source != "haproxy.cfg.tmpl" || tmpl.Destination != "/etc/haproxy/haproxy.cfg" ||
+		tmpl.Command != "service haproxy reload" || tmpl.Debounce != 5*time.Second ||
+		tmpl.Timeout != time.Minute {
+		t.Fatalf("bad: %#v", tmpl)
+	}
+
+	// Checks
+	input = `{"checks": [{"name": "web", "http": "http://localhost:8080/health", "interval": "15s",
+		"timeout": "2s", "leave_after": "5m"}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(config.Checks) != 1 {
+		t.Fatalf("bad: %#v", config)
+	}
+	check := config.Checks[0]
+	if check.Name != "web" || check.HTTP != "http://localhost:8080/health" ||
+		check.Interval != 15*time.Second || check.Timeout != 2*time.Second ||
+		check.LeaveAfter != 5*time.Minute {
+		t.Fatalf("bad: %#v", check)
+	}
+
+	// Cluster name and allowed members
+	input = `{"cluster_name": "prod", "allowed_members": ["web-*", "10.0.0.0/8"]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.ClusterName != "prod" {
+		t.Fatalf("bad: %#v", config)
+	}
+	if !reflect.DeepEqual(config.AllowedMembers, []string{"web-*", "10.0.0.0/8"}) {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Signing key
+	input = `{"signing_key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	key, err := config.SigningPrivateKey()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(key) != 64 {
+		t.Fatalf("bad: %#v", key)
+	}
+	config.SigningKey = "AAAA"
+	if _, err := config.SigningPrivateKey(); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// Event queues
+	input = `{"event_queue_size": 16, "event_handler_concurrency": 4, "event_queue_overflow": "drop-oldest"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if config.EventQueueSize != 16 || config.EventHandlerConcurrency != 4 ||
+		config.EventQueueOverflow != OverflowDropOldest {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Every entry gets its own queue, which script handlers can tune
+	input = `{"event_queue_size": 16, "event_handlers": ["member-join,member-leave=foo.sh", "foo.sh", "foo.sh"],
+		"script_handlers": [{"script": "bar.sh", "events": "user", "queue_size": 4,
+		"concurrency": 2, "queue_overflow": "drop-newest"}]}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	groups := config.EventScriptGroups()
+	names := []string{"member-join,member-leave=foo.sh", "foo.sh", "foo.sh (2)", "user=bar.sh"}
+	if len(groups) != len(names) {
+		t.Fatalf("bad: %#v", groups)
+	}
+	for i, name := range names {
+		if groups[i].Name != name {
+			t.Fatalf("bad: %#v", groups[i])
+		}
+	}
+	if len(groups[0].Scripts) != 2 || groups[0].Queue != (EventQueueConfig{Size: 16}) {
+		t.Fatalf("bad: %#v", groups[0])
+	}
+	if groups[3].Queue != (EventQueueConfig{Size: 4, Concurrency: 2, Overflow: OverflowDropNewest}) {
+		t.Fatalf("bad: %#v", groups[3])
+	}
+}
+
+func TestConfigRPCTLSConfig(t *testing.T) {
+	// Disabled by default
+	c := &Config{}
+	conf, err := c.RPCTLSConfig()
+	if err != nil || conf != nil {
+		t.Fatalf("bad: %v %#v", err, conf)
+	}
+
+	// Client verification without a certificate is an error
+	c = &Config{RPCTLSCA: "ca.pem", RPCTLSVerifyClient: true}
+	if _, err := c.RPCTLSConfig(); err == nil {
+		t.Fatalf("should have err")
+	}
+
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := testTLSFiles(t, dir)
+
+	c = &Config{
+		RPCTLSCert:         files["server-cert"],
+		RPCTLSKey:          files["server-key"],
+		RPCTLSCA:           files["ca"],
+		RPCTLSVerifyClient: true,
+	}
+	conf, err = c.RPCTLSConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(conf.Certificates) != 1 || conf.ClientCAs == nil ||
+		conf.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("bad: %#v", conf)
+	}
 }
 
 func TestDecodeConfig_unknownDirective(t *testing.T) {
@@ -382,6 +661,7 @@ func TestMergeConfig(t *testing.T) {
 		Role:          "bar",
 		Protocol:      7,
 		EventHandlers: []string{"foo"},
+		Webhooks:      []WebhookConfig{{URL: "http://foo"}},
 		StartJoin:     []string{"foo"},
 		ReplayOnJoin:  true,
 		RetryJoin:     []string{"zab"},
@@ -393,6 +673,7 @@ func TestMergeConfig(t *testing.T) {
 		Protocol:               -1,
 		EncryptKey:             "foo",
 		EventHandlers:          []string{"bar"},
+		Webhooks:               []WebhookConfig{{URL: "http://bar"}},
 		StartJoin:              []string{"bar"},
 		LeaveOnTerm:            true,
 		SkipLeaveOnInt:         true,
@@ -506,6 +787,10 @@ func TestMergeConfig(t *testing.T) {
 		t.Fatalf("bad: %#v", c)
 	}
 
+	if len(c.Webhooks) != 2 || c.Webhooks[0].URL != "http://foo" || c.Webhooks[1].URL != "http://bar" {
+		t.Fatalf("bad: %#v", c)
+	}
+
 	expected = []string{"zab", "zip"}
 	if !reflect.DeepEqual(c.RetryJoin, expected) {
 		t.Fatalf("bad: %#v", c)
@@ -0,0 +1,314 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// coprocessMinBackoff and coprocessMaxBackoff bound how long we wait
+	// before restarting a coprocess that exited. The wait doubles after
+	// each exit, and is reset once the process stays up for the maximum.
+	coprocessMinBackoff = time.Second
+	coprocessMaxBackoff = time.Minute
+
+	// maxCoprocessLine limits the length of a line read from a coprocess
+	maxCoprocessLine = 64 * 1024
+)
+
+// CoprocessConfig configures a long-running process that events are
+// streamed to, instead of running a script for every event.
+type CoprocessConfig struct {
+	// Command is run in a shell, like an event script
+	Command string `mapstructure:"command"`
+
+	// Events filters the events sent to the process, using the same
+	// syntax as the event_handlers filters. All events are sent if it
+	// is blank.
+	Events string `mapstructure:"events"`
+}
+
+// coprocessResponse is a line written by a coprocess to respond to a query
+type coprocessResponse struct {
+	ID      uint64 `json:"id"`
+	Payload []byte `json:"payload"`
+}
+
+// CoprocessEventHandler streams the events it receives to a single
+// long-running process as newline-delimited JSON on its stdin. Queries
+// are given an ID, and the process responds to them by writing a line
+// with the ID and a payload to its stdout. The process is restarted with
+// a backoff if it exits.
+type CoprocessEventHandler struct {
+	Command  string
+	Filters  []EventFilter
+	SelfFunc func() serf.Member
+	Logger   *log.Logger
+
+	// l protects the running process and the pending queries
+	l       sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	queries map[uint64]*serf.Query
+	queryID uint64
+
+	// writeLock serializes the events written to stdin
+	writeLock sync.Mutex
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewCoprocessEventHandler returns a handler for the configuration. Start
+// must be called to run the process.
+func NewCoprocessEventHandler(conf CoprocessConfig, selfFunc func() serf.Member,
+	logger *log.Logger) *CoprocessEventHandler {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &CoprocessEventHandler{
+		Command:  conf.Command,
+		Filters:  ParseEventFilter(conf.Events),
+		SelfFunc: selfFunc,
+		Logger:   logger,
+		queries:  make(map[uint64]*serf.Query),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+func (h *CoprocessEventHandler) String() string {
+	return fmt.Sprintf("coprocess: %s", h.Command)
+}
+
+// Start runs the process, restarting it whenever it exits until Stop
+// is called
+func (h *CoprocessEventHandler) Start() {
+	go h.run()
+}
+
+// Stop kills the process and waits for it to exit
+func (h *CoprocessEventHandler) Stop() {
+	close(h.stopCh)
+
+	h.l.Lock()
+	if h.cmd != nil {
+		if err := killProcessGroup(h.cmd); err != nil {
+			h.Logger.Printf("[WARN] agent: Failed to kill coprocess '%s': %v", h.Command, err)
+		}
+	}
+	h.l.Unlock()
+
+	<-h.doneCh
+}
+
+// HandleEvent is used to meet the EventHandler interface. Events that
+// match the filters are written to the process, or dropped if it isn't
+// running.
+func (h *CoprocessEventHandler) HandleEvent(e serf.Event) {
+	e, ok := filterEvent(h.Filters, e)
+	if !ok {
+		return
+	}
+
+	doc, err := newJSONEvent(h.SelfFunc(), e)
+	if err != nil {
+		h.Logger.Printf("[ERR] agent: Failed to encode event for coprocess '%s': %v", h.Command, err)
+		return
+	}
+
+	// Queries are given our own ID for the response
+	h.l.Lock()
+	stdin := h.stdin
+	if query, ok := e.(*serf.Query); ok && stdin != nil {
+		doc.ID = h.registerQuery(query)
+	}
+	h.l.Unlock()
+	if stdin == nil {
+		metrics.IncrCounter([]string{"agent", "coprocess", "dropped"}, 1)
+		h.Logger.Printf("[WARN] agent: Coprocess '%s' isn't running, dropping event: %s",
+			h.Command, e.String())
+		return
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		h.Logger.Printf("[ERR] agent: Failed to encode event for coprocess '%s': %v", h.Command, err)
+		return
+	}
+	line = append(line, '\n')
+
+	h.writeLock.Lock()
+	defer h.writeLock.Unlock()
+	if _, err := stdin.Write(line); err != nil {
+		h.Logger.Printf("[ERR] agent: Failed to send event to coprocess '%s': %v", h.Command, err)
+	}
+}
+
+// registerQuery assigns an ID to a query so the process can respond to it,
+// and forgets any queries that are past their deadline. The lock must
+// be held.
+func (h *CoprocessEventHandler) registerQuery(q *serf.Query) uint64 {
+	now := time.Now()
+	for id, pending := range h.queries {
+		if now.After(pending.Deadline()) {
+			delete(h.queries, id)
+		}
+	}
+
+	h.queryID++
+	h.queries[h.queryID] = q
+	return h.queryID
+}
+
+// respond answers a pending query with a response read from the process
+func (h *CoprocessEventHandler) respond(resp *coprocessResponse) {
+	h.l.Lock()
+	query, ok := h.queries[resp.ID]
+	delete(h.queries, resp.ID)
+	h.l.Unlock()
+
+	if !ok {
+		h.Logger.Printf("[WARN] agent: Coprocess '%s' responded to unknown query %d",
+			h.Command, resp.ID)
+		return
+	}
+	if err := query.Respond(resp.Payload); err != nil {
+		h.Logger.Printf("[WARN] agent: Failed to respond to query '%s': %s",
+			query.String(), err)
+	}
+}
+
+// run keeps the process running until the handler is stopped
+func (h *CoprocessEventHandler) run() {
+	defer close(h.doneCh)
+
+	backoff := coprocessMinBackoff
+	for {
+		start := time.Now()
+		err := h.runOnce()
+
+		select {
+		case <-h.stopCh:
+			return
+		default:
+		}
+
+		if time.Since(start) >= coprocessMaxBackoff {
+			backoff = coprocessMinBackoff
+		}
+		metrics.IncrCounter([]string{"agent", "coprocess", "restart"}, 1)
+		h.Logger.Printf("[ERR] agent: Coprocess '%s' exited, restarting in %v: %v",
+			h.Command, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-h.stopCh:
+			return
+		}
+
+		backoff *= 2
+		if backoff > coprocessMaxBackoff {
+			backoff = coprocessMaxBackoff
+		}
+	}
+}
+
+// runOnce starts the process and waits for it to exit
+func (h *CoprocessEventHandler) runOnce() error {
+	cmd := shellCommand(h.Command)
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// Start the process unless we were stopped in the meantime
+	h.l.Lock()
+	select {
+	case <-h.stopCh:
+		h.l.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		h.l.Unlock()
+		return err
+	}
+	h.cmd = cmd
+	h.stdin = stdin
+	h.l.Unlock()
+	h.Logger.Printf("[INFO] agent: Started coprocess '%s'", h.Command)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		h.readResponses(stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		h.readOutput(stderr)
+	}()
+	wg.Wait()
+	err = cmd.Wait()
+
+	// Forget the process and the queries it didn't answer
+	h.l.Lock()
+	h.cmd = nil
+	h.stdin = nil
+	h.queries = make(map[uint64]*serf.Query)
+	h.l.Unlock()
+	return err
+}
+
+// readResponses reads the query responses written to stdout
+func (h *CoprocessEventHandler) readResponses(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxCoprocessLine)
+	for scanner.Scan() {
+		var resp coprocessResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			h.Logger.Printf("[WARN] agent: Coprocess '%s' wrote an invalid response: %v",
+				h.Command, err)
+			continue
+		}
+		h.respond(&resp)
+	}
+	if err := scanner.Err(); err != nil {
+		h.Logger.Printf("[ERR] agent: Failed to read from coprocess '%s': %v", h.Command, err)
+		io.Copy(ioutil.Discard, r)
+	}
+}
+
+// readOutput logs the lines written to stderr
+func (h *CoprocessEventHandler) readOutput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxCoprocessLine)
+	for scanner.Scan() {
+		h.Logger.Printf("[INFO] agent: Coprocess '%s': %s", h.Command, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		io.Copy(ioutil.Discard, r)
+	}
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+)
+
+// coprocessQueryScript responds "ok" to every query it is sent
+const coprocessQueryScript = `while read line; do
+	id=$(echo "$line" | sed -n 's/.*"id":\([0-9]*\).*/\1/p')
+	if [ -n "$id" ]; then
+		echo "{\"id\":$id,\"payload\":\"b2s=\"}"
+	fi
+done`
+
+// readCoprocessEvents waits for n events to be written to the results
+// file by a coprocess
+func readCoprocessEvents(t *testing.T, results string, n int) []*jsonEvent {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var events []*jsonEvent
+		fh, err := os.Open(results)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			var event jsonEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			events = append(events, &event)
+		}
+		fh.Close()
+
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout, got %d events", len(events))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// testResultFile creates an empty file for a coprocess to write to
+func testResultFile(t *testing.T) string {
+	fh, err := ioutil.TempFile("", "serf-result")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fh.Close()
+	return fh.Name()
+}
+
+func testCoprocess(t *testing.T, command string, events string) *CoprocessEventHandler {
+	h := NewCoprocessEventHandler(CoprocessConfig{Command: command, Events: events},
+		func() serf.Member { return serf.Member{Name: "ourname"} },
+		testutil.TestLogger(t))
+	h.Start()
+
+	// Wait for the process to start
+	for i := 0; i < 100; i++ {
+		h.l.Lock()
+		running := h.stdin != nil
+		h.l.Unlock()
+		if running {
+			return h
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("coprocess didn't start")
+	return nil
+}
+
+func TestCoprocessEventHandler(t *testing.T) {
+	results := testResultFile(t)
+	defer os.Remove(results)
+	h := testCoprocess(t, "cat >>"+results, "member-join,user:deploy")
+	defer h.Stop()
+
+	h.HandleEvent(serf.MemberEvent{
+		Type: serf.EventMemberJoin,
+		Members: []serf.Member{
+			{
+				Name:   "foo",
+				Addr:   net.ParseIP("1.2.3.4"),
+				Port:   7946,
+				Tags:   map[string]string{"role": "web"},
+				Status: serf.StatusAlive,
+			},
+		},
+	})
+	h.HandleEvent(serf.UserEvent{Name: "restart"})
+	h.HandleEvent(serf.UserEvent{LTime: 42, Name: "deploy", Payload: []byte("v1.2.3")})
+
+	events := readCoprocessEvents(t, results, 2)
+	if len(events) != 2 {
+		t.Fatalf("bad: %#v", events)
+	}
+	if events[0].Event != "member-join" || events[0].Self.Name != "ourname" ||
+		len(events[0].Members) != 1 || events[0].Members[0].Tags["role"] != "web" {
+		t.Fatalf("bad: %#v", events[0])
+	}
+	if events[1].Event != "user" || events[1].Name != "deploy" || events[1].LTime != 42 ||
+		string(events[1].Payload) != "v1.2.3" {
+		t.Fatalf("bad: %#v", events[1])
+	}
+}
+
+func TestCoprocessEventHandler_restart(t *testing.T) {
+	results := testResultFile(t)
+	defer os.Remove(results)
+
+	// The process exits after every event
+	h := testCoprocess(t, fmt.Sprintf("read line; echo \"$line\" >>%s", results), "")
+	defer h.Stop()
+
+	h.HandleEvent(serf.UserEvent{Name: "first"})
+	readCoprocessEvents(t, results, 1)
+
+	// Wait for it to be restarted
+	time.Sleep(coprocessMinBackoff + 500*time.Millisecond)
+	h.HandleEvent(serf.UserEvent{Name: "second"})
+
+	events := readCoprocessEvents(t, results, 2)
+	if events[0].Name != "first" || events[1].Name != "second" {
+		t.Fatalf("bad: %#v", events)
+	}
+}
+
+func TestCoprocessEventHandler_stop(t *testing.T) {
+	h := testCoprocess(t, "sleep 10", "")
+
+	doneCh := make(chan struct{})
+	go func() {
+		h.Stop()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	if h.cmd != nil || h.stdin != nil {
+		t.Fatalf("should not be running")
+	}
+}
+
+func TestAgent_coprocessQuery(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+
+	h := testCoprocess(t, coprocessQueryScript, "query:deploy")
+	defer h.Stop()
+	h.SelfFunc = func() serf.Member { return a1.Serf().LocalMember() }
+	a1.RegisterEventHandler(h)
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, err := a1.Query("deploy", nil, &serf.QueryParam{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case r := <-resp.ResponseCh():
+		if r.From != a1.conf.NodeName || string(r.Payload) != "ok" {
+			t.Fatalf("bad: %#v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	if len(h.queries) != 0 {
+		t.Fatalf("bad: %#v", h.queries)
+	}
+}
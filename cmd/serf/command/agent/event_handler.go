@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/serf/serf"
 )
@@ -16,15 +17,19 @@ type EventHandler interface {
 }
 
 // ScriptEventHandler invokes scripts for the events that it receives.
+// Name, if set, is what the handler's queue is reported under.
 type ScriptEventHandler struct {
 	SelfFunc func() serf.Member
 	Scripts  []EventScript
 	Logger   *log.Logger
+	Name     string
 
 	scriptLock sync.Mutex
 	newScripts []EventScript
 }
 
+// HandleEvent is used to meet the EventHandler interface. It may be called
+// concurrently if the agent runs more than one worker per handler.
 func (h *ScriptEventHandler) HandleEvent(e serf.Event) {
 	// Swap in the new scripts if any
 	h.scriptLock.Lock()
@@ -32,26 +37,40 @@ func (h *ScriptEventHandler) HandleEvent(e serf.Event) {
 		h.Scripts = h.newScripts
 		h.newScripts = nil
 	}
-	h.scriptLock.Unlock()
-
 	if h.Logger == nil {
 		h.Logger = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	scripts := h.Scripts
+	logger := h.Logger
+	h.scriptLock.Unlock()
 
 	self := h.SelfFunc()
-	for _, script := range h.Scripts {
-		if !script.Invoke(e) {
+	for _, script := range scripts {
+		event, ok := script.Filter(e)
+		if !ok {
 			continue
 		}
 
-		err := invokeEventScript(h.Logger, script.Script, self, e)
+		var err error
+		if script.Webhook != nil {
+			err = invokeWebhook(logger, script.Webhook, self, event)
+		} else {
+			err = invokeEventScript(logger, &script, self, event)
+		}
 		if err != nil {
-			h.Logger.Printf("[ERR] agent: Error invoking script '%s': %s",
+			logger.Printf("[ERR] agent: Error invoking script '%s': %s",
 				script.Script, err)
 		}
 	}
 }
 
+func (h *ScriptEventHandler) String() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return "scripts"
+}
+
 // UpdateScripts is used to safely update the scripts we invoke in
 // a thread safe manner
 func (h *ScriptEventHandler) UpdateScripts(scripts []EventScript) {
@@ -60,15 +79,71 @@ func (h *ScriptEventHandler) UpdateScripts(scripts []EventScript) {
 	h.newScripts = scripts
 }
 
-// EventFilter is used to filter which events are processed
+// EventFilter is used to filter which events are processed. Name may be
+// a glob, and matches the names that don't fit it if it starts with "!".
+// Member events are narrowed down to the members that match all the Tags.
 type EventFilter struct {
 	Event string
 	Name  string
+	Tags  []TagFilter
+}
+
+// TagFilter matches a member's tag against a glob, or matches the members
+// that don't fit it if Negate is set. A member without the tag never fits.
+type TagFilter struct {
+	Tag    string
+	Value  string
+	Negate bool
+}
+
+// Match checks a member's tags against the filter
+func (t *TagFilter) Match(tags map[string]string) bool {
+	value, ok := tags[t.Tag]
+	return (ok && globMatch(t.Value, value)) != t.Negate
+}
+
+func (t *TagFilter) String() string {
+	if t.Negate {
+		return fmt.Sprintf("%s!=%s", t.Tag, t.Value)
+	}
+	return fmt.Sprintf("%s=%s", t.Tag, t.Value)
 }
 
 // Invoke tests whether or not this event script should be invoked
 // for the given Serf event.
 func (s *EventFilter) Invoke(e serf.Event) bool {
+	_, ok := s.Filter(e)
+	return ok
+}
+
+// Filter tests whether the event matches, and returns the event that
+// should be handled. For member events with tag filters, this is a copy
+// of the event with only the matching members.
+func (s *EventFilter) Filter(e serf.Event) (serf.Event, bool) {
+	if !s.matchEvent(e) {
+		return nil, false
+	}
+
+	me, ok := e.(serf.MemberEvent)
+	if !ok || len(s.Tags) == 0 {
+		return e, true
+	}
+
+	var members []serf.Member
+	for _, m := range me.Members {
+		if s.matchTags(m.Tags) {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		return nil, false
+	}
+	me.Members = members
+	return me, true
+}
+
+// matchEvent checks the event type and the user event or query name
+func (s *EventFilter) matchEvent(e serf.Event) bool {
 	if s.Event == "*" {
 		return true
 	}
@@ -83,7 +158,7 @@ func (s *EventFilter) Invoke(e serf.Event) bool {
 			return false
 		}
 
-		if userE.Name != s.Name {
+		if !matchName(s.Name, userE.Name) {
 			return false
 		}
 	}
@@ -94,7 +169,7 @@ func (s *EventFilter) Invoke(e serf.Event) bool {
 			return false
 		}
 
-		if query.Name != s.Name {
+		if !matchName(s.Name, query.Name) {
 			return false
 		}
 	}
@@ -102,6 +177,16 @@ func (s *EventFilter) Invoke(e serf.Event) bool {
 	return true
 }
 
+// matchTags checks a member's tags against all the tag filters
+func (s *EventFilter) matchTags(tags map[string]string) bool {
+	for _, t := range s.Tags {
+		if !t.Match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
 // Valid checks if this is a valid agent event script.
 func (s *EventFilter) Valid() bool {
 	switch s.Event {
@@ -110,40 +195,155 @@ func (s *EventFilter) Valid() bool {
 	case "member-failed":
 	case "member-update":
 	case "member-reap":
+	case "member-maint":
+	case "member-flap":
 	case "user":
 	case "query":
 	case "*":
 	default:
 		return false
 	}
+
+	// Only members have tags to filter on
+	if len(s.Tags) > 0 && !strings.HasPrefix(s.Event, "member-") {
+		return false
+	}
 	return true
 }
 
+func (s *EventFilter) String() string {
+	filter := s.Event
+	if s.Name != "" {
+		filter += ":" + s.Name
+	}
+	if len(s.Tags) > 0 {
+		tags := make([]string, 0, len(s.Tags))
+		for _, t := range s.Tags {
+			tags = append(tags, t.String())
+		}
+		filter += "[" + strings.Join(tags, ",") + "]"
+	}
+	return filter
+}
+
+// filterEvent applies a list of filters to an event, returning the event
+// to handle if any of them match. Member events are narrowed down to the
+// members matched by at least one of the filters.
+func filterEvent(filters []EventFilter, e serf.Event) (serf.Event, bool) {
+	me, ok := e.(serf.MemberEvent)
+	if !ok {
+		for _, f := range filters {
+			if f.Invoke(e) {
+				return e, true
+			}
+		}
+		return nil, false
+	}
+
+	var members []serf.Member
+	for _, m := range me.Members {
+		for _, f := range filters {
+			if f.matchEvent(e) && f.matchTags(m.Tags) {
+				members = append(members, m)
+				break
+			}
+		}
+	}
+	if len(members) == 0 {
+		return nil, false
+	}
+	me.Members = members
+	return me, true
+}
+
+// matchName matches a name, such as a user event name or a tag value,
+// against a glob, which is negated if it starts with "!"
+func matchName(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "!") {
+		return !globMatch(pattern[1:], name)
+	}
+	return globMatch(pattern, name)
+}
+
+// globMatch matches a string against a pattern in which "*" matches any
+// run of characters, including none
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
+
 // EventScript is a single event script that will be executed in the
 // case of an event, and is configured from the command-line or from
-// a configuration file.
+// a configuration file. If Webhook is set, the event is POSTed to it
+// and Script holds its URL. Otherwise the script is killed if it runs
+// longer than Timeout, and is given the event on stdin in the Format.
 type EventScript struct {
 	EventFilter
-	Script string
+	Script  string
+	Webhook *WebhookConfig
+	Timeout time.Duration
+	Format  string
+}
+
+// ScriptHandlerConfig configures an event script with its own settings,
+// as an alternative to the "type=script" syntax.
+type ScriptHandlerConfig struct {
+	// Script is the command to run
+	Script string `mapstructure:"script"`
+
+	// Events filters the events the script is run for, using the same
+	// syntax as the event_handlers filters. The script is run for all
+	// events if it is blank.
+	Events string `mapstructure:"events"`
+
+	// TimeoutRaw is the string timeout for the script, and Timeout is the
+	// parsed value. It overrides the agent's event_handler_timeout.
+	TimeoutRaw string        `mapstructure:"timeout"`
+	Timeout    time.Duration `mapstructure:"-"`
+
+	// Format is how the event is written to the script's stdin, either
+	// ScriptFormatText or ScriptFormatJSON. The default is text.
+	Format string `mapstructure:"format"`
+
+	// QueueSize, Concurrency and QueueOverflow override the agent's
+	// event_queue_size, event_handler_concurrency and event_queue_overflow
+	// for the script's queue when they are set.
+	QueueSize     int    `mapstructure:"queue_size"`
+	Concurrency   int    `mapstructure:"concurrency"`
+	QueueOverflow string `mapstructure:"queue_overflow"`
 }
 
 func (s *EventScript) String() string {
-	if s.Name != "" {
-		return fmt.Sprintf("Event '%s:%s' invoking '%s'", s.Event, s.Name, s.Script)
-	}
-	return fmt.Sprintf("Event '%s' invoking '%s'", s.Event, s.Script)
+	return fmt.Sprintf("Event '%s' invoking '%s'", s.EventFilter.String(), s.Script)
 }
 
 // ParseEventScript takes a string in the format of "type=script" and
-// parses it into an EventScript struct, if it can.
+// parses it into an EventScript struct, if it can. A script that is an
+// http:// or https:// URL is treated as a webhook with default settings.
 func ParseEventScript(v string) []EventScript {
 	var filter, script string
-	parts := strings.SplitN(v, "=", 2)
-	if len(parts) == 1 {
-		script = parts[0]
+	if i := filterEnd(v); i < 0 {
+		script = v
 	} else {
-		filter = parts[0]
-		script = parts[1]
+		filter = v[:i]
+		script = v[i+1:]
 	}
 
 	filters := ParseEventFilter(filter)
@@ -153,37 +353,123 @@ func ParseEventScript(v string) []EventScript {
 			EventFilter: filt,
 			Script:      script,
 		}
+		if isWebhookURL(script) {
+			result.Webhook = &WebhookConfig{URL: script}
+		}
 		results = append(results, result)
 	}
 	return results
 }
 
 // ParseEventFilter a string with the event type filters and
-// parses it into a series of EventFilters if it can.
+// parses it into a series of EventFilters if it can. Filters are
+// separated by commas, and a filter is an event type, optionally
+// followed by ":name" for user events and queries or by a list of tag
+// expressions such as "[role=web,dc!=us-*]" for member events.
 func ParseEventFilter(v string) []EventFilter {
 	// No filter translates to stream all
 	if v == "" {
 		v = "*"
 	}
 
-	events := strings.Split(v, ",")
+	events := splitEventFilters(v)
 	results := make([]EventFilter, 0, len(events))
 	for _, event := range events {
-		var result EventFilter
-		var name string
+		results = append(results, parseEventFilter(event))
+	}
 
-		if strings.HasPrefix(event, "user:") {
-			name = event[len("user:"):]
-			event = "user"
-		} else if strings.HasPrefix(event, "query:") {
-			name = event[len("query:"):]
-			event = "query"
+	return results
+}
+
+// filterEnd returns the index of the "=" separating the filters from the
+// script, skipping those within tag expressions, or -1 if there isn't one
+func filterEnd(v string) int {
+	depth := 0
+	for i, c := range v {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '=':
+			if depth == 0 {
+				return i
+			}
 		}
+	}
+	return -1
+}
 
-		result.Event = event
-		result.Name = name
-		results = append(results, result)
+// splitEventFilters splits a list of filters on the commas that aren't
+// within a tag expression
+func splitEventFilters(v string) []string {
+	var filters []string
+	depth, start := 0, 0
+	for i, c := range v {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				filters = append(filters, v[start:i])
+				start = i + 1
+			}
+		}
 	}
+	return append(filters, v[start:])
+}
 
-	return results
+// parseEventFilter parses a single filter. A malformed filter keeps the
+// whole string as its event type, so it fails Valid.
+func parseEventFilter(event string) EventFilter {
+	var result EventFilter
+
+	if i := strings.Index(event, "["); i >= 0 {
+		if !strings.HasSuffix(event, "]") {
+			return EventFilter{Event: event}
+		}
+		tags, err := parseTagFilters(event[i+1 : len(event)-1])
+		if err != nil {
+			return EventFilter{Event: event}
+		}
+		result.Tags = tags
+		event = event[:i]
+	}
+
+	if strings.HasPrefix(event, "user:") {
+		result.Name = event[len("user:"):]
+		event = "user"
+	} else if strings.HasPrefix(event, "query:") {
+		result.Name = event[len("query:"):]
+		event = "query"
+	}
+
+	result.Event = event
+	return result
+}
+
+// parseTagFilters parses a comma separated list of "tag=glob" and
+// "tag!=glob" expressions
+func parseTagFilters(v string) ([]TagFilter, error) {
+	exprs := strings.Split(v, ",")
+	results := make([]TagFilter, 0, len(exprs))
+	for _, expr := range exprs {
+		var result TagFilter
+		parts := strings.SplitN(expr, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid tag expression '%s'", expr)
+		}
+		result.Tag, result.Value = parts[0], parts[1]
+		if strings.HasSuffix(result.Tag, "!") {
+			result.Tag = strings.TrimSuffix(result.Tag, "!")
+			result.Negate = true
+		}
+		if result.Tag == "" {
+			return nil, fmt.Errorf("invalid tag expression '%s'", expr)
+		}
+		results = append(results, result)
+	}
+	return results, nil
 }
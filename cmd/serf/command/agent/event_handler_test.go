@@ -1,13 +1,21 @@
 package agent
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
 )
 
 const eventScript = `#!/bin/sh
@@ -44,6 +52,13 @@ while read line; do
 done
 `
 
+const slowScript = `#!/bin/sh
+RESULT_FILE="%s"
+(sleep 1; echo child >>${RESULT_FILE}) &
+sleep 10
+echo parent >>${RESULT_FILE}
+`
+
 // testEventScript creates an event script that can be used with the
 // agent. It returns the path to the event script itself and a path to
 // the file that will contain the events that that script receives.
@@ -120,6 +135,39 @@ func TestScriptEventHandler(t *testing.T) {
 	}
 }
 
+func TestScriptEventHandler_tagFilter(t *testing.T) {
+	script, results := testEventScript(t, "#!/bin/sh\ncat >>%s\n")
+
+	h := &ScriptEventHandler{
+		SelfFunc: func() serf.Member { return serf.Member{Name: "ourname"} },
+		Scripts:  ParseEventScript("member-join[role=web]=" + script),
+	}
+
+	h.HandleEvent(serf.MemberEvent{
+		Type: serf.EventMemberJoin,
+		Members: []serf.Member{
+			{Name: "foo", Addr: net.ParseIP("1.2.3.4"), Tags: map[string]string{"role": "web"}},
+			{Name: "bar", Addr: net.ParseIP("1.2.3.5"), Tags: map[string]string{"role": "db"}},
+		},
+	})
+
+	// No members match, so the script isn't run
+	h.HandleEvent(serf.MemberEvent{
+		Type: serf.EventMemberJoin,
+		Members: []serf.Member{
+			{Name: "baz", Addr: net.ParseIP("1.2.3.6"), Tags: map[string]string{"role": "db"}},
+		},
+	})
+
+	result, err := ioutil.ReadFile(results)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(result) != "foo\t1.2.3.4\tweb\trole=web\n" {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
 func TestScriptUserEventHandler(t *testing.T) {
 	script, results := testEventScript(t, userEventScript)
 
@@ -199,6 +247,254 @@ func TestScriptQueryEventHandler(t *testing.T) {
 	}
 }
 
+func TestScriptEventHandler_timeout(t *testing.T) {
+	script, results := testEventScript(t, slowScript)
+
+	h := &ScriptEventHandler{
+		SelfFunc: func() serf.Member {
+			return serf.Member{Name: "ourname"}
+		},
+		Scripts: []EventScript{
+			{
+				EventFilter: EventFilter{
+					Event: "*",
+				},
+				Script:  script,
+				Timeout: 200 * time.Millisecond,
+			},
+		},
+	}
+
+	start := time.Now()
+	h.HandleEvent(serf.UserEvent{Name: "deploy"})
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("script ran for %v", d)
+	}
+
+	// Neither the script nor the process it started should get to write
+	time.Sleep(1500 * time.Millisecond)
+	result, err := ioutil.ReadFile(results)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(result) != 0 {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
+func TestScriptEventHandler_timeoutHeldOutput(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not found")
+	}
+	defer func(d time.Duration) { killWaitTimeout = d }(killWaitTimeout)
+	killWaitTimeout = 200 * time.Millisecond
+
+	// The sleep started with setsid isn't killed with the script, and
+	// holds its output open
+	h := &ScriptEventHandler{
+		SelfFunc: func() serf.Member {
+			return serf.Member{Name: "ourname"}
+		},
+		Scripts: []EventScript{
+			{
+				EventFilter: EventFilter{
+					Event: "*",
+				},
+				Script:  "setsid sleep 5 & sleep 10",
+				Timeout: 100 * time.Millisecond,
+			},
+		},
+	}
+
+	start := time.Now()
+	h.HandleEvent(serf.UserEvent{Name: "deploy"})
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("script ran for %v", d)
+	}
+}
+
+func TestInvokeLabels(t *testing.T) {
+	failed := exec.Command("/bin/sh", "-c", "exit 3").Run()
+	testCases := []struct {
+		err      error
+		timedOut bool
+		outcome  string
+		exitCode string
+	}{
+		{nil, false, "success", "0"},
+		{failed, false, "failed", "3"},
+		{fmt.Errorf("exec: not found"), false, "error", "-1"},
+		{fmt.Errorf("timed out"), true, "timeout", "-1"},
+	}
+
+	for _, tc := range testCases {
+		labels := invokeLabels(tc.err, tc.timedOut)
+		if labels[0].Value != tc.outcome || labels[1].Value != tc.exitCode {
+			t.Fatalf("bad: %v %#v", tc.err, labels)
+		}
+	}
+}
+
+func TestScriptEventHandler_jsonFormat(t *testing.T) {
+	script, results := testEventScript(t, "#!/bin/sh\ncat >%s\n")
+
+	h := &ScriptEventHandler{
+		SelfFunc: func() serf.Member {
+			return serf.Member{Name: "ourname"}
+		},
+		Scripts: []EventScript{
+			{
+				EventFilter: EventFilter{
+					Event: "*",
+				},
+				Script: script,
+				Format: ScriptFormatJSON,
+			},
+		},
+	}
+
+	h.HandleEvent(serf.MemberEvent{
+		Type: serf.EventMemberJoin,
+		Members: []serf.Member{
+			{
+				Name:        "foo",
+				Addr:        net.ParseIP("1.2.3.4"),
+				Port:        7946,
+				Tags:        map[string]string{"role": "web\tserver", "a=b": "c"},
+				Status:      serf.StatusAlive,
+				ProtocolMax: 5,
+				DelegateCur: 4,
+			},
+		},
+	})
+
+	result, err := ioutil.ReadFile(results)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var doc jsonEvent
+	if err := json.Unmarshal(result, &doc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if doc.Event != "member-join" || doc.Self.Name != "ourname" || len(doc.Members) != 1 {
+		t.Fatalf("bad: %#v", doc)
+	}
+	m := doc.Members[0]
+	if m.Name != "foo" || m.Addr != "1.2.3.4" || m.Port != 7946 || m.Status != "alive" ||
+		m.Tags["role"] != "web\tserver" || m.Tags["a=b"] != "c" ||
+		m.Protocol["version"] != 4 || m.MemberlistProtocol["max"] != 5 {
+		t.Fatalf("bad: %#v", m)
+	}
+}
+
+func TestAgent_scriptQuery(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+
+	// The env script responds with the query's source and deadline, and
+	// the JSON script responds with the event it was given
+	a1.RegisterEventHandler(&ScriptEventHandler{
+		SelfFunc: func() serf.Member { return a1.Serf().LocalMember() },
+		Scripts: []EventScript{
+			{
+				EventFilter: EventFilter{Event: "query", Name: "env"},
+				Script:      `echo "$SERF_QUERY_SOURCE $SERF_QUERY_DEADLINE"`,
+			},
+			{
+				EventFilter: EventFilter{Event: "query", Name: "json"},
+				Script:      "cat",
+				Format:      ScriptFormatJSON,
+			},
+		},
+		Logger: testutil.TestLogger(t),
+	})
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	query := func(name string) []byte {
+		resp, err := a1.Query(name, []byte("foo"), &serf.QueryParam{Timeout: 2 * time.Second})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		select {
+		case r := <-resp.ResponseCh():
+			return r.Payload
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout")
+		}
+		return nil
+	}
+
+	env := strings.Fields(string(query("env")))
+	if len(env) != 2 || env[0] != a1.conf.NodeName {
+		t.Fatalf("bad: %v", env)
+	}
+	deadline, err := strconv.ParseInt(env[1], 10, 64)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if d := time.Until(time.Unix(deadline, 0)); d < 0 || d > 3*time.Second {
+		t.Fatalf("bad: %v", d)
+	}
+
+	var doc jsonEvent
+	if err := json.Unmarshal(query("json"), &doc); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if doc.Event != "query" || doc.Name != "json" || string(doc.Payload) != "foo" ||
+		doc.ID == 0 || doc.Source != a1.conf.NodeName || doc.Deadline == nil {
+		t.Fatalf("bad: %#v", doc)
+	}
+}
+
+func TestAgent_scriptQuerySigned(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	agentConfig := DefaultConfig()
+	agentConfig.SigningKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	key, err := agentConfig.SigningPrivateKey()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serfConfig := serf.DefaultConfig()
+	serfConfig.SigningKey = key
+	a1 := testAgentWithConfig(t, ip1, agentConfig, serfConfig, nil)
+	defer a1.Shutdown()
+
+	a1.RegisterEventHandler(&ScriptEventHandler{
+		SelfFunc: func() serf.Member { return a1.Serf().LocalMember() },
+		Scripts: []EventScript{
+			{
+				EventFilter: EventFilter{Event: "query", Name: "env"},
+				Script:      `echo "$SERF_QUERY_VERIFIED"`,
+			},
+		},
+		Logger: testutil.TestLogger(t),
+	})
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, err := a1.Query("env", nil, &serf.QueryParam{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case r := <-resp.ResponseCh():
+		if strings.TrimSpace(string(r.Payload)) != "true" || !r.Verified {
+			t.Fatalf("bad: %#v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
 func TestEventScriptInvoke(t *testing.T) {
 	testCases := []struct {
 		script EventScript
@@ -206,55 +502,80 @@ func TestEventScriptInvoke(t *testing.T) {
 		invoke bool
 	}{
 		{
-			EventScript{EventFilter{"*", ""}, "script.sh"},
+			EventScript{EventFilter{"*", "", nil}, "script.sh", nil, 0, ""},
 			serf.MemberEvent{},
 			true,
 		},
 		{
-			EventScript{EventFilter{"user", ""}, "script.sh"},
+			EventScript{EventFilter{"user", "", nil}, "script.sh", nil, 0, ""},
 			serf.MemberEvent{},
 			false,
 		},
 		{
-			EventScript{EventFilter{"user", "deploy"}, "script.sh"},
+			EventScript{EventFilter{"user", "deploy", nil}, "script.sh", nil, 0, ""},
 			serf.UserEvent{Name: "deploy"},
 			true,
 		},
 		{
-			EventScript{EventFilter{"user", "deploy"}, "script.sh"},
+			EventScript{EventFilter{"user", "deploy", nil}, "script.sh", nil, 0, ""},
 			serf.UserEvent{Name: "restart"},
 			false,
 		},
 		{
-			EventScript{EventFilter{"member-join", ""}, "script.sh"},
+			EventScript{EventFilter{"member-join", "", nil}, "script.sh", nil, 0, ""},
 			serf.MemberEvent{Type: serf.EventMemberJoin},
 			true,
 		},
 		{
-			EventScript{EventFilter{"member-join", ""}, "script.sh"},
+			EventScript{EventFilter{"member-join", "", nil}, "script.sh", nil, 0, ""},
 			serf.MemberEvent{Type: serf.EventMemberLeave},
 			false,
 		},
 		{
-			EventScript{EventFilter{"member-reap", ""}, "script.sh"},
+			EventScript{EventFilter{"member-reap", "", nil}, "script.sh", nil, 0, ""},
 			serf.MemberEvent{Type: serf.EventMemberReap},
 			true,
 		},
 		{
-			EventScript{EventFilter{"query", "deploy"}, "script.sh"},
+			EventScript{EventFilter{"query", "deploy", nil}, "script.sh", nil, 0, ""},
 			&serf.Query{Name: "deploy"},
 			true,
 		},
 		{
-			EventScript{EventFilter{"query", "uptime"}, "script.sh"},
+			EventScript{EventFilter{"query", "uptime", nil}, "script.sh", nil, 0, ""},
 			&serf.Query{Name: "deploy"},
 			false,
 		},
 		{
-			EventScript{EventFilter{"query", ""}, "script.sh"},
+			EventScript{EventFilter{"query", "", nil}, "script.sh", nil, 0, ""},
 			&serf.Query{Name: "deploy"},
 			true,
 		},
+		{
+			EventScript{EventFilter{"user", "deploy-*", nil}, "script.sh", nil, 0, ""},
+			serf.UserEvent{Name: "deploy-web"},
+			true,
+		},
+		{
+			EventScript{EventFilter{"user", "deploy-*", nil}, "script.sh", nil, 0, ""},
+			serf.UserEvent{Name: "restart-web"},
+			false,
+		},
+		{
+			EventScript{EventFilter{"user", "!deploy-*", nil}, "script.sh", nil, 0, ""},
+			serf.UserEvent{Name: "restart-web"},
+			true,
+		},
+		{
+			EventScript{EventFilter{"query", "!uptime", nil}, "script.sh", nil, 0, ""},
+			&serf.Query{Name: "uptime"},
+			false,
+		},
+		{
+			EventScript{EventFilter{"member-join", "", []TagFilter{{"role", "web", false}}}, "script.sh", nil, 0, ""},
+			serf.MemberEvent{Type: serf.EventMemberJoin, Members: []serf.Member{{Tags: map[string]string{"role": "db"}}}},
+			false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -275,6 +596,7 @@ func TestEventScriptValid(t *testing.T) {
 		{"member-failed", true},
 		{"member-update", true},
 		{"member-reap", true},
+		{"member-flap", true},
 		{"user", true},
 		{"User", false},
 		{"member", false},
@@ -291,6 +613,107 @@ func TestEventScriptValid(t *testing.T) {
 	}
 }
 
+func TestEventFilterValid(t *testing.T) {
+	testCases := []struct {
+		Filter string
+		Valid  bool
+	}{
+		{"member-join[role=web]", true},
+		{"member-failed[dc=us-east-*,role!=db]", true},
+		{"user:deploy-*", true},
+		{"query:!uptime", true},
+		{"user[role=web]", false},
+		{"*[role=web]", false},
+		{"member-join[role]", false},
+		{"member-join[=web]", false},
+		{"member-join[role=web", false},
+	}
+
+	for _, tc := range testCases {
+		filters := ParseEventFilter(tc.Filter)
+		if len(filters) != 1 || filters[0].Valid() != tc.Valid {
+			t.Errorf("bad: %#v %#v", tc, filters)
+		}
+	}
+}
+
+func TestEventFilter_tags(t *testing.T) {
+	web := serf.Member{Name: "web", Tags: map[string]string{"role": "web", "dc": "us-east-1"}}
+	db := serf.Member{Name: "db", Tags: map[string]string{"role": "db", "dc": "us-east-2"}}
+	other := serf.Member{Name: "other", Tags: map[string]string{"dc": "eu-west-1"}}
+	event := serf.MemberEvent{
+		Type:    serf.EventMemberJoin,
+		Members: []serf.Member{web, db, other},
+	}
+
+	testCases := []struct {
+		filter  string
+		members []string
+	}{
+		{"member-join", []string{"web", "db", "other"}},
+		{"member-join[role=web]", []string{"web"}},
+		{"member-join[dc=us-east-*]", []string{"web", "db"}},
+		{"member-join[dc=us-east-*,role!=web]", []string{"db"}},
+		{"member-join[role!=web]", []string{"db", "other"}},
+		{"member-join[role=web],member-join[dc=eu-*]", []string{"web", "other"}},
+		{"member-join[role=cache]", nil},
+		{"member-leave[role=web]", nil},
+	}
+
+	for _, tc := range testCases {
+		filtered, ok := filterEvent(ParseEventFilter(tc.filter), event)
+		if ok != (tc.members != nil) {
+			t.Errorf("bad: %s %v", tc.filter, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		var members []string
+		for _, m := range filtered.(serf.MemberEvent).Members {
+			members = append(members, m.Name)
+		}
+		if !reflect.DeepEqual(members, tc.members) {
+			t.Errorf("bad: %s %v", tc.filter, members)
+		}
+	}
+
+	// The original event is left alone
+	if len(event.Members) != 3 {
+		t.Fatalf("bad: %#v", event)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"", "", true},
+		{"deploy", "deploy", true},
+		{"deploy", "deploy-web", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"deploy-*", "deploy-web", true},
+		{"deploy-*", "deploy", false},
+		{"*-web", "deploy-web", true},
+		{"us-*-1", "us-east-1", true},
+		{"us-*-1", "us-east-2", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+	}
+
+	for _, tc := range testCases {
+		if globMatch(tc.pattern, tc.s) != tc.match {
+			t.Errorf("bad: %#v", tc)
+		}
+	}
+}
+
 func TestParseEventScript(t *testing.T) {
 	testCases := []struct {
 		v       string
@@ -300,51 +723,60 @@ func TestParseEventScript(t *testing.T) {
 		{
 			"script.sh",
 			false,
-			[]EventScript{{EventFilter{"*", ""}, "script.sh"}},
+			[]EventScript{{EventFilter{"*", "", nil}, "script.sh", nil, 0, ""}},
 		},
 
 		{
 			"member-join=script.sh",
 			false,
-			[]EventScript{{EventFilter{"member-join", ""}, "script.sh"}},
+			[]EventScript{{EventFilter{"member-join", "", nil}, "script.sh", nil, 0, ""}},
 		},
 
 		{
 			"foo,bar=script.sh",
 			false,
 			[]EventScript{
-				{EventFilter{"foo", ""}, "script.sh"},
-				{EventFilter{"bar", ""}, "script.sh"},
+				{EventFilter{"foo", "", nil}, "script.sh", nil, 0, ""},
+				{EventFilter{"bar", "", nil}, "script.sh", nil, 0, ""},
 			},
 		},
 
 		{
 			"user:deploy=script.sh",
 			false,
-			[]EventScript{{EventFilter{"user", "deploy"}, "script.sh"}},
+			[]EventScript{{EventFilter{"user", "deploy", nil}, "script.sh", nil, 0, ""}},
 		},
 
 		{
 			"foo,user:blah,bar,query:tubez=script.sh",
 			false,
 			[]EventScript{
-				{EventFilter{"foo", ""}, "script.sh"},
-				{EventFilter{"user", "blah"}, "script.sh"},
-				{EventFilter{"bar", ""}, "script.sh"},
-				{EventFilter{"query", "tubez"}, "script.sh"},
+				{EventFilter{"foo", "", nil}, "script.sh", nil, 0, ""},
+				{EventFilter{"user", "blah", nil}, "script.sh", nil, 0, ""},
+				{EventFilter{"bar", "", nil}, "script.sh", nil, 0, ""},
+				{EventFilter{"query", "tubez", nil}, "script.sh", nil, 0, ""},
 			},
 		},
 
 		{
 			"query:load=script.sh",
 			false,
-			[]EventScript{{EventFilter{"query", "load"}, "script.sh"}},
+			[]EventScript{{EventFilter{"query", "load", nil}, "script.sh", nil, 0, ""}},
 		},
 
 		{
 			"query=script.sh",
 			false,
-			[]EventScript{{EventFilter{"query", ""}, "script.sh"}},
+			[]EventScript{{EventFilter{"query", "", nil}, "script.sh", nil, 0, ""}},
+		},
+
+		{
+			"member-join[role=web,dc=us-*],user:deploy-*=script.sh",
+			false,
+			[]EventScript{
+				{EventFilter{"member-join", "", []TagFilter{{"role", "web", false}, {"dc", "us-*", false}}}, "script.sh", nil, 0, ""},
+				{EventFilter{"user", "deploy-*", nil}, "script.sh", nil, 0, ""},
+			},
 		},
 	}
 
@@ -371,6 +803,10 @@ func TestParseEventScript(t *testing.T) {
 				t.Errorf("User events not equal: %s %s", r.Name, expected.Name)
 			}
 
+			if !reflect.DeepEqual(r.Tags, expected.Tags) {
+				t.Errorf("Tags not equal: %v %v", r.Tags, expected.Tags)
+			}
+
 			if r.Script != expected.Script {
 				t.Errorf("Scripts not equal: %s %s", r.Script, expected.Script)
 			}
@@ -385,44 +821,52 @@ func TestParseEventFilter(t *testing.T) {
 	}{
 		{
 			"",
-			[]EventFilter{EventFilter{"*", ""}},
+			[]EventFilter{EventFilter{"*", "", nil}},
 		},
 
 		{
 			"member-join",
-			[]EventFilter{EventFilter{"member-join", ""}},
+			[]EventFilter{EventFilter{"member-join", "", nil}},
 		},
 
 		{
 			"member-reap",
-			[]EventFilter{EventFilter{"member-reap", ""}},
+			[]EventFilter{EventFilter{"member-reap", "", nil}},
 		},
 
 		{
 			"foo,bar",
 			[]EventFilter{
-				EventFilter{"foo", ""},
-				EventFilter{"bar", ""},
+				EventFilter{"foo", "", nil},
+				EventFilter{"bar", "", nil},
 			},
 		},
 
 		{
 			"user:deploy",
-			[]EventFilter{EventFilter{"user", "deploy"}},
+			[]EventFilter{EventFilter{"user", "deploy", nil}},
 		},
 
 		{
 			"foo,user:blah,bar",
 			[]EventFilter{
-				EventFilter{"foo", ""},
-				EventFilter{"user", "blah"},
-				EventFilter{"bar", ""},
+				EventFilter{"foo", "", nil},
+				EventFilter{"user", "blah", nil},
+				EventFilter{"bar", "", nil},
 			},
 		},
 
 		{
 			"query:load",
-			[]EventFilter{EventFilter{"query", "load"}},
+			[]EventFilter{EventFilter{"query", "load", nil}},
+		},
+
+		{
+			"member-join[role=web,dc!=us-*],user:!deploy-*",
+			[]EventFilter{
+				EventFilter{"member-join", "", []TagFilter{{"role", "web", false}, {"dc", "us-*", true}}},
+				EventFilter{"user", "!deploy-*", nil},
+			},
 		},
 	}
 
@@ -448,6 +892,10 @@ func TestParseEventFilter(t *testing.T) {
 			if r.Name != expected.Name {
 				t.Errorf("User events not equal: %s %s", r.Name, expected.Name)
 			}
+
+			if !reflect.DeepEqual(r.Tags, expected.Tags) {
+				t.Errorf("Tags not equal: %v %v", r.Tags, expected.Tags)
+			}
 		}
 	}
 }
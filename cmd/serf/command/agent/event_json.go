@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// jsonMember is a member as described to webhooks, coprocesses and
+// scripts using the JSON format. The protocol versions are the Serf
+// versions, as in the output of "serf members -format json".
+type jsonMember struct {
+	Name               string            `json:"name"`
+	Addr               string            `json:"addr"`
+	Port               uint16            `json:"port"`
+	Tags               map[string]string `json:"tags"`
+	Status             string            `json:"status"`
+	Protocol           map[string]uint8  `json:"protocol"`
+	MemberlistProtocol map[string]uint8  `json:"memberlist_protocol"`
+	FlapCount          int               `json:"flap_count"`
+}
+
+// jsonEvent is the JSON document describing an event that is sent to
+// webhooks, coprocesses and scripts using the JSON format. For queries
+// sent to a coprocess, ID is the ID it uses to respond rather than the
+// ID assigned by the source node.
+type jsonEvent struct {
+	Event    string       `json:"event"`
+	ID       uint64       `json:"id,omitempty"`
+	Self     jsonMember   `json:"self"`
+	Members  []jsonMember `json:"members,omitempty"`
+	Name     string       `json:"name,omitempty"`
+	LTime    uint64       `json:"ltime,omitempty"`
+	Payload  []byte       `json:"payload,omitempty"`
+	Coalesce bool         `json:"coalesce,omitempty"`
+	Source   string       `json:"source,omitempty"`
+	Deadline *time.Time   `json:"deadline,omitempty"`
+	Verified bool         `json:"verified,omitempty"`
+}
+
+func newJSONMember(m serf.Member) jsonMember {
+	return jsonMember{
+		Name:   m.Name,
+		Addr:   m.Addr.String(),
+		Port:   m.Port,
+		Tags:   publicTags(m.Tags),
+		Status: m.Status.String(),
+		Protocol: map[string]uint8{
+			"min":     m.DelegateMin,
+			"max":     m.DelegateMax,
+			"version": m.DelegateCur,
+		},
+		MemberlistProtocol: map[string]uint8{
+			"min":     m.ProtocolMin,
+			"max":     m.ProtocolMax,
+			"version": m.ProtocolCur,
+		},
+		FlapCount: m.FlapCount,
+	}
+}
+
+// newJSONEvent returns the document describing the event
+func newJSONEvent(self serf.Member, event serf.Event) (*jsonEvent, error) {
+	doc := &jsonEvent{
+		Event: event.EventType().String(),
+		Self:  newJSONMember(self),
+	}
+	switch e := event.(type) {
+	case serf.MemberEvent:
+		for _, m := range e.Members {
+			doc.Members = append(doc.Members, newJSONMember(m))
+		}
+	case serf.UserEvent:
+		doc.Name = e.Name
+		doc.LTime = uint64(e.LTime)
+		doc.Payload = e.Payload
+		doc.Coalesce = e.Coalesce
+		doc.Source = e.SourceNode
+		doc.Verified = e.Verified
+	case *serf.Query:
+		doc.ID = uint64(e.ID())
+		doc.Name = e.Name
+		doc.LTime = uint64(e.LTime)
+		doc.Payload = e.Payload
+		doc.Source = e.SourceNode()
+		doc.Verified = e.Verified
+		if deadline := e.Deadline(); !deadline.IsZero() {
+			doc.Deadline = &deadline
+		}
+	default:
+		return nil, fmt.Errorf("Unknown event type: %s", event.EventType().String())
+	}
+	return doc, nil
+}
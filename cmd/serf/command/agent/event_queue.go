@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// OverflowBlock makes the agent wait for room in a full queue, which
+	// eventually blocks Serf from delivering more events
+	OverflowBlock = "block"
+
+	// OverflowDropOldest discards the oldest queued event to make room
+	OverflowDropOldest = "drop-oldest"
+
+	// OverflowDropNewest discards the event that doesn't fit
+	OverflowDropNewest = "drop-newest"
+
+	// defaultEventQueueSize is used for queues that don't set a size
+	defaultEventQueueSize = 64
+)
+
+// EventQueueConfig controls how events are queued for an event handler
+type EventQueueConfig struct {
+	// Size is how many events may be waiting for the handler
+	Size int
+
+	// Concurrency is how many events the handler is given at once. Events
+	// may be handled out of order if it is more than one.
+	Concurrency int
+
+	// Overflow is what happens to events when the queue is full, which is
+	// one of OverflowBlock, OverflowDropOldest or OverflowDropNewest
+	Overflow string
+}
+
+// validEventQueueOverflow checks if an overflow policy is known. A blank
+// policy defaults to OverflowBlock.
+func validEventQueueOverflow(overflow string) bool {
+	switch overflow {
+	case "", OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventQueue is a bounded queue of events for a single EventHandler, which
+// is drained by its own workers so a slow handler can't hold up the others.
+type eventQueue struct {
+	// dropped is first so it is aligned for atomic access
+	dropped uint64
+
+	name     string
+	handler  EventHandler
+	overflow string
+	logger   *log.Logger
+
+	eventCh chan serf.Event
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	stopWg   sync.WaitGroup
+}
+
+// newEventQueue creates a queue for the handler and starts its workers
+func newEventQueue(name string, handler EventHandler, conf EventQueueConfig, logger *log.Logger) *eventQueue {
+	if conf.Size <= 0 {
+		conf.Size = defaultEventQueueSize
+	}
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = 1
+	}
+	if conf.Overflow == "" {
+		conf.Overflow = OverflowBlock
+	}
+
+	q := &eventQueue{
+		name:     name,
+		handler:  handler,
+		overflow: conf.Overflow,
+		logger:   logger,
+		eventCh:  make(chan serf.Event, conf.Size),
+		stopCh:   make(chan struct{}),
+	}
+	for i := 0; i < conf.Concurrency; i++ {
+		q.stopWg.Add(1)
+		go q.run()
+	}
+	return q
+}
+
+// eventHandlerName returns the name a handler's queue is reported under
+func eventHandlerName(eh EventHandler) string {
+	if s, ok := eh.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", eh)
+}
+
+// Enqueue adds an event to the queue, applying the overflow policy if it
+// is full. It returns once the event is queued or dropped, or the queue
+// is stopped.
+func (q *eventQueue) Enqueue(e serf.Event) {
+	defer q.updateDepth()
+
+	select {
+	case q.eventCh <- e:
+		return
+	default:
+	}
+
+	switch q.overflow {
+	case OverflowDropNewest:
+		q.drop(e)
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.eventCh <- e:
+				return
+			default:
+			}
+			select {
+			case old := <-q.eventCh:
+				q.drop(old)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case q.eventCh <- e:
+		case <-q.stopCh:
+		}
+	}
+}
+
+// drop records that an event was discarded
+func (q *eventQueue) drop(e serf.Event) {
+	atomic.AddUint64(&q.dropped, 1)
+	metrics.IncrCounterWithLabels([]string{"agent", "event_queue", "dropped"}, 1, q.labels())
+	q.logger.Printf("[WARN] agent: Event queue for '%s' is full, dropping event: %s",
+		q.name, e.String())
+}
+
+// updateDepth reports the number of queued events
+func (q *eventQueue) updateDepth() {
+	metrics.SetGaugeWithLabels([]string{"agent", "event_queue", "depth"},
+		float32(len(q.eventCh)), q.labels())
+}
+
+// labels returns the labels for the queue's metrics. A new slice is
+// returned each time since go-metrics may filter it in place.
+func (q *eventQueue) labels() []metrics.Label {
+	return []metrics.Label{{Name: "handler", Value: q.name}}
+}
+
+// run is a worker that hands queued events to the handler
+func (q *eventQueue) run() {
+	defer q.stopWg.Done()
+	for {
+		// Don't start on another event once stopped
+		select {
+		case <-q.stopCh:
+			return
+		default:
+		}
+
+		select {
+		case e := <-q.eventCh:
+			q.updateDepth()
+			q.handler.HandleEvent(e)
+		case <-q.stopCh:
+			return
+		}
+	}
+}
+
+// Stop discards any queued events and stops the workers once they finish
+// the events they are handling. It is safe to call more than once.
+func (q *eventQueue) Stop() {
+	q.stopOnce.Do(func() {
+		close(q.stopCh)
+	})
+}
+
+// Wait blocks until the workers have stopped
+func (q *eventQueue) Wait() {
+	q.stopWg.Wait()
+}
+
+// Stats returns the queue's depth and drop count
+func (q *eventQueue) Stats() (depth int, dropped uint64) {
+	return len(q.eventCh), atomic.LoadUint64(&q.dropped)
+}
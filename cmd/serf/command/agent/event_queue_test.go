@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+)
+
+// blockingEventHandler records events, waiting on release before
+// handling each one
+type blockingEventHandler struct {
+	release chan struct{}
+	started chan struct{}
+
+	l      sync.Mutex
+	events []string
+}
+
+func newBlockingEventHandler() *blockingEventHandler {
+	return &blockingEventHandler{
+		release: make(chan struct{}),
+		started: make(chan struct{}, 64),
+	}
+}
+
+func (h *blockingEventHandler) HandleEvent(e serf.Event) {
+	h.started <- struct{}{}
+	<-h.release
+
+	name := e.String()
+	if userEvent, ok := e.(serf.UserEvent); ok {
+		name = userEvent.Name
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.events = append(h.events, name)
+}
+
+func (h *blockingEventHandler) Events() []string {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return append([]string(nil), h.events...)
+}
+
+func testEventQueue(t *testing.T, overflow string) (*eventQueue, *blockingEventHandler) {
+	h := newBlockingEventHandler()
+	q := newEventQueue("test", h, EventQueueConfig{Size: 2, Overflow: overflow},
+		testutil.TestLogger(t))
+
+	// Wait for the worker to pick up the first event, then fill the queue
+	q.Enqueue(serf.UserEvent{Name: "0"})
+	<-h.started
+	q.Enqueue(serf.UserEvent{Name: "1"})
+	q.Enqueue(serf.UserEvent{Name: "2"})
+	return q, h
+}
+
+// drain releases the handler for n events and waits for them
+func drain(t *testing.T, h *blockingEventHandler, n int) []string {
+	close(h.release)
+	deadline := time.Now().Add(time.Second)
+	for len(h.Events()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout, got: %v", h.Events())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return h.Events()
+}
+
+func TestEventQueue_dropNewest(t *testing.T) {
+	q, h := testEventQueue(t, OverflowDropNewest)
+	defer q.Stop()
+
+	q.Enqueue(serf.UserEvent{Name: "3"})
+	q.Enqueue(serf.UserEvent{Name: "4"})
+	if depth, dropped := q.Stats(); depth != 2 || dropped != 2 {
+		t.Fatalf("bad: %d %d", depth, dropped)
+	}
+
+	events := drain(t, h, 3)
+	if len(events) != 3 || events[1] != "1" || events[2] != "2" {
+		t.Fatalf("bad: %v", events)
+	}
+}
+
+func TestEventQueue_dropOldest(t *testing.T) {
+	q, h := testEventQueue(t, OverflowDropOldest)
+	defer q.Stop()
+
+	q.Enqueue(serf.UserEvent{Name: "3"})
+	q.Enqueue(serf.UserEvent{Name: "4"})
+	if depth, dropped := q.Stats(); depth != 2 || dropped != 2 {
+		t.Fatalf("bad: %d %d", depth, dropped)
+	}
+
+	events := drain(t, h, 3)
+	if len(events) != 3 || events[1] != "3" || events[2] != "4" {
+		t.Fatalf("bad: %v", events)
+	}
+}
+
+func TestEventQueue_block(t *testing.T) {
+	q, h := testEventQueue(t, OverflowBlock)
+	defer q.Stop()
+
+	doneCh := make(chan struct{})
+	go func() {
+		q.Enqueue(serf.UserEvent{Name: "3"})
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+		t.Fatalf("should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	events := drain(t, h, 4)
+	<-doneCh
+	if _, dropped := q.Stats(); dropped != 0 || events[3] != "3" {
+		t.Fatalf("bad: %v %d", events, dropped)
+	}
+}
+
+func TestEventQueue_stop(t *testing.T) {
+	q, h := testEventQueue(t, OverflowBlock)
+
+	// A blocked enqueue returns once the queue is stopped
+	doneCh := make(chan struct{})
+	go func() {
+		q.Enqueue(serf.UserEvent{Name: "3"})
+		close(doneCh)
+	}()
+	q.Stop()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// The event being handled is finished, and the rest are discarded
+	close(h.release)
+	q.Wait()
+	if events := h.Events(); len(events) != 1 {
+		t.Fatalf("bad: %v", events)
+	}
+}
+
+func TestEventQueue_concurrency(t *testing.T) {
+	h := newBlockingEventHandler()
+	q := newEventQueue("test", h, EventQueueConfig{Concurrency: 3}, testutil.TestLogger(t))
+	defer q.Stop()
+
+	for i := 0; i < 3; i++ {
+		q.Enqueue(serf.UserEvent{Name: "foo"})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-h.started:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d events started", i)
+		}
+	}
+	drain(t, h, 3)
+}
+
+func TestAgent_slowEventHandler(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+
+	slow := newBlockingEventHandler()
+	defer close(slow.release)
+	a1.RegisterEventHandlerWithQueue(slow, EventQueueConfig{Size: 1, Overflow: OverflowDropNewest})
+
+	handler := new(MockEventHandler)
+	a1.RegisterEventHandler(handler)
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	// The slow handler is stuck on the join, and only has room for one of
+	// the user events
+	for i := 0; i < 3; i++ {
+		if err := a1.UserEvent("deploy", nil, false); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	testutil.Yield()
+
+	handler.Lock()
+	numEvents := len(handler.Events)
+	handler.Unlock()
+	if numEvents != 4 {
+		t.Fatalf("bad: %d events", numEvents)
+	}
+
+	stats := a1.Stats()["event_queues"]
+	name := eventHandlerName(slow)
+	if stats[name+".depth"] != "1" || stats[name+".dropped"] != "2" {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
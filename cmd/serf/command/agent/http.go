@@ -0,0 +1,530 @@
+package agent
+
+/*
+ The agent can optionally expose an HTTP API that mirrors the msgpack IPC
+ for clients that would rather speak JSON. Every endpoint is a thin wrapper
+ around the same Agent methods the IPC layer calls, so the behavior of the
+ two interfaces is identical. Request and response bodies reuse the IPC
+ structures, encoded as JSON.
+
+ Query results are streamed back as they arrive, either as newline
+ delimited JSON records (the default) or as server-sent events if the
+ client asks for "text/event-stream".
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/coordinate"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// httpTokenHeader is the header used to provide the RPC auth token
+	httpTokenHeader = "X-Serf-Token"
+
+	// httpEventStream is the content type used to stream server-sent events
+	httpEventStream = "text/event-stream"
+
+	// httpReadTimeout bounds how long a client may take to send the
+	// request headers
+	httpReadTimeout = 10 * time.Second
+)
+
+// httpError is used to return an error with a specific status code
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+// AgentHTTP serves the HTTP API for an Agent
+type AgentHTTP struct {
+	agent     *Agent
+	authKey   string
+	aclTokens map[string]*RPCACLToken
+	listener  net.Listener
+	logger    *log.Logger
+	mux       *http.ServeMux
+	server    *http.Server
+
+	shutdown     bool
+	shutdownLock sync.Mutex
+}
+
+// NewAgentHTTP is used to create a new Agent HTTP handler that serves
+// requests on the given listener. Requests are authenticated the same
+// way as the IPC, using the authKey or one of the aclTokens.
+func NewAgentHTTP(agent *Agent, authKey string, aclTokens []RPCACLToken,
+	listener net.Listener, logOutput io.Writer) *AgentHTTP {
+	if logOutput == nil {
+		logOutput = os.Stderr
+	}
+	h := &AgentHTTP{
+		agent:     agent,
+		authKey:   authKey,
+		aclTokens: aclTokenMap(aclTokens),
+		listener:  listener,
+		logger:    log.New(logOutput, "", log.LstdFlags),
+		mux:       http.NewServeMux(),
+	}
+	h.registerHandlers()
+	h.server = &http.Server{
+		Handler:           h.mux,
+		ErrorLog:          h.logger,
+		ReadHeaderTimeout: httpReadTimeout,
+	}
+	go h.serve()
+	return h
+}
+
+// Shutdown is used to shutdown the HTTP server
+func (h *AgentHTTP) Shutdown() {
+	h.shutdownLock.Lock()
+	defer h.shutdownLock.Unlock()
+
+	if h.shutdown {
+		return
+	}
+	h.shutdown = true
+	h.server.Close()
+}
+
+// serve is a long running routine that serves requests until shutdown
+func (h *AgentHTTP) serve() {
+	err := h.server.Serve(h.listener)
+	if err != nil && err != http.ErrServerClosed {
+		h.logger.Printf("[ERR] agent.http: Failed to serve: %v", err)
+	}
+}
+
+// registerHandlers installs the endpoints on the mux
+func (h *AgentHTTP) registerHandlers() {
+	h.mux.HandleFunc("/v1/members", h.wrap("GET", membersCommand, h.handleMembers))
+	h.mux.HandleFunc("/v1/members/filtered", h.wrap("GET", membersFilteredCommand, h.handleMembersFiltered))
+	h.mux.HandleFunc("/v1/join", h.wrap("PUT", joinCommand, h.handleJoin))
+	h.mux.HandleFunc("/v1/leave", h.wrap("PUT", leaveCommand, h.handleLeave))
+	h.mux.HandleFunc("/v1/force-leave", h.wrap("PUT", forceLeaveCommand, h.handleForceLeave))
+	h.mux.HandleFunc("/v1/event", h.wrap("PUT", eventCommand, h.handleEvent))
+	h.mux.HandleFunc("/v1/query", h.wrap("PUT", queryCommand, h.handleQuery))
+	h.mux.HandleFunc("/v1/tags", h.wrap("", tagsCommand, h.handleTags))
+	h.mux.HandleFunc("/v1/keys", h.wrap("GET", listKeysCommand, h.handleListKeys))
+	h.mux.HandleFunc("/v1/keys/install", h.wrap("PUT", installKeyCommand, h.handleInstallKey))
+	h.mux.HandleFunc("/v1/keys/use", h.wrap("PUT", useKeyCommand, h.handleUseKey))
+	h.mux.HandleFunc("/v1/keys/remove", h.wrap("PUT", removeKeyCommand, h.handleRemoveKey))
+	h.mux.HandleFunc("/v1/keys/rotate", h.wrap("PUT", rotateKeyCommand, h.handleRotateKey))
+	h.mux.HandleFunc("/v1/keys/repair", h.wrap("PUT", repairKeysCommand, h.handleRepairKeys))
+	h.mux.HandleFunc("/v1/stats", h.wrap("GET", statsCommand, h.handleStats))
+	h.mux.HandleFunc("/v1/coordinate", h.wrap("GET", getCoordinateCommand, h.handleGetCoordinate))
+	h.mux.HandleFunc("/v1/checks", h.wrap("GET", checksCommand, h.handleChecks))
+	h.mux.HandleFunc("/v1/maint", h.wrap("PUT", maintCommand, h.handleMaint))
+}
+
+// wrap is used to wrap an endpoint with method checking, authentication,
+// metrics and JSON encoding of the result. The command is the equivalent
+// IPC command, used to check the permissions of ACL tokens. A nil result
+// means the handler has already written the response. PUT endpoints also
+// accept POST.
+func (h *AgentHTTP) wrap(method, command string,
+	handler func(http.ResponseWriter, *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		metrics.IncrCounterWithLabels([]string{"agent", "http", "request"}, 1, nil)
+
+		if method != "" && req.Method != method &&
+			!(method == "PUT" && req.Method == "POST") {
+			h.writeError(resp, req, &httpError{http.StatusMethodNotAllowed,
+				fmt.Sprintf("Method %s not allowed", req.Method)})
+			return
+		}
+
+		if err := h.checkAuth(req, command); err != nil {
+			h.logger.Printf("[WARN] agent.http: Rejected request from %s: %v", req.RemoteAddr, err)
+			h.writeError(resp, req, err)
+			return
+		}
+
+		obj, err := handler(resp, req)
+		if err != nil {
+			h.writeError(resp, req, err)
+			return
+		}
+		if obj == nil {
+			return
+		}
+
+		buf, err := json.Marshal(obj)
+		if err != nil {
+			h.writeError(resp, req, err)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write(buf)
+	}
+}
+
+// checkAuth verifies the request carries the RPC auth token or an ACL
+// token that allows the command, if authentication is enabled
+func (h *AgentHTTP) checkAuth(req *http.Request, command string) error {
+	token := requestToken(req)
+	if acl, ok := h.aclTokens[token]; ok {
+		if !acl.AllowCommand(command) {
+			return &httpError{http.StatusForbidden, permissionDenied}
+		}
+		return nil
+	}
+	if h.authKey == "" && len(h.aclTokens) == 0 {
+		return nil
+	}
+
+	switch {
+	case token == "":
+		return &httpError{http.StatusForbidden, authRequired}
+	case token != h.authKey:
+		return &httpError{http.StatusForbidden, invalidAuthToken}
+	}
+	return nil
+}
+
+// requestACL returns the ACL token used by the request, or nil if the
+// request used the auth key or authentication is disabled
+func (h *AgentHTTP) requestACL(req *http.Request) *RPCACLToken {
+	return h.aclTokens[requestToken(req)]
+}
+
+// requestToken returns the token from the header or query string
+func requestToken(req *http.Request) string {
+	token := req.Header.Get(httpTokenHeader)
+	if token == "" {
+		token = req.URL.Query().Get("token")
+	}
+	return token
+}
+
+// writeError writes out an error, using the status code if it is an httpError
+func (h *AgentHTTP) writeError(resp http.ResponseWriter, req *http.Request, err error) {
+	code := http.StatusInternalServerError
+	if herr, ok := err.(*httpError); ok {
+		code = herr.code
+	} else {
+		h.logger.Printf("[ERR] agent.http: Request %s %s failed: %v", req.Method, req.URL.Path, err)
+	}
+	resp.WriteHeader(code)
+	fmt.Fprint(resp, err.Error())
+}
+
+// decodeBody decodes the JSON request body into out
+func decodeBody(req *http.Request, out interface{}) error {
+	if req.Body == nil {
+		return &httpError{http.StatusBadRequest, "Missing request body"}
+	}
+	if err := json.NewDecoder(req.Body).Decode(out); err != nil {
+		return &httpError{http.StatusBadRequest, fmt.Sprintf("Failed to decode request body: %v", err)}
+	}
+	return nil
+}
+
+func (h *AgentHTTP) handleMembers(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	raw := h.agent.Serf().Members()
+	return h.membersResponse(raw), nil
+}
+
+// handleMembersFiltered filters the members using the "name" and "status"
+// query parameters, and any number of "tag" parameters given as key=regexp.
+func (h *AgentHTTP) handleMembersFiltered(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	params := req.URL.Query()
+	tags, err := UnmarshalTags(params["tag"])
+	if err != nil {
+		return nil, &httpError{http.StatusBadRequest, err.Error()}
+	}
+
+	raw, err := filterMembers(h.agent.Serf().Members(), tags,
+		params.Get("status"), params.Get("name"))
+	if err != nil {
+		return nil, &httpError{http.StatusBadRequest, err.Error()}
+	}
+	return h.membersResponse(raw), nil
+}
+
+func (h *AgentHTTP) membersResponse(raw []serf.Member) *membersResponse {
+	members := make([]Member, 0, len(raw))
+	for _, m := range raw {
+		members = append(members, ipcMember(m))
+	}
+	return &membersResponse{Members: members}
+}
+
+func (h *AgentHTTP) handleJoin(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args joinRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+
+	num, err := h.agent.Join(args.Existing, args.Replay)
+	if err != nil {
+		return nil, err
+	}
+	return &joinResponse{Num: int32(num)}, nil
+}
+
+func (h *AgentHTTP) handleLeave(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	h.logger.Printf("[INFO] agent.http: Graceful leave triggered")
+
+	// Do the leave
+	if err := h.agent.Leave(); err != nil {
+		h.logger.Printf("[ERR] agent.http: leave failed: %v", err)
+		return nil, err
+	}
+
+	// Respond before we trigger the shutdown
+	resp.WriteHeader(http.StatusOK)
+	if f, ok := resp.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	if err := h.agent.Shutdown(); err != nil {
+		h.logger.Printf("[ERR] agent.http: shutdown failed: %v", err)
+	}
+	return nil, nil
+}
+
+func (h *AgentHTTP) handleForceLeave(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args forceLeaveRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if args.Prune {
+		err = h.agent.ForceLeavePrune(args.Node)
+	} else {
+		err = h.agent.ForceLeave(args.Node)
+	}
+	return nil, err
+}
+
+func (h *AgentHTTP) handleEvent(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args eventRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+	if acl := h.requestACL(req); acl != nil && !acl.AllowEvent(args.Name) {
+		return nil, &httpError{http.StatusForbidden, permissionDenied}
+	}
+	return nil, h.agent.UserEvent(args.Name, args.Payload, args.Coalesce)
+}
+
+// handleQuery starts a query and streams the acks and responses back
+// until the query deadline is reached. The query is closed early if the
+// client goes away.
+func (h *AgentHTTP) handleQuery(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args queryRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+
+	params := serf.QueryParam{
+		FilterNodes:        args.FilterNodes,
+		FilterTags:         args.FilterTags,
+		RequestAck:         args.RequestAck,
+		RelayFactor:        args.RelayFactor,
+		Timeout:            args.Timeout,
+		IncludeMaintenance: args.IncludeMaintenance,
+	}
+	queryResp, err := h.agent.Query(args.Name, args.Payload, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newHTTPStreamClient(resp, req)
+	qs := newQueryResponseStream(client, 0, h.logger)
+	qs.Stream(queryResp, req.Context().Done())
+	return nil, nil
+}
+
+func (h *AgentHTTP) handleTags(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	switch req.Method {
+	case "GET":
+		return publicTags(h.agent.Serf().LocalMember().Tags), nil
+	case "PUT", "POST":
+		var args tagsRequest
+		if err := decodeBody(req, &args); err != nil {
+			return nil, err
+		}
+		return nil, h.agent.UpdateTags(args.Tags, args.DeleteTags)
+	default:
+		return nil, &httpError{http.StatusMethodNotAllowed,
+			fmt.Sprintf("Method %s not allowed", req.Method)}
+	}
+}
+
+func (h *AgentHTTP) handleInstallKey(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args keyRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+	return keyQueryResponse(h.agent.InstallKey(args.Key))
+}
+
+func (h *AgentHTTP) handleUseKey(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args keyRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+	return keyQueryResponse(h.agent.UseKey(args.Key))
+}
+
+func (h *AgentHTTP) handleRemoveKey(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args keyRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+	return keyQueryResponse(h.agent.RemoveKey(args.Key))
+}
+
+func (h *AgentHTTP) handleRotateKey(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args keyRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+	return keyQueryResponse(h.agent.RotateKey(args.Key))
+}
+
+func (h *AgentHTTP) handleListKeys(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return keyQueryResponse(h.agent.ListKeys())
+}
+
+func (h *AgentHTTP) handleRepairKeys(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return keyQueryResponse(h.agent.RepairKeys())
+}
+
+// keyQueryResponse converts the result of a key operation into a response
+func keyQueryResponse(queryResp *serf.KeyResponse, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &keyResponse{
+		Messages:        queryResp.Messages,
+		Keys:            queryResp.Keys,
+		PrimaryKeys:     queryResp.PrimaryKeys,
+		NodeKeys:        queryResp.NodeKeys,
+		NodePrimaryKeys: queryResp.NodePrimaryKeys,
+		NumNodes:        queryResp.NumNodes,
+		NumErr:          queryResp.NumErr,
+		NumResp:         queryResp.NumResp,
+	}, nil
+}
+
+func (h *AgentHTTP) handleStats(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return h.agent.Stats(), nil
+}
+
+// handleGetCoordinate returns the cached coordinate for the node given by
+// the "node" query parameter, defaulting to the local node.
+func (h *AgentHTTP) handleGetCoordinate(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	node := req.URL.Query().Get("node")
+	if node == "" {
+		node = h.agent.SerfConfig().NodeName
+	}
+
+	var result coordinate.Coordinate
+	coord, ok := h.agent.Serf().GetCachedCoordinate(node)
+	if ok {
+		result = *coord
+	}
+	return &coordinateResponse{
+		Coord: result,
+		Ok:    ok,
+	}, nil
+}
+
+func (h *AgentHTTP) handleChecks(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return &checksResponse{
+		Checks: h.agent.Checks(),
+	}, nil
+}
+
+func (h *AgentHTTP) handleMaint(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var args maintRequest
+	if err := decodeBody(req, &args); err != nil {
+		return nil, err
+	}
+	return nil, h.agent.SetMaintenance(args.Enable, args.Reason)
+}
+
+// httpStreamClient adapts an HTTP response to the streamClient interface
+// so the IPC streamers can be reused to write chunked JSON or server-sent
+// events.
+type httpStreamClient struct {
+	resp    http.ResponseWriter
+	flusher http.Flusher
+	remote  string
+	sse     bool
+}
+
+func newHTTPStreamClient(resp http.ResponseWriter, req *http.Request) *httpStreamClient {
+	c := &httpStreamClient{
+		resp:   resp,
+		remote: req.RemoteAddr,
+		sse:    strings.Contains(req.Header.Get("Accept"), httpEventStream),
+	}
+	c.flusher, _ = resp.(http.Flusher)
+
+	if c.sse {
+		resp.Header().Set("Content-Type", httpEventStream)
+		resp.Header().Set("Cache-Control", "no-cache")
+	} else {
+		resp.Header().Set("Content-Type", "application/json")
+	}
+	resp.WriteHeader(http.StatusOK)
+	c.flush()
+	return c
+}
+
+// Send writes a single record to the client. The response header is not
+// needed since each HTTP request carries a single stream.
+func (c *httpStreamClient) Send(header *responseHeader, obj interface{}) error {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	if c.sse {
+		_, err = fmt.Fprintf(c.resp, "data: %s\n\n", buf)
+	} else {
+		_, err = fmt.Fprintf(c.resp, "%s\n", buf)
+	}
+	if err != nil {
+		return err
+	}
+	c.flush()
+	return nil
+}
+
+// RegisterQuery is not supported over HTTP since there is no way to
+// respond, the ID returned is always zero.
+func (c *httpStreamClient) RegisterQuery(q *serf.Query) uint64 {
+	return 0
+}
+
+func (c *httpStreamClient) flush() {
+	if c.flusher != nil {
+		c.flusher.Flush()
+	}
+}
+
+func (c *httpStreamClient) String() string {
+	return fmt.Sprintf("http.client: %v", c.remote)
+}
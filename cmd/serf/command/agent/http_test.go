@@ -0,0 +1,311 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+)
+
+func testAgentHTTP(t *testing.T, ip net.IP, authKey string) (string, *Agent, *AgentHTTP) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	agent := testAgent(t, ip, nil)
+	h := NewAgentHTTP(agent, authKey, nil, l, testutil.TestWriter(t))
+	return "http://" + l.Addr().String(), agent, h
+}
+
+func TestAgentHTTP_Members(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	addr, a1, h := testAgentHTTP(t, ip1, "")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	resp, err := http.Get(addr + "/v1/members")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+
+	var out membersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out.Members) != 1 || out.Members[0].Name != a1.conf.NodeName {
+		t.Fatalf("bad: %#v", out.Members)
+	}
+
+	// A filter that doesn't match should return no members
+	resp2, err := http.Get(addr + "/v1/members/filtered?status=failed")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp2.Body.Close()
+
+	out = membersResponse{}
+	if err := json.NewDecoder(resp2.Body).Decode(&out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out.Members) != 0 {
+		t.Fatalf("bad: %#v", out.Members)
+	}
+}
+
+func TestAgentHTTP_Auth(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	addr, a1, h := testAgentHTTP(t, ip1, "foobar")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	resp, err := http.Get(addr + "/v1/stats")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", addr+"/v1/stats", nil)
+	req.Header.Set(httpTokenHeader, "foobar")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+}
+
+func TestAgentHTTP_ACL(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	addr, a1, h := testAgentHTTP(t, ip1, "")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+	h.aclTokens = aclTokenMap([]RPCACLToken{
+		{Name: "read", Token: "read-token", Commands: []string{"stats"}},
+	})
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/v1/stats", http.StatusForbidden},
+		{"/v1/stats?token=read-token", http.StatusOK},
+		{"/v1/members?token=read-token", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(addr + c.path)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Fatalf("bad: %s %d", c.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestAgentHTTP_Event(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	addr, a1, h := testAgentHTTP(t, ip1, "")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+
+	eventHandler := new(MockEventHandler)
+	a1.RegisterEventHandler(eventHandler)
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	body := bytes.NewBufferString(`{"Name": "deploy", "Payload": "Zm9v", "Coalesce": false}`)
+	resp, err := http.Post(addr+"/v1/event", "application/json", body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+
+	testutil.Yield()
+
+	eventHandler.Lock()
+	defer eventHandler.Unlock()
+	if len(eventHandler.Events) == 0 {
+		t.Fatal("no events")
+	}
+	e, ok := eventHandler.Events[len(eventHandler.Events)-1].(serf.UserEvent)
+	if !ok {
+		t.Fatalf("bad: %#v", eventHandler.Events)
+	}
+	if e.Name != "deploy" || string(e.Payload) != "foo" {
+		t.Fatalf("bad: %#v", e)
+	}
+}
+
+func TestAgentHTTP_Query(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	addr, a1, h := testAgentHTTP(t, ip1, "")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	body := bytes.NewBufferString(`{"Name": "ping", "RequestAck": true, "Timeout": 200000000}`)
+	resp, err := http.Post(addr+"/v1/query", "application/json", body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var records []queryRecord
+	scan := bufio.NewScanner(resp.Body)
+	deadline := time.Now().Add(2 * time.Second)
+	for scan.Scan() && time.Now().Before(deadline) {
+		var rec queryRecord
+		if err := json.Unmarshal(scan.Bytes(), &rec); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		records = append(records, rec)
+	}
+
+	if len(records) < 2 {
+		t.Fatalf("bad: %#v", records)
+	}
+	if records[0].Type != queryRecordAck || records[0].From != a1.conf.NodeName {
+		t.Fatalf("bad: %#v", records[0])
+	}
+	if last := records[len(records)-1]; last.Type != queryRecordDone {
+		t.Fatalf("bad: %#v", last)
+	}
+}
+
+func TestAgentHTTP_Query_Disconnect(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	_, a1, h := testAgentHTTP(t, ip1, "")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	// The query would run for a minute, but the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	body := bytes.NewBufferString(`{"Name": "ping", "Timeout": 60000000000}`)
+	req := httptest.NewRequest("PUT", "/v1/query", body).WithContext(ctx)
+	resp := httptest.NewRecorder()
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		if _, err := h.handleQuery(resp, req); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-doneCh:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("query should stop when the client disconnects")
+	}
+
+	if strings.Contains(resp.Body.String(), queryRecordDone) {
+		t.Fatalf("bad: %s", resp.Body.String())
+	}
+}
+
+func TestAgentHTTP_Query_SSE(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	addr, a1, h := testAgentHTTP(t, ip1, "")
+	defer h.Shutdown()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	body := bytes.NewBufferString(`{"Name": "ping", "Timeout": 100000000}`)
+	req, _ := http.NewRequest("PUT", addr+"/v1/query", body)
+	req.Header.Set("Accept", httpEventStream)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != httpEventStream {
+		t.Fatalf("bad: %s", ct)
+	}
+
+	var last string
+	scan := bufio.NewScanner(resp.Body)
+	for scan.Scan() {
+		if line := scan.Text(); strings.HasPrefix(line, "data: ") {
+			last = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	var rec queryRecord
+	if err := json.Unmarshal([]byte(last), &rec); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if rec.Type != queryRecordDone {
+		t.Fatalf("bad: %#v", rec)
+	}
+}
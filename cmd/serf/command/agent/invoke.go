@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -8,6 +9,7 @@ import (
 	"os/exec"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,10 +28,67 @@ const (
 
 	// warnSlow is used to warn about a slow handler invocation
 	warnSlow = time.Second
+
+	// ScriptFormatText writes member events to a script's stdin as lines
+	// of tab separated fields, and other events as their payload
+	ScriptFormatText = "text"
+
+	// ScriptFormatJSON writes the whole event to a script's stdin as a
+	// JSON document
+	ScriptFormatJSON = "json"
 )
 
 var sanitizeTagRegexp = regexp.MustCompile(`[^A-Z0-9_]`)
 
+// killWaitTimeout is how long we wait for a killed script to be reaped.
+// Processes it started outside of its process group can hold its output
+// open, which would otherwise block the wait forever.
+var killWaitTimeout = 5 * time.Second
+
+// waitKilled waits for the result of a killed script's Wait, giving up
+// after killWaitTimeout. It returns false if it gave up, in which case the
+// script's output mustn't be read since it may still be written to.
+func waitKilled(waitCh <-chan error) bool {
+	select {
+	case <-waitCh:
+		return true
+	case <-time.After(killWaitTimeout):
+		return false
+	}
+}
+
+// runCommand runs a command in a shell and returns its combined output,
+// truncated to maxBufSize. The command is killed along with any processes
+// it started if it runs longer than the timeout.
+func runCommand(command string, timeout time.Duration) ([]byte, error) {
+	output, _ := circbuf.NewBuffer(maxBufSize)
+	cmd := shellCommand(command)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-waitCh:
+		return output.Bytes(), err
+	case <-timer.C:
+		killProcessGroup(cmd)
+		if !waitKilled(waitCh) {
+			return nil, fmt.Errorf("timed out after %v, and its output was still open %v after it was killed",
+				timeout, killWaitTimeout)
+		}
+		return output.Bytes(), fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
 // invokeEventScript will execute the given event script with the given
 // event. Depending on the event, the semantics of how data are passed
 // are a bit different. For all events, the SERF_EVENT environmental
@@ -38,22 +97,24 @@ var sanitizeTagRegexp = regexp.MustCompile(`[^A-Z0-9_]`)
 // event that was fired.
 //
 // In all events, data is passed in via stdin to facilitate piping. See
-// the various stdin functions below for more information.
-func invokeEventScript(logger *log.Logger, script string, self serf.Member, event serf.Event) error {
-	defer metrics.MeasureSinceWithLabels([]string{"agent", "invoke", script}, time.Now(), nil)
+// the various stdin functions below for more information. If the script's
+// format is JSON, the whole event is passed as a JSON document instead.
+//
+// If the script runs longer than its timeout, its whole process group is
+// killed. Queries default to a timeout of their deadline, and other events
+// have no timeout if it is zero.
+func invokeEventScript(logger *log.Logger, eventScript *EventScript,
+	self serf.Member, event serf.Event) (err error) {
+	script, timeout := eventScript.Script, eventScript.Timeout
+	start := time.Now()
+	timedOut := false
+	defer func() {
+		metrics.MeasureSinceWithLabels([]string{"agent", "invoke", script}, start,
+			invokeLabels(err, timedOut))
+	}()
 	output, _ := circbuf.NewBuffer(maxBufSize)
 
-	// Determine the shell invocation based on OS
-	var shell, flag string
-	if runtime.GOOS == windows {
-		shell = "cmd"
-		flag = "/C"
-	} else {
-		shell = "/bin/sh"
-		flag = "-c"
-	}
-
-	cmd := exec.Command(shell, flag, script)
+	cmd := shellCommand(script)
 	cmd.Env = append(os.Environ(),
 		"SERF_EVENT="+event.EventType().String(),
 		"SERF_SELF_NAME="+self.Name,
@@ -61,9 +122,10 @@ func invokeEventScript(logger *log.Logger, script string, self serf.Member, even
 	)
 	cmd.Stderr = output
 	cmd.Stdout = output
+	setProcessGroup(cmd)
 
 	// Add all the tags
-	for name, val := range self.Tags {
+	for name, val := range publicTags(self.Tags) {
 		//http://stackoverflow.com/questions/2821043/allowed-characters-in-linux-environment-variable-names
 		//(http://pubs.opengroup.org/onlinepubs/000095399/basedefs/xbd_chap08.html for the long version)
 		//says that env var names must be in [A-Z0-9_] and not start with [0-9].
@@ -78,17 +140,48 @@ func invokeEventScript(logger *log.Logger, script string, self serf.Member, even
 		return err
 	}
 
+	var doc *jsonEvent
+	if eventScript.Format == ScriptFormatJSON {
+		if doc, err = newJSONEvent(self, event); err != nil {
+			return err
+		}
+	}
+
 	switch e := event.(type) {
 	case serf.MemberEvent:
-		go memberEventStdin(logger, stdin, &e)
+		if doc != nil {
+			go jsonEventStdin(logger, stdin, doc)
+		} else {
+			go memberEventStdin(logger, stdin, &e)
+		}
 	case serf.UserEvent:
 		cmd.Env = append(cmd.Env, "SERF_USER_EVENT="+e.Name)
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SERF_USER_LTIME=%d", e.LTime))
-		go streamPayload(logger, stdin, e.Payload)
+		cmd.Env = append(cmd.Env, "SERF_USER_SOURCE="+e.SourceNode)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("SERF_USER_VERIFIED=%v", e.Verified))
+		if doc != nil {
+			go jsonEventStdin(logger, stdin, doc)
+		} else {
+			go streamPayload(logger, stdin, e.Payload)
+		}
 	case *serf.Query:
 		cmd.Env = append(cmd.Env, "SERF_QUERY_NAME="+e.Name)
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SERF_QUERY_LTIME=%d", e.LTime))
-		go streamPayload(logger, stdin, e.Payload)
+		cmd.Env = append(cmd.Env, "SERF_QUERY_SOURCE="+e.SourceNode())
+		cmd.Env = append(cmd.Env, fmt.Sprintf("SERF_QUERY_VERIFIED=%v", e.Verified))
+		if !e.Deadline().IsZero() {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("SERF_QUERY_DEADLINE=%d", e.Deadline().Unix()))
+		}
+		if doc != nil {
+			go jsonEventStdin(logger, stdin, doc)
+		} else {
+			go streamPayload(logger, stdin, e.Payload)
+		}
+
+		// There's no point running past the deadline for responses
+		if timeout == 0 && !e.Deadline().IsZero() {
+			timeout = time.Until(e.Deadline())
+		}
 	default:
 		return fmt.Errorf("Unknown event type: %s", event.EventType().String())
 	}
@@ -109,7 +202,36 @@ func invokeEventScript(logger *log.Logger, script string, self serf.Member, even
 			script, output.TotalWritten(), output.Size())
 	}
 
-	err = cmd.Wait()
+	// Wait for the script to exit, killing it if it takes too long
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case err = <-waitCh:
+	case <-timeoutCh:
+		timedOut = true
+		logger.Printf("[ERR] agent: Script '%s' timed out after %v, killing it",
+			script, timeout)
+		metrics.IncrCounterWithLabels([]string{"agent", "invoke", "timeout"}, 1,
+			[]metrics.Label{{Name: "script", Value: script}})
+		if err := killProcessGroup(cmd); err != nil {
+			logger.Printf("[WARN] agent: Failed to kill script '%s': %v", script, err)
+		}
+		err = fmt.Errorf("timed out after %v", timeout)
+		if !waitKilled(waitCh) {
+			slowTimer.Stop()
+			logger.Printf("[WARN] agent: Script '%s' output is still open %v after it was killed, abandoning it",
+				script, killWaitTimeout)
+			return err
+		}
+	}
 	slowTimer.Stop()
 	logger.Printf("[DEBUG] agent: Event '%s' script output: %s",
 		event.EventType().String(), output.String())
@@ -128,6 +250,50 @@ func invokeEventScript(logger *log.Logger, script string, self serf.Member, even
 	return nil
 }
 
+// validScriptFormat checks if a script format is known. A blank format
+// defaults to ScriptFormatText.
+func validScriptFormat(format string) bool {
+	switch format {
+	case "", ScriptFormatText, ScriptFormatJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// shellCommand returns a command that runs the script in a shell, based
+// on the OS.
+func shellCommand(script string) *exec.Cmd {
+	var shell, flag string
+	if runtime.GOOS == windows {
+		shell = "cmd"
+		flag = "/C"
+	} else {
+		shell = "/bin/sh"
+		flag = "-c"
+	}
+	return exec.Command(shell, flag, script)
+}
+
+// invokeLabels returns the labels describing how a script invocation
+// ended, for the agent.invoke metrics.
+func invokeLabels(err error, timedOut bool) []metrics.Label {
+	outcome, exitCode := "success", 0
+	switch {
+	case timedOut:
+		outcome, exitCode = "timeout", -1
+	case err != nil:
+		outcome, exitCode = "error", -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			outcome, exitCode = "failed", exitErr.ExitCode()
+		}
+	}
+	return []metrics.Label{
+		{Name: "outcome", Value: outcome},
+		{Name: "exit_code", Value: strconv.Itoa(exitCode)},
+	}
+}
+
 // eventClean cleans a value to be a parameter in an event line.
 func eventClean(v string) string {
 	v = strings.Replace(v, "\t", "\\t", -1)
@@ -147,7 +313,7 @@ func memberEventStdin(logger *log.Logger, stdin io.WriteCloser, e *serf.MemberEv
 	for _, member := range e.Members {
 		// Format the tags as tag1=v1,tag2=v2,...
 		var tagPairs []string
-		for name, value := range member.Tags {
+		for name, value := range publicTags(member.Tags) {
 			tagPairs = append(tagPairs, fmt.Sprintf("%s=%s", name, value))
 		}
 		tags := strings.Join(tagPairs, ",")
@@ -165,6 +331,22 @@ func memberEventStdin(logger *log.Logger, stdin io.WriteCloser, e *serf.MemberEv
 	}
 }
 
+// Sends the JSON document describing an event on stdin, followed by a
+// newline.
+func jsonEventStdin(logger *log.Logger, stdin io.WriteCloser, doc *jsonEvent) {
+	defer stdin.Close()
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		logger.Printf("[ERR] agent: Failed to encode event: %s", err)
+		return
+	}
+	buf = append(buf, '\n')
+	if _, err := stdin.Write(buf); err != nil {
+		logger.Printf("[ERR] Error writing event: %s", err)
+	}
+}
+
 // Sends data on stdin for an event. The stdin simply contains the
 // payload (if any).
 // Most shells read implementations need a newline, force it to be there
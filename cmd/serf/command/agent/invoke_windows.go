@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package agent
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, which doesn't have process groups
+// that can be signaled.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills the script's shell, since Windows doesn't have
+// process groups that can be signaled.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -53,6 +53,7 @@ const (
 	joinCommand            = "join"
 	membersCommand         = "members"
 	membersFilteredCommand = "members-filtered"
+	membersWaitCommand     = "members-wait"
 	streamCommand          = "stream"
 	stopCommand            = "stop"
 	monitorCommand         = "monitor"
@@ -61,12 +62,16 @@ const (
 	useKeyCommand          = "use-key"
 	removeKeyCommand       = "remove-key"
 	listKeysCommand        = "list-keys"
+	rotateKeyCommand       = "rotate-key"
+	repairKeysCommand      = "repair-keys"
 	tagsCommand            = "tags"
 	queryCommand           = "query"
 	respondCommand         = "respond"
 	authCommand            = "auth"
 	statsCommand           = "stats"
 	getCoordinateCommand   = "get-coordinate"
+	checksCommand          = "checks"
+	maintCommand           = "maint"
 )
 
 const (
@@ -80,6 +85,7 @@ const (
 	invalidQueryID        = "No pending queries matching ID"
 	authRequired          = "Authentication required"
 	invalidAuthToken      = "Invalid authentication token"
+	permissionDenied      = "Permission denied"
 )
 
 const (
@@ -88,6 +94,12 @@ const (
 	queryRecordDone     = "done"
 )
 
+const (
+	// maxMembersWait bounds how long a members-wait request blocks, and
+	// is used when the request doesn't set a timeout
+	maxMembersWait = 10 * time.Minute
+)
+
 // Request header is sent before each request
 type requestHeader struct {
 	Command string
@@ -117,6 +129,10 @@ type coordinateResponse struct {
 	Ok    bool
 }
 
+type checksResponse struct {
+	Checks []CheckState
+}
+
 type eventRequest struct {
 	Name     string
 	Payload  []byte
@@ -147,16 +163,29 @@ type membersResponse struct {
 	Members []Member
 }
 
+type membersWaitRequest struct {
+	Index   uint64
+	Timeout time.Duration
+}
+
+type membersWaitResponse struct {
+	Members []Member
+	Index   uint64
+}
+
 type keyRequest struct {
 	Key string
 }
 
 type keyResponse struct {
-	Messages map[string]string
-	Keys     map[string]int
-	NumNodes int
-	NumErr   int
-	NumResp  int
+	Messages        map[string]string
+	Keys            map[string]int
+	PrimaryKeys     map[string]int
+	NodeKeys        map[string][]string
+	NodePrimaryKeys map[string]string
+	NumNodes        int
+	NumErr          int
+	NumResp         int
 }
 
 type monitorRequest struct {
@@ -184,6 +213,15 @@ type queryRequest struct {
 	Timeout     time.Duration
 	Name        string
 	Payload     []byte
+
+	// IncludeMaintenance sends the query to nodes in maintenance mode,
+	// which ignore queries by default
+	IncludeMaintenance bool
+}
+
+type maintRequest struct {
+	Enable bool
+	Reason string
 }
 
 type respondRequest struct {
@@ -192,9 +230,10 @@ type respondRequest struct {
 }
 
 type queryRecord struct {
-	Type    string
-	From    string
-	Payload []byte
+	Type     string
+	From     string
+	Payload  []byte
+	Verified bool
 }
 
 type logRecord struct {
@@ -202,19 +241,22 @@ type logRecord struct {
 }
 
 type userEventRecord struct {
+	Event      string
+	LTime      serf.LamportTime
+	Name       string
+	Payload    []byte
+	Coalesce   bool
+	SourceNode string
+	Verified   bool
+}
+
+type queryEventRecord struct {
 	Event    string
+	ID       uint64 // ID is opaque to client, used to respond
 	LTime    serf.LamportTime
 	Name     string
 	Payload  []byte
-	Coalesce bool
-}
-
-type queryEventRecord struct {
-	Event   string
-	ID      uint64 // ID is opaque to client, used to respond
-	LTime   serf.LamportTime
-	Name    string
-	Payload []byte
+	Verified bool
 }
 
 type Member struct {
@@ -229,6 +271,7 @@ type Member struct {
 	DelegateMin uint8
 	DelegateMax uint8
 	DelegateCur uint8
+	FlapCount   int
 }
 
 type memberEventRecord struct {
@@ -240,6 +283,8 @@ type AgentIPC struct {
 	sync.Mutex
 	agent     *Agent
 	authKey   string
+	aclTokens map[string]*RPCACLToken
+	clientSeq uint64 // Used to name unix socket clients
 	clients   map[string]*IPCClient
 	listener  net.Listener
 	logger    *log.Logger
@@ -264,7 +309,12 @@ type IPCClient struct {
 	pendingQueries map[uint64]*serf.Query
 	queryLock      sync.Mutex
 
-	didAuth bool // Did we get an auth token yet?
+	didAuth bool         // Did we get an auth token yet?
+	acl     *RPCACLToken // Restricts commands, nil if authenticated with the auth key
+
+	// stopCh is closed when the client disconnects, to stop any waits
+	// that are answering it in the background
+	stopCh chan struct{}
 }
 
 // send is used to send an object using the MsgPack encoding. send
@@ -325,15 +375,18 @@ func (c *IPCClient) RegisterQuery(q *serf.Query) uint64 {
 	return id
 }
 
-// NewAgentIPC is used to create a new Agent IPC handler
-func NewAgentIPC(agent *Agent, authKey string, listener net.Listener,
-	logOutput io.Writer, logWriter *logWriter) *AgentIPC {
+// NewAgentIPC is used to create a new Agent IPC handler. Clients must
+// authenticate with either the authKey, which allows every command, or
+// one of the aclTokens, which allow only the commands they list.
+func NewAgentIPC(agent *Agent, authKey string, aclTokens []RPCACLToken,
+	listener net.Listener, logOutput io.Writer, logWriter *logWriter) *AgentIPC {
 	if logOutput == nil {
 		logOutput = os.Stderr
 	}
 	ipc := &AgentIPC{
 		agent:     agent,
 		authKey:   authKey,
+		aclTokens: aclTokenMap(aclTokens),
 		clients:   make(map[string]*IPCClient),
 		listener:  listener,
 		logger:    log.New(logOutput, "", log.LstdFlags),
@@ -383,12 +436,13 @@ func (i *AgentIPC) listen() {
 
 		// Wrap the connection in a client
 		client := &IPCClient{
-			name:           conn.RemoteAddr().String(),
+			name:           i.clientName(conn),
 			conn:           conn,
 			reader:         bufio.NewReader(conn),
 			writer:         bufio.NewWriter(conn),
 			eventStreams:   make(map[uint64]*eventStream),
 			pendingQueries: make(map[uint64]*serf.Query),
+			stopCh:         make(chan struct{}),
 		}
 		client.dec = codec.NewDecoder(client.reader,
 			&codec.MsgpackHandle{RawToString: true, WriteExt: true})
@@ -407,10 +461,23 @@ func (i *AgentIPC) listen() {
 	}
 }
 
+// clientName returns a unique name for a newly accepted connection.
+// Peers on a Unix socket are usually unbound and share an empty address,
+// so they are numbered instead.
+func (i *AgentIPC) clientName(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil {
+		if name := addr.String(); name != "" && name != "@" {
+			return name
+		}
+	}
+	return fmt.Sprintf("unix#%d", atomic.AddUint64(&i.clientSeq, 1))
+}
+
 // deregisterClient is called to cleanup after a client disconnects
 func (i *AgentIPC) deregisterClient(client *IPCClient) {
 	// Close the socket
 	client.conn.Close()
+	close(client.stopCh)
 
 	// Remove from the clients list
 	i.Lock()
@@ -471,79 +538,92 @@ func (i *AgentIPC) handleRequest(client *IPCClient, reqHeader *requestHeader) er
 	metrics.IncrCounterWithLabels([]string{"agent", "ipc", "command"}, 1, nil)
 
 	// Ensure the client has authenticated after the handshake if necessary
-	if i.authKey != "" && !client.didAuth && command != authCommand && command != handshakeCommand {
+	authEnabled := i.authKey != "" || len(i.aclTokens) > 0
+	if authEnabled && !client.didAuth && command != authCommand && command != handshakeCommand {
 		i.logger.Printf("[WARN] agent.ipc: Client sending commands before auth")
 		respHeader := responseHeader{Seq: seq, Error: authRequired}
 		client.Send(&respHeader, nil)
 		return nil
 	}
 
-	// Dispatch command specific handlers
-	switch command {
-	case handshakeCommand:
-		return i.handleHandshake(client, seq)
-
-	case authCommand:
-		return i.handleAuth(client, seq)
-
-	case eventCommand:
-		return i.handleEvent(client, seq)
-
-	case membersCommand, membersFilteredCommand:
-		return i.handleMembers(client, command, seq)
-
-	case streamCommand:
-		return i.handleStream(client, seq)
-
-	case monitorCommand:
-		return i.handleMonitor(client, seq)
-
-	case stopCommand:
-		return i.handleStop(client, seq)
-
-	case forceLeaveCommand:
-		return i.handleForceLeave(client, seq)
-
-	case joinCommand:
-		return i.handleJoin(client, seq)
-
-	case leaveCommand:
-		return i.handleLeave(client, seq)
-
-	case installKeyCommand:
-		return i.handleInstallKey(client, seq)
-
-	case useKeyCommand:
-		return i.handleUseKey(client, seq)
-
-	case removeKeyCommand:
-		return i.handleRemoveKey(client, seq)
-
-	case listKeysCommand:
-		return i.handleListKeys(client, seq)
+	// Look up the command's handler
+	cmd, ok := ipcCommands[command]
+	if !ok {
+		respHeader := responseHeader{Seq: seq, Error: unsupportedCommand}
+		client.Send(&respHeader, nil)
+		return fmt.Errorf("command '%s' not recognized", command)
+	}
 
-	case tagsCommand:
-		return i.handleTags(client, seq)
+	// Ensure the client's ACL token permits the command
+	if client.acl != nil && !client.acl.AllowCommand(command) {
+		i.logger.Printf("[WARN] agent.ipc: Token '%s' denied command '%s'", client.acl.Name, command)
+		metrics.IncrCounterWithLabels([]string{"agent", "ipc", "denied"}, 1, nil)
+		if !cmd.noBody {
+			var body interface{}
+			if err := client.dec.Decode(&body); err != nil {
+				return fmt.Errorf("decode failed: %v", err)
+			}
+		}
+		respHeader := responseHeader{Seq: seq, Error: permissionDenied}
+		return client.Send(&respHeader, nil)
+	}
 
-	case queryCommand:
-		return i.handleQuery(client, seq)
+	// Dispatch the command specific handler
+	return cmd.handler(i, client, command, seq)
+}
 
-	case respondCommand:
-		return i.handleRespond(client, seq)
+// ipcCommand describes how an IPC command is dispatched and authorized
+type ipcCommand struct {
+	// handler evaluates the command
+	handler func(i *AgentIPC, client *IPCClient, command string, seq uint64) error
 
-	case statsCommand:
-		return i.handleStats(client, seq)
+	// noBody is set if the command isn't followed by a request body. The
+	// body of other commands must be consumed from the stream even when
+	// they are denied.
+	noBody bool
 
-	case getCoordinateCommand:
-		return i.handleGetCoordinate(client, seq)
+	// public is set if every ACL token may run the command
+	public bool
+}
 
-	default:
-		respHeader := responseHeader{Seq: seq, Error: unsupportedCommand}
-		client.Send(&respHeader, nil)
-		return fmt.Errorf("command '%s' not recognized", command)
+// ipcHandler adapts a handler that doesn't need the command name
+func ipcHandler(handler func(*AgentIPC, *IPCClient, uint64) error) func(*AgentIPC, *IPCClient, string, uint64) error {
+	return func(i *AgentIPC, client *IPCClient, command string, seq uint64) error {
+		return handler(i, client, seq)
 	}
 }
 
+// ipcCommands is the table of every IPC command, which the dispatcher and
+// the ACL tokens both use, so that a command can't be added to one and not
+// the other.
+var ipcCommands = map[string]ipcCommand{
+	handshakeCommand:       {handler: ipcHandler((*AgentIPC).handleHandshake), public: true},
+	authCommand:            {handler: ipcHandler((*AgentIPC).handleAuth), public: true},
+	eventCommand:           {handler: ipcHandler((*AgentIPC).handleEvent)},
+	membersCommand:         {handler: (*AgentIPC).handleMembers, noBody: true},
+	membersFilteredCommand: {handler: (*AgentIPC).handleMembers},
+	membersWaitCommand:     {handler: ipcHandler((*AgentIPC).handleMembersWait)},
+	streamCommand:          {handler: ipcHandler((*AgentIPC).handleStream)},
+	monitorCommand:         {handler: ipcHandler((*AgentIPC).handleMonitor)},
+	stopCommand:            {handler: ipcHandler((*AgentIPC).handleStop)},
+	forceLeaveCommand:      {handler: ipcHandler((*AgentIPC).handleForceLeave)},
+	joinCommand:            {handler: ipcHandler((*AgentIPC).handleJoin)},
+	leaveCommand:           {handler: ipcHandler((*AgentIPC).handleLeave), noBody: true},
+	installKeyCommand:      {handler: ipcHandler((*AgentIPC).handleInstallKey)},
+	useKeyCommand:          {handler: ipcHandler((*AgentIPC).handleUseKey)},
+	removeKeyCommand:       {handler: ipcHandler((*AgentIPC).handleRemoveKey)},
+	listKeysCommand:        {handler: ipcHandler((*AgentIPC).handleListKeys), noBody: true},
+	rotateKeyCommand:       {handler: ipcHandler((*AgentIPC).handleRotateKey)},
+	repairKeysCommand:      {handler: ipcHandler((*AgentIPC).handleRepairKeys), noBody: true},
+	tagsCommand:            {handler: ipcHandler((*AgentIPC).handleTags)},
+	queryCommand:           {handler: ipcHandler((*AgentIPC).handleQuery)},
+	respondCommand:         {handler: ipcHandler((*AgentIPC).handleRespond)},
+	statsCommand:           {handler: ipcHandler((*AgentIPC).handleStats), noBody: true},
+	getCoordinateCommand:   {handler: ipcHandler((*AgentIPC).handleGetCoordinate)},
+	checksCommand:          {handler: ipcHandler((*AgentIPC).handleChecks), noBody: true},
+	maintCommand:           {handler: ipcHandler((*AgentIPC).handleMaint)},
+}
+
 func (i *AgentIPC) handleHandshake(client *IPCClient, seq uint64) error {
 	var req handshakeRequest
 	if err := client.dec.Decode(&req); err != nil {
@@ -577,9 +657,14 @@ func (i *AgentIPC) handleAuth(client *IPCClient, seq uint64) error {
 		Error: "",
 	}
 
-	// Check the token matches
-	if req.AuthKey == i.authKey {
+	// Check the token matches an ACL token or the auth key. A blank auth
+	// key must not grant full access when ACL tokens are in use.
+	if acl, ok := i.aclTokens[req.AuthKey]; ok {
+		client.didAuth = true
+		client.acl = acl
+	} else if req.AuthKey == i.authKey && (i.authKey != "" || len(i.aclTokens) == 0) {
 		client.didAuth = true
+		client.acl = nil
 	} else {
 		resp.Error = invalidAuthToken
 	}
@@ -592,6 +677,14 @@ func (i *AgentIPC) handleEvent(client *IPCClient, seq uint64) error {
 		return fmt.Errorf("decode failed: %v", err)
 	}
 
+	// Ensure the client's ACL token permits the event name
+	if client.acl != nil && !client.acl.AllowEvent(req.Name) {
+		i.logger.Printf("[WARN] agent.ipc: Token '%s' denied event '%s'", client.acl.Name, req.Name)
+		metrics.IncrCounterWithLabels([]string{"agent", "ipc", "denied"}, 1, nil)
+		resp := responseHeader{Seq: seq, Error: permissionDenied}
+		return client.Send(&resp, nil)
+	}
+
 	// Attempt the send
 	err := i.agent.UserEvent(req.Name, req.Payload, req.Coalesce)
 
@@ -656,27 +749,14 @@ func (i *AgentIPC) handleMembers(client *IPCClient, command string, seq uint64)
 		if err != nil {
 			return fmt.Errorf("decode failed: %v", err)
 		}
-		raw, err = i.filterMembers(raw, req.Tags, req.Status, req.Name)
+		raw, err = filterMembers(raw, req.Tags, req.Status, req.Name)
 		if err != nil {
 			return err
 		}
 	}
 
 	for _, m := range raw {
-		sm := Member{
-			Name:        m.Name,
-			Addr:        m.Addr,
-			Port:        m.Port,
-			Tags:        m.Tags,
-			Status:      m.Status.String(),
-			ProtocolMin: m.ProtocolMin,
-			ProtocolMax: m.ProtocolMax,
-			ProtocolCur: m.ProtocolCur,
-			DelegateMin: m.DelegateMin,
-			DelegateMax: m.DelegateMax,
-			DelegateCur: m.DelegateCur,
-		}
-		members = append(members, sm)
+		members = append(members, ipcMember(m))
 	}
 
 	header := responseHeader{
@@ -689,7 +769,49 @@ func (i *AgentIPC) handleMembers(client *IPCClient, command string, seq uint64)
 	return client.Send(&header, &resp)
 }
 
-func (i *AgentIPC) filterMembers(members []serf.Member, tags map[string]string,
+func (i *AgentIPC) handleMembersWait(client *IPCClient, seq uint64) error {
+	var req membersWaitRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > maxMembersWait {
+		timeout = maxMembersWait
+	}
+
+	// Wait in the background so the client can keep sending requests
+	go func() {
+		raw, index := i.agent.Serf().MembersWaitWithCancel(req.Index, timeout, client.stopCh)
+		select {
+		case <-client.stopCh:
+			return
+		default:
+		}
+
+		members := make([]Member, 0, len(raw))
+		for _, m := range raw {
+			members = append(members, ipcMember(m))
+		}
+
+		header := responseHeader{
+			Seq:   seq,
+			Error: "",
+		}
+		resp := membersWaitResponse{
+			Members: members,
+			Index:   index,
+		}
+		if err := client.Send(&header, &resp); err != nil {
+			i.logger.Printf("[ERR] agent.ipc: Failed to send members to %v: %v", client, err)
+		}
+	}()
+	return nil
+}
+
+// filterMembers returns the subset of members whose tags, status and name
+// match the given anchored regular expressions.
+func filterMembers(members []serf.Member, tags map[string]string,
 	status string, name string) ([]serf.Member, error) {
 
 	result := make([]serf.Member, 0, len(members))
@@ -809,6 +931,53 @@ func (i *AgentIPC) handleRemoveKey(client *IPCClient, seq uint64) error {
 func (i *AgentIPC) handleListKeys(client *IPCClient, seq uint64) error {
 	queryResp, err := i.agent.ListKeys()
 
+	header := responseHeader{
+		Seq:   seq,
+		Error: errToString(err),
+	}
+	resp := keyResponse{
+		Messages:        queryResp.Messages,
+		Keys:            queryResp.Keys,
+		PrimaryKeys:     queryResp.PrimaryKeys,
+		NodeKeys:        queryResp.NodeKeys,
+		NodePrimaryKeys: queryResp.NodePrimaryKeys,
+		NumNodes:        queryResp.NumNodes,
+		NumErr:          queryResp.NumErr,
+		NumResp:         queryResp.NumResp,
+	}
+
+	return client.Send(&header, &resp)
+}
+
+func (i *AgentIPC) handleRepairKeys(client *IPCClient, seq uint64) error {
+	queryResp, err := i.agent.RepairKeys()
+
+	header := responseHeader{
+		Seq:   seq,
+		Error: errToString(err),
+	}
+	resp := keyResponse{
+		Messages:        queryResp.Messages,
+		Keys:            queryResp.Keys,
+		PrimaryKeys:     queryResp.PrimaryKeys,
+		NodeKeys:        queryResp.NodeKeys,
+		NodePrimaryKeys: queryResp.NodePrimaryKeys,
+		NumNodes:        queryResp.NumNodes,
+		NumErr:          queryResp.NumErr,
+		NumResp:         queryResp.NumResp,
+	}
+
+	return client.Send(&header, &resp)
+}
+
+func (i *AgentIPC) handleRotateKey(client *IPCClient, seq uint64) error {
+	var req keyRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	queryResp, err := i.agent.RotateKey(req.Key)
+
 	header := responseHeader{
 		Seq:   seq,
 		Error: errToString(err),
@@ -856,8 +1025,13 @@ func (i *AgentIPC) handleStream(client *IPCClient, seq uint64) error {
 	client.eventStreams[seq] = es
 
 	// Register with the agent. Defer so that we can respond before
-	// registration, avoids any possible race condition
-	defer i.agent.RegisterEventHandler(es)
+	// registration, avoids any possible race condition. The stream has
+	// a single worker so that the events are sent in order.
+	defer i.agent.RegisterEventHandlerWithQueue(es, EventQueueConfig{
+		Size:        i.agent.agentConf.EventQueueSize,
+		Concurrency: 1,
+		Overflow:    i.agent.agentConf.EventQueueOverflow,
+	})
 
 SEND:
 	return client.Send(&resp, nil)
@@ -953,23 +1127,7 @@ func (i *AgentIPC) handleTags(client *IPCClient, seq uint64) error {
 		return fmt.Errorf("decode failed: %v", err)
 	}
 
-	tags := make(map[string]string)
-
-	for key, val := range i.agent.SerfConfig().Tags {
-		var delTag bool
-		for _, delkey := range req.DeleteTags {
-			delTag = (delTag || delkey == key)
-		}
-		if !delTag {
-			tags[key] = val
-		}
-	}
-
-	for key, val := range req.Tags {
-		tags[key] = val
-	}
-
-	err := i.agent.SetTags(tags)
+	err := i.agent.UpdateTags(req.Tags, req.DeleteTags)
 
 	resp := responseHeader{Seq: seq, Error: errToString(err)}
 	return client.Send(&resp, nil)
@@ -983,11 +1141,12 @@ func (i *AgentIPC) handleQuery(client *IPCClient, seq uint64) error {
 
 	// Setup the query
 	params := serf.QueryParam{
-		FilterNodes: req.FilterNodes,
-		FilterTags:  req.FilterTags,
-		RequestAck:  req.RequestAck,
-		RelayFactor: req.RelayFactor,
-		Timeout:     req.Timeout,
+		FilterNodes:        req.FilterNodes,
+		FilterTags:         req.FilterTags,
+		RequestAck:         req.RequestAck,
+		RelayFactor:        req.RelayFactor,
+		Timeout:            req.Timeout,
+		IncludeMaintenance: req.IncludeMaintenance,
 	}
 
 	// Start the query
@@ -997,7 +1156,7 @@ func (i *AgentIPC) handleQuery(client *IPCClient, seq uint64) error {
 	if err == nil {
 		qs := newQueryResponseStream(client, seq, i.logger)
 		defer func() {
-			go qs.Stream(queryResp)
+			go qs.Stream(queryResp, nil)
 		}()
 	}
 
@@ -1072,6 +1231,49 @@ func (i *AgentIPC) handleGetCoordinate(client *IPCClient, seq uint64) error {
 	return client.Send(&header, &resp)
 }
 
+// handleChecks is used to list the state of the local health checks
+func (i *AgentIPC) handleChecks(client *IPCClient, seq uint64) error {
+	header := responseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	resp := checksResponse{
+		Checks: i.agent.Checks(),
+	}
+	return client.Send(&header, &resp)
+}
+
+// handleMaint is used to put the local node into or out of maintenance mode
+func (i *AgentIPC) handleMaint(client *IPCClient, seq uint64) error {
+	var req maintRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	err := i.agent.SetMaintenance(req.Enable, req.Reason)
+
+	resp := responseHeader{Seq: seq, Error: errToString(err)}
+	return client.Send(&resp, nil)
+}
+
+// ipcMember converts a Serf member into the representation used on the wire
+func ipcMember(m serf.Member) Member {
+	return Member{
+		Name:        m.Name,
+		Addr:        m.Addr,
+		Port:        m.Port,
+		Tags:        publicTags(m.Tags),
+		Status:      m.Status.String(),
+		ProtocolMin: m.ProtocolMin,
+		ProtocolMax: m.ProtocolMax,
+		ProtocolCur: m.ProtocolCur,
+		DelegateMin: m.DelegateMin,
+		DelegateMax: m.DelegateMax,
+		DelegateCur: m.DelegateCur,
+		FlapCount:   m.FlapCount,
+	}
+}
+
 // Used to convert an error to a string representation
 func errToString(err error) string {
 	if err == nil {
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"strings"
+)
+
+// aclAllCommands can be used in an ACL token's command list to allow
+// every command.
+const aclAllCommands = "*"
+
+// aclImpliedCommands lists commands that are granted along with another
+// command, since one is not useful without the other.
+var aclImpliedCommands = map[string][]string{
+	membersCommand: {membersFilteredCommand, membersWaitCommand},
+	streamCommand:  {stopCommand},
+	monitorCommand: {stopCommand},
+}
+
+// RPCACLToken is a named RPC token that is only allowed to run a subset
+// of the IPC commands. Clients authenticate with the token exactly as
+// they would with the RPC auth key.
+type RPCACLToken struct {
+	// Name identifies the token in logs
+	Name string `mapstructure:"name"`
+
+	// Token is the secret presented by the client
+	Token string `mapstructure:"token"`
+
+	// Commands is the list of IPC commands the token may run, such as
+	// "members" or "event". A "*" allows every command.
+	Commands []string `mapstructure:"commands"`
+
+	// EventPrefix, if set, restricts the user events the token may
+	// send to names with this prefix.
+	EventPrefix string `mapstructure:"event_prefix"`
+}
+
+// aclTokenMap indexes the tokens by their secret, skipping any that are
+// blank so they can never match an empty auth key.
+func aclTokenMap(tokens []RPCACLToken) map[string]*RPCACLToken {
+	acls := make(map[string]*RPCACLToken, len(tokens))
+	for idx := range tokens {
+		if tokens[idx].Token != "" {
+			acls[tokens[idx].Token] = &tokens[idx]
+		}
+	}
+	return acls
+}
+
+// AllowCommand returns true if the token may run the given command. Only
+// the commands in the IPC command table are allowed, and the public ones,
+// handshake and auth, are always allowed.
+func (t *RPCACLToken) AllowCommand(command string) bool {
+	cmd, ok := ipcCommands[command]
+	if !ok {
+		return false
+	}
+	if cmd.public {
+		return true
+	}
+	for _, allowed := range t.Commands {
+		if allowed == aclAllCommands || allowed == command {
+			return true
+		}
+		for _, implied := range aclImpliedCommands[allowed] {
+			if implied == command {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowEvent returns true if the token may send a user event with the
+// given name.
+func (t *RPCACLToken) AllowEvent(name string) bool {
+	return strings.HasPrefix(name, t.EventPrefix)
+}
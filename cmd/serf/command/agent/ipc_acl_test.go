@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"testing"
+)
+
+func TestRPCACLToken_AllowCommand(t *testing.T) {
+	acl := &RPCACLToken{Name: "read", Commands: []string{"members", "stream"}}
+
+	allowed := []string{handshakeCommand, authCommand, membersCommand,
+		membersFilteredCommand, streamCommand, stopCommand}
+	for _, cmd := range allowed {
+		if !acl.AllowCommand(cmd) {
+			t.Fatalf("should allow %s", cmd)
+		}
+	}
+
+	denied := []string{forceLeaveCommand, installKeyCommand, eventCommand, monitorCommand}
+	for _, cmd := range denied {
+		if acl.AllowCommand(cmd) {
+			t.Fatalf("should deny %s", cmd)
+		}
+	}
+
+	acl = &RPCACLToken{Name: "admin", Commands: []string{"*"}}
+	if !acl.AllowCommand(forceLeaveCommand) {
+		t.Fatalf("should allow")
+	}
+
+	// Commands missing from the IPC command table are never allowed
+	if acl.AllowCommand("foo") {
+		t.Fatalf("should deny")
+	}
+}
+
+func TestRPCACLToken_impliedCommands(t *testing.T) {
+	for command, implied := range aclImpliedCommands {
+		for _, cmd := range append(implied, command) {
+			if _, ok := ipcCommands[cmd]; !ok {
+				t.Fatalf("missing from the command table: %s", cmd)
+			}
+		}
+	}
+}
+
+func TestRPCACLToken_AllowEvent(t *testing.T) {
+	acl := &RPCACLToken{Name: "deploy", Commands: []string{"event"}, EventPrefix: "deploy-"}
+	if !acl.AllowEvent("deploy-web") {
+		t.Fatalf("should allow")
+	}
+	if acl.AllowEvent("restart") {
+		t.Fatalf("should deny")
+	}
+
+	acl.EventPrefix = ""
+	if !acl.AllowEvent("restart") {
+		t.Fatalf("should allow")
+	}
+}
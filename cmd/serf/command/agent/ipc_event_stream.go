@@ -35,15 +35,12 @@ func newEventStream(client streamClient, filters []EventFilter, seq uint64, logg
 
 func (es *eventStream) HandleEvent(e serf.Event) {
 	// Check the event
-	for _, f := range es.filters {
-		if f.Invoke(e) {
-			goto HANDLE
-		}
+	e, ok := filterEvent(es.filters, e)
+	if !ok {
+		return
 	}
-	return
 
 	// Do a non-blocking send
-HANDLE:
 	select {
 	case es.eventCh <- e:
 	default:
@@ -51,6 +48,13 @@ HANDLE:
 	}
 }
 
+// String is the name the stream's queue is reported under. It is the same
+// for every stream, so that clients coming and going don't create new
+// metrics.
+func (es *eventStream) String() string {
+	return "ipc-stream"
+}
+
 func (es *eventStream) Stop() {
 	close(es.eventCh)
 }
@@ -80,20 +84,7 @@ func (es *eventStream) stream() {
 func (es *eventStream) sendMemberEvent(me serf.MemberEvent) error {
 	members := make([]Member, 0, len(me.Members))
 	for _, m := range me.Members {
-		sm := Member{
-			Name:        m.Name,
-			Addr:        m.Addr,
-			Port:        m.Port,
-			Tags:        m.Tags,
-			Status:      m.Status.String(),
-			ProtocolMin: m.ProtocolMin,
-			ProtocolMax: m.ProtocolMax,
-			ProtocolCur: m.ProtocolCur,
-			DelegateMin: m.DelegateMin,
-			DelegateMax: m.DelegateMax,
-			DelegateCur: m.DelegateCur,
-		}
-		members = append(members, sm)
+		members = append(members, ipcMember(m))
 	}
 
 	header := responseHeader{
@@ -114,11 +105,13 @@ func (es *eventStream) sendUserEvent(ue serf.UserEvent) error {
 		Error: "",
 	}
 	rec := userEventRecord{
-		Event:    ue.EventType().String(),
-		LTime:    ue.LTime,
-		Name:     ue.Name,
-		Payload:  ue.Payload,
-		Coalesce: ue.Coalesce,
+		Event:      ue.EventType().String(),
+		LTime:      ue.LTime,
+		Name:       ue.Name,
+		Payload:    ue.Payload,
+		Coalesce:   ue.Coalesce,
+		SourceNode: ue.SourceNode,
+		Verified:   ue.Verified,
 	}
 	return es.client.Send(&header, &rec)
 }
@@ -132,11 +125,12 @@ func (es *eventStream) sendQuery(q *serf.Query) error {
 		Error: "",
 	}
 	rec := queryEventRecord{
-		Event:   q.EventType().String(),
-		ID:      id,
-		LTime:   q.LTime,
-		Name:    q.Name,
-		Payload: q.Payload,
+		Event:    q.EventType().String(),
+		ID:       id,
+		LTime:    q.LTime,
+		Name:     q.Name,
+		Payload:  q.Payload,
+		Verified: q.Verified,
 	}
 	return es.client.Send(&header, &rec)
 }
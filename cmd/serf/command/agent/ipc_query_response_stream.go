@@ -23,8 +23,9 @@ func newQueryResponseStream(client streamClient, seq uint64, logger *log.Logger)
 	return qs
 }
 
-// Stream is a long running routine used to stream the results of a query back to a client
-func (qs *queryResponseStream) Stream(resp *serf.QueryResponse) {
+// Stream is a long running routine used to stream the results of a query back to a client.
+// If stopCh is closed before the query ends, the query is closed and nothing more is sent.
+func (qs *queryResponseStream) Stream(resp *serf.QueryResponse, stopCh <-chan struct{}) {
 	// Setup a timer for the query ending
 	remaining := resp.Deadline().Sub(time.Now())
 	done := time.After(remaining)
@@ -39,7 +40,7 @@ func (qs *queryResponseStream) Stream(resp *serf.QueryResponse) {
 				return
 			}
 		case r := <-respCh:
-			if err := qs.sendResponse(r.From, r.Payload); err != nil {
+			if err := qs.sendResponse(r); err != nil {
 				qs.logger.Printf("[ERR] agent.ipc: Failed to stream response to %v: %v", qs.client, err)
 				return
 			}
@@ -48,6 +49,9 @@ func (qs *queryResponseStream) Stream(resp *serf.QueryResponse) {
 				qs.logger.Printf("[ERR] agent.ipc: Failed to stream query end to %v: %v", qs.client, err)
 			}
 			return
+		case <-stopCh:
+			resp.Close()
+			return
 		}
 	}
 }
@@ -66,15 +70,16 @@ func (qs *queryResponseStream) sendAck(from string) error {
 }
 
 // sendResponse is used to send a single response
-func (qs *queryResponseStream) sendResponse(from string, payload []byte) error {
+func (qs *queryResponseStream) sendResponse(r serf.NodeResponse) error {
 	header := responseHeader{
 		Seq:   qs.seq,
 		Error: "",
 	}
 	rec := queryRecord{
-		Type:    queryRecordResponse,
-		From:    from,
-		Payload: payload,
+		Type:     queryRecordResponse,
+		From:     r.From,
+		Payload:  r.Payload,
+		Verified: r.Verified,
 	}
 	return qs.client.Send(&header, &rec)
 }
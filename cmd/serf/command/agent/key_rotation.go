@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// keyRotationRetryMin and keyRotationRetryMax bound the backoff between
+	// the attempts of a scheduled key rotation that failed
+	keyRotationRetryMin = 10 * time.Second
+	keyRotationRetryMax = 10 * time.Minute
+)
+
+// keyRotationState is a scheduled key rotation that is in progress. It is
+// persisted next to the keyring file so that a rotation interrupted by a
+// restart is resumed rather than leaving the cluster with an extra key.
+type keyRotationState struct {
+	// Key is the new key being rotated to
+	Key string `json:"key"`
+
+	// Previous are the keys that were installed when the rotation started,
+	// which tells them apart from the keys of another node's rotation
+	Previous []string `json:"previous"`
+}
+
+// keyRotationFile returns the path the rotation in progress is kept at
+func (a *Agent) keyRotationFile() string {
+	return a.agentConf.KeyringFile + ".rotation"
+}
+
+// loadKeyRotation returns the rotation in progress, or nil if there is none
+func (a *Agent) loadKeyRotation() (*keyRotationState, error) {
+	raw, err := ioutil.ReadFile(a.keyRotationFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key rotation file: %s", err)
+	}
+	var state keyRotationState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("Failed to decode key rotation file: %s", err)
+	}
+	return &state, nil
+}
+
+// saveKeyRotation persists the rotation in progress
+func (a *Agent) saveKeyRotation(state *keyRotationState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Failed to encode key rotation: %s", err)
+	}
+
+	// Use 0600 for permissions because key data is sensitive
+	if err := ioutil.WriteFile(a.keyRotationFile(), raw, 0600); err != nil {
+		return fmt.Errorf("Failed to write key rotation file: %s", err)
+	}
+	return nil
+}
+
+// clearKeyRotation removes the persisted rotation once it is finished
+func (a *Agent) clearKeyRotation() {
+	if err := os.Remove(a.keyRotationFile()); err != nil && !os.IsNotExist(err) {
+		a.logger.Printf("[ERR] agent: Failed to remove key rotation file: %v", err)
+	}
+}
+
+// keyRotationBackoff returns the time to wait before retrying a rotation
+// that failed, given the previous wait, which is 0 after the first failure
+func keyRotationBackoff(prev, interval time.Duration) time.Duration {
+	wait := 2 * prev
+	if wait < keyRotationRetryMin {
+		wait = keyRotationRetryMin
+	}
+	if wait > keyRotationRetryMax {
+		wait = keyRotationRetryMax
+	}
+	if wait > interval {
+		wait = interval
+	}
+	return wait
+}
+
+// keyRotationLoop rotates to a newly generated key every rotation interval.
+// A rotation that was interrupted by a restart is resumed right away, and
+// one that fails is retried with the same key after a backoff.
+func (a *Agent) keyRotationLoop() {
+	interval := a.agentConf.KeyRotationInterval
+	state, err := a.loadKeyRotation()
+	if err != nil {
+		a.logger.Printf("[ERR] agent: %v", err)
+	}
+
+	wait := interval
+	if state != nil {
+		a.logger.Printf("[INFO] agent: Resuming interrupted key rotation")
+		wait = 0
+	}
+	var retry time.Duration
+	for {
+		select {
+		case <-time.After(wait):
+		case <-a.shutdownCh:
+			return
+		}
+
+		state, err = a.rotateKey(state)
+		if err != nil {
+			retry = keyRotationBackoff(retry, interval)
+			wait = retry
+			a.logger.Printf("[ERR] agent: Key rotation failed, retrying in %v: %v", wait, err)
+			continue
+		}
+		retry = 0
+		wait = interval
+	}
+}
+
+// rotateKey makes one attempt at a scheduled key rotation, starting a new
+// one if state is nil. It returns the rotation that is still in progress.
+//
+// Only one rotation may run at a time, so a new one isn't started while
+// more than one key is installed, which means that another node's rotation
+// or a manual key change is in flight. Two rotations that started at once
+// both see the other's key, and the one with the lower key goes ahead.
+func (a *Agent) rotateKey(state *keyRotationState) (*keyRotationState, error) {
+	resp, err := a.ListKeys()
+	if err != nil {
+		return state, fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	if state == nil {
+		if len(resp.Keys) > 1 {
+			return nil, fmt.Errorf("%d keys are installed, another key rotation may be in progress",
+				len(resp.Keys))
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %v", err)
+		}
+		state = &keyRotationState{Key: base64.StdEncoding.EncodeToString(key)}
+		for installed := range resp.Keys {
+			state.Previous = append(state.Previous, installed)
+		}
+		sort.Strings(state.Previous)
+		if err := a.saveKeyRotation(state); err != nil {
+			return nil, err
+		}
+	} else {
+		for installed := range resp.Keys {
+			if installed < state.Key && !containsKey(state.Previous, installed) {
+				a.logger.Printf("[WARN] agent: Abandoning key rotation, another one is in progress")
+				a.clearKeyRotation()
+				return nil, nil
+			}
+		}
+	}
+
+	if _, err := a.RotateKey(state.Key); err != nil {
+		return state, err
+	}
+	a.logger.Printf("[INFO] agent: Key rotation complete")
+	a.clearKeyRotation()
+	return nil, nil
+}
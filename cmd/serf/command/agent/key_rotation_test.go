@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+	"github.com/hashicorp/serf/testutil/retry"
+)
+
+// testRotationKey returns a key made of the given byte, so that tests can
+// choose how keys sort
+func testRotationKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// testKeyRotationAgent starts an agent with the given keyring and rotation
+// in progress, which may be nil
+func testKeyRotationAgent(t *testing.T, keys []string, state *keyRotationState,
+	interval time.Duration) (*Agent, func()) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	keyringFile := filepath.Join(td, "keyring.json")
+	raw, _ := json.Marshal(keys)
+	if err := ioutil.WriteFile(keyringFile, raw, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if state != nil {
+		raw, _ := json.Marshal(state)
+		if err := ioutil.WriteFile(keyringFile+".rotation", raw, 0600); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	serfConfig := serf.DefaultConfig()
+	serfConfig.KeyringFile = keyringFile
+	agentConfig := DefaultConfig()
+	agentConfig.KeyringFile = keyringFile
+	agentConfig.KeyRotationInterval = interval
+	agentConfig.KeyRotationGracePeriod = 10 * time.Millisecond
+
+	ip1, returnFn1 := testutil.TakeIP()
+	a1 := testAgentWithConfig(t, ip1, agentConfig, serfConfig, nil)
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return a1, func() {
+		a1.Shutdown()
+		returnFn1()
+		os.RemoveAll(td)
+	}
+}
+
+// waitForKeys waits for the cluster to have exactly the given keys
+func waitForKeys(t *testing.T, a *Agent, keys ...string) {
+	retry.Run(t, func(r *retry.R) {
+		resp, err := a.ListKeys()
+		if err != nil {
+			r.Fatalf("err: %v", err)
+		}
+		if len(resp.Keys) != len(keys) {
+			r.Fatalf("bad: %v", resp.Keys)
+		}
+		for _, key := range keys {
+			if resp.Keys[key] == 0 {
+				r.Fatalf("bad: %v", resp.Keys)
+			}
+		}
+	})
+}
+
+func TestAgentKeyRotation_resume(t *testing.T) {
+	oldKey, newKey := testRotationKey(1), testRotationKey(2)
+
+	// The agent restarted after installing the new key, and finishes the
+	// rotation without waiting for the interval
+	a1, cleanup := testKeyRotationAgent(t, []string{oldKey, newKey},
+		&keyRotationState{Key: newKey, Previous: []string{oldKey}}, time.Hour)
+	defer cleanup()
+
+	waitForKeys(t, a1, newKey)
+	retry.Run(t, func(r *retry.R) {
+		if _, err := os.Stat(a1.keyRotationFile()); !os.IsNotExist(err) {
+			r.Fatalf("err: %v", err)
+		}
+	})
+}
+
+func TestAgentKeyRotation_yield(t *testing.T) {
+	oldKey, otherKey, newKey := testRotationKey(0x80), testRotationKey(1), testRotationKey(0x40)
+
+	// Another node's rotation installed a lower key, so this one gives up
+	a1, cleanup := testKeyRotationAgent(t, []string{oldKey, otherKey},
+		&keyRotationState{Key: newKey, Previous: []string{oldKey}}, time.Hour)
+	defer cleanup()
+
+	retry.Run(t, func(r *retry.R) {
+		if _, err := os.Stat(a1.keyRotationFile()); !os.IsNotExist(err) {
+			r.Fatalf("err: %v", err)
+		}
+	})
+	waitForKeys(t, a1, oldKey, otherKey)
+}
+
+func TestAgentKeyRotation_inFlight(t *testing.T) {
+	oldKey, otherKey := testRotationKey(1), testRotationKey(2)
+
+	// A new rotation isn't started while another one is in flight
+	a1, cleanup := testKeyRotationAgent(t, []string{oldKey, otherKey}, nil,
+		50*time.Millisecond)
+	defer cleanup()
+
+	time.Sleep(300 * time.Millisecond)
+	waitForKeys(t, a1, oldKey, otherKey)
+	if _, err := os.Stat(a1.keyRotationFile()); !os.IsNotExist(err) {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestKeyRotationBackoff(t *testing.T) {
+	cases := []struct {
+		prev     time.Duration
+		interval time.Duration
+		expected time.Duration
+	}{
+		{0, time.Hour, keyRotationRetryMin},
+		{keyRotationRetryMin, time.Hour, 2 * keyRotationRetryMin},
+		{keyRotationRetryMax, time.Hour, keyRotationRetryMax},
+		{0, time.Second, time.Second},
+	}
+	for _, tc := range cases {
+		if wait := keyRotationBackoff(tc.prev, tc.interval); wait != tc.expected {
+			t.Fatalf("bad: %v %v: %v", tc.prev, tc.interval, wait)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/serf"
+)
+
+// mergeDelegates is a serf.MergeDelegate that refuses a merge if any of
+// the delegates refuses it
+type mergeDelegates []serf.MergeDelegate
+
+// NotifyMerge is used to meet the serf.MergeDelegate interface
+func (d mergeDelegates) NotifyMerge(members []*serf.Member) error {
+	for _, delegate := range d {
+		if err := delegate.NotifyMerge(members); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowListDelegate is a serf.MergeDelegate that refuses nodes that aren't
+// in the allowed_members list. A join is refused if any of the nodes being
+// merged isn't allowed, and an individual node is refused when it is
+// learned about through gossip.
+type AllowListDelegate struct {
+	// Self is the name of the local node, which is always allowed
+	Self string
+
+	Names  []string
+	Nets   []*net.IPNet
+	Logger *log.Logger
+}
+
+// NewAllowListDelegate parses the allowed_members entries. Entries that
+// contain a "/" are parsed as CIDR blocks, and the rest are name globs.
+func NewAllowListDelegate(self string, allowed []string, logger *log.Logger) (*AllowListDelegate, error) {
+	d := &AllowListDelegate{Self: self, Logger: logger}
+	for _, entry := range allowed {
+		if !strings.Contains(entry, "/") {
+			d.Names = append(d.Names, entry)
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid allowed member '%s': %v", entry, err)
+		}
+		d.Nets = append(d.Nets, ipNet)
+	}
+	return d, nil
+}
+
+// NotifyMerge is used to meet the serf.MergeDelegate interface
+func (d *AllowListDelegate) NotifyMerge(members []*serf.Member) error {
+	for _, m := range members {
+		if !d.Allowed(m) {
+			metrics.IncrCounter([]string{"agent", "member", "rejected"}, 1)
+			d.Logger.Printf("[WARN] agent: Rejecting node '%s' (%s), which isn't an allowed member",
+				m.Name, m.Addr)
+			return fmt.Errorf("Node '%s' (%s) isn't an allowed member", m.Name, m.Addr)
+		}
+	}
+	return nil
+}
+
+// Allowed returns if a member's name or address matches the list
+func (d *AllowListDelegate) Allowed(m *serf.Member) bool {
+	if m.Name == d.Self {
+		return true
+	}
+	for _, pattern := range d.Names {
+		if globMatch(pattern, m.Name) {
+			return true
+		}
+	}
+	for _, ipNet := range d.Nets {
+		if ipNet.Contains(m.Addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+)
+
+func TestAllowListDelegate_Allowed(t *testing.T) {
+	d, err := NewAllowListDelegate("self", []string{"web-*", "db", "10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		addr    string
+		allowed bool
+	}{
+		{"self", "192.168.0.1", true},
+		{"web-1", "192.168.0.1", true},
+		{"db", "192.168.0.1", true},
+		{"db-2", "192.168.0.1", false},
+		{"cache", "10.1.2.3", true},
+		{"cache", "11.1.2.3", false},
+	}
+	for _, tc := range cases {
+		m := &serf.Member{Name: tc.name, Addr: net.ParseIP(tc.addr)}
+		if allowed := d.Allowed(m); allowed != tc.allowed {
+			t.Fatalf("%s (%s): got %v", tc.name, tc.addr, allowed)
+		}
+	}
+}
+
+func TestNewAllowListDelegate_badCIDR(t *testing.T) {
+	if _, err := NewAllowListDelegate("self", []string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestAgent_allowedMembers(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	ip3, returnFn3 := testutil.TakeIP()
+	defer returnFn3()
+
+	agentConfig := DefaultConfig()
+	agentConfig.AllowedMembers = []string{ip2.String() + "/32"}
+	a1 := testAgentWithConfig(t, ip1, agentConfig, serf.DefaultConfig(), nil)
+	defer a1.Shutdown()
+	a2 := testAgent(t, ip2, nil)
+	defer a2.Shutdown()
+	a3 := testAgent(t, ip3, nil)
+	defer a3.Shutdown()
+
+	for _, a := range []*Agent{a1, a2, a3} {
+		if err := a.Start(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	_, err := a1.Join([]string{a3.conf.NodeName + "/" + a3.conf.MemberlistConfig.BindAddr}, false)
+	if err == nil || !strings.Contains(err.Error(), "isn't an allowed member") {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, err = a1.Join([]string{a2.conf.NodeName + "/" + a2.conf.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	if n := len(a1.Serf().Members()); n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+}
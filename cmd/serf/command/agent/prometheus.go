@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/armon/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serfStatsGauges maps the keys of Serf.Stats() that are exported as
+// gauges to their help text.
+var serfStatsGauges = map[string]string{
+	"members":           "Number of known members.",
+	"failed":            "Number of failed members.",
+	"left":              "Number of members that have left.",
+	"health_score":      "Memberlist health score, lower is better.",
+	"member_time":       "Lamport clock for membership changes.",
+	"event_time":        "Lamport clock for user events.",
+	"query_time":        "Lamport clock for queries.",
+	"intent_queue":      "Number of queued membership intents.",
+	"event_queue":       "Number of queued user events.",
+	"query_queue":       "Number of queued queries.",
+	"encrypted":         "Whether gossip encryption is enabled.",
+	"coordinate_resets": "Number of network coordinate resets.",
+}
+
+// noHostnameSink wraps a metrics sink to take the hostname back out of the
+// gauge names, for a sink that shares its metrics configuration with sinks
+// that keep the hostname.
+type noHostnameSink struct {
+	metrics.MetricSink
+	conf *metrics.Config
+}
+
+// SetGauge is used to meet the MetricSink interface
+func (s *noHostnameSink) SetGauge(key []string, val float32) {
+	s.MetricSink.SetGauge(s.stripHostname(key), val)
+}
+
+// SetGaugeWithLabels is used to meet the MetricSink interface
+func (s *noHostnameSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.MetricSink.SetGaugeWithLabels(s.stripHostname(key), val, labels)
+}
+
+// stripHostname returns the key without the hostname, which go-metrics
+// puts after the service name and type prefix
+func (s *noHostnameSink) stripHostname(key []string) []string {
+	if !s.conf.EnableHostname || s.conf.EnableHostnameLabel || s.conf.HostName == "" {
+		return key
+	}
+	i := 0
+	if s.conf.ServiceName != "" && !s.conf.EnableServiceLabel {
+		i++
+	}
+	if s.conf.EnableTypePrefix {
+		i++
+	}
+	if i >= len(key) || key[i] != s.conf.HostName {
+		return key
+	}
+	stripped := make([]string, 0, len(key)-1)
+	stripped = append(stripped, key[:i]...)
+	return append(stripped, key[i+1:]...)
+}
+
+// statsCollector is a Prometheus collector that reports the values of
+// Serf.Stats() as gauges each time it is scraped.
+type statsCollector struct {
+	agent *Agent
+	descs map[string]*prometheus.Desc
+}
+
+func newStatsCollector(agent *Agent) *statsCollector {
+	descs := make(map[string]*prometheus.Desc, len(serfStatsGauges))
+	for key, help := range serfStatsGauges {
+		descs[key] = prometheus.NewDesc(
+			prometheus.BuildFQName("serf", "stats", key), help, nil, nil)
+	}
+	return &statsCollector{agent: agent, descs: descs}
+}
+
+// Describe is used to meet the Collector interface
+func (s *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range s.descs {
+		ch <- desc
+	}
+}
+
+// Collect is used to meet the Collector interface
+func (s *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for key, raw := range s.agent.Serf().Stats() {
+		desc, ok := s.descs[key]
+		if !ok {
+			continue
+		}
+
+		var val float64
+		switch raw {
+		case "true":
+			val = 1
+		case "false":
+			val = 0
+		default:
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			val = v
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, val)
+	}
+}
+
+// AgentPrometheus serves the agent's metrics at /metrics in the
+// Prometheus exposition format. The metrics recorded through go-metrics
+// are included if a Prometheus sink has been registered, along with
+// gauges derived from Serf.Stats().
+type AgentPrometheus struct {
+	listener net.Listener
+	logger   *log.Logger
+	server   *http.Server
+
+	shutdown     bool
+	shutdownLock sync.Mutex
+}
+
+// NewAgentPrometheus is used to create a new metrics endpoint that
+// serves requests on the given listener.
+func NewAgentPrometheus(agent *Agent, listener net.Listener,
+	logOutput io.Writer) *AgentPrometheus {
+	if logOutput == nil {
+		logOutput = os.Stderr
+	}
+
+	// The stats collector gets its own registry so that an agent can be
+	// restarted in the same process without a duplicate registration
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newStatsCollector(agent))
+
+	p := &AgentPrometheus{
+		listener: listener,
+		logger:   log.New(logOutput, "", log.LstdFlags),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, registry},
+		promhttp.HandlerOpts{ErrorLog: p.logger}))
+	p.server = &http.Server{
+		Handler:           mux,
+		ErrorLog:          p.logger,
+		ReadHeaderTimeout: httpReadTimeout,
+	}
+	go p.serve()
+	return p
+}
+
+// Shutdown is used to shutdown the metrics server
+func (p *AgentPrometheus) Shutdown() {
+	p.shutdownLock.Lock()
+	defer p.shutdownLock.Unlock()
+
+	if p.shutdown {
+		return
+	}
+	p.shutdown = true
+	p.server.Close()
+}
+
+// serve is a long running routine that serves requests until shutdown
+func (p *AgentPrometheus) serve() {
+	err := p.server.Serve(p.listener)
+	if err != nil && err != http.ErrServerClosed {
+		p.logger.Printf("[ERR] agent.prometheus: Failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
+	"github.com/hashicorp/serf/testutil"
+	"github.com/mitchellh/cli"
+	gprom "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAgentPrometheus(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	sink, err := prometheus.NewPrometheusSinkFrom(prometheus.PrometheusOpts{
+		Expiration: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer gprom.Unregister(sink)
+	sink.IncrCounter([]string{"agent", "ipc", "accept"}, 1)
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p := NewAgentPrometheus(a1, l, testutil.TestWriter(t))
+	defer p.Shutdown()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad: %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := []string{
+		"serf_stats_members 1",
+		"serf_stats_failed 0",
+		"serf_stats_health_score",
+		"serf_stats_encrypted 0",
+		"agent_ipc_accept 1",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(body), exp) {
+			t.Fatalf("missing %q in: %s", exp, body)
+		}
+	}
+}
+
+func TestCommand_setupMetricsPrometheus(t *testing.T) {
+	statsd, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer statsd.Close()
+
+	config := DefaultConfig()
+	config.StatsdAddr = statsd.LocalAddr().String()
+	config.PrometheusAddr = "127.0.0.1:0"
+
+	c := &Command{Ui: new(cli.MockUi)}
+	conf, sink := c.setupMetrics(config, metrics.NewInmemSink(10*time.Second, time.Minute))
+	if sink == nil {
+		t.Fatalf("bad: %s", c.Ui.(*cli.MockUi).ErrorWriter.String())
+	}
+	fanout := sink.(metrics.FanoutSink)
+	defer gprom.Unregister(fanout[1].(*noHostnameSink).MetricSink.(*prometheus.PrometheusSink))
+
+	conf.EnableRuntimeMetrics = false
+	m, err := metrics.New(conf, sink)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	m.SetGauge([]string{"members"}, 3)
+
+	// Statsd keeps the hostname in the gauge name
+	buf := make([]byte, 1024)
+	statsd.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := statsd.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := "serf-agent." + conf.HostName + ".members:3"
+	if !strings.HasPrefix(string(buf[:n]), expected) {
+		t.Fatalf("bad: %q, expected %q", buf[:n], expected)
+	}
+
+	// Prometheus leaves it out
+	families, err := gprom.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() == "serf_agent_members" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("missing serf_agent_members")
+	}
+}
@@ -2,9 +2,14 @@ package agent
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -37,7 +42,7 @@ func testRPCClientWithConfig(t *testing.T, ip net.IP, agentConf *Config,
 	mult := io.MultiWriter(tw, lw)
 
 	agent := testAgentWithConfig(t, ip, agentConf, serfConf, mult)
-	ipc := NewAgentIPC(agent, "", l, mult, lw)
+	ipc := NewAgentIPC(agent, "", nil, l, mult, lw)
 
 	rpcClient, err := client.NewRPCClient(l.Addr().String())
 	if err != nil {
@@ -264,6 +269,72 @@ func TestRPCClientMembers(t *testing.T) {
 	}
 }
 
+func TestRPCClientMembersWait(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	client, a1, ipc := testRPCClient(t, ip1)
+	defer ipc.Shutdown()
+	defer client.Close()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	a2 := testAgent(t, ip2, nil)
+	if err := a2.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer a2.Shutdown()
+
+	testutil.Yield()
+
+	mem, index, err := client.MembersWait(0, time.Second)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(mem) != 1 || index == 0 {
+		t.Fatalf("bad: %d %#v", index, mem)
+	}
+
+	type result struct {
+		num   int
+		index uint64
+		err   error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		mem, index, err := client.MembersWait(index, 10*time.Second)
+		resultCh <- result{len(mem), index, err}
+	}()
+
+	// Other requests aren't blocked by the wait
+	if _, err := client.Stats(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, err = client.Join([]string{a2.conf.NodeName + "/" + a2.conf.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			t.Fatalf("err: %v", r.err)
+		}
+		if r.index <= index || r.num != 2 {
+			t.Fatalf("bad: %d %d", r.index, r.num)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
 func TestRPCClientMembersFiltered(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
@@ -938,6 +1009,224 @@ func TestRPCClientAuth(t *testing.T) {
 	}
 }
 
+func TestRPCClientACL(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	cl, a1, ipc := testRPCClient(t, ip1)
+	defer ipc.Shutdown()
+	defer cl.Close()
+	defer a1.Shutdown()
+
+	// Setup a read-only token and a token limited to deploy events
+	ipc.aclTokens = aclTokenMap([]RPCACLToken{
+		{Name: "read", Token: "read-token", Commands: []string{"members", "stats"}},
+		{Name: "deploy", Token: "deploy-token", Commands: []string{"event"}, EventPrefix: "deploy"},
+	})
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	// Clients must authenticate once ACL tokens are configured
+	if _, err := cl.Members(); err == nil || err.Error() != authRequired {
+		t.Fatalf("err: %v", err)
+	}
+
+	config := client.Config{Addr: ipc.listener.Addr().String(), AuthKey: "read-token"}
+	readClient, err := client.ClientFromConfig(&config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer readClient.Close()
+
+	mem, err := readClient.Members()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(mem) != 1 {
+		t.Fatalf("bad: %#v", mem)
+	}
+	if err := readClient.ForceLeave("foo"); err != client.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+	if err := readClient.UserEvent("deploy", nil, false); err != client.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := readClient.RepairKeys(); err != client.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The connection should still be usable after a denied request
+	if _, err := readClient.Stats(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	config.AuthKey = "deploy-token"
+	deployClient, err := client.ClientFromConfig(&config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer deployClient.Close()
+
+	if err := deployClient.UserEvent("deploy-web", nil, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := deployClient.UserEvent("restart", nil, false); err != client.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := deployClient.Members(); err != client.ErrPermissionDenied {
+		t.Fatalf("err: %v", err)
+	}
+
+	// An empty key must not grant access
+	config.AuthKey = ""
+	openClient, err := client.ClientFromConfig(&config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer openClient.Close()
+	if _, err := openClient.Members(); err == nil || err.Error() != authRequired {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestRPCClientTLS(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := testTLSFiles(t, dir)
+
+	agentConf := DefaultConfig()
+	agentConf.RPCTLSCert = files["server-cert"]
+	agentConf.RPCTLSKey = files["server-key"]
+	agentConf.RPCTLSCA = files["ca"]
+	agentConf.RPCTLSVerifyClient = true
+	serverTLS, err := agentConf.RPCTLSConfig()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l = tls.NewListener(l, serverTLS)
+
+	lw := NewLogWriter(512)
+	a1 := testAgentWithConfig(t, ip1, agentConf, serf.DefaultConfig(), nil)
+	defer a1.Shutdown()
+	ipc := NewAgentIPC(a1, "", nil, l, testutil.TestWriter(t), lw)
+	defer ipc.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	caPEM, err := ioutil.ReadFile(files["ca"])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+
+	// A plain connection should fail the handshake
+	config := client.Config{Addr: l.Addr().String(), Timeout: time.Second}
+	if _, err := client.ClientFromConfig(&config); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Missing client certificate should be rejected
+	config.TLSConfig = &tls.Config{RootCAs: pool}
+	if _, err := client.ClientFromConfig(&config); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Mutual TLS should work
+	cert, err := tls.LoadX509KeyPair(files["client-cert"], files["client-key"])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	config.TLSConfig = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	rpcClient, err := client.ClientFromConfig(&config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer rpcClient.Close()
+
+	mem, err := rpcClient.Members()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(mem) != 1 {
+		t.Fatalf("bad: %#v", mem)
+	}
+}
+
+func TestRPCClientUnixSocket(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	agentConf := DefaultConfig()
+	agentConf.RPCAddr = "unix://" + filepath.Join(dir, "serf.sock")
+	l, err := listenRPC(agentConf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	lw := NewLogWriter(512)
+	a1 := testAgentWithConfig(t, ip1, agentConf, serf.DefaultConfig(), nil)
+	defer a1.Shutdown()
+	ipc := NewAgentIPC(a1, "", nil, l, testutil.TestWriter(t), lw)
+	defer ipc.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	// Connect two clients to make sure they are tracked separately
+	config := client.Config{Addr: agentConf.RPCAddr}
+	client1, err := client.ClientFromConfig(&config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client1.Close()
+	client2, err := client.ClientFromConfig(&config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer client2.Close()
+
+	ipc.Lock()
+	num := len(ipc.clients)
+	ipc.Unlock()
+	if num != 2 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	mem, err := client2.Members()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(mem) != 1 {
+		t.Fatalf("bad: %#v", mem)
+	}
+}
+
 func TestRPCClient_Keys_EncryptionDisabledError(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
@@ -1119,6 +1408,66 @@ func TestRPCClientStats(t *testing.T) {
 	}
 }
 
+func TestRPCClientChecks(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	client, a1, ipc := testRPCClient(t, ip1)
+	defer ipc.Shutdown()
+	defer client.Close()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	a1.StartChecks([]CheckConfig{{Name: "web", Script: "echo ok"}})
+	waitForHealth(t, a1, HealthPassing)
+
+	checks, err := client.Checks()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	state := a1.Checks()[0]
+	if len(checks) != 1 || checks[0].Name != "web" || checks[0].Status != HealthPassing ||
+		checks[0].Output != "ok\n" || !checks[0].Since.Equal(state.Since) {
+		t.Fatalf("bad: %#v", checks)
+	}
+}
+
+func TestRPCClientMaintenance(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	client, a1, ipc := testRPCClient(t, ip1)
+	defer ipc.Shutdown()
+	defer client.Close()
+	defer a1.Shutdown()
+
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := client.Maintenance(true, "patching"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mem, err := client.Members()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(mem) != 1 || mem[0].Tags[serf.MaintenanceTag] != "patching" {
+		t.Fatalf("bad: %#v", mem)
+	}
+
+	if err := client.Maintenance(false, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if maint, _ := a1.Serf().Maintenance(); maint {
+		t.Fatalf("should not be in maintenance")
+	}
+}
+
 func TestRPCClientGetCoordinate(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
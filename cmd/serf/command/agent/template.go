@@ -0,0 +1,342 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// defaultTemplateDebounce is how long a template waits for member
+	// events to stop arriving before it is rendered
+	defaultTemplateDebounce = time.Second
+
+	// templateMaxDebounce limits how many times the debounce period a
+	// steady stream of events can put off rendering
+	templateMaxDebounce = 10
+
+	// defaultTemplateTimeout is how long a template's command may run
+	// before it is killed
+	defaultTemplateTimeout = 30 * time.Second
+)
+
+// TemplateConfig configures a Go text/template file that is rendered with
+// the cluster members whenever the membership changes.
+type TemplateConfig struct {
+	// Source is the path of the template
+	Source string `mapstructure:"source"`
+
+	// Destination is the path the output is written to. It is replaced
+	// atomically, and only when the output changes.
+	Destination string `mapstructure:"destination"`
+
+	// Command is run in a shell after the destination is written, such as
+	// to reload a service. It is optional.
+	Command string `mapstructure:"command"`
+
+	// DebounceRaw is the string time to wait for member events to settle
+	// before rendering, and Debounce is the parsed value.
+	DebounceRaw string        `mapstructure:"debounce"`
+	Debounce    time.Duration `mapstructure:"-"`
+
+	// TimeoutRaw is the string time the command may run before it is
+	// killed along with any processes it started, and Timeout is the
+	// parsed value.
+	TimeoutRaw string        `mapstructure:"timeout"`
+	Timeout    time.Duration `mapstructure:"-"`
+}
+
+// templateData is what a template is executed with
+type templateData struct {
+	Self    serf.Member
+	Members []serf.Member
+}
+
+// templateFuncs are the helpers available to templates. The filters and
+// sorts take the members last so they can be chained in a pipeline, as in
+// {{range .Members | status "alive" | tag "role" "web" | sortByName}}.
+var templateFuncs = template.FuncMap{
+	"status":     templateStatus,
+	"tag":        templateTag,
+	"hasTag":     templateHasTag,
+	"sortByName": templateSortByName,
+	"sortByTag":  templateSortByTag,
+}
+
+// templateStatus returns the members with a status, such as "alive"
+func templateStatus(status string, members []serf.Member) []serf.Member {
+	var result []serf.Member
+	for _, m := range members {
+		if m.Status.String() == status {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// templateTag returns the members with a tag matching a value, which may
+// be a glob and is negated if it starts with "!"
+func templateTag(name, value string, members []serf.Member) []serf.Member {
+	var result []serf.Member
+	for _, m := range members {
+		if v, ok := m.Tags[name]; ok && matchName(value, v) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// templateHasTag returns the members that have a tag
+func templateHasTag(name string, members []serf.Member) []serf.Member {
+	var result []serf.Member
+	for _, m := range members {
+		if _, ok := m.Tags[name]; ok {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// templateSortByName returns the members sorted by name
+func templateSortByName(members []serf.Member) []serf.Member {
+	result := append([]serf.Member(nil), members...)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// templateSortByTag returns the members sorted by the value of a tag, and
+// then by name
+func templateSortByTag(name string, members []serf.Member) []serf.Member {
+	result := append([]serf.Member(nil), members...)
+	sort.Slice(result, func(i, j int) bool {
+		vi, vj := result[i].Tags[name], result[j].Tags[name]
+		if vi != vj {
+			return vi < vj
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// TemplateEventHandler renders a template with the cluster members when
+// member events are received. A burst of events results in a single
+// render once no events have arrived for the debounce period.
+type TemplateEventHandler struct {
+	Source      string
+	Destination string
+	Command     string
+	Debounce    time.Duration
+	Timeout     time.Duration
+	SelfFunc    func() serf.Member
+	MembersFunc func() []serf.Member
+	Logger      *log.Logger
+
+	triggerCh chan struct{}
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+}
+
+// NewTemplateEventHandler returns a handler for the configuration. Start
+// must be called for it to render.
+func NewTemplateEventHandler(conf TemplateConfig, selfFunc func() serf.Member,
+	membersFunc func() []serf.Member, logger *log.Logger) *TemplateEventHandler {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	debounce := conf.Debounce
+	if debounce <= 0 {
+		debounce = defaultTemplateDebounce
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTemplateTimeout
+	}
+	return &TemplateEventHandler{
+		Source:      conf.Source,
+		Destination: conf.Destination,
+		Command:     conf.Command,
+		Debounce:    debounce,
+		Timeout:     timeout,
+		SelfFunc:    selfFunc,
+		MembersFunc: membersFunc,
+		Logger:      logger,
+		triggerCh:   make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+}
+
+func (h *TemplateEventHandler) String() string {
+	return fmt.Sprintf("template: %s", h.Destination)
+}
+
+// Start renders the template once the debounce period has passed, and
+// again after every burst of member events until Stop is called
+func (h *TemplateEventHandler) Start() {
+	h.trigger()
+	go h.run()
+}
+
+// Stop waits for any render in progress and stops the handler
+func (h *TemplateEventHandler) Stop() {
+	close(h.stopCh)
+	<-h.doneCh
+}
+
+// HandleEvent is used to meet the EventHandler interface. Member events
+// schedule a render.
+func (h *TemplateEventHandler) HandleEvent(e serf.Event) {
+	if _, ok := e.(serf.MemberEvent); ok {
+		h.trigger()
+	}
+}
+
+// trigger schedules a render if one isn't already pending
+func (h *TemplateEventHandler) trigger() {
+	select {
+	case h.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// run waits for events to settle and renders the template
+func (h *TemplateEventHandler) run() {
+	defer close(h.doneCh)
+	for {
+		select {
+		case <-h.triggerCh:
+		case <-h.stopCh:
+			return
+		}
+
+		timer := time.NewTimer(h.Debounce)
+		maxWait := time.After(h.Debounce * templateMaxDebounce)
+	WAIT:
+		for {
+			select {
+			case <-h.triggerCh:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(h.Debounce)
+			case <-timer.C:
+				break WAIT
+			case <-maxWait:
+				timer.Stop()
+				break WAIT
+			case <-h.stopCh:
+				timer.Stop()
+				return
+			}
+		}
+
+		if err := h.update(); err != nil {
+			metrics.IncrCounter([]string{"agent", "template", "error"}, 1)
+			h.Logger.Printf("[ERR] agent: Failed to render template '%s': %v", h.Source, err)
+		}
+	}
+}
+
+// update renders the template, and writes the output and runs the command
+// if it changed
+func (h *TemplateEventHandler) update() error {
+	out, err := h.render()
+	if err != nil {
+		return err
+	}
+
+	existing, err := ioutil.ReadFile(h.Destination)
+	if err == nil && bytes.Equal(existing, out) {
+		return nil
+	}
+	if err := writeFileAtomic(h.Destination, out); err != nil {
+		return err
+	}
+	metrics.IncrCounter([]string{"agent", "template", "render"}, 1)
+	h.Logger.Printf("[INFO] agent: Rendered template '%s' to '%s'", h.Source, h.Destination)
+
+	if h.Command == "" {
+		return nil
+	}
+	output, err := runCommand(h.Command, h.Timeout)
+	h.Logger.Printf("[DEBUG] agent: Template command '%s' output: %s", h.Command, output)
+	if err != nil {
+		return fmt.Errorf("command '%s' failed: %v", h.Command, err)
+	}
+	return nil
+}
+
+// render executes the template with the current members
+func (h *TemplateEventHandler) render() ([]byte, error) {
+	raw, err := ioutil.ReadFile(h.Source)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(h.Source)).Funcs(templateFuncs).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	data := &templateData{
+		Self:    h.SelfFunc(),
+		Members: h.MembersFunc(),
+	}
+	data.Self.Tags = publicTags(data.Self.Tags)
+	for i := range data.Members {
+		data.Members[i].Tags = publicTags(data.Members[i].Tags)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic replaces a file by writing a temporary file next to it
+// and renaming it into place, keeping the mode of the existing file
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	fh, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := fh.Name()
+	if _, err := fh.Write(data); err != nil {
+		fh.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+)
+
+const testTemplate = `{{range .Members | status "alive" | tag "role" "web*" | sortByName -}}
+server {{.Name}} {{.Addr}}:{{.Port}}
+{{end -}}
+# {{.Self.Name}}
+`
+
+// testMembers is a settable member list for a template handler
+type testMembers struct {
+	l       sync.Mutex
+	members []serf.Member
+}
+
+func (m *testMembers) Set(members ...serf.Member) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.members = members
+}
+
+func (m *testMembers) Members() []serf.Member {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return append([]serf.Member(nil), m.members...)
+}
+
+func testTemplateHandler(t *testing.T, command string) (*TemplateEventHandler, *testMembers, string) {
+	dir, err := ioutil.TempDir("", "serf-template")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	source := filepath.Join(dir, "haproxy.cfg.tmpl")
+	if err := ioutil.WriteFile(source, []byte(testTemplate), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	members := new(testMembers)
+	conf := TemplateConfig{
+		Source:      source,
+		Destination: filepath.Join(dir, "haproxy.cfg"),
+		Command:     command,
+		Debounce:    50 * time.Millisecond,
+	}
+	h := NewTemplateEventHandler(conf,
+		func() serf.Member { return serf.Member{Name: "lb"} },
+		members.Members, testutil.TestLogger(t))
+	return h, members, dir
+}
+
+// waitForFile waits for a file to have the expected contents
+func waitForFile(t *testing.T, path string, expected string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out, _ := ioutil.ReadFile(path)
+		if string(out) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %q", out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	members := []serf.Member{
+		{Name: "c", Status: serf.StatusAlive, Tags: map[string]string{"role": "web", "zone": "b"}},
+		{Name: "a", Status: serf.StatusFailed, Tags: map[string]string{"role": "web-canary", "zone": "a"}},
+		{Name: "b", Status: serf.StatusAlive, Tags: map[string]string{"role": "db", "zone": "b"}},
+		{Name: "d", Status: serf.StatusLeft},
+	}
+
+	names := func(members []serf.Member) string {
+		var result []string
+		for _, m := range members {
+			result = append(result, m.Name)
+		}
+		return strings.Join(result, ",")
+	}
+
+	testCases := []struct {
+		members  []serf.Member
+		expected string
+	}{
+		{templateStatus("alive", members), "c,b"},
+		{templateStatus("left", members), "d"},
+		{templateTag("role", "web", members), "c"},
+		{templateTag("role", "web*", members), "c,a"},
+		{templateTag("role", "!web*", members), "b"},
+		{templateHasTag("zone", members), "c,a,b"},
+		{templateSortByName(members), "a,b,c,d"},
+		{templateSortByTag("zone", members), "d,a,b,c"},
+	}
+
+	for i, tc := range testCases {
+		if actual := names(tc.members); actual != tc.expected {
+			t.Errorf("%d: bad: %s", i, actual)
+		}
+	}
+
+	// The input isn't reordered
+	if names(members) != "c,a,b,d" {
+		t.Fatalf("bad: %s", names(members))
+	}
+}
+
+func TestTemplateEventHandler(t *testing.T) {
+	h, members, dir := testTemplateHandler(t, "")
+	defer os.RemoveAll(dir)
+
+	members.Set(
+		serf.Member{Name: "web2", Addr: net.ParseIP("10.0.0.2"), Port: 80,
+			Status: serf.StatusAlive, Tags: map[string]string{"role": "web"}},
+		serf.Member{Name: "web1", Addr: net.ParseIP("10.0.0.1"), Port: 80,
+			Status: serf.StatusAlive, Tags: map[string]string{"role": "web"}},
+		serf.Member{Name: "db1", Addr: net.ParseIP("10.0.0.3"), Port: 5432,
+			Status: serf.StatusAlive, Tags: map[string]string{"role": "db"}},
+	)
+	h.Start()
+	defer h.Stop()
+
+	waitForFile(t, h.Destination,
+		"server web1 10.0.0.1:80\nserver web2 10.0.0.2:80\n# lb\n")
+
+	// A member fails
+	members.Set(
+		serf.Member{Name: "web1", Addr: net.ParseIP("10.0.0.1"), Port: 80,
+			Status: serf.StatusFailed, Tags: map[string]string{"role": "web"}},
+		serf.Member{Name: "web2", Addr: net.ParseIP("10.0.0.2"), Port: 80,
+			Status: serf.StatusAlive, Tags: map[string]string{"role": "web"}},
+	)
+	h.HandleEvent(serf.MemberEvent{Type: serf.EventMemberFailed})
+	waitForFile(t, h.Destination, "server web2 10.0.0.2:80\n# lb\n")
+}
+
+func TestTemplateEventHandler_debounce(t *testing.T) {
+	h, members, dir := testTemplateHandler(t, "")
+	defer os.RemoveAll(dir)
+
+	// The command counts the renders that changed the output
+	runs := filepath.Join(dir, "runs")
+	h.Command = "echo run >>" + runs
+
+	h.Start()
+	defer h.Stop()
+	waitForFile(t, runs, "run\n")
+
+	// A burst of events results in a single render
+	for i := 0; i < 10; i++ {
+		members.Set(serf.Member{Name: "web1", Status: serf.StatusAlive,
+			Tags: map[string]string{"role": "web"}})
+		h.HandleEvent(serf.MemberEvent{Type: serf.EventMemberJoin})
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForFile(t, runs, "run\nrun\n")
+
+	// Events that don't change the output don't run the command
+	h.HandleEvent(serf.MemberEvent{Type: serf.EventMemberUpdate})
+	time.Sleep(200 * time.Millisecond)
+	waitForFile(t, runs, "run\nrun\n")
+
+	// Other events are ignored
+	members.Set()
+	h.HandleEvent(serf.UserEvent{Name: "deploy"})
+	time.Sleep(200 * time.Millisecond)
+	waitForFile(t, runs, "run\nrun\n")
+}
+
+func TestTemplateEventHandler_timeout(t *testing.T) {
+	h, members, dir := testTemplateHandler(t, "")
+	defer os.RemoveAll(dir)
+
+	// The command hangs, so it must be killed before the next render
+	runs := filepath.Join(dir, "runs")
+	h.Command = "echo run >>" + runs + "; sleep 10"
+	h.Timeout = 100 * time.Millisecond
+
+	h.Start()
+	defer h.Stop()
+	waitForFile(t, runs, "run\n")
+
+	members.Set(serf.Member{Name: "web1", Status: serf.StatusAlive,
+		Tags: map[string]string{"role": "web"}})
+	h.HandleEvent(serf.MemberEvent{Type: serf.EventMemberJoin})
+	waitForFile(t, runs, "run\nrun\n")
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serf-template")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(out) != "new" {
+		t.Fatalf("bad: %s", out)
+	}
+
+	// The mode is kept, and the temporary file is gone
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("bad: %v", info.Mode())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("bad: %v", files)
+	}
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unixSocketPrefix is the scheme used in rpc_addr to listen on a Unix
+// domain socket instead of a TCP address.
+const unixSocketPrefix = "unix://"
+
+// listenRPC opens the listener for the agent's RPC interface. Addresses
+// prefixed with unix:// are bound as a Unix domain socket, with the file
+// mode and ownership taken from the configuration.
+func listenRPC(config *Config) (net.Listener, error) {
+	if !strings.HasPrefix(config.RPCAddr, unixSocketPrefix) {
+		return net.Listen("tcp", config.RPCAddr)
+	}
+
+	path := strings.TrimPrefix(config.RPCAddr, unixSocketPrefix)
+	if path == "" {
+		return nil, fmt.Errorf("Missing socket path in %q", config.RPCAddr)
+	}
+
+	// A socket left behind by an agent that didn't shut down cleanly is
+	// replaced, but refuse to clobber anything else
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	// Bind the socket in a private directory next to its path, and only
+	// move it into place once its permissions are set, so that it can't be
+	// connected to with the permissions of the umask in the meantime
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".serf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "rpc.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+	if err := setSocketPermissions(tmpPath, config); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixSocketListener{UnixListener: l, path: path}, nil
+}
+
+// unixSocketListener is a listener on a socket that was bound under a
+// temporary name and moved to path, which it removes when closed.
+type unixSocketListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Addr returns the path the socket was moved to
+func (l *unixSocketListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket
+func (l *unixSocketListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// setSocketPermissions applies the configured mode, owner and group to
+// the socket at path.
+func setSocketPermissions(path string, config *Config) error {
+	if config.RPCSocketMode != "" {
+		mode, err := strconv.ParseUint(config.RPCSocketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid socket mode %q: %s", config.RPCSocketMode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+
+	if config.RPCSocketUser == "" && config.RPCSocketGroup == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if config.RPCSocketUser != "" {
+		id, err := lookupID(config.RPCSocketUser, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("Invalid socket user %q: %s", config.RPCSocketUser, err)
+		}
+		uid = id
+	}
+	if config.RPCSocketGroup != "" {
+		id, err := lookupID(config.RPCSocketGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("Invalid socket group %q: %s", config.RPCSocketGroup, err)
+		}
+		gid = id
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID resolves a user or group given either as a numeric ID or as a
+// name, using lookup for the latter.
+func lookupID(v string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(v); err == nil {
+		return id, nil
+	}
+	id, err := lookup(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenRPC_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "serf.sock")
+	config := DefaultConfig()
+	config.RPCAddr = unixSocketPrefix + path
+	config.RPCSocketMode = "0600"
+	config.RPCSocketUser = "0"
+	config.RPCSocketGroup = "0"
+
+	// Chown to root is only permitted when running as root
+	if os.Getuid() != 0 {
+		config.RPCSocketUser = ""
+		config.RPCSocketGroup = ""
+	}
+
+	l, err := listenRPC(config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if l.Addr().Network() != "unix" || l.Addr().String() != path {
+		t.Fatalf("bad: %v", l.Addr())
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad: %v", fi.Mode())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conn.Close()
+	l.Close()
+
+	// The socket is removed, and nothing is left of the private directory
+	// it was bound in
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("bad: %v", files)
+	}
+}
+
+func TestListenRPC_UnixSocket_Stale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A socket left behind by a previous agent should be replaced
+	path := filepath.Join(dir, "serf.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	config := DefaultConfig()
+	config.RPCAddr = unixSocketPrefix + path
+	l, err := listenRPC(config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Close()
+
+	// A regular file should never be removed
+	path = filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	config.RPCAddr = unixSocketPrefix + path
+	if _, err := listenRPC(config); err == nil {
+		t.Fatalf("should have err")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestListenRPC_BadSocketMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.RPCAddr = unixSocketPrefix + filepath.Join(dir, "serf.sock")
+	config.RPCSocketMode = "rwx"
+	if _, err := listenRPC(config); err == nil {
+		t.Fatalf("should have err")
+	}
+
+	// The socket never appears
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("bad: %v", files)
+	}
+}
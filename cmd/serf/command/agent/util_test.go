@@ -1,9 +1,18 @@
 package agent
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io"
-	"math/rand"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
 	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -13,7 +22,7 @@ import (
 
 func init() {
 	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
+	mathrand.Seed(time.Now().UnixNano())
 }
 
 func drainEventCh(ch <-chan string) {
@@ -49,3 +58,67 @@ func testAgentWithConfig(t *testing.T, ip net.IP, agentConfig *Config, serfConfi
 	}
 	return agent
 }
+
+// testTLSFiles writes a CA, a server certificate valid for 127.0.0.1 and a
+// client certificate into dir, all signed by the CA. The paths are returned
+// keyed by "ca", "server-cert", "server-key", "client-cert" and "client-key".
+func testTLSFiles(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Serf Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	files["ca"] = writeTestPEM(t, dir, "ca.pem", "CERTIFICATE", caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		files[name+"-cert"] = writeTestPEM(t, dir, name+".pem", "CERTIFICATE", der)
+		files[name+"-key"] = writeTestPEM(t, dir, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	}
+	issue("server", 2, x509.ExtKeyUsageServerAuth)
+	issue("client", 3, x509.ExtKeyUsageClientAuth)
+	return files
+}
+
+func writeTestPEM(t *testing.T, dir, name, typ string, der []byte) string {
+	path := filepath.Join(dir, name)
+	buf := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return path
+}
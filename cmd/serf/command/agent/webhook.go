@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// defaultWebhookTimeout is used for webhooks that don't set a timeout
+	defaultWebhookTimeout = 10 * time.Second
+
+	// webhookRetryInterval is how long we wait between webhook attempts
+	webhookRetryInterval = time.Second
+)
+
+// WebhookConfig configures an event handler that POSTs a JSON document
+// describing each event to a URL, instead of running a script.
+type WebhookConfig struct {
+	// URL is the http:// or https:// address the events are sent to
+	URL string `mapstructure:"url"`
+
+	// Events filters the events sent to the webhook, using the same
+	// syntax as the event_handlers filters. All events are sent if it
+	// is blank.
+	Events string `mapstructure:"events"`
+
+	// Headers are added to each request, such as an Authorization header
+	Headers map[string]string `mapstructure:"headers"`
+
+	// TimeoutRaw is the string timeout for each attempt, and Timeout is
+	// the parsed value. The default is 10 seconds.
+	TimeoutRaw string        `mapstructure:"timeout"`
+	Timeout    time.Duration `mapstructure:"-"`
+
+	// Retries is how many times a request is retried if it fails or
+	// the response doesn't have a 2xx status
+	Retries int `mapstructure:"retries"`
+}
+
+// isWebhookURL returns true if an event handler should be treated as a
+// webhook URL rather than a script
+func isWebhookURL(script string) bool {
+	return strings.HasPrefix(script, "http://") || strings.HasPrefix(script, "https://")
+}
+
+// invokeWebhook POSTs the event to the webhook, retrying failed attempts.
+// For queries, a non-empty response body is used to respond.
+func invokeWebhook(logger *log.Logger, hook *WebhookConfig, self serf.Member, event serf.Event) error {
+	defer metrics.MeasureSinceWithLabels([]string{"agent", "webhook", event.EventType().String()}, time.Now(), nil)
+
+	doc, err := newJSONEvent(self, event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	var output []byte
+	for attempt := 0; ; attempt++ {
+		output, err = postWebhook(client, hook, body)
+		if err == nil {
+			break
+		}
+		if attempt >= hook.Retries {
+			return err
+		}
+		logger.Printf("[WARN] agent: Webhook '%s' failed, retrying: %v", hook.URL, err)
+		time.Sleep(webhookRetryInterval)
+	}
+
+	// If this is a query and we have output, respond
+	if query, ok := event.(*serf.Query); ok && len(output) > 0 {
+		if err := query.Respond(output); err != nil {
+			logger.Printf("[WARN] agent: Failed to respond to query '%s': %s",
+				event.String(), err)
+		}
+	}
+	return nil
+}
+
+// postWebhook makes a single request to the webhook, returning up to
+// maxBufSize bytes of the response body
+func postWebhook(client *http.Client, hook *WebhookConfig, body []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, val := range hook.Headers {
+		req.Header.Set(name, val)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	output, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBufSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return output, nil
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/hashicorp/serf/testutil"
+)
+
+// testWebhookServer records the requests made to it, failing the first
+// failures requests with a 500 and responding to the rest with response.
+type testWebhookServer struct {
+	*httptest.Server
+
+	l        sync.Mutex
+	failures int
+	response string
+	requests []*http.Request
+	docs     []*jsonEvent
+}
+
+func newTestWebhookServer(t *testing.T, failures int, response string) *testWebhookServer {
+	s := &testWebhookServer{failures: failures, response: response}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		var doc jsonEvent
+		if err := json.Unmarshal(body, &doc); err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+
+		s.l.Lock()
+		defer s.l.Unlock()
+		s.requests = append(s.requests, r)
+		s.docs = append(s.docs, &doc)
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(s.response))
+	}))
+	return s
+}
+
+func TestScriptEventHandler_webhook(t *testing.T) {
+	s := newTestWebhookServer(t, 0, "")
+	defer s.Close()
+
+	h := &ScriptEventHandler{
+		SelfFunc: func() serf.Member { return serf.Member{Name: "ourname"} },
+		Scripts:  ParseEventScript("member-join=" + s.URL),
+	}
+
+	event := serf.MemberEvent{
+		Type: serf.EventMemberJoin,
+		Members: []serf.Member{
+			{
+				Name:   "foo",
+				Addr:   net.ParseIP("1.2.3.4"),
+				Port:   7946,
+				Tags:   map[string]string{"role": "web"},
+				Status: serf.StatusAlive,
+			},
+		},
+	}
+	h.HandleEvent(event)
+
+	// Filtered out
+	h.HandleEvent(serf.UserEvent{Name: "deploy"})
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if len(s.docs) != 1 {
+		t.Fatalf("bad: %d requests", len(s.docs))
+	}
+	if ct := s.requests[0].Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("bad: %s", ct)
+	}
+
+	doc := s.docs[0]
+	if doc.Event != "member-join" || doc.Self.Name != "ourname" || len(doc.Members) != 1 {
+		t.Fatalf("bad: %#v", doc)
+	}
+	m := doc.Members[0]
+	if m.Name != "foo" || m.Addr != "1.2.3.4" || m.Port != 7946 ||
+		m.Tags["role"] != "web" || m.Status != "alive" {
+		t.Fatalf("bad: %#v", m)
+	}
+}
+
+func TestInvokeWebhook_userEvent(t *testing.T) {
+	s := newTestWebhookServer(t, 2, "")
+	defer s.Close()
+
+	hook := &WebhookConfig{
+		URL:     s.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Retries: 2,
+	}
+	event := serf.UserEvent{
+		LTime:    42,
+		Name:     "deploy",
+		Payload:  []byte("v1.2.3"),
+		Coalesce: true,
+	}
+	if err := invokeWebhook(testutil.TestLogger(t), hook, serf.Member{}, event); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if len(s.docs) != 3 {
+		t.Fatalf("bad: %d requests", len(s.docs))
+	}
+	if auth := s.requests[2].Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Fatalf("bad: %s", auth)
+	}
+	doc := s.docs[2]
+	if doc.Event != "user" || doc.Name != "deploy" || doc.LTime != 42 ||
+		string(doc.Payload) != "v1.2.3" || !doc.Coalesce {
+		t.Fatalf("bad: %#v", doc)
+	}
+}
+
+func TestInvokeWebhook_retriesExhausted(t *testing.T) {
+	s := newTestWebhookServer(t, 2, "")
+	defer s.Close()
+
+	hook := &WebhookConfig{URL: s.URL, Retries: 1}
+	err := invokeWebhook(testutil.TestLogger(t), hook, serf.Member{}, serf.UserEvent{Name: "deploy"})
+	if err == nil {
+		t.Fatalf("should fail")
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if len(s.docs) != 2 {
+		t.Fatalf("bad: %d requests", len(s.docs))
+	}
+}
+
+func TestInvokeWebhook_timeout(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer s.Close()
+
+	hook := &WebhookConfig{URL: s.URL, Timeout: 50 * time.Millisecond}
+	err := invokeWebhook(testutil.TestLogger(t), hook, serf.Member{}, serf.UserEvent{Name: "deploy"})
+	if err == nil {
+		t.Fatalf("should time out")
+	}
+}
+
+func TestAgent_webhookQuery(t *testing.T) {
+	s := newTestWebhookServer(t, 0, "deployed")
+	defer s.Close()
+
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	a1 := testAgent(t, ip1, nil)
+	defer a1.Shutdown()
+
+	a1.RegisterEventHandler(&ScriptEventHandler{
+		SelfFunc: func() serf.Member { return a1.Serf().LocalMember() },
+		Scripts:  ParseEventScript("query:deploy=" + s.URL),
+		Logger:   testutil.TestLogger(t),
+	})
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, err := a1.Query("deploy", []byte("v1.2.3"), &serf.QueryParam{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case r := <-resp.ResponseCh():
+		if r.From != a1.conf.NodeName || string(r.Payload) != "deployed" {
+			t.Fatalf("bad: %#v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	doc := s.docs[0]
+	if doc.Event != "query" || doc.Name != "deploy" || string(doc.Payload) != "v1.2.3" {
+		t.Fatalf("bad: %#v", doc)
+	}
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// ChecksCommand is a Command implementation that lists the state of a
+// running Serf agent's local health checks.
+type ChecksCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &ChecksCommand{}
+
+// Check is the output format of a single check
+type Check struct {
+	Name   string    `json:"name"`
+	Status string    `json:"status"`
+	Output string    `json:"output"`
+	Since  time.Time `json:"since"`
+}
+
+type CheckContainer struct {
+	Checks []Check `json:"checks"`
+}
+
+func (c CheckContainer) String() string {
+	var result []string
+	for _, check := range c.Checks {
+		// Only the first line of the output fits in the table
+		output := strings.TrimSpace(check.Output)
+		if i := strings.Index(output, "\n"); i >= 0 {
+			output = output[:i]
+		}
+		result = append(result, fmt.Sprintf("%s|%s|%s|%s",
+			check.Name, check.Status, check.Since.Format(time.RFC3339), output))
+	}
+	return columnize.SimpleFormat(result)
+}
+
+func (c *ChecksCommand) Help() string {
+	helpText := `
+Usage: serf checks [options]
+
+  Outputs the state of the local health checks of a running Serf agent,
+  with the time each last changed status.
+
+Options:
+
+  -format                   If provided, output is returned in the specified
+                            format. Valid formats are 'json', and 'text' (default)
+
+  -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
+
+  -rpc-auth=""              RPC auth token of the Serf agent.
+
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ChecksCommand) Run(args []string) int {
+	var format string
+	cmdFlags := flag.NewFlagSet("checks", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&format, "format", "text", "output format")
+	rpcAddr := RPCAddrFlag(cmdFlags)
+	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
+		return 1
+	}
+	defer client.Close()
+
+	checks, err := client.Checks()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error retrieving checks: %s", err))
+		return 1
+	}
+
+	result := CheckContainer{}
+	for _, check := range checks {
+		result.Checks = append(result.Checks, Check{
+			Name:   check.Name,
+			Status: check.Status,
+			Output: check.Output,
+			Since:  check.Since,
+		})
+	}
+
+	output, err := formatOutput(result, format)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Encoding error: %s", err))
+		return 1
+	}
+
+	c.Ui.Output(string(output))
+	return 0
+}
+
+func (c *ChecksCommand) Synopsis() string {
+	return "Lists the state of the agent's health checks"
+}
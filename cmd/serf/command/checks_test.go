@@ -0,0 +1,50 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/cmd/serf/command/agent"
+	"github.com/hashicorp/serf/testutil"
+	"github.com/mitchellh/cli"
+)
+
+func TestChecksCommandRun(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	a1 := testAgent(t, ip1)
+	defer a1.Shutdown()
+	a1.StartChecks([]agent.CheckConfig{{Name: "disk", Script: "echo 91% used; exit 1"}})
+
+	rpcAddr, ipc := testIPC(t, ip2, a1)
+	defer ipc.Shutdown()
+
+	// Wait for the check to run
+	deadline := time.Now().Add(5 * time.Second)
+	for a1.Checks()[0].Status != agent.HealthWarning {
+		if time.Now().After(deadline) {
+			t.Fatalf("bad: %#v", a1.Checks())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ui := new(cli.MockUi)
+	c := &ChecksCommand{Ui: ui}
+	args := []string{"-rpc-addr=" + rpcAddr}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	out := ui.OutputWriter.String()
+	if !strings.Contains(out, "disk") || !strings.Contains(out, "warning") ||
+		!strings.Contains(out, "91% used") {
+		t.Fatalf("bad: %#v", out)
+	}
+}
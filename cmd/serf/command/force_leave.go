@@ -24,6 +24,7 @@ func (c *ForceLeaveCommand) Run(args []string) int {
 	cmdFlags.BoolVar(&prune, "prune", false, "Remove agent forcibly from list of members")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -36,7 +37,7 @@ func (c *ForceLeaveCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
@@ -81,6 +82,11 @@ Options:
 
   -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
   -rpc-auth=""              RPC auth token of the Serf agent.
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
   -prune                    Remove agent forcibly from list of members
 `
 	return strings.TrimSpace(helpText)
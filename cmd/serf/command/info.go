@@ -32,6 +32,14 @@ Options:
   -rpc-addr=127.0.0.1:7373 RPC address of the Serf agent.
 
   -rpc-auth=""             RPC auth token of the Serf agent.
+
+  -rpc-tls-cert=""         Client certificate presented to the agent if its
+                           RPC listener requires TLS client certificates.
+
+  -rpc-tls-key=""          Private key for the -rpc-tls-cert certificate.
+
+  -rpc-tls-ca=""           CA certificate used to verify the agent's RPC
+                           certificate. Any -rpc-tls-* flag enables TLS.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -43,11 +51,12 @@ func (i *InfoCommand) Run(args []string) int {
 	cmdFlags.StringVar(&format, "format", "text", "output format")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		i.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
@@ -28,6 +28,11 @@ Options:
   -replay                   Replay past user events.
   -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
   -rpc-auth=""              RPC auth token of the Serf agent.
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -40,6 +45,7 @@ func (c *JoinCommand) Run(args []string) int {
 	cmdFlags.BoolVar(&replayEvents, "replay", false, "replay")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -52,7 +58,7 @@ func (c *JoinCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
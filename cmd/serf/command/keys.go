@@ -3,8 +3,10 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/hashicorp/serf/serf"
 	"github.com/mitchellh/cli"
 	"github.com/ryanuber/columnize"
 )
@@ -45,29 +47,50 @@ Options:
                             eachother.
   -remove=<key>             Remove a key from Serf's internal keyring. The key
                             being removed may not be the current primary key.
+  -rotate=<key>             Rotate the cluster to a new key. The key is installed
+                            on all members, made the primary key once every
+                            member has it, and the other keys are removed after
+                            the agent's key_rotation_grace_period. If a rotation
+                            is interrupted, run it again with the same key to
+                            resume it.
   -list                     List all currently known keys in the cluster. This
                             will ask all nodes in the cluster for a list of keys
                             and dump a summary containing each key and the
                             number of members it is installed on to the console.
+  -status                   Compare the keyring of each member with the keys
+                            and primary key of the majority, and list the
+                            members whose keyring differs. Returns 1 if any do.
+  -repair                   Install the keys that the majority has onto only
+                            the members that are missing them. Extra keys and
+                            differing primary keys are listed but left alone.
   -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
   -rpc-auth=""              RPC auth token of the Serf agent.
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *KeysCommand) Run(args []string) int {
-	var installKey, useKey, removeKey string
+	var installKey, useKey, removeKey, rotateKey string
 	var lines []string
-	var listKeys bool
+	var listKeys, status, repair bool
 
 	cmdFlags := flag.NewFlagSet("key", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 	cmdFlags.StringVar(&installKey, "install", "", "install a new key")
 	cmdFlags.StringVar(&useKey, "use", "", "change primary encryption key")
 	cmdFlags.StringVar(&removeKey, "remove", "", "remove a key")
+	cmdFlags.StringVar(&rotateKey, "rotate", "", "rotate to a new key")
 	cmdFlags.BoolVar(&listKeys, "list", false, "list cluster keys")
+	cmdFlags.BoolVar(&status, "status", false, "show keyring differences")
+	cmdFlags.BoolVar(&repair, "repair", false, "install missing keys")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -80,13 +103,14 @@ func (c *KeysCommand) Run(args []string) int {
 	}
 
 	// Make sure that we only have one actionable argument to avoid ambiguity
-	found := listKeys
-	for _, arg := range []string{installKey, useKey, removeKey} {
-		if found && len(arg) > 0 {
-			c.Ui.Error("Only one of -install, -use, -remove, or -list allowed")
+	found := false
+	for _, set := range []bool{installKey != "", useKey != "", removeKey != "",
+		rotateKey != "", listKeys, status, repair} {
+		if found && set {
+			c.Ui.Error("Only one of -install, -use, -remove, -rotate, -list, -status, or -repair allowed")
 			return 1
 		}
-		found = found || len(arg) > 0
+		found = found || set
 	}
 
 	// Fail fast if no actionable args were passed
@@ -95,7 +119,7 @@ func (c *KeysCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
@@ -132,6 +156,41 @@ func (c *KeysCommand) Run(args []string) int {
 		return 0
 	}
 
+	if status {
+		c.Ui.Info("Asking all members for installed keys...")
+		resp, err := client.ListKeysDetailed()
+		if err != nil {
+			c.outputFailures(resp.Messages)
+			c.Ui.Error("")
+			c.Ui.Error(fmt.Sprintf("Failed to gather member keys: %s", err))
+			if len(resp.NodeKeys) == 0 {
+				return 1
+			}
+			c.Ui.Error("Comparing the keyrings of the members that responded")
+		}
+
+		c.Ui.Output("")
+		if c.outputReport(resp) || err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if repair {
+		c.Ui.Info("Installing missing keys on members...")
+		resp, err := client.RepairKeys()
+		if err != nil {
+			c.outputFailures(resp.Messages)
+			c.Ui.Error("")
+			c.Ui.Error(fmt.Sprintf("Error repairing keyring: %s", err))
+			return 1
+		}
+		c.Ui.Info("Successfully repaired keyring!")
+		c.Ui.Output("")
+		c.outputReport(resp)
+		return 0
+	}
+
 	if installKey != "" {
 		c.Ui.Info("Installing key on all members...")
 		if failures, err := client.InstallKey(installKey); err != nil {
@@ -186,10 +245,71 @@ func (c *KeysCommand) Run(args []string) int {
 		return 0
 	}
 
+	if rotateKey != "" {
+		c.Ui.Info("Rotating key on all members...")
+		if failures, err := client.RotateKey(rotateKey); err != nil {
+			if len(failures) > 0 {
+				for node, message := range failures {
+					lines = append(lines, fmt.Sprintf("failed: | %s | %s", node, message))
+				}
+				out := columnize.SimpleFormat(lines)
+				c.Ui.Error(out)
+			}
+			c.Ui.Error("")
+			c.Ui.Error(fmt.Sprintf("Error rotating key: %s", err))
+			return 1
+		}
+		c.Ui.Info("Successfully rotated key!")
+		return 0
+	}
+
 	// Should never reach this point
 	return 0
 }
 
+// outputFailures prints the messages of the nodes that reported failure
+func (c *KeysCommand) outputFailures(failures map[string]string) {
+	if len(failures) == 0 {
+		return
+	}
+	var lines []string
+	for node, message := range failures {
+		lines = append(lines, fmt.Sprintf("failed: | %s | %s", node, message))
+	}
+	c.Ui.Error(columnize.SimpleFormat(lines))
+}
+
+// outputReport prints the majority's keyring and the members whose keyring
+// differs from it, returning if any do
+func (c *KeysCommand) outputReport(resp *serf.KeyResponse) bool {
+	report := resp.Report()
+	c.Ui.Output(fmt.Sprintf("Primary key: %s", report.PrimaryKey))
+	c.Ui.Output(fmt.Sprintf("Keys:        %s", strings.Join(report.Keys, ", ")))
+	c.Ui.Output("")
+
+	if len(report.Drift) == 0 {
+		c.Ui.Output(fmt.Sprintf("All %d members have the same keyring", len(resp.NodeKeys)))
+		return false
+	}
+
+	nodes := make([]string, 0, len(report.Drift))
+	for node := range report.Drift {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	lines := []string{"Node|Missing|Extra|Primary"}
+	for _, node := range nodes {
+		drift := report.Drift[node]
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%s", node,
+			strings.Join(drift.Missing, ", "), strings.Join(drift.Extra, ", "), drift.PrimaryKey))
+	}
+	c.Ui.Output(fmt.Sprintf("%d/%d members have a keyring that differs from the majority:",
+		len(report.Drift), len(resp.NodeKeys)))
+	c.Ui.Output(columnize.SimpleFormat(lines))
+	return true
+}
+
 func (c *KeysCommand) Synopsis() string {
 	return "Manipulate the internal encryption keyring used by Serf"
 }
@@ -5,6 +5,7 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/serf/client"
@@ -29,6 +30,7 @@ func testKeysCommandAgent(t *testing.T, ip net.IP) *agent.Agent {
 	}
 
 	agentConf := agent.DefaultConfig()
+	agentConf.KeyRotationGracePeriod = 10 * time.Millisecond
 	serfConf := serf.DefaultConfig()
 	serfConf.MemberlistConfig.Keyring = keyring
 
@@ -379,6 +381,122 @@ func TestKeysCommandRun_ListKeysFailure(t *testing.T) {
 	}
 }
 
+func TestKeysCommandRun_RotateKey(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	a1 := testKeysCommandAgent(t, ip1)
+	defer a1.Shutdown()
+
+	rpcAddr, ipc := testIPC(t, ip2, a1)
+	defer ipc.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &KeysCommand{Ui: ui}
+
+	args := []string{
+		"-rpc-addr=" + rpcAddr,
+		"-rotate", "HvY8ubRZMgafUOWvrOadwOckVa1wN3QWAo46FVKbVN8=",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	if !strings.Contains(ui.OutputWriter.String(), "Successfully rotated key") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	rpcClient, err := client.NewRPCClient(rpcAddr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	keys, _, _, err := rpcClient.ListKeys()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("bad: %v", keys)
+	}
+	if _, ok := keys["HvY8ubRZMgafUOWvrOadwOckVa1wN3QWAo46FVKbVN8="]; !ok {
+		t.Fatalf("missing rotated key: %v", keys)
+	}
+}
+
+func TestKeysCommandRun_Status(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	a1 := testKeysCommandAgent(t, ip1)
+	defer a1.Shutdown()
+
+	a2 := testKeysCommandAgent(t, ip2)
+	defer a2.Shutdown()
+
+	rpcAddr, ipc := testIPC(t, ip1, a1)
+	defer ipc.Shutdown()
+
+	_, err := a1.Join([]string{a2.SerfConfig().NodeName + "/" + a2.SerfConfig().MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	testutil.Yield()
+
+	ui := new(cli.MockUi)
+	c := &KeysCommand{Ui: ui}
+	args := []string{"-rpc-addr=" + rpcAddr, "-status"}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "All 2 members have the same keyring") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	// An extra key on one of the members is reported
+	extraKey := "HvY8ubRZMgafUOWvrOadwOckVa1wN3QWAo46FVKbVN8="
+	extraKeyBytes, err := base64.StdEncoding.DecodeString(extraKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := a2.SerfConfig().MemberlistConfig.Keyring.AddKey(extraKeyBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KeysCommand{Ui: ui}
+	code = c.Run(args)
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	out := ui.OutputWriter.String()
+	if !strings.Contains(out, "1/2 members") || !strings.Contains(out, a2.SerfConfig().NodeName) ||
+		!strings.Contains(out, extraKey) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// Repairing only installs missing keys, so the extra key is still listed
+	ui = new(cli.MockUi)
+	c = &KeysCommand{Ui: ui}
+	code = c.Run([]string{"-rpc-addr=" + rpcAddr, "-repair"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	out = ui.OutputWriter.String()
+	if !strings.Contains(out, "Successfully repaired keyring") || !strings.Contains(out, extraKey) {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
 func TestKeysCommandRun_BadOptions(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
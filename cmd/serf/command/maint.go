@@ -0,0 +1,110 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// MaintCommand is a Command implementation that puts a running Serf
+// agent into or out of maintenance mode.
+type MaintCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &MaintCommand{}
+
+func (c *MaintCommand) Help() string {
+	helpText := `
+Usage: serf maint [options] enable|disable
+
+  Puts a running Serf agent into or out of maintenance mode. If a node
+  drops out of the cluster while in maintenance, the other agents fire a
+  member-maint event for it instead of member-failed. Nodes in maintenance
+  ignore queries unless they are sent with -include-maint.
+
+Options:
+
+  -reason=""                A reason for the maintenance, which is shown to
+                            the other members in the "serf.maint" tag.
+  -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
+  -rpc-auth=""              RPC auth token of the Serf agent.
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *MaintCommand) Run(args []string) int {
+	var reason string
+	cmdFlags := flag.NewFlagSet("maint", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&reason, "reason", "", "maintenance reason")
+	rpcAddr := RPCAddrFlag(cmdFlags)
+	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	// Allow the options to come after the action as well
+	args = cmdFlags.Args()
+	if len(args) > 0 {
+		if err := cmdFlags.Parse(args[1:]); err != nil {
+			return 1
+		}
+		if cmdFlags.NArg() > 0 {
+			c.Ui.Error("Too many command line arguments. Only an action must be specified.")
+			c.Ui.Error("")
+			c.Ui.Error(c.Help())
+			return 1
+		}
+	}
+
+	var enable bool
+	switch {
+	case len(args) == 0:
+		c.Ui.Error("An action of enable or disable must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	case args[0] == "enable":
+		enable = true
+	case args[0] == "disable":
+		if reason != "" {
+			c.Ui.Error("A reason can only be given when enabling maintenance mode.")
+			return 1
+		}
+	default:
+		c.Ui.Error(fmt.Sprintf("Invalid action: %s", args[0]))
+		return 1
+	}
+
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
+		return 1
+	}
+	defer client.Close()
+
+	if err := client.Maintenance(enable, reason); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error setting maintenance mode: %s", err))
+		return 1
+	}
+
+	if enable {
+		c.Ui.Output("Maintenance mode enabled")
+	} else {
+		c.Ui.Output("Maintenance mode disabled")
+	}
+	return 0
+}
+
+func (c *MaintCommand) Synopsis() string {
+	return "Puts a Serf agent into or out of maintenance mode"
+}
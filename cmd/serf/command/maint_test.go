@@ -0,0 +1,64 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/serf/testutil"
+	"github.com/mitchellh/cli"
+)
+
+func TestMaintCommandRun(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	a1 := testAgent(t, ip1)
+	defer a1.Shutdown()
+
+	rpcAddr, ipc := testIPC(t, ip2, a1)
+	defer ipc.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &MaintCommand{Ui: ui}
+	args := []string{"-rpc-addr=" + rpcAddr, "enable", "-reason=kernel upgrade"}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Maintenance mode enabled") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+	if maint, reason := a1.Serf().Maintenance(); !maint || reason != "kernel upgrade" {
+		t.Fatalf("bad: %v %s", maint, reason)
+	}
+
+	ui = new(cli.MockUi)
+	c = &MaintCommand{Ui: ui}
+	code = c.Run([]string{"-rpc-addr=" + rpcAddr, "disable"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if maint, _ := a1.Serf().Maintenance(); maint {
+		t.Fatalf("should not be in maintenance")
+	}
+}
+
+func TestMaintCommandRun_badAction(t *testing.T) {
+	cases := [][]string{
+		{},
+		{"pause"},
+		{"enable", "extra"},
+		{"disable", "-reason=foo"},
+	}
+	for _, args := range cases {
+		ui := new(cli.MockUi)
+		c := &MaintCommand{Ui: ui}
+		if code := c.Run(args); code != 1 {
+			t.Fatalf("bad: %v %d", args, code)
+		}
+	}
+}
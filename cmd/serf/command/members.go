@@ -30,6 +30,7 @@ type Member struct {
 	Tags   map[string]string `json:"tags"`
 	Status string            `json:"status"`
 	Proto  map[string]uint8  `json:"protocol"`
+	Flaps  int               `json:"flap_count"`
 }
 
 type MemberContainer struct {
@@ -44,8 +45,8 @@ func (c MemberContainer) String() string {
 			member.Name, member.Addr, member.Status, tags)
 		if member.detail {
 			line += fmt.Sprintf(
-				"|Protocol Version: %d|Available Protocol Range: [%d, %d]",
-				member.Proto["version"], member.Proto["min"], member.Proto["max"])
+				"|Protocol Version: %d|Available Protocol Range: [%d, %d]|Flaps: %d",
+				member.Proto["version"], member.Proto["min"], member.Proto["max"], member.Flaps)
 		}
 		result = append(result, line)
 	}
@@ -88,6 +89,14 @@ Options:
   -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
 
   -rpc-auth=""              RPC auth token of the Serf agent.
+
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -106,6 +115,7 @@ func (c *MembersCommand) Run(args []string) int {
 	cmdFlags.StringVar(&nameFilter, "name", "", "name filter")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -122,7 +132,7 @@ func (c *MembersCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
@@ -152,6 +162,7 @@ func (c *MembersCommand) Run(args []string) int {
 				"max":     member.DelegateMax,
 				"version": member.DelegateCur,
 			},
+			Flaps: member.FlapCount,
 		})
 	}
 
@@ -32,25 +32,34 @@ Usage: serf monitor [options]
 
 Options:
 
+  -events=*                 Filter for the events shown, with the same syntax
+                            as event handlers, such as "member-join[role=web]".
   -log-level=info          Log level of the agent.
   -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
   -rpc-auth=""              RPC auth token of the Serf agent.
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *MonitorCommand) Run(args []string) int {
-	var logLevel string
+	var logLevel, events string
 	cmdFlags := flag.NewFlagSet("monitor", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 	cmdFlags.StringVar(&logLevel, "log-level", "INFO", "log level")
+	cmdFlags.StringVar(&events, "events", "*", "event filter")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	client, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
@@ -58,7 +67,7 @@ func (c *MonitorCommand) Run(args []string) int {
 	defer client.Close()
 
 	eventCh := make(chan map[string]interface{}, 1024)
-	streamHandle, err := client.Stream("*", eventCh)
+	streamHandle, err := client.Stream(events, eventCh)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error starting stream: %s", err))
 		return 1
@@ -45,15 +45,27 @@ Options:
   -relay-factor             If provided, query responses will be relayed through this
                             number of extra nodes for redundancy.
 
+  -include-maint            Send the query to nodes in maintenance mode as well,
+                            which ignore queries by default.
+
   -rpc-addr=127.0.0.1:7373  RPC address of the Serf agent.
 
   -rpc-auth=""              RPC auth token of the Serf agent.
+
+  -rpc-tls-cert=""          Client certificate presented to the agent if its
+                            RPC listener requires TLS client certificates.
+
+  -rpc-tls-key=""           Private key for the -rpc-tls-cert certificate.
+
+  -rpc-tls-ca=""            CA certificate used to verify the agent's RPC
+                            certificate. Any -rpc-tls-* flag enables TLS.
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *QueryCommand) Run(args []string) int {
 	var noAck bool
+	var includeMaint bool
 	var nodes []string
 	var tags []string
 	var timeout time.Duration
@@ -67,8 +79,10 @@ func (c *QueryCommand) Run(args []string) int {
 	cmdFlags.BoolVar(&noAck, "no-ack", false, "no-ack")
 	cmdFlags.StringVar(&format, "format", "text", "output format")
 	cmdFlags.IntVar(&relayFactor, "relay-factor", 0, "response relay count")
+	cmdFlags.BoolVar(&includeMaint, "include-maint", false, "include nodes in maintenance")
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	rpcTLS := RPCTLSFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -104,7 +118,7 @@ func (c *QueryCommand) Run(args []string) int {
 		payload = []byte(args[1])
 	}
 
-	cl, err := RPCClient(*rpcAddr, *rpcAuth)
+	cl, err := RPCClient(*rpcAddr, *rpcAuth, rpcTLS)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Serf agent: %s", err))
 		return 1
@@ -143,6 +157,8 @@ func (c *QueryCommand) Run(args []string) int {
 		Payload:     payload,
 		AckCh:       ackCh,
 		RespCh:      respCh,
+
+		IncludeMaintenance: includeMaint,
 	}
 	if err := cl.Query(&params); err != nil {
 		c.Ui.Error(fmt.Sprintf("Error sending query: %s", err))
@@ -216,7 +232,11 @@ func (t *textQueryRespFormat) ResponseReceived(r client.NodeResponse) {
 		payload = payload[:n-1]
 	}
 
-	t.ui.Info(fmt.Sprintf("Response from '%s': %s", r.From, payload))
+	if r.Verified {
+		t.ui.Info(fmt.Sprintf("Response from '%s' (verified): %s", r.From, payload))
+	} else {
+		t.ui.Info(fmt.Sprintf("Response from '%s': %s", r.From, payload))
+	}
 }
 
 func (t *textQueryRespFormat) Finished() error {
@@ -232,6 +252,7 @@ type jsonQueryRespFormat struct {
 	ui        cli.Ui
 	Acks      []string
 	Responses map[string]string
+	Verified  []string `json:",omitempty"`
 }
 
 func (j *jsonQueryRespFormat) Started() {}
@@ -242,6 +263,9 @@ func (j *jsonQueryRespFormat) AckReceived(from string) {
 
 func (j *jsonQueryRespFormat) ResponseReceived(r client.NodeResponse) {
 	j.Responses[r.From] = string(r.Payload)
+	if r.Verified {
+		j.Verified = append(j.Verified, r.From)
+	}
 }
 
 func (j *jsonQueryRespFormat) Finished() error {
@@ -1,7 +1,11 @@
 package command
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/hashicorp/serf/client"
@@ -16,7 +20,7 @@ func RPCAddrFlag(f *flag.FlagSet) *string {
 	}
 
 	return f.String("rpc-addr", defaultRpcAddr,
-		"RPC address of the Serf agent")
+		"RPC address of the Serf agent, or unix:///path for a Unix socket")
 }
 
 // RPCAuthFlag returns a pointer to a string that will be populated
@@ -27,8 +31,63 @@ func RPCAuthFlag(f *flag.FlagSet) *string {
 		"RPC auth token of the Serf agent")
 }
 
+// RPCTLS holds the certificate paths used to connect to an agent whose
+// RPC listener is wrapped in TLS.
+type RPCTLS struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// RPCTLSFlags returns a pointer to an RPCTLS that will be populated
+// when the given flagset is parsed with the -rpc-tls-* flags.
+func RPCTLSFlags(f *flag.FlagSet) *RPCTLS {
+	var t RPCTLS
+	f.StringVar(&t.CertFile, "rpc-tls-cert", os.Getenv("SERF_RPC_TLS_CERT"),
+		"RPC TLS client certificate")
+	f.StringVar(&t.KeyFile, "rpc-tls-key", os.Getenv("SERF_RPC_TLS_KEY"),
+		"RPC TLS client key")
+	f.StringVar(&t.CAFile, "rpc-tls-ca", os.Getenv("SERF_RPC_TLS_CA"),
+		"RPC TLS CA certificate")
+	return &t
+}
+
+// TLSConfig returns the client TLS configuration, or nil if none of the
+// TLS flags were given.
+func (t *RPCTLS) TLSConfig() (*tls.Config, error) {
+	if t == nil || (t.CertFile == "" && t.KeyFile == "" && t.CAFile == "") {
+		return nil, nil
+	}
+
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load RPC TLS certificate: %s", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read RPC TLS CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Failed to parse RPC TLS CA: no certificates found")
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
 // RPCClient returns a new Serf RPC client with the given address.
-func RPCClient(addr, auth string) (*client.RPCClient, error) {
-	config := client.Config{Addr: addr, AuthKey: auth}
+func RPCClient(addr, auth string, rpcTLS *RPCTLS) (*client.RPCClient, error) {
+	tlsConf, err := rpcTLS.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	config := client.Config{Addr: addr, AuthKey: auth, TLSConfig: tlsConf}
 	return client.ClientFromConfig(&config)
 }
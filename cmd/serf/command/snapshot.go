@@ -0,0 +1,46 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// SnapshotCommand is a Command implementation that groups the commands
+// used to work with an agent's snapshot file offline.
+type SnapshotCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &SnapshotCommand{}
+
+func (c *SnapshotCommand) Run(_ []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *SnapshotCommand) Synopsis() string {
+	return "Inspects and repairs an agent's snapshot file"
+}
+
+func (c *SnapshotCommand) Help() string {
+	helpText := `
+Usage: serf snapshot <subcommand> [options] <path>
+
+  Inspects or rewrites the snapshot file an agent was started with using
+  the -snapshot flag. The agent using the snapshot must not be running
+  when the file is rewritten.
+
+  Inspect a snapshot:
+
+      $ serf snapshot inspect /var/lib/serf/snapshot
+
+  Compact a snapshot:
+
+      $ serf snapshot compact /var/lib/serf/snapshot
+
+  Clear a recorded leave so the agent rejoins on its next start:
+
+      $ serf snapshot clear-leave /var/lib/serf/snapshot
+`
+	return strings.TrimSpace(helpText)
+}
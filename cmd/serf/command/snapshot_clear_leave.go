@@ -0,0 +1,52 @@
+package command
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+)
+
+// SnapshotClearLeaveCommand is a Command implementation that removes a
+// recorded leave from a snapshot file offline.
+type SnapshotClearLeaveCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &SnapshotClearLeaveCommand{}
+
+func (c *SnapshotClearLeaveCommand) Help() string {
+	helpText := `
+Usage: serf snapshot clear-leave <path>
+
+  Rewrites a snapshot file without any recorded leave, so the agent
+  rejoins the previously known nodes on its next start even without the
+  -rejoin flag. The agent using the snapshot must not be running.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SnapshotClearLeaveCommand) Run(args []string) int {
+	if len(args) != 1 {
+		c.Ui.Error("A single snapshot path must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if err := serf.ClearSnapshotLeave(args[0], logger); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error clearing leave: %s", err))
+		return 1
+	}
+
+	c.Ui.Output("Snapshot leave cleared")
+	return 0
+}
+
+func (c *SnapshotClearLeaveCommand) Synopsis() string {
+	return "Removes a recorded leave from a snapshot file"
+}
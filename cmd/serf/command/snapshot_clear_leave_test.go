@@ -0,0 +1,28 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+)
+
+func TestSnapshotClearLeaveCommandRun(t *testing.T) {
+	path, cleanup := testSnapshotFile(t, "alive: foo 127.0.0.1:5000", "leave")
+	defer cleanup()
+
+	ui := new(cli.MockUi)
+	c := &SnapshotClearLeaveCommand{Ui: ui}
+	code := c.Run([]string{path})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	info, err := serf.InspectSnapshot(path, false, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.LeaveRecorded || len(info.AliveNodes) != 1 {
+		t.Fatalf("bad: %#v", info)
+	}
+}
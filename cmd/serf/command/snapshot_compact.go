@@ -0,0 +1,69 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+)
+
+// SnapshotCompactCommand is a Command implementation that compacts a
+// snapshot file offline.
+type SnapshotCompactCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &SnapshotCompactCommand{}
+
+func (c *SnapshotCompactCommand) Help() string {
+	helpText := `
+Usage: serf snapshot compact [options] <path>
+
+  Rewrites a snapshot file so it only contains the state the agent would
+  recover from it, dropping any record left incomplete by a crash and
+  converting a snapshot in the legacy text format. The agent using the
+  snapshot must not be running.
+
+Options:
+
+  -rejoin                   Compact the snapshot as an agent started with
+                            -rejoin would see it. Without it, a recorded
+                            leave clears the state that came before it.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SnapshotCompactCommand) Run(args []string) int {
+	var rejoin bool
+	cmdFlags := flag.NewFlagSet("snapshot compact", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.BoolVar(&rejoin, "rejoin", false, "ignore a recorded leave")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		c.Ui.Error("A single snapshot path must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if err := serf.CompactSnapshot(args[0], rejoin, logger); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error compacting snapshot: %s", err))
+		return 1
+	}
+
+	c.Ui.Output("Snapshot compacted")
+	return 0
+}
+
+func (c *SnapshotCompactCommand) Synopsis() string {
+	return "Compacts a snapshot file offline"
+}
@@ -0,0 +1,32 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+)
+
+func TestSnapshotCompactCommandRun(t *testing.T) {
+	path, cleanup := testSnapshotFile(t,
+		"alive: foo 127.0.0.1:5000", "alive: bar 127.0.0.2:5000", "not-alive: bar")
+	defer cleanup()
+
+	ui := new(cli.MockUi)
+	c := &SnapshotCompactCommand{Ui: ui}
+	code := c.Run([]string{path})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	info, err := serf.InspectSnapshot(path, false, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.Version != serf.SnapshotVersion || info.Size != info.CompactedSize {
+		t.Fatalf("bad: %#v", info)
+	}
+	if len(info.AliveNodes) != 1 || info.AliveNodes[0].Name != "foo" {
+		t.Fatalf("bad: %#v", info.AliveNodes)
+	}
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// SnapshotInspectCommand is a Command implementation that replays a
+// snapshot file and prints the state an agent would recover from it.
+type SnapshotInspectCommand struct {
+	Ui cli.Ui
+}
+
+var _ cli.Command = &SnapshotInspectCommand{}
+
+// SnapshotInfoContainer is used to format the output of a snapshot
+// inspection
+type SnapshotInfoContainer struct {
+	Version          uint16         `json:"version"`
+	NodeName         string         `json:"node_name"`
+	AliveNodes       []SnapshotNode `json:"alive_nodes"`
+	Clock            uint64         `json:"clock"`
+	EventClock       uint64         `json:"event_clock"`
+	QueryClock       uint64         `json:"query_clock"`
+	LeaveRecorded    bool           `json:"leave_recorded"`
+	Size             int64          `json:"size"`
+	TornBytes        int64          `json:"torn_bytes"`
+	CorruptRecords   int            `json:"corrupt_records"`
+	CompactedSize    int64          `json:"compacted_size"`
+	CompactThreshold int64          `json:"compact_threshold"`
+}
+
+// SnapshotNode is a previously known alive node
+type SnapshotNode struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+func (c SnapshotInfoContainer) String() string {
+	version := fmt.Sprintf("%d", c.Version)
+	if c.Version == 0 {
+		version = "legacy"
+	}
+	result := []string{
+		fmt.Sprintf("Version|%s", version),
+		fmt.Sprintf("Node Name|%s", c.NodeName),
+		fmt.Sprintf("Clock|%d", c.Clock),
+		fmt.Sprintf("Event Clock|%d", c.EventClock),
+		fmt.Sprintf("Query Clock|%d", c.QueryClock),
+		fmt.Sprintf("Leave Recorded|%v", c.LeaveRecorded),
+		fmt.Sprintf("Size|%d", c.Size),
+		fmt.Sprintf("Torn Bytes|%d", c.TornBytes),
+		fmt.Sprintf("Corrupt Records|%d", c.CorruptRecords),
+		fmt.Sprintf("Compacted Size|%d", c.CompactedSize),
+		fmt.Sprintf("Compact Threshold|%d", c.CompactThreshold),
+	}
+	out := columnize.SimpleFormat(result)
+
+	out += fmt.Sprintf("\n\nAlive Nodes (%d):\n", len(c.AliveNodes))
+	var nodes []string
+	for _, node := range c.AliveNodes {
+		nodes = append(nodes, fmt.Sprintf("%s|%s", node.Name, node.Addr))
+	}
+	return out + columnize.SimpleFormat(nodes)
+}
+
+func (c *SnapshotInspectCommand) Help() string {
+	helpText := `
+Usage: serf snapshot inspect [options] <path>
+
+  Replays a snapshot file the same way the agent does on start and prints
+  the last known alive nodes, the Lamport clocks, whether a leave was
+  recorded, and the size of the file compared to its compacted size. The
+  file is not modified.
+
+Options:
+
+  -format                   If provided, output is returned in the specified
+                            format. Valid formats are 'json', and 'text' (default)
+
+  -rejoin                   Replay the snapshot as an agent started with
+                            -rejoin would, ignoring a recorded leave.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SnapshotInspectCommand) Run(args []string) int {
+	var format string
+	var rejoin bool
+	cmdFlags := flag.NewFlagSet("snapshot inspect", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&format, "format", "text", "output format")
+	cmdFlags.BoolVar(&rejoin, "rejoin", false, "ignore a recorded leave")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		c.Ui.Error("A single snapshot path must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	info, err := serf.InspectSnapshot(args[0], rejoin, logger)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading snapshot: %s", err))
+		return 1
+	}
+
+	result := SnapshotInfoContainer{
+		Version:          info.Version,
+		NodeName:         info.NodeName,
+		AliveNodes:       []SnapshotNode{},
+		Clock:            uint64(info.LastClock),
+		EventClock:       uint64(info.LastEventClock),
+		QueryClock:       uint64(info.LastQueryClock),
+		LeaveRecorded:    info.LeaveRecorded,
+		Size:             info.Size,
+		TornBytes:        info.TornBytes,
+		CorruptRecords:   info.CorruptRecords,
+		CompactedSize:    info.CompactedSize,
+		CompactThreshold: info.CompactThreshold,
+	}
+	for _, node := range info.AliveNodes {
+		result.AliveNodes = append(result.AliveNodes, SnapshotNode{node.Name, node.Addr})
+	}
+
+	output, err := formatOutput(result, format)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Encoding error: %s", err))
+		return 1
+	}
+
+	c.Ui.Output(string(output))
+	return 0
+}
+
+func (c *SnapshotInspectCommand) Synopsis() string {
+	return "Prints the state recorded in a snapshot file"
+}
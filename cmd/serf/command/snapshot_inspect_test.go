@@ -0,0 +1,70 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func testSnapshotFile(t *testing.T, lines ...string) (string, func()) {
+	dir, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	path := filepath.Join(dir, "snapshot")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("err: %v", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestSnapshotInspectCommandRun(t *testing.T) {
+	path, cleanup := testSnapshotFile(t,
+		"alive: foo 127.0.0.1:5000", "clock: 10", "event-clock: 20", "query-clock: 30")
+	defer cleanup()
+
+	ui := new(cli.MockUi)
+	c := &SnapshotInspectCommand{Ui: ui}
+	code := c.Run([]string{path})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	out := ui.OutputWriter.String()
+	for _, exp := range []string{"Version", "legacy", "Event Clock", "20", "foo", "127.0.0.1:5000"} {
+		if !strings.Contains(out, exp) {
+			t.Fatalf("missing %q in: %s", exp, out)
+		}
+	}
+}
+
+func TestSnapshotInspectCommandRun_JSON(t *testing.T) {
+	path, cleanup := testSnapshotFile(t, "alive: foo 127.0.0.1:5000", "leave")
+	defer cleanup()
+
+	ui := new(cli.MockUi)
+	c := &SnapshotInspectCommand{Ui: ui}
+	code := c.Run([]string{"-format=json", "-rejoin", path})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	out := ui.OutputWriter.String()
+	if !strings.Contains(out, `"leave_recorded": true`) || !strings.Contains(out, `"name": "foo"`) {
+		t.Fatalf("bad: %s", out)
+	}
+}
+
+func TestSnapshotInspectCommandRun_noPath(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &SnapshotInspectCommand{Ui: ui}
+	code := c.Run(nil)
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
@@ -57,6 +57,6 @@ func testIPC(t *testing.T, ip net.IP, a *agent.Agent) (string, *agent.AgentIPC)
 
 	lw := agent.NewLogWriter(512)
 	mult := io.MultiWriter(tw, lw)
-	ipc := agent.NewAgentIPC(a, "", l, mult, lw)
+	ipc := agent.NewAgentIPC(a, "", nil, l, mult, lw)
 	return rpcAddr, ipc
 }
@@ -17,6 +17,24 @@ func init() {
 	ui := &cli.BasicUi{Writer: os.Stdout}
 
 	Commands = map[string]cli.CommandFactory{
+		"admission": func() (cli.Command, error) {
+			return &command.AdmissionCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"admission keygen": func() (cli.Command, error) {
+			return &command.AdmissionKeygenCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"admission token": func() (cli.Command, error) {
+			return &command.AdmissionTokenCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"agent": func() (cli.Command, error) {
 			return &agent.Command{
 				Ui:         ui,
@@ -24,6 +42,12 @@ func init() {
 			}, nil
 		},
 
+		"checks": func() (cli.Command, error) {
+			return &command.ChecksCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"event": func() (cli.Command, error) {
 			return &command.EventCommand{
 				Ui: ui,
@@ -67,6 +91,12 @@ func init() {
 			}, nil
 		},
 
+		"maint": func() (cli.Command, error) {
+			return &command.MaintCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"members": func() (cli.Command, error) {
 			return &command.MembersCommand{
 				Ui: ui,
@@ -80,6 +110,30 @@ func init() {
 			}, nil
 		},
 
+		"snapshot": func() (cli.Command, error) {
+			return &command.SnapshotCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"snapshot inspect": func() (cli.Command, error) {
+			return &command.SnapshotInspectCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"snapshot compact": func() (cli.Command, error) {
+			return &command.SnapshotCompactCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"snapshot clear-leave": func() (cli.Command, error) {
+			return &command.SnapshotClearLeaveCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"tags": func() (cli.Command, error) {
 			return &command.TagsCommand{
 				Ui: ui,
@@ -18,5 +18,6 @@ require (
 	github.com/mitchellh/cli v1.1.0
 	github.com/mitchellh/mapstructure v0.0.0-20160808181253-ca63d7c062ee
 	github.com/posener/complete v1.2.3 // indirect
+	github.com/prometheus/client_golang v1.0.0
 	github.com/ryanuber/columnize v0.0.0-20160712163229-9b3edd62028f
 )
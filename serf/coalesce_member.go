@@ -8,6 +8,10 @@ type coalesceEvent struct {
 type memberEventCoalescer struct {
 	lastEvents   map[string]EventType
 	latestEvents map[string]coalesceEvent
+
+	// flaps are kept apart from the other events, since a flap comes
+	// with the join of the same member rather than replacing it
+	flaps map[string]Member
 }
 
 func (c *memberEventCoalescer) Handle(e Event) bool {
@@ -22,6 +26,10 @@ func (c *memberEventCoalescer) Handle(e Event) bool {
 		return true
 	case EventMemberReap:
 		return true
+	case EventMemberMaint:
+		return true
+	case EventMemberFlap:
+		return true
 	default:
 		return false
 	}
@@ -29,6 +37,12 @@ func (c *memberEventCoalescer) Handle(e Event) bool {
 
 func (c *memberEventCoalescer) Coalesce(raw Event) {
 	e := raw.(MemberEvent)
+	if e.Type == EventMemberFlap {
+		for _, m := range e.Members {
+			c.flaps[m.Name] = m
+		}
+		return
+	}
 	for _, m := range e.Members {
 		c.latestEvents[m.Name] = coalesceEvent{
 			Type:   e.Type,
@@ -65,4 +79,14 @@ func (c *memberEventCoalescer) Flush(outCh chan<- Event) {
 	for _, event := range events {
 		outCh <- *event
 	}
+
+	// Flaps are sent last, so they follow the joins they came with
+	if len(c.flaps) > 0 {
+		flap := MemberEvent{Type: EventMemberFlap}
+		for name, m := range c.flaps {
+			flap.Members = append(flap.Members, m)
+			delete(c.flaps, name)
+		}
+		outCh <- flap
+	}
 }
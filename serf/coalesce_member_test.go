@@ -163,6 +163,65 @@ func TestMemberEventCoalesce_TagUpdate(t *testing.T) {
 	}
 }
 
+func TestMemberEventCoalesce_Flap(t *testing.T) {
+	outCh := make(chan Event, 64)
+	shutdownCh := make(chan struct{})
+	defer close(shutdownCh)
+
+	c := &memberEventCoalescer{
+		lastEvents:   make(map[string]EventType),
+		latestEvents: make(map[string]coalesceEvent),
+		flaps:        make(map[string]Member),
+	}
+
+	inCh := coalescedEventCh(outCh, shutdownCh,
+		5*time.Millisecond, 5*time.Millisecond, c)
+
+	// The flap follows the join it came with, even once they are coalesced
+	send := []Event{
+		MemberEvent{
+			Type:    EventMemberJoin,
+			Members: []Member{Member{Name: "foo"}},
+		},
+		MemberEvent{
+			Type:    EventMemberFlap,
+			Members: []Member{Member{Name: "foo", FlapCount: 1}},
+		},
+		MemberEvent{
+			Type:    EventMemberJoin,
+			Members: []Member{Member{Name: "bar"}},
+		},
+	}
+
+	for _, e := range send {
+		inCh <- e
+	}
+
+	var events []MemberEvent
+	timeout := time.After(10 * time.Millisecond)
+
+MEMBEREVENTFORLOOP:
+	for {
+		select {
+		case e := <-outCh:
+			events = append(events, e.(MemberEvent))
+		case <-timeout:
+			break MEMBEREVENTFORLOOP
+		}
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("bad: %#v", events)
+	}
+	if events[0].Type != EventMemberJoin || len(events[0].Members) != 2 {
+		t.Fatalf("bad: %#v", events[0])
+	}
+	if events[1].Type != EventMemberFlap || len(events[1].Members) != 1 ||
+		events[1].Members[0].Name != "foo" || events[1].Members[0].FlapCount != 1 {
+		t.Fatalf("bad: %#v", events[1])
+	}
+}
+
 func TestMemberEventCoalesce_passThrough(t *testing.T) {
 	cases := []struct {
 		e      Event
@@ -174,6 +233,7 @@ func TestMemberEventCoalesce_passThrough(t *testing.T) {
 		{MemberEvent{Type: EventMemberFailed}, true},
 		{MemberEvent{Type: EventMemberUpdate}, true},
 		{MemberEvent{Type: EventMemberReap}, true},
+		{MemberEvent{Type: EventMemberFlap}, true},
 	}
 
 	for _, tc := range cases {
@@ -1,6 +1,7 @@
 package serf
 
 import (
+	"crypto/ed25519"
 	"io"
 	"log"
 	"os"
@@ -104,6 +105,7 @@ type Config struct {
 	//
 	// ReconnectTimeout is the amount of time to attempt to reconnect to
 	// a failed node before giving up and considering it completely gone.
+	// This also applies to nodes that dropped out while in maintenance.
 	//
 	// TombstoneTimeout is the amount of time to keep around nodes
 	// that gracefully left as tombstones for syncing state with other
@@ -114,10 +116,10 @@ type Config struct {
 	TombstoneTimeout  time.Duration
 
 	// FlapTimeout is the amount of time less than which we consider a node
-	// being failed and rejoining looks like a flap for telemetry purposes.
-	// This should be set less than a typical reboot time, but large enough
-	// to see actual events, given our expected detection times for a failed
-	// node.
+	// being failed and rejoining looks like a flap, which is counted for
+	// the member and fires an EventMemberFlap. This should be set less than
+	// a typical reboot time, but large enough to see actual events, given
+	// our expected detection times for a failed node.
 	FlapTimeout time.Duration
 
 	// QueueCheckInterval is the interval at which we check the message
@@ -214,6 +216,12 @@ type Config struct {
 	// succeeds and will also avoid replaying old user events.
 	SnapshotPath string
 
+	// SnapshotStore can be provided instead of SnapshotPath to persist the
+	// snapshot somewhere other than a local file, such as an embedded
+	// key/value store. See InmemSnapshotStore for an example. Serf closes
+	// the store when it shuts down.
+	SnapshotStore SnapshotStore
+
 	// RejoinAfterLeave controls our interaction with the snapshot file.
 	// When set to false (default), a leave causes a Serf to not rejoin
 	// the cluster until an explicit join is received. If this is set to
@@ -236,6 +244,12 @@ type Config struct {
 	// two nodes. Enabling this option adds some overhead to ping messages.
 	DisableCoordinates bool
 
+	// SnapshotCoordinateMaxAge is how old the network coordinates recorded
+	// in the snapshot may be and still be restored when Serf starts. Older
+	// coordinates are discarded and the node converges from the origin
+	// again. A zero value restores coordinates regardless of their age.
+	SnapshotCoordinateMaxAge time.Duration
+
 	// KeyringFile provides the location of a writable file where Serf can
 	// persist changes to the encryption keyring.
 	KeyringFile string
@@ -244,6 +258,21 @@ type Config struct {
 	// and conditionally abort the merge.
 	Merge MergeDelegate
 
+	// ClusterName, if set, is gossiped in the reserved ClusterNameTag and
+	// checked whenever nodes are merged, so a node with a different or
+	// missing cluster name can't join or be joined to this one. This
+	// guards against two clusters being merged by a misconfigured join.
+	ClusterName string
+
+	// SigningKey, if set, is used to sign the user events, queries and
+	// query responses sent by this node. The public key is gossiped in the
+	// reserved SigningKeyTag, and the events and responses received from
+	// other nodes are marked as Verified if they carry a valid signature
+	// from the key their sender published. Pair this with a check of the
+	// nodes that may join, such as a MergeDelegate, since otherwise any
+	// node could publish its own key under a name it doesn't own.
+	SigningKey ed25519.PrivateKey
+
 	// UserEventSizeLimit is maximum byte size limit of user event `name` + `payload` in bytes.
 	// It's optimal to be relatively small, since it's going to be gossiped through the cluster.
 	UserEventSizeLimit int
@@ -311,6 +340,7 @@ func DefaultConfig() *Config {
 		QuerySizeLimit:               1024,
 		EnableNameConflictResolution: true,
 		DisableCoordinates:           false,
+		SnapshotCoordinateMaxAge:     time.Hour,
 		ValidateNodeNames:            false,
 		UserEventSizeLimit:           512,
 	}
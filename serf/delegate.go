@@ -17,9 +17,10 @@ type delegate struct {
 var _ memberlist.Delegate = &delegate{}
 
 func (d *delegate) NodeMeta(limit int) []byte {
-	roleBytes := d.serf.encodeTags(d.serf.config.Tags)
+	tags := d.serf.localTags()
+	roleBytes := d.serf.encodeTags(tags)
 	if len(roleBytes) > limit {
-		panic(fmt.Errorf("Node tags '%v' exceeds length limit of %d bytes", d.serf.config.Tags, limit))
+		panic(fmt.Errorf("Node tags '%v' exceeds length limit of %d bytes", tags, limit))
 	}
 
 	return roleBytes
@@ -184,6 +185,7 @@ func (d *delegate) LocalState(join bool) []byte {
 		EventLTime:   d.serf.eventClock.Time(),
 		Events:       d.serf.eventBuffer,
 		QueryLTime:   d.serf.queryClock.Time(),
+		ClusterName:  d.serf.config.ClusterName,
 	}
 
 	// Add all the join LTimes
@@ -229,6 +231,14 @@ func (d *delegate) MergeRemoteState(buf []byte, isJoin bool) {
 		return
 	}
 
+	// Ignore the state of another cluster
+	if name := d.serf.config.ClusterName; name != "" && pp.ClusterName != name {
+		metrics.IncrCounterWithLabels([]string{"serf", "merge", "cluster_name_mismatch"}, 1, d.serf.metricLabels)
+		d.serf.logger.Printf("[WARN] serf: Ignoring remote state from cluster '%s', expected '%s'",
+			pp.ClusterName, name)
+		return
+	}
+
 	// Witness the Lamport clocks first.
 	// We subtract 1 since no message with that clock has been sent yet
 	if pp.LTime > 0 {
@@ -291,6 +301,9 @@ func (d *delegate) MergeRemoteState(buf []byte, isJoin bool) {
 		for _, e := range events.Events {
 			userEvent.Name = e.Name
 			userEvent.Payload = e.Payload
+			userEvent.CC = e.CC
+			userEvent.SourceNode = e.SourceNode
+			userEvent.Signature = e.Signature
 			d.serf.handleUserEvent(&userEvent)
 		}
 	}
@@ -21,6 +21,8 @@ const (
 	EventMemberReap
 	EventUser
 	EventQuery
+	EventMemberMaint
+	EventMemberFlap
 )
 
 func (t EventType) String() string {
@@ -39,6 +41,10 @@ func (t EventType) String() string {
 		return "user"
 	case EventQuery:
 		return "query"
+	case EventMemberMaint:
+		return "member-maint"
+	case EventMemberFlap:
+		return "member-flap"
 	default:
 		panic(fmt.Sprintf("unknown event type: %d", t))
 	}
@@ -75,6 +81,10 @@ func (m MemberEvent) String() string {
 		return "member-update"
 	case EventMemberReap:
 		return "member-reap"
+	case EventMemberMaint:
+		return "member-maint"
+	case EventMemberFlap:
+		return "member-flap"
 	default:
 		panic(fmt.Sprintf("unknown event type: %d", m.Type))
 	}
@@ -87,6 +97,14 @@ type UserEvent struct {
 	Name     string
 	Payload  []byte
 	Coalesce bool
+
+	// SourceNode is the node that sent the event. It is only known if the
+	// sender signs its events.
+	SourceNode string
+
+	// Verified is true if the event was signed by the key SourceNode
+	// published in its SigningKeyTag
+	Verified bool
 }
 
 func (u UserEvent) EventType() EventType {
@@ -103,6 +121,10 @@ type Query struct {
 	Name    string
 	Payload []byte
 
+	// Verified is true if the query was signed by the key its source node
+	// published in its SigningKeyTag
+	Verified bool
+
 	serf        *Serf
 	id          uint32    // ID is not exported, since it may change
 	addr        []byte    // Address to respond to
@@ -121,6 +143,12 @@ func (q *Query) String() string {
 	return fmt.Sprintf("query: %s", q.Name)
 }
 
+// ID returns the ID the initiating node assigned to the query. Together
+// with the LTime, it identifies the query.
+func (q *Query) ID() uint32 {
+	return q.id
+}
+
 // SourceNode returns the name of the node initiating the query
 func (q *Query) SourceNode() string {
 	return q.sourceNode
@@ -133,12 +161,14 @@ func (q *Query) Deadline() time.Time {
 
 func (q *Query) createResponse(buf []byte) messageQueryResponse {
 	// Create response
-	return messageQueryResponse{
+	resp := messageQueryResponse{
 		LTime:   q.LTime,
 		ID:      q.id,
 		From:    q.serf.config.NodeName,
 		Payload: buf,
 	}
+	resp.Signature = q.serf.sign(messageQueryResponseType, &resp)
+	return resp
 }
 
 // Check response size
@@ -192,9 +192,11 @@ func TestQuery(t *testing.T) {
 
 func TestEventType_String(t *testing.T) {
 	events := []EventType{EventMemberJoin, EventMemberLeave, EventMemberFailed,
-		EventMemberUpdate, EventMemberReap, EventUser, EventQuery}
+		EventMemberUpdate, EventMemberReap, EventUser, EventQuery, EventMemberMaint,
+		EventMemberFlap}
 	expect := []string{"member-join", "member-leave", "member-failed",
-		"member-update", "member-reap", "user", "query"}
+		"member-update", "member-reap", "user", "query", "member-maint",
+		"member-flap"}
 
 	for idx, event := range events {
 		if event.String() != expect[idx] {
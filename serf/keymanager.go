@@ -3,7 +3,9 @@ package serf
 import (
 	"encoding/base64"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 // KeyManager encapsulates all functionality within Serf for handling
@@ -13,6 +15,10 @@ type KeyManager struct {
 
 	// Lock to protect read and write operations
 	l sync.RWMutex
+
+	// seqLock serializes the operations that are made up of several
+	// keyring queries, such as rotations and repairs
+	seqLock sync.Mutex
 }
 
 // keyRequest is used to contain input parameters which get broadcasted to all
@@ -35,6 +41,12 @@ type KeyResponse struct {
 	// PrimaryKeys is a mapping of the base64-encoded value of the primary
 	// key bytes to the number of nodes that have the key installed.
 	PrimaryKeys map[string]int
+
+	// NodeKeys is a mapping of node name to the base64-encoded keys the
+	// node has installed, and NodePrimaryKeys to the node's primary key.
+	// They are only filled in for key list queries.
+	NodeKeys        map[string][]string
+	NodePrimaryKeys map[string]string
 }
 
 // KeyRequestOptions is used to contain optional parameters for a keyring operation
@@ -42,6 +54,20 @@ type KeyRequestOptions struct {
 	// RelayFactor is the number of duplicate query responses to send by relaying through
 	// other nodes, for redundancy
 	RelayFactor uint8
+
+	// FilterNodes limits the operation to the named nodes. If empty, all
+	// nodes take part.
+	FilterNodes []string
+}
+
+// RotateOptions is used to contain optional parameters for a key rotation
+type RotateOptions struct {
+	KeyRequestOptions
+
+	// GracePeriod is how long to wait after all nodes have switched to the
+	// new primary key before the old keys are removed. This gives messages
+	// that are still in flight time to be received.
+	GracePeriod time.Duration
 }
 
 // streamKeyResp takes care of reading responses from a channel and composing
@@ -85,6 +111,12 @@ func (k *KeyManager) streamKeyResp(resp *KeyResponse, ch <-chan NodeResponse) {
 
 		resp.PrimaryKeys[nodeResponse.PrimaryKey]++
 
+		// Only key list responses carry the node's keyring
+		if nodeResponse.Result && nodeResponse.PrimaryKey != "" {
+			resp.NodeKeys[r.From] = nodeResponse.Keys
+			resp.NodePrimaryKeys[r.From] = nodeResponse.PrimaryKey
+		}
+
 	NEXT:
 		// Return early if all nodes have responded. This allows us to avoid
 		// waiting for the full timeout when there is nothing left to do.
@@ -99,9 +131,11 @@ func (k *KeyManager) streamKeyResp(resp *KeyResponse, ch <-chan NodeResponse) {
 // KeyResponse for uniform response handling.
 func (k *KeyManager) handleKeyRequest(key, query string, opts *KeyRequestOptions) (*KeyResponse, error) {
 	resp := &KeyResponse{
-		Messages:    make(map[string]string),
-		Keys:        make(map[string]int),
-		PrimaryKeys: make(map[string]int),
+		Messages:        make(map[string]string),
+		Keys:            make(map[string]int),
+		PrimaryKeys:     make(map[string]int),
+		NodeKeys:        make(map[string][]string),
+		NodePrimaryKeys: make(map[string]string),
 	}
 	qName := internalQueryName(query)
 
@@ -120,6 +154,7 @@ func (k *KeyManager) handleKeyRequest(key, query string, opts *KeyRequestOptions
 	qParam := k.serf.DefaultQueryParams()
 	if opts != nil {
 		qParam.RelayFactor = opts.RelayFactor
+		qParam.FilterNodes = opts.FilterNodes
 	}
 	queryResp, err := k.serf.Query(qName, req, qParam)
 	if err != nil {
@@ -128,6 +163,9 @@ func (k *KeyManager) handleKeyRequest(key, query string, opts *KeyRequestOptions
 
 	// Handle the response stream and populate the KeyResponse
 	resp.NumNodes = k.serf.memberlist.NumMembers()
+	if len(qParam.FilterNodes) > 0 {
+		resp.NumNodes = len(qParam.FilterNodes)
+	}
 	k.streamKeyResp(resp, queryResp.respCh)
 
 	// Check the response for any reported failure conditions
@@ -198,3 +236,222 @@ func (k *KeyManager) ListKeysWithOptions(opts *KeyRequestOptions) (*KeyResponse,
 
 	return k.handleKeyRequest("", listKeysQuery, opts)
 }
+
+// Rotate replaces the keyring of every member with a single new key. The key
+// is installed, made the primary key once every node has acknowledged the
+// install, and the other keys are removed after the grace period once
+// ListKeys confirms every node has switched.
+//
+// Each step is skipped if ListKeys shows it was already completed, so a
+// rotation that was interrupted can be resumed by calling Rotate again with
+// the same key. The returned KeyResponse is from the final ListKeys.
+func (k *KeyManager) Rotate(key string, opts *RotateOptions) (*KeyResponse, error) {
+	k.seqLock.Lock()
+	defer k.seqLock.Unlock()
+
+	if opts == nil {
+		opts = &RotateOptions{}
+	}
+	logger := k.serf.logger
+
+	resp, err := k.ListKeysWithOptions(&opts.KeyRequestOptions)
+	if err != nil {
+		return resp, fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	if resp.Keys[key] < resp.NumNodes {
+		logger.Printf("[INFO] serf: Key rotation installing new key on %d nodes", resp.NumNodes)
+		if resp, err = k.InstallKeyWithOptions(key, &opts.KeyRequestOptions); err != nil {
+			return resp, fmt.Errorf("failed to install key: %v", err)
+		}
+	}
+
+	if resp.PrimaryKeys[key] < resp.NumNodes {
+		logger.Printf("[INFO] serf: Key rotation switching primary key on %d nodes", resp.NumNodes)
+		if resp, err = k.UseKeyWithOptions(key, &opts.KeyRequestOptions); err != nil {
+			return resp, fmt.Errorf("failed to change primary key: %v", err)
+		}
+	}
+
+	// Only remove the old keys once every node is using the new one, since
+	// a node that is still using an old key couldn't be heard otherwise
+	resp, err = k.ListKeysWithOptions(&opts.KeyRequestOptions)
+	if err != nil {
+		return resp, fmt.Errorf("failed to verify primary key: %v", err)
+	}
+	if n := resp.PrimaryKeys[key]; n != resp.NumNodes {
+		return resp, fmt.Errorf("%d/%d nodes switched to the new primary key", n, resp.NumNodes)
+	}
+
+	var old []string
+	for installed := range resp.Keys {
+		if installed != key {
+			old = append(old, installed)
+		}
+	}
+	if len(old) == 0 {
+		return resp, nil
+	}
+	sort.Strings(old)
+
+	if opts.GracePeriod > 0 {
+		logger.Printf("[INFO] serf: Key rotation waiting %v before removing %d old keys",
+			opts.GracePeriod, len(old))
+		select {
+		case <-time.After(opts.GracePeriod):
+		case <-k.serf.ShutdownCh():
+			return resp, fmt.Errorf("Serf shutdown before old keys were removed")
+		}
+	}
+
+	for _, oldKey := range old {
+		if resp, err = k.RemoveKeyWithOptions(oldKey, &opts.KeyRequestOptions); err != nil {
+			return resp, fmt.Errorf("failed to remove old key: %v", err)
+		}
+	}
+	logger.Printf("[INFO] serf: Key rotation removed %d old keys", len(old))
+
+	return k.ListKeysWithOptions(&opts.KeyRequestOptions)
+}
+
+// KeyringReport compares the keyring of each node in a key list response
+// with the keyring of the majority of the nodes
+type KeyringReport struct {
+	// Keys are the keys installed on more than half of the nodes that
+	// listed their keys
+	Keys []string
+
+	// PrimaryKey is the primary key of the most nodes
+	PrimaryKey string
+
+	// Drift has an entry for each node whose keyring differs from the
+	// majority's
+	Drift map[string]*KeyDrift
+}
+
+// KeyDrift describes how a node's keyring differs from the majority's
+type KeyDrift struct {
+	// Missing are the majority's keys that the node doesn't have
+	Missing []string
+
+	// Extra are the node's keys that the majority doesn't have
+	Extra []string
+
+	// PrimaryKey is the node's primary key if it differs from the
+	// majority's, and empty otherwise
+	PrimaryKey string
+}
+
+// Report compares the keyring of each node with the majority's. It uses the
+// per-node keyrings, so it is only meaningful for key list responses.
+func (r *KeyResponse) Report() *KeyringReport {
+	report := &KeyringReport{Drift: make(map[string]*KeyDrift)}
+
+	counts := make(map[string]int)
+	for _, keys := range r.NodeKeys {
+		for _, key := range keys {
+			counts[key]++
+		}
+	}
+	for key, n := range counts {
+		if 2*n > len(r.NodeKeys) {
+			report.Keys = append(report.Keys, key)
+		}
+	}
+	sort.Strings(report.Keys)
+
+	// Ties are broken by the key so the report is stable
+	primaries := make(map[string]int)
+	for _, key := range r.NodePrimaryKeys {
+		primaries[key]++
+	}
+	for key, n := range primaries {
+		best := primaries[report.PrimaryKey]
+		if n > best || (n == best && key < report.PrimaryKey) {
+			report.PrimaryKey = key
+		}
+	}
+
+	majority := make(map[string]bool)
+	for _, key := range report.Keys {
+		majority[key] = true
+	}
+	for node, keys := range r.NodeKeys {
+		drift := &KeyDrift{}
+		installed := make(map[string]bool)
+		for _, key := range keys {
+			installed[key] = true
+			if !majority[key] {
+				drift.Extra = append(drift.Extra, key)
+			}
+		}
+		for _, key := range report.Keys {
+			if !installed[key] {
+				drift.Missing = append(drift.Missing, key)
+			}
+		}
+		if primary := r.NodePrimaryKeys[node]; primary != report.PrimaryKey {
+			drift.PrimaryKey = primary
+		}
+
+		if len(drift.Missing) > 0 || len(drift.Extra) > 0 || drift.PrimaryKey != "" {
+			sort.Strings(drift.Extra)
+			report.Drift[node] = drift
+		}
+	}
+	return report
+}
+
+// RepairKeys installs the keys that a majority of the nodes have onto the
+// nodes that are missing them, sending each key only to those nodes. Extra
+// keys and differing primary keys are reported by KeyResponse.Report but
+// left alone, since there is no telling which side is right. The returned
+// KeyResponse is from a ListKeys after the repair.
+func (k *KeyManager) RepairKeys() (*KeyResponse, error) {
+	return k.RepairKeysWithOptions(nil)
+}
+
+func (k *KeyManager) RepairKeysWithOptions(opts *KeyRequestOptions) (*KeyResponse, error) {
+	k.seqLock.Lock()
+	defer k.seqLock.Unlock()
+
+	if opts == nil {
+		opts = &KeyRequestOptions{}
+	}
+
+	resp, err := k.ListKeysWithOptions(opts)
+	if err != nil {
+		return resp, fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	// Group the nodes by the keys they are missing
+	missing := make(map[string][]string)
+	for node, drift := range resp.Report().Drift {
+		for _, key := range drift.Missing {
+			missing[key] = append(missing[key], node)
+		}
+	}
+	if len(missing) == 0 {
+		return resp, nil
+	}
+
+	keys := make([]string, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		nodes := missing[key]
+		sort.Strings(nodes)
+		k.serf.logger.Printf("[INFO] serf: Installing missing key on %d nodes: %v", len(nodes), nodes)
+
+		installOpts := *opts
+		installOpts.FilterNodes = nodes
+		if resp, err = k.InstallKeyWithOptions(key, &installOpts); err != nil {
+			return resp, fmt.Errorf("failed to install missing key: %v", err)
+		}
+	}
+
+	return k.ListKeysWithOptions(opts)
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/base64"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/serf/testutil"
@@ -317,3 +320,247 @@ func TestSerf_ListKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestSerf_Rotate(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	s1, err := testKeyringSerf(t, ip1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2, err := testKeyringSerf(t, ip2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	newKey := "5K9OtfP7efFrNKe5WCQvXvnaXJ5cWP0SvXiwe0kkjM4="
+	newKeyBytes, err := base64.StdEncoding.DecodeString(newKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Simulate a rotation that was interrupted after installing the key on
+	// only one of the nodes
+	s2.config.MemberlistConfig.Keyring.AddKey(newKeyBytes)
+
+	waitUntilNumNodes(t, 1, s1, s2)
+
+	// Join s1 and s2
+	_, err = s1.Join([]string{s2.config.NodeName + "/" + s2.config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 2, s1, s2)
+
+	manager := s1.KeyManager()
+	resp, err := manager.Rotate(newKey, &RotateOptions{GracePeriod: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(resp.Keys) != 1 || resp.Keys[newKey] != 2 || resp.PrimaryKeys[newKey] != 2 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	for _, s := range []*Serf{s1, s2} {
+		keyring := s.config.MemberlistConfig.Keyring
+		if !bytes.Equal(newKeyBytes, keyring.GetPrimaryKey()) {
+			t.Fatalf("Unexpected primary key on %s", s.config.NodeName)
+		}
+		if n := len(keyring.GetKeys()); n != 1 {
+			t.Fatalf("Expected 1 key on %s, found %d", s.config.NodeName, n)
+		}
+	}
+
+	// Rotating to the same key again has nothing left to do
+	resp, err = manager.Rotate(newKey, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(resp.Keys) != 1 || resp.PrimaryKeys[newKey] != 2 {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestSerf_Rotate_NoEncryption(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	s1, err := Create(testConfig(t, ip1))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	resp, err := s1.KeyManager().Rotate("5K9OtfP7efFrNKe5WCQvXvnaXJ5cWP0SvXiwe0kkjM4=", nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to list keys") {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := resp.Messages[s1.config.NodeName]; !ok {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestSerf_InstallKey_FilterNodes(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	s1, err := testKeyringSerf(t, ip1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2, err := testKeyringSerf(t, ip2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2)
+
+	// Join s1 and s2
+	_, err = s1.Join([]string{s2.config.NodeName + "/" + s2.config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 2, s1, s2)
+
+	newKey := "5K9OtfP7efFrNKe5WCQvXvnaXJ5cWP0SvXiwe0kkjM4="
+	newKeyBytes, err := base64.StdEncoding.DecodeString(newKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	opts := &KeyRequestOptions{FilterNodes: []string{s2.config.NodeName}}
+	resp, err := s1.KeyManager().InstallKeyWithOptions(newKey, opts)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.NumNodes != 1 || resp.NumResp != 1 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	if keyExistsInRing(s1.config.MemberlistConfig.Keyring, newKeyBytes) {
+		t.Fatal("Key installed on s1")
+	}
+	if !keyExistsInRing(s2.config.MemberlistConfig.Keyring, newKeyBytes) {
+		t.Fatal("Key not installed on s2")
+	}
+}
+
+func TestKeyResponse_Report(t *testing.T) {
+	resp := &KeyResponse{
+		NodeKeys: map[string][]string{
+			"node1": {"a", "b"},
+			"node2": {"a", "b"},
+			"node3": {"a"},
+			"node4": {"b", "a", "c"},
+		},
+		NodePrimaryKeys: map[string]string{
+			"node1": "a",
+			"node2": "a",
+			"node3": "a",
+			"node4": "b",
+		},
+	}
+
+	report := resp.Report()
+	if !reflect.DeepEqual(report.Keys, []string{"a", "b"}) || report.PrimaryKey != "a" {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	expected := map[string]*KeyDrift{
+		"node3": {Missing: []string{"b"}},
+		"node4": {Extra: []string{"c"}, PrimaryKey: "b"},
+	}
+	if !reflect.DeepEqual(report.Drift, expected) {
+		t.Fatalf("bad: %#v", report.Drift)
+	}
+}
+
+func TestSerf_RepairKeys(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	ip3, returnFn3 := testutil.TakeIP()
+	defer returnFn3()
+
+	s1, err := testKeyringSerf(t, ip1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2, err := testKeyringSerf(t, ip2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	s3, err := testKeyringSerf(t, ip3)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s3.Shutdown()
+
+	// s3 is missing one of the keys
+	staleKey := "HvY8ubRZMgafUOWvrOadwOckVa1wN3QWAo46FVKbVN8="
+	staleKeyBytes, err := base64.StdEncoding.DecodeString(staleKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := s3.config.MemberlistConfig.Keyring.RemoveKey(staleKeyBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 1, s1, s2, s3)
+
+	_, err = s1.Join([]string{
+		s2.config.NodeName + "/" + s2.config.MemberlistConfig.BindAddr,
+		s3.config.NodeName + "/" + s3.config.MemberlistConfig.BindAddr,
+	}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 3, s1, s2, s3)
+
+	manager := s1.KeyManager()
+	resp, err := manager.ListKeys()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(resp.NodeKeys) != 3 || len(resp.NodePrimaryKeys) != 3 {
+		t.Fatalf("bad: %#v", resp)
+	}
+	drift := resp.Report().Drift
+	if len(drift) != 1 || !reflect.DeepEqual(drift[s3.config.NodeName].Missing, []string{staleKey}) {
+		t.Fatalf("bad: %#v", drift)
+	}
+
+	resp, err = manager.RepairKeys()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if drift := resp.Report().Drift; len(drift) != 0 {
+		t.Fatalf("bad: %#v", drift)
+	}
+	if !keyExistsInRing(s3.config.MemberlistConfig.Keyring, staleKeyBytes) {
+		t.Fatal("Key not installed on s3")
+	}
+}
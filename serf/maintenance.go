@@ -0,0 +1,64 @@
+package serf
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// MaintenanceTag is the reserved tag that marks a member as being in
+// maintenance mode, with the reason as its value. It is gossiped with the
+// rest of the member's tags, and can't be set with SetTags. It is
+// namespaced since it is always reserved, and the other members would
+// take any member carrying it to be in maintenance.
+const MaintenanceTag = "serf.maint"
+
+// InMaintenance returns whether the member is in maintenance mode, and
+// the reason that was given for it
+func (m Member) InMaintenance() (bool, string) {
+	reason, ok := m.Tags[MaintenanceTag]
+	return ok, reason
+}
+
+// SetMaintenance puts the local node into or out of maintenance mode, and
+// blocks until the change is broadcast. If a node drops out of the cluster
+// while in maintenance, the other members mark it with StatusMaintenance
+// and fire EventMemberMaint instead of failing it, though it is still
+// reaped after the ReconnectTimeout. Nodes in maintenance also ignore
+// queries unless they ask for them with IncludeMaintenance.
+func (s *Serf) SetMaintenance(enable bool, reason string) error {
+	s.maintLock.Lock()
+	oldEnabled, oldReason := s.maintEnabled, s.maintReason
+	s.maintEnabled = enable
+	s.maintReason = ""
+	if enable {
+		s.maintReason = reason
+	}
+	s.maintLock.Unlock()
+
+	// Check that the meta data length is okay
+	if len(s.encodeTags(s.localTags())) > memberlist.MetaMaxSize {
+		s.maintLock.Lock()
+		s.maintEnabled, s.maintReason = oldEnabled, oldReason
+		s.maintLock.Unlock()
+		return fmt.Errorf("Encoded length of tags exceeds limit of %d bytes",
+			memberlist.MetaMaxSize)
+	}
+
+	if enable {
+		s.logger.Printf("[INFO] serf: Entering maintenance mode: %s", reason)
+	} else {
+		s.logger.Printf("[INFO] serf: Leaving maintenance mode")
+	}
+
+	// Trigger a memberlist update
+	return s.memberlist.UpdateNode(s.config.BroadcastTimeout)
+}
+
+// Maintenance returns whether the local node is in maintenance mode, and
+// the reason that was given for it
+func (s *Serf) Maintenance() (bool, string) {
+	s.maintLock.RLock()
+	defer s.maintLock.RUnlock()
+	return s.maintEnabled, s.maintReason
+}
@@ -0,0 +1,206 @@
+package serf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/testutil"
+	"github.com/hashicorp/serf/testutil/retry"
+)
+
+func TestSerf_Maintenance(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	eventCh := make(chan Event, 8)
+	s1Config := testConfig(t, ip1)
+	s1Config.EventCh = eventCh
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2Config := testConfig(t, ip2)
+	s2Config.Tags = map[string]string{"role": "web"}
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2)
+
+	_, err = s1.Join([]string{s2Config.NodeName + "/" + s2Config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 2, s1, s2)
+
+	if err := s2.SetMaintenance(true, "patching"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if maint, reason := s2.Maintenance(); !maint || reason != "patching" {
+		t.Fatalf("bad: %v %s", maint, reason)
+	}
+
+	// The tag is gossiped, but not kept with the configured tags
+	retry.Run(t, func(r *retry.R) {
+		for _, m := range s1.Members() {
+			if m.Name != s2Config.NodeName {
+				continue
+			}
+			if maint, reason := m.InMaintenance(); !maint || reason != "patching" {
+				r.Fatalf("bad: %v", m.Tags)
+			}
+			if m.Tags["role"] != "web" {
+				r.Fatalf("bad: %v", m.Tags)
+			}
+		}
+	})
+	if _, ok := s2.config.Tags[MaintenanceTag]; ok {
+		t.Fatalf("bad: %v", s2.config.Tags)
+	}
+
+	// Changing the tags keeps us in maintenance
+	if err := s2.SetTags(map[string]string{"role": "db"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if maint, _ := s2.LocalMember().InMaintenance(); !maint {
+		t.Fatalf("bad: %v", s2.LocalMember().Tags)
+	}
+
+	// Dropping out fires a maintenance event instead of a failure
+	if err := s2.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	retry.Run(t, func(r *retry.R) {
+		testMember(r, s1.Members(), s2Config.NodeName, StatusMaintenance)
+	})
+
+	waitUntilNumNodes(t, 1, s1)
+
+	testEvents(t, eventCh, s2Config.NodeName,
+		[]EventType{EventMemberJoin, EventMemberUpdate, EventMemberUpdate,
+			EventMemberMaint, EventMemberReap})
+}
+
+func TestSerf_Maintenance_disable(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	s1, err := Create(testConfig(t, ip1))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	if err := s1.SetMaintenance(true, "patching"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := s1.SetMaintenance(false, "ignored"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if maint, reason := s1.Maintenance(); maint || reason != "" {
+		t.Fatalf("bad: %v %s", maint, reason)
+	}
+	if _, ok := s1.LocalMember().Tags[MaintenanceTag]; ok {
+		t.Fatalf("bad: %v", s1.LocalMember().Tags)
+	}
+}
+
+func TestSerf_Maintenance_reservedTag(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	c := testConfig(t, ip1)
+	c.Tags = map[string]string{MaintenanceTag: "patching"}
+	if _, err := Create(c); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	c.Tags = nil
+	s1, err := Create(c)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	if err := s1.SetTags(map[string]string{MaintenanceTag: "patching"}); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// A plain maint tag is an ordinary tag
+	if err := s1.SetTags(map[string]string{"maint": "weekly"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if maint, _ := s1.LocalMember().InMaintenance(); maint {
+		t.Fatalf("bad: %v", s1.LocalMember().Tags)
+	}
+}
+
+func TestSerf_Maintenance_query(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	s1, err := Create(testConfig(t, ip1))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2Config := testConfig(t, ip2)
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2)
+
+	_, err = s1.Join([]string{s2Config.NodeName + "/" + s2Config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 2, s1, s2)
+
+	if err := s2.SetMaintenance(true, "patching"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// queryAcks runs a query from s1 and returns the nodes that acked it
+	queryAcks := func(includeMaint bool) map[string]bool {
+		params := s1.DefaultQueryParams()
+		params.RequestAck = true
+		params.Timeout = 500 * time.Millisecond
+		params.IncludeMaintenance = includeMaint
+		resp, err := s1.Query("load", nil, params)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		acks := make(map[string]bool)
+		for a := range resp.AckCh() {
+			acks[a] = true
+		}
+		return acks
+	}
+
+	acks := queryAcks(false)
+	if len(acks) != 1 || !acks[s1.config.NodeName] {
+		t.Fatalf("bad: %v", acks)
+	}
+
+	acks = queryAcks(true)
+	if len(acks) != 2 || !acks[s2Config.NodeName] {
+		t.Fatalf("bad: %v", acks)
+	}
+}
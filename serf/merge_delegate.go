@@ -4,9 +4,15 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/memberlist"
 )
 
+// ClusterNameTag is the reserved tag the Config.ClusterName is gossiped
+// in, if it is set. It is namespaced and always reserved, so an ordinary
+// tag can't pass for a cluster name.
+const ClusterNameTag = "serf.cluster"
+
 type MergeDelegate interface {
 	NotifyMerge([]*Member) error
 }
@@ -23,6 +29,12 @@ func (m *mergeDelegate) NotifyMerge(nodes []*memberlist.Node) error {
 		if err != nil {
 			return err
 		}
+		if err := m.checkClusterName(members[idx]); err != nil {
+			return err
+		}
+	}
+	if m.serf.config.Merge == nil {
+		return nil
 	}
 	return m.serf.config.Merge.NotifyMerge(members)
 }
@@ -32,9 +44,30 @@ func (m *mergeDelegate) NotifyAlive(peer *memberlist.Node) error {
 	if err != nil {
 		return err
 	}
+	if err := m.checkClusterName(member); err != nil {
+		return err
+	}
+	if m.serf.config.Merge == nil {
+		return nil
+	}
 	return m.serf.config.Merge.NotifyMerge([]*Member{member})
 }
 
+// checkClusterName rejects a member of another cluster, if we have a
+// cluster name set
+func (m *mergeDelegate) checkClusterName(member *Member) error {
+	name := m.serf.config.ClusterName
+	if name == "" || member.Tags[ClusterNameTag] == name {
+		return nil
+	}
+
+	metrics.IncrCounterWithLabels([]string{"serf", "merge", "cluster_name_mismatch"}, 1, m.serf.metricLabels)
+	m.serf.logger.Printf("[WARN] serf: Rejecting node '%s' (%s) from cluster '%s', expected '%s'",
+		member.Name, member.Addr, member.Tags[ClusterNameTag], name)
+	return fmt.Errorf("Node '%s' is in cluster '%s', not '%s'",
+		member.Name, member.Tags[ClusterNameTag], name)
+}
+
 func (m *mergeDelegate) nodeToMember(n *memberlist.Node) (*Member, error) {
 	status := StatusNone
 	if n.State == memberlist.StateLeft {
@@ -32,6 +32,10 @@ const (
 	// NoBroadcast is used to prevent re-broadcast of a query.
 	// this can be used to selectively send queries to individual members
 	queryFlagNoBroadcast
+
+	// IncludeMaintenance is used to have nodes in maintenance mode handle
+	// a query, since they ignore them by default
+	queryFlagIncludeMaintenance
 )
 
 // filterType is used with a queryFilter to specify the type of
@@ -67,6 +71,7 @@ type messagePushPull struct {
 	EventLTime   LamportTime            // Lamport time for event clock
 	Events       []*userEvents          // Recent events
 	QueryLTime   LamportTime            // Lamport time for query clock
+	ClusterName  string                 // Cluster name of the sender, if set
 }
 
 // messageUserEvent is used for user-generated events
@@ -75,6 +80,11 @@ type messageUserEvent struct {
 	Name    string
 	Payload []byte
 	CC      bool // "Can Coalesce". Zero value is compatible with Serf 0.1
+
+	// SourceNode and Signature are only set if the sender signs its
+	// events, and are left out of the encoding otherwise
+	SourceNode string `codec:",omitempty"`
+	Signature  []byte `codec:",omitempty"`
 }
 
 // messageQuery is used for query events
@@ -90,6 +100,7 @@ type messageQuery struct {
 	Timeout     time.Duration // Maximum time between delivery and response
 	Name        string        // Query name
 	Payload     []byte        // Query payload
+	Signature   []byte        `codec:",omitempty"` // Sender's signature, if it signs queries
 }
 
 // Ack checks if the ack flag is set
@@ -102,6 +113,11 @@ func (m *messageQuery) NoBroadcast() bool {
 	return (m.Flags & queryFlagNoBroadcast) != 0
 }
 
+// IncludeMaintenance checks if the include maintenance flag is set
+func (m *messageQuery) IncludeMaintenance() bool {
+	return (m.Flags & queryFlagIncludeMaintenance) != 0
+}
+
 // filterNode is used with the filterNodeType, and is a list
 // of node names
 type filterNode []string
@@ -120,6 +136,9 @@ type messageQueryResponse struct {
 	From    string      // Node name
 	Flags   uint32      // Used to provide various flags
 	Payload []byte      // Optional response payload
+
+	// Signature is the responder's signature, if it signs its responses
+	Signature []byte `codec:",omitempty"`
 }
 
 // Ack checks if the ack flag is set
@@ -34,6 +34,10 @@ type QueryParam struct {
 	// back to the sender through other nodes for redundancy.
 	RelayFactor uint8
 
+	// If true, nodes in maintenance mode handle the query as well. They
+	// ignore it by default.
+	IncludeMaintenance bool
+
 	// The timeout limits how long the query is left open. If not provided,
 	// then a default timeout is used based on the configuration of Serf
 	Timeout time.Duration
@@ -205,6 +209,10 @@ func (r *QueryResponse) sendAck(nr *messageQueryResponse) error {
 type NodeResponse struct {
 	From    string
 	Payload []byte
+
+	// Verified is true if the response was signed by the key From
+	// published in its SigningKeyTag
+	Verified bool
 }
 
 // shouldProcessQuery checks if a query should be proceeded given
@@ -2,6 +2,7 @@ package serf
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -81,6 +83,12 @@ type Serf struct {
 	// we've seen. The memberLock protects this structure.
 	recentIntents map[string]nodeIntent
 
+	// memberIndex is increased on every change to the members, and
+	// memberIndexCh is closed and replaced to wake up any waiters when it
+	// is. The memberLock protects both.
+	memberIndex   uint64
+	memberIndexCh chan struct{}
+
 	eventBroadcasts *memberlist.TransmitLimitedQueue
 	eventBuffer     []*userEvents
 	eventJoinIgnore atomic.Value
@@ -102,6 +110,12 @@ type Serf struct {
 	snapshotter *Snapshotter
 	keyManager  *KeyManager
 
+	// maintEnabled and maintReason are the maintenance mode of the local
+	// node, which is gossiped in the MaintenanceTag
+	maintEnabled bool
+	maintReason  string
+	maintLock    sync.RWMutex
+
 	coordClient    *coordinate.Client
 	coordCache     map[string]*coordinate.Coordinate
 	coordCacheLock sync.RWMutex
@@ -152,6 +166,10 @@ type Member struct {
 	DelegateMin uint8
 	DelegateMax uint8
 	DelegateCur uint8
+
+	// FlapCount is the number of times the member has rejoined within the
+	// FlapTimeout of failing, as seen by this node.
+	FlapCount int
 }
 
 // MemberStatus is the state that a member is in.
@@ -163,6 +181,12 @@ const (
 	StatusLeaving
 	StatusLeft
 	StatusFailed
+
+	// StatusMaintenance is a member that dropped out of the cluster while
+	// in maintenance mode. It is treated like a failed member, but is
+	// expected to come back. It is reaped after the ReconnectTimeout
+	// like a failed member.
+	StatusMaintenance
 )
 
 func (s MemberStatus) String() string {
@@ -177,6 +201,8 @@ func (s MemberStatus) String() string {
 		return "left"
 	case StatusFailed:
 		return "failed"
+	case StatusMaintenance:
+		return "maintenance"
 	default:
 		panic(fmt.Sprintf("unknown MemberStatus: %d", s))
 	}
@@ -209,6 +235,12 @@ type nodeIntent struct {
 type userEvent struct {
 	Name    string
 	Payload []byte
+
+	// CC, SourceNode and Signature are kept so that an event replayed
+	// from the buffer can still be verified
+	CC         bool   `codec:",omitempty"`
+	SourceNode string `codec:",omitempty"`
+	Signature  []byte `codec:",omitempty"`
 }
 
 func (ue *userEvent) Equals(other *userEvent) bool {
@@ -270,6 +302,7 @@ func Create(conf *Config) (*Serf, error) {
 		config:        conf,
 		logger:        logger,
 		members:       make(map[string]*memberState),
+		memberIndexCh: make(chan struct{}),
 		queryResponse: make(map[LamportTime]*QueryResponse),
 		shutdownCh:    make(chan struct{}),
 		state:         SerfAlive,
@@ -278,7 +311,10 @@ func Create(conf *Config) (*Serf, error) {
 	serf.eventJoinIgnore.Store(false)
 
 	// Check that the meta data length is okay
-	if len(serf.encodeTags(conf.Tags)) > memberlist.MetaMaxSize {
+	if err := serf.checkReservedTags(conf.Tags); err != nil {
+		return nil, err
+	}
+	if len(serf.encodeTags(serf.localTags())) > memberlist.MetaMaxSize {
 		return nil, fmt.Errorf("Encoded length of tags exceeds limit of %d bytes", memberlist.MetaMaxSize)
 	}
 	if err := serf.ValidateNodeNames(); err != nil {
@@ -290,6 +326,7 @@ func Create(conf *Config) (*Serf, error) {
 		c := &memberEventCoalescer{
 			lastEvents:   make(map[string]EventType),
 			latestEvents: make(map[string]coalesceEvent),
+			flaps:        make(map[string]Member),
 		}
 
 		conf.EventCh = coalescedEventCh(conf.EventCh, serf.shutdownCh,
@@ -328,9 +365,19 @@ func Create(conf *Config) (*Serf, error) {
 	// Try access the snapshot
 	var oldClock, oldEventClock, oldQueryClock LamportTime
 	var prev []*PreviousNode
+	if conf.SnapshotPath != "" && conf.SnapshotStore != nil {
+		return nil, fmt.Errorf("Cannot specify both SnapshotPath and SnapshotStore")
+	}
+	store := conf.SnapshotStore
 	if conf.SnapshotPath != "" {
-		eventCh, snap, err := NewSnapshotter(
-			conf.SnapshotPath,
+		store, err = NewFileSnapshotStore(conf.SnapshotPath, conf.NodeName, serf.logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup snapshot: %v", err)
+		}
+	}
+	if store != nil {
+		eventCh, snap, err := NewSnapshotterWithStore(
+			store,
 			snapshotSizeLimit,
 			conf.RejoinAfterLeave,
 			serf.logger,
@@ -355,6 +402,10 @@ func Create(conf *Config) (*Serf, error) {
 	// make sure we get a good initial value from there, if we got one.
 	if !conf.DisableCoordinates {
 		serf.coordCache = make(map[string]*coordinate.Coordinate)
+		if serf.snapshotter != nil {
+			serf.restoreCoordinates(serf.snapshotter.RecentCoordinates(conf.SnapshotCoordinateMaxAge))
+			serf.snapshotter.SetCoordinateSource(serf.snapshotCoordinates)
+		}
 		serf.coordCache[conf.NodeName] = serf.coordClient.GetCoordinate()
 	}
 
@@ -405,7 +456,7 @@ func Create(conf *Config) (*Serf, error) {
 	}
 
 	// Setup a merge delegate if necessary
-	if conf.Merge != nil {
+	if conf.Merge != nil || conf.ClusterName != "" {
 		md := &mergeDelegate{serf: serf}
 		conf.MemberlistConfig.Merge = md
 		conf.MemberlistConfig.Alive = md
@@ -489,6 +540,10 @@ func (s *Serf) UserEvent(name string, payload []byte, coalesce bool) error {
 		Payload: payload,
 		CC:      coalesce,
 	}
+	if s.config.SigningKey != nil {
+		msg.SourceNode = s.config.NodeName
+		msg.Signature = s.sign(messageUserEventType, &msg)
+	}
 
 	// Start broadcasting the event
 	raw, err := encodeMessage(messageUserEventType, &msg)
@@ -554,6 +609,9 @@ func (s *Serf) Query(name string, payload []byte, params *QueryParam) (*QueryRes
 	if params.RequestAck {
 		flags |= queryFlagAck
 	}
+	if params.IncludeMaintenance {
+		flags |= queryFlagIncludeMaintenance
+	}
 
 	// Create a message
 	q := messageQuery{
@@ -569,6 +627,7 @@ func (s *Serf) Query(name string, payload []byte, params *QueryParam) (*QueryRes
 		Name:        name,
 		Payload:     payload,
 	}
+	q.Signature = s.sign(messageQueryType, &q)
 
 	// Encode the query
 	raw, err := encodeMessage(messageQueryType, &q)
@@ -618,8 +677,12 @@ func (s *Serf) registerQueryResponse(timeout time.Duration, resp *QueryResponse)
 // the local node. This will propagate the change to the rest of
 // the cluster. Blocks until a the message is broadcast out.
 func (s *Serf) SetTags(tags map[string]string) error {
-	// Check that the meta data length is okay
-	if len(s.encodeTags(tags)) > memberlist.MetaMaxSize {
+	if err := s.checkReservedTags(tags); err != nil {
+		return err
+	}
+
+	// Check that the meta data length is okay, including the reserved tags
+	if len(s.encodeTags(s.withReservedTags(tags))) > memberlist.MetaMaxSize {
 		return fmt.Errorf("Encoded length of tags exceeds limit of %d bytes",
 			memberlist.MetaMaxSize)
 	}
@@ -803,6 +866,70 @@ func (s *Serf) Members() []Member {
 	return members
 }
 
+// MemberIndex returns the membership index, which increases every time
+// a member joins, leaves, fails, updates, or is reaped.
+func (s *Serf) MemberIndex() uint64 {
+	s.memberLock.RLock()
+	defer s.memberLock.RUnlock()
+	return s.memberIndex
+}
+
+// MembersWait blocks until the membership index is greater than the given
+// index, the timeout elapses, or Serf shuts down. It returns the members
+// along with the membership index they correspond to, which can be passed
+// to the next call. A zero timeout waits until Serf shuts down.
+func (s *Serf) MembersWait(index uint64, timeout time.Duration) ([]Member, uint64) {
+	return s.MembersWaitWithCancel(index, timeout, nil)
+}
+
+// MembersWaitWithCancel is like MembersWait, but also returns early when
+// cancelCh is closed, so a waiter that goes away doesn't linger until the
+// timeout.
+func (s *Serf) MembersWaitWithCancel(index uint64, timeout time.Duration, cancelCh <-chan struct{}) ([]Member, uint64) {
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+WAIT:
+	for {
+		s.memberLock.RLock()
+		current, changeCh := s.memberIndex, s.memberIndexCh
+		s.memberLock.RUnlock()
+		if current > index {
+			break
+		}
+
+		select {
+		case <-changeCh:
+		case <-timeoutCh:
+			break WAIT
+		case <-cancelCh:
+			break WAIT
+		case <-s.shutdownCh:
+			break WAIT
+		}
+	}
+
+	s.memberLock.RLock()
+	defer s.memberLock.RUnlock()
+	members := make([]Member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m.Member)
+	}
+	return members, s.memberIndex
+}
+
+// bumpMemberIndex records a change to the members and wakes up any
+// waiters. Must be called with the memberLock held.
+func (s *Serf) bumpMemberIndex() {
+	s.memberIndex++
+	close(s.memberIndexCh)
+	s.memberIndexCh = make(chan struct{})
+}
+
 // RemoveFailedNode is a backwards compatible form
 // of forceleave
 func (s *Serf) RemoveFailedNode(node string) error {
@@ -933,6 +1060,7 @@ func (s *Serf) handleNodeJoin(n *memberlist.Node) {
 	}
 
 	var oldStatus MemberStatus
+	var flapped bool
 	member, ok := s.members[n.Name]
 	if !ok {
 		oldStatus = StatusNone
@@ -963,6 +1091,8 @@ func (s *Serf) handleNodeJoin(n *memberlist.Node) {
 		deadTime := time.Now().Sub(member.leaveTime)
 		if oldStatus == StatusFailed && deadTime < s.config.FlapTimeout {
 			metrics.IncrCounterWithLabels([]string{"serf", "member", "flap"}, 1, s.metricLabels)
+			member.FlapCount++
+			flapped = true
 		}
 
 		member.Status = StatusAlive
@@ -983,13 +1113,14 @@ func (s *Serf) handleNodeJoin(n *memberlist.Node) {
 	// If node was previously in a failed state, then clean up some
 	// internal accounting.
 	// TODO(mitchellh): needs tests to verify not reaped
-	if oldStatus == StatusFailed || oldStatus == StatusLeft {
+	if oldStatus == StatusFailed || oldStatus == StatusMaintenance || oldStatus == StatusLeft {
 		s.failedMembers = removeOldMember(s.failedMembers, member.Name)
 		s.leftMembers = removeOldMember(s.leftMembers, member.Name)
 	}
 
 	// Update some metrics
 	metrics.IncrCounterWithLabels([]string{"serf", "member", "join"}, 1, s.metricLabels)
+	s.bumpMemberIndex()
 
 	// Send an event along
 	s.logger.Printf("[INFO] serf: EventMemberJoin: %s %s",
@@ -1000,6 +1131,18 @@ func (s *Serf) handleNodeJoin(n *memberlist.Node) {
 			Members: []Member{member.Member},
 		}
 	}
+
+	// A quick rejoin after a failure is also reported as a flap
+	if flapped {
+		s.logger.Printf("[INFO] serf: EventMemberFlap: %s %s (%d flaps)",
+			member.Member.Name, member.Member.Addr, member.FlapCount)
+		if s.config.EventCh != nil {
+			s.config.EventCh <- MemberEvent{
+				Type:    EventMemberFlap,
+				Members: []Member{member.Member},
+			}
+		}
+	}
 }
 
 // handleNodeLeave is called when a node leave event is received
@@ -1021,7 +1164,12 @@ func (s *Serf) handleNodeLeave(n *memberlist.Node) {
 		member.leaveTime = time.Now()
 		s.leftMembers = append(s.leftMembers, member)
 	case StatusAlive:
+		// Members in maintenance are expected to drop out, so they are
+		// reconnected to like failed members but fire a different event
 		member.Status = StatusFailed
+		if maint, _ := member.InMaintenance(); maint {
+			member.Status = StatusMaintenance
+		}
 		member.leaveTime = time.Now()
 		s.failedMembers = append(s.failedMembers, member)
 	default:
@@ -1033,13 +1181,18 @@ func (s *Serf) handleNodeLeave(n *memberlist.Node) {
 	// Send an event along
 	event := EventMemberLeave
 	eventStr := "EventMemberLeave"
-	if member.Status != StatusLeft {
+	switch member.Status {
+	case StatusFailed:
 		event = EventMemberFailed
 		eventStr = "EventMemberFailed"
+	case StatusMaintenance:
+		event = EventMemberMaint
+		eventStr = "EventMemberMaint"
 	}
 
 	// Update some metrics
 	metrics.IncrCounterWithLabels([]string{"serf", "member", member.Status.String()}, 1, s.metricLabels)
+	s.bumpMemberIndex()
 
 	s.logger.Printf("[INFO] serf: %s: %s %s",
 		eventStr, member.Member.Name, member.Member.Addr)
@@ -1084,6 +1237,7 @@ func (s *Serf) handleNodeUpdate(n *memberlist.Node) {
 
 	// Update some metrics
 	metrics.IncrCounterWithLabels([]string{"serf", "member", "update"}, 1, s.metricLabels)
+	s.bumpMemberIndex()
 
 	// Send an event along
 	s.logger.Printf("[INFO] serf: EventMemberUpdate: %s", member.Member.Name)
@@ -1145,12 +1299,13 @@ func (s *Serf) handleNodeLeaveIntent(leaveMsg *messageLeave) bool {
 	switch member.Status {
 	case StatusAlive:
 		member.Status = StatusLeaving
+		s.bumpMemberIndex()
 
 		if leaveMsg.Prune {
 			s.handlePrune(member)
 		}
 		return true
-	case StatusFailed:
+	case StatusFailed, StatusMaintenance:
 		member.Status = StatusLeft
 
 		// Remove from the failed list and add to the left list. We add
@@ -1159,6 +1314,7 @@ func (s *Serf) handleNodeLeaveIntent(leaveMsg *messageLeave) bool {
 
 		s.failedMembers = removeOldMember(s.failedMembers, member.Name)
 		s.leftMembers = append(s.leftMembers, member)
+		s.bumpMemberIndex()
 
 		// We must push a message indicating the node has now
 		// left to allow higher-level applications to handle the
@@ -1232,6 +1388,7 @@ func (s *Serf) handleNodeJoinIntent(joinMsg *messageJoin) bool {
 	// since the leaving message must have been for an older time
 	if member.Status == StatusLeaving {
 		member.Status = StatusAlive
+		s.bumpMemberIndex()
 	}
 	return true
 }
@@ -1242,6 +1399,12 @@ func (s *Serf) handleUserEvent(eventMsg *messageUserEvent) bool {
 	// Witness a potentially newer time
 	s.eventClock.Witness(eventMsg.LTime)
 
+	// Look up the sender's key before taking the event lock
+	var key ed25519.PublicKey
+	if len(eventMsg.Signature) > 0 {
+		key = s.signingKey(eventMsg.SourceNode)
+	}
+
 	s.eventLock.Lock()
 	defer s.eventLock.Unlock()
 
@@ -1265,7 +1428,13 @@ func (s *Serf) handleUserEvent(eventMsg *messageUserEvent) bool {
 	// Check if we've already seen this
 	idx := eventMsg.LTime % LamportTime(len(s.eventBuffer))
 	seen := s.eventBuffer[idx]
-	userEvent := userEvent{Name: eventMsg.Name, Payload: eventMsg.Payload}
+	userEvent := userEvent{
+		Name:       eventMsg.Name,
+		Payload:    eventMsg.Payload,
+		CC:         eventMsg.CC,
+		SourceNode: eventMsg.SourceNode,
+		Signature:  eventMsg.Signature,
+	}
 	if seen != nil && seen.LTime == eventMsg.LTime {
 		for _, previous := range seen.Events {
 			if previous.Equals(&userEvent) {
@@ -1280,16 +1449,23 @@ func (s *Serf) handleUserEvent(eventMsg *messageUserEvent) bool {
 	// Add to recent events
 	seen.Events = append(seen.Events, userEvent)
 
+	// Verify the signature now that we know the event is new
+	unsigned := *eventMsg
+	unsigned.Signature = nil
+	verified := s.verifySignature(key, messageUserEventType, &unsigned, eventMsg.Signature)
+
 	// Update some metrics
 	metrics.IncrCounterWithLabels([]string{"serf", "events"}, 1, s.metricLabels)
 	metrics.IncrCounterWithLabels([]string{"serf", "events", eventMsg.Name}, 1, s.metricLabels)
 
 	if s.config.EventCh != nil {
 		s.config.EventCh <- UserEvent{
-			LTime:    eventMsg.LTime,
-			Name:     eventMsg.Name,
-			Payload:  eventMsg.Payload,
-			Coalesce: eventMsg.CC,
+			LTime:      eventMsg.LTime,
+			Name:       eventMsg.Name,
+			Payload:    eventMsg.Payload,
+			Coalesce:   eventMsg.CC,
+			SourceNode: eventMsg.SourceNode,
+			Verified:   verified,
 		}
 	}
 	return true
@@ -1301,6 +1477,12 @@ func (s *Serf) handleQuery(query *messageQuery) bool {
 	// Witness a potentially newer time
 	s.queryClock.Witness(query.LTime)
 
+	// Look up the sender's key before taking the query lock
+	var key ed25519.PublicKey
+	if len(query.Signature) > 0 {
+		key = s.signingKey(query.SourceNode)
+	}
+
 	s.queryLock.Lock()
 	defer s.queryLock.Unlock()
 
@@ -1356,6 +1538,13 @@ func (s *Serf) handleQuery(query *messageQuery) bool {
 		return rebroadcast
 	}
 
+	// Nodes in maintenance only handle the queries that ask for them,
+	// along with our internal queries
+	if maint, _ := s.Maintenance(); maint && !query.IncludeMaintenance() &&
+		!strings.HasPrefix(query.Name, InternalQueryPrefix) {
+		return rebroadcast
+	}
+
 	// Send ack if requested, without waiting for client to Respond()
 	if query.Ack() {
 		ack := messageQueryResponse{
@@ -1383,10 +1572,13 @@ func (s *Serf) handleQuery(query *messageQuery) bool {
 	}
 
 	if s.config.EventCh != nil {
+		unsigned := *query
+		unsigned.Signature = nil
 		s.config.EventCh <- &Query{
 			LTime:       query.LTime,
 			Name:        query.Name,
 			Payload:     query.Payload,
+			Verified:    s.verifySignature(key, messageQueryType, &unsigned, query.Signature),
 			serf:        s,
 			id:          query.ID,
 			addr:        query.Addr,
@@ -1445,7 +1637,13 @@ func (s *Serf) handleQueryResponse(resp *messageQueryResponse) {
 		}
 
 		metrics.IncrCounterWithLabels([]string{"serf", "query_responses"}, 1, s.metricLabels)
-		err := query.sendResponse(NodeResponse{From: resp.From, Payload: resp.Payload})
+		unsigned := *resp
+		unsigned.Signature = nil
+		err := query.sendResponse(NodeResponse{
+			From:     resp.From,
+			Payload:  resp.Payload,
+			Verified: s.verifySignature(s.signingKey(resp.From), messageQueryResponseType, &unsigned, resp.Signature),
+		})
 		if err != nil {
 			s.logger.Printf("[WARN] %v", err)
 		}
@@ -1532,6 +1730,7 @@ func (s *Serf) resolveNodeConflict() {
 func (s *Serf) eraseNode(m *memberState) {
 	// Delete from members
 	delete(s.members, m.Name)
+	s.bumpMemberIndex()
 
 	// Tell the coordinate client the node has gone away and delete
 	// its cached coordinates.
@@ -1779,6 +1978,51 @@ func (s *Serf) handleRejoin(previous []*PreviousNode) {
 	s.logger.Printf("[WARN] serf: Failed to re-join any previously known node")
 }
 
+// checkReservedTags returns an error if the tags set any of the tags that
+// Serf reserves for itself
+func (s *Serf) checkReservedTags(tags map[string]string) error {
+	if _, ok := tags[MaintenanceTag]; ok {
+		return fmt.Errorf("The '%s' tag is reserved for maintenance mode", MaintenanceTag)
+	}
+	if _, ok := tags[ClusterNameTag]; ok {
+		return fmt.Errorf("The '%s' tag is reserved for the cluster name", ClusterNameTag)
+	}
+	if _, ok := tags[SigningKeyTag]; ok {
+		return fmt.Errorf("The '%s' tag is reserved for the signing key", SigningKeyTag)
+	}
+	return nil
+}
+
+// localTags returns the tags gossiped for the local node
+func (s *Serf) localTags() map[string]string {
+	return s.withReservedTags(s.config.Tags)
+}
+
+// withReservedTags returns the tags with the maintenance, cluster name
+// and signing key tags added, if they are set
+func (s *Serf) withReservedTags(tags map[string]string) map[string]string {
+	enabled, reason := s.Maintenance()
+	if !enabled && s.config.ClusterName == "" && s.config.SigningKey == nil {
+		return tags
+	}
+
+	result := make(map[string]string, len(tags)+3)
+	for key, val := range tags {
+		result[key] = val
+	}
+	if enabled {
+		result[MaintenanceTag] = reason
+	}
+	if s.config.ClusterName != "" {
+		result[ClusterNameTag] = s.config.ClusterName
+	}
+	if s.config.SigningKey != nil {
+		pub := s.config.SigningKey.Public().(ed25519.PublicKey)
+		result[SigningKeyTag] = base64.StdEncoding.EncodeToString(pub)
+	}
+	return result
+}
+
 // encodeTags is used to encode a tag map
 func (s *Serf) encodeTags(tags map[string]string) []byte {
 	// Support role-only backwards compatibility
@@ -1884,6 +2128,45 @@ func (s *Serf) GetCoordinate() (*coordinate.Coordinate, error) {
 	return nil, fmt.Errorf("Coordinates are disabled")
 }
 
+// restoreCoordinates seeds the coordinate client and cache with the
+// coordinates recovered from the snapshot. Coordinates that are invalid
+// or don't match our dimensionality are skipped.
+func (s *Serf) restoreCoordinates(local *coordinate.Coordinate, peers map[string]*coordinate.Coordinate) {
+	if local != nil {
+		if err := s.coordClient.SetCoordinate(local); err != nil {
+			s.logger.Printf("[WARN] serf: Failed to restore coordinate from snapshot: %v", err)
+		}
+	}
+
+	current := s.coordClient.GetCoordinate()
+	restored := 0
+	for name, coord := range peers {
+		if name == s.config.NodeName || !coord.IsValid() || !current.IsCompatibleWith(coord) {
+			continue
+		}
+		s.coordCache[name] = coord
+		restored++
+	}
+	if local != nil || restored > 0 {
+		s.logger.Printf("[INFO] serf: Restored coordinates for %d peers from snapshot", restored)
+	}
+}
+
+// snapshotCoordinates returns the local coordinate and a copy of the
+// cached peer coordinates for the snapshotter to record.
+func (s *Serf) snapshotCoordinates() (*coordinate.Coordinate, map[string]*coordinate.Coordinate) {
+	s.coordCacheLock.RLock()
+	defer s.coordCacheLock.RUnlock()
+
+	peers := make(map[string]*coordinate.Coordinate, len(s.coordCache))
+	for name, coord := range s.coordCache {
+		if name != s.config.NodeName {
+			peers[name] = coord
+		}
+	}
+	return s.coordClient.GetCoordinate(), peers
+}
+
 // GetCachedCoordinate returns the network coordinate for the node with the given
 // name. This will only be valid if DisableCoordinates is set to false.
 func (s *Serf) GetCachedCoordinate(name string) (coord *coordinate.Coordinate, ok bool) {
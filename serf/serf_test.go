@@ -189,6 +189,76 @@ func TestSerf_eventsFailed(t *testing.T) {
 		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberReap})
 }
 
+func TestSerf_eventsFlap(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	// Keep the failed member around so it can flap
+	eventCh := make(chan Event, 8)
+	s1Config := testConfig(t, ip1)
+	s1Config.EventCh = eventCh
+	s1Config.ReconnectTimeout = 30 * time.Second
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2Config := testConfig(t, ip2)
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2)
+
+	_, err = s1.Join([]string{s2Config.NodeName + "/" + s2Config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 2, s1, s2)
+
+	if err := s2.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		testMember(r, s1.Members(), s2Config.NodeName, StatusFailed)
+	})
+
+	// Bring s2 back quickly
+	s2Config = testConfig(t, ip2)
+	s2, err = Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	_, err = s2.Join([]string{s1Config.NodeName + "/" + s1Config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		testMember(r, s1.Members(), s2Config.NodeName, StatusAlive)
+	})
+
+	for _, m := range s1.Members() {
+		if m.Name == s2Config.NodeName && m.FlapCount != 1 {
+			t.Fatalf("bad: %#v", m)
+		}
+	}
+
+	testEvents(t, eventCh, s2Config.NodeName,
+		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin, EventMemberFlap})
+}
+
 func TestSerf_eventsJoin(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
@@ -644,6 +714,78 @@ func TestSerf_joinLeave(t *testing.T) {
 	waitUntilNumNodes(t, 1, s1, s2)
 }
 
+func TestSerf_MembersWait(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	s1Config := testConfig(t, ip1)
+	s2Config := testConfig(t, ip2)
+
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2)
+
+	// The local node's join has been indexed, so this returns right away
+	members, index := s1.MembersWait(0, time.Second)
+	if len(members) != 1 || index == 0 || index != s1.MemberIndex() {
+		t.Fatalf("bad: %d %#v", index, members)
+	}
+
+	// Nothing changes, so this times out
+	start := time.Now()
+	_, timedOut := s1.MembersWait(index, 50*time.Millisecond)
+	if timedOut != index || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("bad: %d", timedOut)
+	}
+
+	// Closing the cancel channel ends the wait early
+	cancelCh := make(chan struct{})
+	close(cancelCh)
+	start = time.Now()
+	if _, cancelled := s1.MembersWaitWithCancel(index, 10*time.Second, cancelCh); cancelled != index ||
+		time.Since(start) > 5*time.Second {
+		t.Fatalf("bad: %d", cancelled)
+	}
+
+	// A join wakes up the waiter
+	type result struct {
+		members []Member
+		index   uint64
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		members, index := s1.MembersWait(index, 10*time.Second)
+		resultCh <- result{members, index}
+	}()
+
+	_, err = s1.Join([]string{s2Config.NodeName + "/" + s2Config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case r := <-resultCh:
+		if r.index <= index || len(r.members) != 2 {
+			t.Fatalf("bad: %d %#v", r.index, r.members)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
 // Bug: GH-58
 func TestSerf_leaveRejoinDifferentRole(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
@@ -981,7 +1123,7 @@ func TestSerf_reconnect(t *testing.T) {
 	// time.Sleep(s1Config.ReconnectInterval * 5)
 
 	testEvents(t, eventCh, s2Name,
-		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin})
+		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin, EventMemberFlap})
 }
 
 func TestSerf_reconnect_sameIP(t *testing.T) {
@@ -1046,7 +1188,7 @@ func TestSerf_reconnect_sameIP(t *testing.T) {
 	waitUntilNumNodes(t, 2, s1, s2)
 
 	testEvents(t, eventCh, s2Name,
-		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin})
+		[]EventType{EventMemberJoin, EventMemberFailed, EventMemberJoin, EventMemberFlap})
 }
 
 func TestSerf_update(t *testing.T) {
@@ -1591,8 +1733,9 @@ func TestRecentIntent(t *testing.T) {
 }
 
 func TestMemberStatus_String(t *testing.T) {
-	status := []MemberStatus{StatusNone, StatusAlive, StatusLeaving, StatusLeft, StatusFailed}
-	expect := []string{"none", "alive", "leaving", "left", "failed"}
+	status := []MemberStatus{StatusNone, StatusAlive, StatusLeaving, StatusLeft, StatusFailed,
+		StatusMaintenance}
+	expect := []string{"none", "alive", "leaving", "left", "failed", "maintenance"}
 
 	for idx, s := range status {
 		if s.String() != expect[idx] {
@@ -1935,6 +2078,129 @@ func TestSerf_Leave_SnapshotRecovery(t *testing.T) {
 	})
 }
 
+func TestSerf_SnapshotStore(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	store := NewInmemSnapshotStore()
+	s1Config := testConfig(t, ip1)
+	s1Config.SnapshotStore = store
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	if err := s1.UserEvent("first", nil, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := s1.UserEvent("second", nil, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	eventTime := s1.eventClock.Time()
+
+	retry.Run(t, func(r *retry.R) {
+		if len(store.Records()) == 0 {
+			r.Fatalf("no records")
+		}
+	})
+	if err := s1.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Restart with the same store, the event clock should be restored
+	s1Config = testConfig(t, ip1)
+	s1Config.SnapshotStore = store
+	s1, err = Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	if s1.eventClock.Time() < eventTime {
+		t.Fatalf("bad event clock: %d < %d", s1.eventClock.Time(), eventTime)
+	}
+	s1.Shutdown()
+
+	// A store and a path can't both be set
+	s1Config = testConfig(t, ip1)
+	s1Config.SnapshotStore = store
+	s1Config.SnapshotPath = "snap"
+	if _, err := Create(s1Config); err == nil {
+		t.Fatalf("should have err")
+	}
+}
+
+func TestSerf_SnapshotCoordinates(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	s1Config := testConfig(t, ip1)
+	s1Config.SnapshotPath = td + "snap"
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	// Move the coordinate away from the origin
+	coord, err := s1.GetCoordinate()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	coord.Vec[0] = 0.125
+	coord.Height = 0.001
+	if err := s1.coordClient.SetCoordinate(coord); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The coordinate is recorded on shutdown
+	if err := s1.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	s1Config = testConfig(t, ip1)
+	s1Config.SnapshotPath = td + "snap"
+	s1, err = Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	restored, err := s1.GetCoordinate()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(restored, coord) {
+		t.Fatalf("bad: %#v", restored)
+	}
+	s1.Shutdown()
+
+	// A stale coordinate should be ignored
+	s1Config = testConfig(t, ip1)
+	s1Config.SnapshotPath = td + "snap"
+	s1Config.SnapshotCoordinateMaxAge = time.Nanosecond
+	s1, err = Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	restored, err = s1.GetCoordinate()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if restored.Vec[0] != 0 {
+		t.Fatalf("bad: %#v", restored)
+	}
+}
+
 func TestSerf_SetTags(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
@@ -2642,6 +2908,91 @@ func TestSerf_Join_Cancel(t *testing.T) {
 	}
 }
 
+func TestSerf_Join_ClusterName(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	ip3, returnFn3 := testutil.TakeIP()
+	defer returnFn3()
+
+	s1Config := testConfig(t, ip1)
+	s1Config.ClusterName = "prod"
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	s2Config := testConfig(t, ip2)
+	s2Config.ClusterName = "staging"
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	s3Config := testConfig(t, ip3)
+	s3Config.ClusterName = "prod"
+	s3, err := Create(s3Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s3.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2, s3)
+
+	// Joining the other cluster fails from either side
+	_, err = s1.Join([]string{s2Config.NodeName + "/" + s2Config.MemberlistConfig.BindAddr}, false)
+	if err == nil || !strings.Contains(err.Error(), "is in cluster 'staging', not 'prod'") {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = s2.Join([]string{s1Config.NodeName + "/" + s1Config.MemberlistConfig.BindAddr}, false)
+	if err == nil || !strings.Contains(err.Error(), "is in cluster 'prod', not 'staging'") {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, err = s1.Join([]string{s3Config.NodeName + "/" + s3Config.MemberlistConfig.BindAddr}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	waitUntilNumNodes(t, 2, s1, s3)
+	waitUntilNumNodes(t, 1, s2)
+
+	if name := s1.LocalMember().Tags[ClusterNameTag]; name != "prod" {
+		t.Fatalf("bad: %s", name)
+	}
+	if err := s1.SetTags(map[string]string{ClusterNameTag: "staging"}); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// A node without a cluster name can't set the tag either, while a
+	// plain cluster tag is an ordinary tag
+	ip4, returnFn4 := testutil.TakeIP()
+	defer returnFn4()
+
+	s4Config := testConfig(t, ip4)
+	s4, err := Create(s4Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s4.Shutdown()
+
+	if err := s4.SetTags(map[string]string{ClusterNameTag: "prod"}); err == nil {
+		t.Fatalf("should fail")
+	}
+	if err := s4.SetTags(map[string]string{"cluster": "prod"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = s1.Join([]string{s4Config.NodeName + "/" + s4Config.MemberlistConfig.BindAddr}, false)
+	if err == nil || !strings.Contains(err.Error(), "is in cluster '', not 'prod'") {
+		t.Fatalf("err: %v", err)
+	}
+}
+
 func TestSerf_Coordinates(t *testing.T) {
 	ip1, returnFn1 := testutil.TakeIP()
 	defer returnFn1()
@@ -0,0 +1,60 @@
+package serf
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+)
+
+// SigningKeyTag is the reserved tag the public key of a node's
+// Config.SigningKey is gossiped under. It is namespaced and always
+// reserved, so an ordinary tag can't pass for a signing key.
+const SigningKeyTag = "serf.sigkey"
+
+// sign returns the signature of the encoded message, which must not have
+// its signature set yet. It returns nil if signing isn't enabled.
+func (s *Serf) sign(t messageType, msg interface{}) []byte {
+	if s.config.SigningKey == nil {
+		return nil
+	}
+	raw, err := encodeMessage(t, msg)
+	if err != nil {
+		s.logger.Printf("[ERR] serf: Failed to encode message for signing: %v", err)
+		return nil
+	}
+	return ed25519.Sign(s.config.SigningKey, raw)
+}
+
+// signingKey returns the public key a member published in its tags, or nil
+// if it didn't publish a valid key
+func (s *Serf) signingKey(name string) ed25519.PublicKey {
+	s.memberLock.RLock()
+	ms, ok := s.members[name]
+	var encoded string
+	if ok {
+		encoded = ms.Tags[SigningKeyTag]
+	}
+	s.memberLock.RUnlock()
+	if encoded == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil
+	}
+	return ed25519.PublicKey(key)
+}
+
+// verifySignature returns if the encoded message, which must have its
+// signature cleared, was signed by the key
+func (s *Serf) verifySignature(key ed25519.PublicKey, t messageType, msg interface{}, sig []byte) bool {
+	if key == nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	raw, err := encodeMessage(t, msg)
+	if err != nil {
+		s.logger.Printf("[ERR] serf: Failed to encode message for verification: %v", err)
+		return false
+	}
+	return ed25519.Verify(key, raw, sig)
+}
@@ -0,0 +1,196 @@
+package serf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/testutil"
+)
+
+func testSigningKey(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return key
+}
+
+// waitForEvent returns the next event of a type from the channel
+func waitForEvent(t *testing.T, ch <-chan Event, typ EventType) Event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if e.EventType() == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %s", typ)
+		}
+	}
+}
+
+func TestSerf_signing(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	ip2, returnFn2 := testutil.TakeIP()
+	defer returnFn2()
+
+	ip3, returnFn3 := testutil.TakeIP()
+	defer returnFn3()
+
+	s1Config := testConfig(t, ip1)
+	s1Config.SigningKey = testSigningKey(t)
+	s1, err := Create(s1Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s1.Shutdown()
+
+	eventCh := make(chan Event, 64)
+	s2Config := testConfig(t, ip2)
+	s2Config.SigningKey = testSigningKey(t)
+	s2Config.EventCh = eventCh
+	s2, err := Create(s2Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s2.Shutdown()
+
+	// The third node doesn't sign
+	s3Config := testConfig(t, ip3)
+	s3, err := Create(s3Config)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s3.Shutdown()
+
+	waitUntilNumNodes(t, 1, s1, s2, s3)
+
+	_, err = s1.Join([]string{
+		s2Config.NodeName + "/" + s2Config.MemberlistConfig.BindAddr,
+		s3Config.NodeName + "/" + s3Config.MemberlistConfig.BindAddr,
+	}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	waitUntilNumNodes(t, 3, s1, s2, s3)
+
+	if _, ok := s1.LocalMember().Tags[SigningKeyTag]; !ok {
+		t.Fatalf("bad: %#v", s1.LocalMember().Tags)
+	}
+	if err := s1.SetTags(map[string]string{SigningKeyTag: "forged"}); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// A node that doesn't sign can't set the tag either, while a plain
+	// sigkey tag is an ordinary tag
+	if err := s3.SetTags(map[string]string{SigningKeyTag: "forged"}); err == nil {
+		t.Fatalf("should fail")
+	}
+	if err := s3.SetTags(map[string]string{"sigkey": "forged"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A signed event is verified
+	if err := s1.UserEvent("deploy", []byte("v1"), false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	e := waitForEvent(t, eventCh, EventUser).(UserEvent)
+	if e.Name != "deploy" || e.SourceNode != s1Config.NodeName || !e.Verified {
+		t.Fatalf("bad: %#v", e)
+	}
+
+	// An unsigned event isn't
+	if err := s3.UserEvent("deploy", []byte("v2"), false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	e = waitForEvent(t, eventCh, EventUser).(UserEvent)
+	if string(e.Payload) != "v2" || e.SourceNode != "" || e.Verified {
+		t.Fatalf("bad: %#v", e)
+	}
+
+	// Queries and responses are verified too
+	resp, err := s1.Query("load", nil, s1.DefaultQueryParams())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	q := waitForEvent(t, eventCh, EventQuery).(*Query)
+	if !q.Verified {
+		t.Fatalf("bad: %#v", q)
+	}
+	if err := q.Respond([]byte("ok")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case r := <-resp.ResponseCh():
+		if r.From != s2Config.NodeName || !r.Verified {
+			t.Fatalf("bad: %#v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestSerf_handleUserEvent_forged(t *testing.T) {
+	ip1, returnFn1 := testutil.TakeIP()
+	defer returnFn1()
+
+	eventCh := make(chan Event, 64)
+	c := testConfig(t, ip1)
+	c.SigningKey = testSigningKey(t)
+	c.EventCh = eventCh
+	s, err := Create(c)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer s.Shutdown()
+
+	// Claim to be the local node, but sign with another key
+	msg := messageUserEvent{
+		LTime:      1,
+		Name:       "deploy",
+		SourceNode: c.NodeName,
+	}
+	raw, err := encodeMessage(messageUserEventType, &msg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	msg.Signature = ed25519.Sign(testSigningKey(t), raw)
+
+	if !s.handleUserEvent(&msg) {
+		t.Fatalf("should rebroadcast")
+	}
+	e := waitForEvent(t, eventCh, EventUser).(UserEvent)
+	if e.SourceNode != c.NodeName || e.Verified {
+		t.Fatalf("bad: %#v", e)
+	}
+}
+
+func TestMessageUserEvent_unsignedEncoding(t *testing.T) {
+	// Without a signature, user events encode the same as before signing
+	// was added
+	legacy := struct {
+		LTime   LamportTime
+		Name    string
+		Payload []byte
+		CC      bool
+	}{1, "deploy", []byte("v1"), true}
+	expected, err := encodeMessage(messageUserEventType, &legacy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	msg := messageUserEvent{LTime: 1, Name: "deploy", Payload: []byte("v1"), CC: true}
+	raw, err := encodeMessage(messageUserEventType, &msg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(raw, expected) {
+		t.Fatalf("bad: %v %v", raw, expected)
+	}
+}
@@ -1,17 +1,15 @@
 package serf
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"math/rand"
 	"net"
-	"os"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/coordinate"
 )
 
 /*
@@ -22,7 +20,12 @@ as the latest clock values to the file during normal operation,
 and periodically checkpoint and roll over the file. During a restore,
 we can replay the various member events to recall a list of known
 nodes to re-join, as well as restore our clock values to avoid replaying
-old events.
+old events. The local network coordinate and the cached coordinates of
+other nodes are also recorded periodically, so that a restarted node
+doesn't have to converge from the origin again.
+
+The records are persisted by a SnapshotStore, which is a local file by
+default. The on-disk format is described in snapshot_format.go.
 */
 
 const (
@@ -32,6 +35,10 @@ const (
 	// clockUpdateInterval is how often we fetch the current lamport time of the cluster and write to the snapshot file
 	clockUpdateInterval = 500 * time.Millisecond
 
+	// coordinateUpdateInterval is how often we record the network
+	// coordinates in the snapshot file
+	coordinateUpdateInterval = 2 * time.Minute
+
 	// tmpExt is the extention we use for the temporary file during compaction
 	tmpExt = ".compact"
 
@@ -49,6 +56,10 @@ const (
 	// snapshotBytesPerNode is an estimated bytes per node to snapshot
 	snapshotBytesPerNode = 128
 
+	// snapshotBytesPerCoordinate is an estimated bytes per node to snapshot
+	// the node's network coordinate
+	snapshotBytesPerCoordinate = 256
+
 	// snapshotCompactionThreshold is the threshold we apply to
 	// the snapshot size estimate (nodes * bytes per node) before compacting.
 	snapshotCompactionThreshold = 2
@@ -57,10 +68,9 @@ const (
 // Snapshotter is responsible for ingesting events and persisting
 // them to disk, and providing a recovery mechanism at start time.
 type Snapshotter struct {
-	aliveNodes              map[string]string
+	aliveNodes              map[string]*snapshotAlive
 	clock                   *LamportClock
-	fh                      *os.File
-	buffered                *bufio.Writer
+	store                   SnapshotStore
 	inCh                    <-chan Event
 	streamCh                chan Event
 	lastFlush               time.Time
@@ -68,10 +78,10 @@ type Snapshotter struct {
 	lastEventClock          LamportTime
 	lastQueryClock          LamportTime
 	leaveCh                 chan struct{}
+	leaveRecorded           bool
 	leaving                 bool
 	logger                  *log.Logger
 	minCompactSize          int64
-	path                    string
 	offset                  int64
 	outCh                   chan<- Event
 	rejoinAfterLeave        bool
@@ -79,6 +89,20 @@ type Snapshotter struct {
 	waitCh                  chan struct{}
 	lastAttemptedCompaction time.Time
 	metricLabels            []metrics.Label
+
+	// coordSource returns the current local and peer coordinates. It is
+	// nil if coordinates are disabled.
+	coordSource     func() (*coordinate.Coordinate, map[string]*coordinate.Coordinate)
+	coordSourceLock sync.Mutex
+	localCoord      *snapshotCoordinate
+	peerCoords      map[string]*snapshotCoordinate
+}
+
+// snapshotCoordinate is a network coordinate along with the time it
+// was recorded
+type snapshotCoordinate struct {
+	Coord *coordinate.Coordinate
+	Time  time.Time
 }
 
 // PreviousNode is used to represent the previously known alive nodes
@@ -96,56 +120,49 @@ func (p PreviousNode) String() string {
 // recover old state. Snapshotter works by reading an event channel it returns,
 // passing through to an output channel, and persisting relevant events to disk.
 // Setting rejoinAfterLeave makes leave not clear the state, and can be used
-// if you intend to rejoin the same cluster after a leave.
+// if you intend to rejoin the same cluster after a leave. The nodeName is
+// recorded in the snapshot header.
 func NewSnapshotter(path string,
+	nodeName string,
 	minCompactSize int,
 	rejoinAfterLeave bool,
 	logger *log.Logger,
 	clock *LamportClock,
 	outCh chan<- Event,
 	shutdownCh <-chan struct{}) (chan<- Event, *Snapshotter, error) {
-	inCh := make(chan Event, eventChSize)
-	streamCh := make(chan Event, eventChSize)
-
-	// Try to open the file
-	fh, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	store, err := NewFileSnapshotStore(path, nodeName, logger)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open snapshot: %v", err)
+		return nil, nil, err
 	}
+	return NewSnapshotterWithStore(store, minCompactSize, rejoinAfterLeave,
+		logger, clock, outCh, shutdownCh)
+}
 
-	// Determine the offset
-	info, err := fh.Stat()
-	if err != nil {
-		fh.Close()
-		return nil, nil, fmt.Errorf("failed to stat snapshot: %v", err)
-	}
-	offset := info.Size()
+// NewSnapshotterWithStore creates a new Snapshotter like NewSnapshotter,
+// but persists to the given store instead of a local file. The Snapshotter
+// takes ownership of the store and closes it when it shuts down, or if
+// the store can't be replayed.
+func NewSnapshotterWithStore(store SnapshotStore,
+	minCompactSize int,
+	rejoinAfterLeave bool,
+	logger *log.Logger,
+	clock *LamportClock,
+	outCh chan<- Event,
+	shutdownCh <-chan struct{}) (chan<- Event, *Snapshotter, error) {
+	inCh := make(chan Event, eventChSize)
+	streamCh := make(chan Event, eventChSize)
 
 	// Create the snapshotter
-	snap := &Snapshotter{
-		aliveNodes:       make(map[string]string),
-		clock:            clock,
-		fh:               fh,
-		buffered:         bufio.NewWriter(fh),
-		inCh:             inCh,
-		streamCh:         streamCh,
-		lastClock:        0,
-		lastEventClock:   0,
-		lastQueryClock:   0,
-		leaveCh:          make(chan struct{}),
-		logger:           logger,
-		minCompactSize:   int64(minCompactSize),
-		path:             path,
-		offset:           offset,
-		outCh:            outCh,
-		rejoinAfterLeave: rejoinAfterLeave,
-		shutdownCh:       shutdownCh,
-		waitCh:           make(chan struct{}),
-	}
+	snap := newSnapshotter(store, minCompactSize, rejoinAfterLeave, logger)
+	snap.clock = clock
+	snap.inCh = inCh
+	snap.streamCh = streamCh
+	snap.outCh = outCh
+	snap.shutdownCh = shutdownCh
 
 	// Recover the last known state
 	if err := snap.replay(); err != nil {
-		fh.Close()
+		store.Close()
 		return nil, nil, err
 	}
 
@@ -155,6 +172,25 @@ func NewSnapshotter(path string,
 	return inCh, snap, nil
 }
 
+// newSnapshotter returns a Snapshotter for the store that doesn't handle
+// any events yet
+func newSnapshotter(store SnapshotStore, minCompactSize int, rejoinAfterLeave bool,
+	logger *log.Logger) *Snapshotter {
+	return &Snapshotter{
+		aliveNodes:       make(map[string]*snapshotAlive),
+		store:            store,
+		lastClock:        0,
+		lastEventClock:   0,
+		lastQueryClock:   0,
+		leaveCh:          make(chan struct{}),
+		logger:           logger,
+		minCompactSize:   int64(minCompactSize),
+		rejoinAfterLeave: rejoinAfterLeave,
+		waitCh:           make(chan struct{}),
+		peerCoords:       make(map[string]*snapshotCoordinate),
+	}
+}
+
 // LastClock returns the last known clock time
 func (s *Snapshotter) LastClock() LamportTime {
 	return s.lastClock
@@ -174,8 +210,8 @@ func (s *Snapshotter) LastQueryClock() LamportTime {
 func (s *Snapshotter) AliveNodes() []*PreviousNode {
 	// Copy the previously known
 	previous := make([]*PreviousNode, 0, len(s.aliveNodes))
-	for name, addr := range s.aliveNodes {
-		previous = append(previous, &PreviousNode{name, addr})
+	for name, alive := range s.aliveNodes {
+		previous = append(previous, &PreviousNode{name, alive.Addr})
 	}
 
 	// Randomize the order, prevents hot shards
@@ -186,6 +222,35 @@ func (s *Snapshotter) AliveNodes() []*PreviousNode {
 	return previous
 }
 
+// SetCoordinateSource sets the function used to fetch the local and
+// peer network coordinates, which are then recorded periodically.
+func (s *Snapshotter) SetCoordinateSource(fn func() (*coordinate.Coordinate, map[string]*coordinate.Coordinate)) {
+	s.coordSourceLock.Lock()
+	defer s.coordSourceLock.Unlock()
+	s.coordSource = fn
+}
+
+// RecentCoordinates returns the last recorded local coordinate and the
+// coordinates of the last known alive nodes, skipping any recorded more
+// than maxAge ago. A zero maxAge disables the cutoff.
+func (s *Snapshotter) RecentCoordinates(maxAge time.Duration) (*coordinate.Coordinate, map[string]*coordinate.Coordinate) {
+	fresh := func(c *snapshotCoordinate) bool {
+		return maxAge == 0 || time.Since(c.Time) <= maxAge
+	}
+
+	var local *coordinate.Coordinate
+	if s.localCoord != nil && fresh(s.localCoord) {
+		local = s.localCoord.Coord.Clone()
+	}
+	peers := make(map[string]*coordinate.Coordinate)
+	for name, c := range s.peerCoords {
+		if _, ok := s.aliveNodes[name]; ok && fresh(c) {
+			peers[name] = c.Coord.Clone()
+		}
+	}
+	return local, peers
+}
+
 // Wait is used to wait until the snapshotter finishes shut down
 func (s *Snapshotter) Wait() {
 	<-s.waitCh
@@ -245,6 +310,9 @@ func (s *Snapshotter) stream() {
 	clockTicker := time.NewTicker(clockUpdateInterval)
 	defer clockTicker.Stop()
 
+	coordTicker := time.NewTicker(coordinateUpdateInterval)
+	defer coordTicker.Stop()
+
 	// flushEvent is used to handle writing out an event
 	flushEvent := func(e Event) {
 		// Stop recording events after a leave is issued
@@ -270,13 +338,15 @@ func (s *Snapshotter) stream() {
 
 			// If we plan to re-join, keep our state
 			if !s.rejoinAfterLeave {
-				s.aliveNodes = make(map[string]string)
+				s.aliveNodes = make(map[string]*snapshotAlive)
+				s.localCoord = nil
+				s.peerCoords = make(map[string]*snapshotCoordinate)
 			}
-			s.tryAppend("leave\n")
-			if err := s.buffered.Flush(); err != nil {
+			s.tryAppend(snapshotLeaveType, &snapshotLeave{})
+			if err := s.store.Flush(); err != nil {
 				s.logger.Printf("[ERR] serf: failed to flush leave to snapshot: %v", err)
 			}
-			if err := s.fh.Sync(); err != nil {
+			if err := s.store.Sync(); err != nil {
 				s.logger.Printf("[ERR] serf: failed to sync leave to snapshot: %v", err)
 			}
 
@@ -286,12 +356,16 @@ func (s *Snapshotter) stream() {
 		case <-clockTicker.C:
 			s.updateClock()
 
+		case <-coordTicker.C:
+			s.updateCoordinates()
+
 		case <-s.shutdownCh:
 			// Setup a timeout
 			flushTimeout := time.After(shutdownFlushTimeout)
 
-			// Snapshot the clock
+			// Snapshot the clock and coordinates
 			s.updateClock()
+			s.updateCoordinates()
 
 			// Clear out the buffers
 		FLUSH:
@@ -306,13 +380,15 @@ func (s *Snapshotter) stream() {
 				}
 			}
 
-			if err := s.buffered.Flush(); err != nil {
+			if err := s.store.Flush(); err != nil {
 				s.logger.Printf("[ERR] serf: failed to flush snapshot: %v", err)
 			}
-			if err := s.fh.Sync(); err != nil {
+			if err := s.store.Sync(); err != nil {
 				s.logger.Printf("[ERR] serf: failed to sync snapshot: %v", err)
 			}
-			s.fh.Close()
+			if err := s.store.Close(); err != nil {
+				s.logger.Printf("[ERR] serf: failed to close snapshot: %v", err)
+			}
 			close(s.waitCh)
 			return
 		}
@@ -325,16 +401,20 @@ func (s *Snapshotter) processMemberEvent(e MemberEvent) {
 	case EventMemberJoin:
 		for _, mem := range e.Members {
 			addr := net.TCPAddr{IP: mem.Addr, Port: int(mem.Port)}
-			s.aliveNodes[mem.Name] = addr.String()
-			s.tryAppend(fmt.Sprintf("alive: %s %s\n", mem.Name, addr.String()))
+			alive := &snapshotAlive{
+				Name: mem.Name,
+				Addr: addr.String(),
+				Tags: mem.Tags,
+			}
+			s.aliveNodes[mem.Name] = alive
+			s.tryAppend(snapshotAliveType, alive)
 		}
 
-	case EventMemberLeave:
-		fallthrough
-	case EventMemberFailed:
+	case EventMemberLeave, EventMemberFailed, EventMemberMaint:
 		for _, mem := range e.Members {
 			delete(s.aliveNodes, mem.Name)
-			s.tryAppend(fmt.Sprintf("not-alive: %s\n", mem.Name))
+			delete(s.peerCoords, mem.Name)
+			s.tryAppend(snapshotNotAliveType, &snapshotNotAlive{mem.Name, mem.Status})
 		}
 	}
 	s.updateClock()
@@ -347,7 +427,47 @@ func (s *Snapshotter) updateClock() {
 	lastSeen := s.clock.Time() - 1
 	if lastSeen > s.lastClock {
 		s.lastClock = lastSeen
-		s.tryAppend(fmt.Sprintf("clock: %d\n", s.lastClock))
+		s.tryAppend(snapshotClockType, &snapshotClock{s.lastClock})
+	}
+}
+
+// updateCoordinates records the current local and peer coordinates, if
+// a coordinate source has been set
+func (s *Snapshotter) updateCoordinates() {
+	// Stop recording coordinates after a leave is issued
+	if s.leaving {
+		return
+	}
+
+	s.coordSourceLock.Lock()
+	source := s.coordSource
+	s.coordSourceLock.Unlock()
+	if source == nil {
+		return
+	}
+
+	now := time.Now()
+	local, peers := source()
+	if local != nil {
+		s.localCoord = &snapshotCoordinate{local, now}
+		s.tryAppend(snapshotCoordinateType, s.localCoord.record(""))
+	}
+	for name, coord := range peers {
+		if _, ok := s.aliveNodes[name]; !ok {
+			continue
+		}
+		c := &snapshotCoordinate{coord, now}
+		s.peerCoords[name] = c
+		s.tryAppend(snapshotPeerCoordinateType, c.record(name))
+	}
+}
+
+// record returns the snapshot record for the coordinate
+func (c *snapshotCoordinate) record(name string) *snapshotCoordinateRecord {
+	return &snapshotCoordinateRecord{
+		Name:  name,
+		Time:  c.Time.Unix(),
+		Coord: c.Coord,
 	}
 }
 
@@ -358,7 +478,7 @@ func (s *Snapshotter) processUserEvent(e UserEvent) {
 		return
 	}
 	s.lastEventClock = e.LTime
-	s.tryAppend(fmt.Sprintf("event-clock: %d\n", e.LTime))
+	s.tryAppend(snapshotEventClockType, &snapshotClock{e.LTime})
 }
 
 // processQuery is used to handle a single query event
@@ -368,12 +488,12 @@ func (s *Snapshotter) processQuery(q *Query) {
 		return
 	}
 	s.lastQueryClock = q.LTime
-	s.tryAppend(fmt.Sprintf("query-clock: %d\n", q.LTime))
+	s.tryAppend(snapshotQueryClockType, &snapshotClock{q.LTime})
 }
 
-// tryAppend will invoke append line but will not return an error
-func (s *Snapshotter) tryAppend(l string) {
-	if err := s.appendLine(l); err != nil {
+// tryAppend will invoke append record but will not return an error
+func (s *Snapshotter) tryAppend(t snapshotRecordType, body interface{}) {
+	if err := s.appendRecord(t, body); err != nil {
 		s.logger.Printf("[ERR] serf: Failed to update snapshot: %v", err)
 		now := time.Now()
 		if now.Sub(s.lastAttemptedCompaction) > snapshotErrorRecoveryInterval {
@@ -389,26 +509,29 @@ func (s *Snapshotter) tryAppend(l string) {
 	}
 }
 
-// appendLine is used to append a line to the existing log
-func (s *Snapshotter) appendLine(l string) error {
+// appendRecord is used to append a record to the existing log
+func (s *Snapshotter) appendRecord(t snapshotRecordType, body interface{}) error {
 	defer metrics.MeasureSinceWithLabels([]string{"serf", "snapshot", "appendLine"}, time.Now(), s.metricLabels)
 
-	n, err := s.buffered.WriteString(l)
+	buf, err := encodeSnapshotRecord(t, body)
 	if err != nil {
 		return err
 	}
+	if err := s.store.Append(buf); err != nil {
+		return err
+	}
 
 	// Check if we should flush
 	now := time.Now()
 	if now.Sub(s.lastFlush) > flushInterval {
 		s.lastFlush = now
-		if err := s.buffered.Flush(); err != nil {
+		if err := s.store.Flush(); err != nil {
 			return err
 		}
 	}
 
 	// Check if a compaction is necessary
-	s.offset += int64(n)
+	s.offset += int64(len(buf))
 	if s.offset > s.snapshotMaxSize() {
 		return s.compact()
 	}
@@ -419,6 +542,9 @@ func (s *Snapshotter) appendLine(l string) error {
 func (s *Snapshotter) snapshotMaxSize() int64 {
 	nodes := int64(len(s.aliveNodes))
 	estSize := nodes * snapshotBytesPerNode
+	if len(s.peerCoords) > 0 {
+		estSize += nodes * snapshotBytesPerCoordinate
+	}
 	threshold := estSize * snapshotCompactionThreshold
 
 	// Apply a minimum threshold to avoid frequent compaction
@@ -432,197 +558,164 @@ func (s *Snapshotter) snapshotMaxSize() int64 {
 func (s *Snapshotter) compact() error {
 	defer metrics.MeasureSinceWithLabels([]string{"serf", "snapshot", "compact"}, time.Now(), s.metricLabels)
 
-	// Try to open the file to new fiel
-	newPath := s.path + tmpExt
-	fh, err := os.OpenFile(newPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0755)
+	records, err := s.stateRecords()
 	if err != nil {
-		return fmt.Errorf("failed to open new snapshot: %v", err)
+		return err
+	}
+	if err := s.store.Compact(records); err != nil {
+		return err
 	}
 
-	// Create a buffered writer
-	buf := bufio.NewWriter(fh)
-
-	// Write out the live nodes
 	var offset int64
-	for name, addr := range s.aliveNodes {
-		line := fmt.Sprintf("alive: %s %s\n", name, addr)
-		n, err := buf.WriteString(line)
+	for _, record := range records {
+		offset += int64(len(record))
+	}
+	s.offset = offset
+	s.lastFlush = time.Now()
+	return nil
+}
+
+// stateRecords returns the records needed to recover our current state
+func (s *Snapshotter) stateRecords() ([][]byte, error) {
+	var records [][]byte
+	add := func(t snapshotRecordType, body interface{}) error {
+		record, err := encodeSnapshotRecord(t, body)
 		if err != nil {
-			fh.Close()
 			return err
 		}
-		offset += int64(n)
+		records = append(records, record)
+		return nil
 	}
 
-	// Write out the clocks
-	line := fmt.Sprintf("clock: %d\n", s.lastClock)
-	n, err := buf.WriteString(line)
-	if err != nil {
-		fh.Close()
-		return err
-	}
-	offset += int64(n)
-
-	line = fmt.Sprintf("event-clock: %d\n", s.lastEventClock)
-	n, err = buf.WriteString(line)
-	if err != nil {
-		fh.Close()
-		return err
-	}
-	offset += int64(n)
-
-	line = fmt.Sprintf("query-clock: %d\n", s.lastQueryClock)
-	n, err = buf.WriteString(line)
-	if err != nil {
-		fh.Close()
-		return err
+	// Write out the live nodes
+	for _, alive := range s.aliveNodes {
+		if err := add(snapshotAliveType, alive); err != nil {
+			return nil, err
+		}
 	}
-	offset += int64(n)
 
-	// Flush the new snapshot
-	err = buf.Flush()
-
-	if err != nil {
-		return fmt.Errorf("failed to flush new snapshot: %v", err)
+	// Write out the clocks
+	clocks := []struct {
+		t     snapshotRecordType
+		ltime LamportTime
+	}{
+		{snapshotClockType, s.lastClock},
+		{snapshotEventClockType, s.lastEventClock},
+		{snapshotQueryClockType, s.lastQueryClock},
+	}
+	for _, c := range clocks {
+		if err := add(c.t, &snapshotClock{c.ltime}); err != nil {
+			return nil, err
+		}
 	}
 
-	err = fh.Sync()
-
-	if err != nil {
-		fh.Close()
-		return fmt.Errorf("failed to fsync new snapshot: %v", err)
+	// Write out the coordinates
+	if s.localCoord != nil {
+		if err := add(snapshotCoordinateType, s.localCoord.record("")); err != nil {
+			return nil, err
+		}
 	}
-
-	fh.Close()
-
-	// We now need to swap the old snapshot file with the new snapshot.
-	// Turns out, Windows won't let us rename the files if we have
-	// open handles to them or if the destination already exists. This
-	// means we are forced to close the existing handles, delete the
-	// old file, move the new one in place, and then re-open the file
-	// handles.
-
-	// Flush the existing snapshot, ignoring errors since we will
-	// delete it momentarily.
-	s.buffered.Flush()
-	s.buffered = nil
-
-	// Close the file handle to the old snapshot
-	s.fh.Close()
-	s.fh = nil
-
-	// Delete the old file
-	if err := os.Remove(s.path); err != nil {
-		return fmt.Errorf("failed to remove old snapshot: %v", err)
+	for name, c := range s.peerCoords {
+		if err := add(snapshotPeerCoordinateType, c.record(name)); err != nil {
+			return nil, err
+		}
 	}
+	return records, nil
+}
 
-	// Move the new file into place
-	if err := os.Rename(newPath, s.path); err != nil {
-		return fmt.Errorf("failed to install new snapshot: %v", err)
-	}
+// replay is used to reset our internal state by replaying the records
+// in the store. It is used at initialization time to read old state.
+func (s *Snapshotter) replay() error {
+	var offset int64
+	err := s.store.Replay(func(record []byte) error {
+		offset += int64(len(record))
 
-	// Open the new snapshot
-	fh, err = os.OpenFile(s.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0755)
+		t, body, err := checkSnapshotRecord(record)
+		if err != nil {
+			s.logger.Printf("[WARN] serf: Skipping corrupt snapshot record")
+			return nil
+		}
+		if err := s.applyRecord(t, body); err != nil {
+			s.logger.Printf("[WARN] serf: Failed to decode %s snapshot record: %v", t, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open snapshot: %v", err)
+		return err
 	}
-	buf = bufio.NewWriter(fh)
-
-	// Rotate our handles
-	s.fh = fh
-	s.buffered = buf
 	s.offset = offset
-	s.lastFlush = time.Now()
 	return nil
 }
 
-// replay is used to seek to reset our internal state by replaying
-// the snapshot file. It is used at initialization time to read old
-// state
-func (s *Snapshotter) replay() error {
-	// Seek to the beginning
-	if _, err := s.fh.Seek(0, os.SEEK_SET); err != nil {
-		return err
-	}
-
-	// Read each line
-	reader := bufio.NewReader(s.fh)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
+// applyRecord updates our internal state from a single snapshot record
+func (s *Snapshotter) applyRecord(t snapshotRecordType, body []byte) error {
+	switch t {
+	case snapshotAliveType:
+		var alive snapshotAlive
+		if err := decodeSnapshotRecord(body, &alive); err != nil {
+			return err
 		}
+		s.aliveNodes[alive.Name] = &alive
 
-		// Skip the newline
-		line = line[:len(line)-1]
-
-		// Switch on the prefix
-		if strings.HasPrefix(line, "alive: ") {
-			info := strings.TrimPrefix(line, "alive: ")
-			addrIdx := strings.LastIndex(info, " ")
-			if addrIdx == -1 {
-				s.logger.Printf("[WARN] serf: Failed to parse address: %v", line)
-				continue
-			}
-			addr := info[addrIdx+1:]
-			name := info[:addrIdx]
-			s.aliveNodes[name] = addr
-
-		} else if strings.HasPrefix(line, "not-alive: ") {
-			name := strings.TrimPrefix(line, "not-alive: ")
-			delete(s.aliveNodes, name)
-
-		} else if strings.HasPrefix(line, "clock: ") {
-			timeStr := strings.TrimPrefix(line, "clock: ")
-			timeInt, err := strconv.ParseUint(timeStr, 10, 64)
-			if err != nil {
-				s.logger.Printf("[WARN] serf: Failed to convert clock time: %v", err)
-				continue
-			}
-			s.lastClock = LamportTime(timeInt)
-
-		} else if strings.HasPrefix(line, "event-clock: ") {
-			timeStr := strings.TrimPrefix(line, "event-clock: ")
-			timeInt, err := strconv.ParseUint(timeStr, 10, 64)
-			if err != nil {
-				s.logger.Printf("[WARN] serf: Failed to convert event clock time: %v", err)
-				continue
-			}
-			s.lastEventClock = LamportTime(timeInt)
+	case snapshotNotAliveType:
+		var notAlive snapshotNotAlive
+		if err := decodeSnapshotRecord(body, &notAlive); err != nil {
+			return err
+		}
+		delete(s.aliveNodes, notAlive.Name)
+		delete(s.peerCoords, notAlive.Name)
 
-		} else if strings.HasPrefix(line, "query-clock: ") {
-			timeStr := strings.TrimPrefix(line, "query-clock: ")
-			timeInt, err := strconv.ParseUint(timeStr, 10, 64)
-			if err != nil {
-				s.logger.Printf("[WARN] serf: Failed to convert query clock time: %v", err)
-				continue
-			}
-			s.lastQueryClock = LamportTime(timeInt)
-
-		} else if strings.HasPrefix(line, "coordinate: ") {
-			continue // Ignores any coordinate persistence from old snapshots, serf should re-converge
-		} else if line == "leave" {
-			// Ignore a leave if we plan on re-joining
-			if s.rejoinAfterLeave {
-				s.logger.Printf("[INFO] serf: Ignoring previous leave in snapshot")
-				continue
-			}
-			s.aliveNodes = make(map[string]string)
-			s.lastClock = 0
-			s.lastEventClock = 0
-			s.lastQueryClock = 0
+	case snapshotClockType, snapshotEventClockType, snapshotQueryClockType:
+		var clock snapshotClock
+		if err := decodeSnapshotRecord(body, &clock); err != nil {
+			return err
+		}
+		switch t {
+		case snapshotClockType:
+			s.lastClock = clock.LTime
+		case snapshotEventClockType:
+			s.lastEventClock = clock.LTime
+		case snapshotQueryClockType:
+			s.lastQueryClock = clock.LTime
+		}
 
-		} else if strings.HasPrefix(line, "#") {
-			// Skip comment lines
+	case snapshotLeaveType:
+		s.replayLeave()
 
+	case snapshotCoordinateType, snapshotPeerCoordinateType:
+		var rec snapshotCoordinateRecord
+		if err := decodeSnapshotRecord(body, &rec); err != nil {
+			return err
+		}
+		if rec.Coord == nil {
+			return fmt.Errorf("missing coordinate")
+		}
+		c := &snapshotCoordinate{rec.Coord, time.Unix(rec.Time, 0)}
+		if t == snapshotCoordinateType {
+			s.localCoord = c
 		} else {
-			s.logger.Printf("[WARN] serf: Unrecognized snapshot line: %v", line)
+			s.peerCoords[rec.Name] = c
 		}
-	}
 
-	// Seek to the end
-	if _, err := s.fh.Seek(0, os.SEEK_END); err != nil {
-		return err
+	default:
+		s.logger.Printf("[WARN] serf: Skipping unrecognized snapshot record type %d", uint8(t))
 	}
 	return nil
 }
+
+// replayLeave resets our internal state when a leave is replayed
+func (s *Snapshotter) replayLeave() {
+	s.leaveRecorded = true
+
+	// Ignore a leave if we plan on re-joining
+	if s.rejoinAfterLeave {
+		s.logger.Printf("[INFO] serf: Ignoring previous leave in snapshot")
+		return
+	}
+	s.aliveNodes = make(map[string]*snapshotAlive)
+	s.localCoord = nil
+	s.peerCoords = make(map[string]*snapshotCoordinate)
+	s.lastClock = 0
+	s.lastEventClock = 0
+	s.lastQueryClock = 0
+}
@@ -0,0 +1,358 @@
+package serf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FileSnapshotStore is a SnapshotStore that keeps the records in a local
+// file, in the format described in snapshot_format.go. Snapshots in the
+// legacy text format are migrated when they are replayed, and a torn
+// record at the end of the file is truncated away. Corrupt records in the
+// middle of the file are replayed for the Snapshotter to skip, and are
+// dropped at the next compaction.
+type FileSnapshotStore struct {
+	path     string
+	nodeName string
+	logger   *log.Logger
+	readOnly bool
+
+	fh       *os.File
+	buffered *bufio.Writer
+
+	// header, offset and size describe the file as it was replayed.
+	// header is nil for a file in the legacy text format, and any bytes
+	// between offset and size belong to a torn record.
+	header *snapshotHeader
+	offset int64
+	size   int64
+
+	// corrupt is the number of records in the middle of the file that
+	// failed their checksum
+	corrupt int
+}
+
+// NewFileSnapshotStore opens or creates the snapshot file at the given
+// path. The nodeName is recorded in the file's header.
+func NewFileSnapshotStore(path string, nodeName string, logger *log.Logger) (*FileSnapshotStore, error) {
+	return openFileSnapshotStore(path, nodeName, logger, false)
+}
+
+// openFileSnapshotStore opens the snapshot file. A read-only store never
+// modifies the file, so it can't be used by a Snapshotter.
+func openFileSnapshotStore(path string, nodeName string, logger *log.Logger,
+	readOnly bool) (*FileSnapshotStore, error) {
+	flag := os.O_RDWR | os.O_APPEND | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	fh, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+
+	f := &FileSnapshotStore{
+		path:     path,
+		nodeName: nodeName,
+		logger:   logger,
+		readOnly: readOnly,
+		fh:       fh,
+		buffered: bufio.NewWriter(fh),
+	}
+	return f, nil
+}
+
+// Replay is used to meet the SnapshotStore interface
+func (f *FileSnapshotStore) Replay(fn func(record []byte) error) error {
+	info, err := f.fh.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat snapshot: %v", err)
+	}
+	f.size = info.Size()
+
+	// Seek to the beginning
+	if _, err := f.fh.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+
+	// Read the header
+	reader := bufio.NewReader(f.fh)
+	header, offset, err := readSnapshotHeader(reader)
+	if err == errSnapshotLegacy {
+		return f.replayLegacy(reader, fn)
+	} else if err != nil {
+		return err
+	}
+	if header.Version > SnapshotVersion {
+		return fmt.Errorf("snapshot version %d is newer than the supported version %d",
+			header.Version, SnapshotVersion)
+	}
+	if f.nodeName == "" {
+		f.nodeName = header.NodeName
+	} else if header.NodeName != f.nodeName {
+		f.logger.Printf("[WARN] serf: Snapshot was written by node '%s', not '%s'",
+			header.NodeName, f.nodeName)
+	}
+	f.header = header
+
+	// Read each record until the end or a torn record. A corrupt record
+	// in the middle of the file is passed on for the caller to skip, while
+	// one at the end is taken to be torn.
+	for {
+		record, err := readSnapshotRecord(reader)
+		if err == errSnapshotCorrupt {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				break
+			}
+			f.corrupt++
+		} else if err == io.EOF || err == errSnapshotTorn {
+			break
+		} else if err != nil {
+			return err
+		}
+		offset += int64(len(record))
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	f.offset = offset
+
+	if f.offset < f.size && !f.readOnly {
+		f.logger.Printf("[WARN] serf: Truncating torn snapshot tail at offset %d", f.offset)
+		if err := f.fh.Truncate(f.offset); err != nil {
+			return fmt.Errorf("failed to truncate snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// replayLegacy converts each line of a snapshot in the legacy text format
+// to a record, and then rewrites the file in the current format. An empty
+// file just gets a header.
+func (f *FileSnapshotStore) replayLegacy(reader *bufio.Reader, fn func(record []byte) error) error {
+	f.offset = f.size
+	if f.size == 0 {
+		if f.readOnly {
+			return nil
+		}
+		header := encodeSnapshotHeader(f.nodeName)
+		if _, err := f.fh.Write(header); err != nil {
+			return fmt.Errorf("failed to write snapshot header: %v", err)
+		}
+		f.header = &snapshotHeader{SnapshotVersion, f.nodeName}
+		f.offset = int64(len(header))
+		f.size = f.offset
+		return nil
+	}
+
+	records := readLegacySnapshot(reader, f.logger)
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if f.readOnly {
+		return nil
+	}
+
+	// Rewrite the snapshot in the current format
+	f.logger.Printf("[INFO] serf: Migrating snapshot to format version %d", SnapshotVersion)
+	return f.Compact(records)
+}
+
+// Append is used to meet the SnapshotStore interface
+func (f *FileSnapshotStore) Append(record []byte) error {
+	_, err := f.buffered.Write(record)
+	return err
+}
+
+// Flush is used to meet the SnapshotStore interface
+func (f *FileSnapshotStore) Flush() error {
+	return f.buffered.Flush()
+}
+
+// Sync is used to meet the SnapshotStore interface
+func (f *FileSnapshotStore) Sync() error {
+	return f.fh.Sync()
+}
+
+// Compact is used to meet the SnapshotStore interface. The records are
+// written to a new file that then replaces the snapshot.
+func (f *FileSnapshotStore) Compact(records [][]byte) error {
+	if f.readOnly {
+		return fmt.Errorf("snapshot was opened read-only")
+	}
+
+	// Try to open the file to new fiel
+	newPath := f.path + tmpExt
+	fh, err := os.OpenFile(newPath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open new snapshot: %v", err)
+	}
+
+	// Create a buffered writer
+	buf := bufio.NewWriter(fh)
+
+	// Write out the header and records
+	if _, err := buf.Write(encodeSnapshotHeader(f.nodeName)); err != nil {
+		fh.Close()
+		return err
+	}
+	for _, record := range records {
+		if _, err := buf.Write(record); err != nil {
+			fh.Close()
+			return err
+		}
+	}
+
+	// Flush the new snapshot
+	err = buf.Flush()
+
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to flush new snapshot: %v", err)
+	}
+
+	err = fh.Sync()
+
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to fsync new snapshot: %v", err)
+	}
+
+	fh.Close()
+
+	// We now need to swap the old snapshot file with the new snapshot.
+	// Turns out, Windows won't let us rename the files if we have
+	// open handles to them or if the destination already exists. This
+	// means we are forced to close the existing handles, delete the
+	// old file, move the new one in place, and then re-open the file
+	// handles.
+
+	// Flush the existing snapshot, ignoring errors since we will
+	// delete it momentarily.
+	f.buffered.Flush()
+	f.buffered = nil
+
+	// Close the file handle to the old snapshot
+	f.fh.Close()
+	f.fh = nil
+
+	// Delete the old file
+	if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("failed to remove old snapshot: %v", err)
+	}
+
+	// Move the new file into place
+	if err := os.Rename(newPath, f.path); err != nil {
+		return fmt.Errorf("failed to install new snapshot: %v", err)
+	}
+
+	// Open the new snapshot
+	fh, err = os.OpenFile(f.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	buf = bufio.NewWriter(fh)
+
+	// Rotate our handles
+	f.fh = fh
+	f.buffered = buf
+	return nil
+}
+
+// Close is used to meet the SnapshotStore interface
+func (f *FileSnapshotStore) Close() error {
+	if f.fh == nil {
+		return nil
+	}
+	return f.fh.Close()
+}
+
+// readLegacySnapshot converts the lines of a snapshot in the legacy text
+// format to records
+func readLegacySnapshot(reader *bufio.Reader, logger *log.Logger) [][]byte {
+	var records [][]byte
+	add := func(t snapshotRecordType, body interface{}) {
+		record, err := encodeSnapshotRecord(t, body)
+		if err != nil {
+			logger.Printf("[WARN] serf: Failed to convert %s snapshot line: %v", t, err)
+			return
+		}
+		records = append(records, record)
+	}
+
+	// Read each line
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		// Skip the newline
+		line = line[:len(line)-1]
+
+		// Switch on the prefix
+		if strings.HasPrefix(line, "alive: ") {
+			info := strings.TrimPrefix(line, "alive: ")
+			addrIdx := strings.LastIndex(info, " ")
+			if addrIdx == -1 {
+				logger.Printf("[WARN] serf: Failed to parse address: %v", line)
+				continue
+			}
+			addr := info[addrIdx+1:]
+			name := info[:addrIdx]
+			add(snapshotAliveType, &snapshotAlive{Name: name, Addr: addr})
+
+		} else if strings.HasPrefix(line, "not-alive: ") {
+			name := strings.TrimPrefix(line, "not-alive: ")
+			add(snapshotNotAliveType, &snapshotNotAlive{Name: name})
+
+		} else if strings.HasPrefix(line, "clock: ") {
+			timeStr := strings.TrimPrefix(line, "clock: ")
+			timeInt, err := strconv.ParseUint(timeStr, 10, 64)
+			if err != nil {
+				logger.Printf("[WARN] serf: Failed to convert clock time: %v", err)
+				continue
+			}
+			add(snapshotClockType, &snapshotClock{LamportTime(timeInt)})
+
+		} else if strings.HasPrefix(line, "event-clock: ") {
+			timeStr := strings.TrimPrefix(line, "event-clock: ")
+			timeInt, err := strconv.ParseUint(timeStr, 10, 64)
+			if err != nil {
+				logger.Printf("[WARN] serf: Failed to convert event clock time: %v", err)
+				continue
+			}
+			add(snapshotEventClockType, &snapshotClock{LamportTime(timeInt)})
+
+		} else if strings.HasPrefix(line, "query-clock: ") {
+			timeStr := strings.TrimPrefix(line, "query-clock: ")
+			timeInt, err := strconv.ParseUint(timeStr, 10, 64)
+			if err != nil {
+				logger.Printf("[WARN] serf: Failed to convert query clock time: %v", err)
+				continue
+			}
+			add(snapshotQueryClockType, &snapshotClock{LamportTime(timeInt)})
+
+		} else if strings.HasPrefix(line, "coordinate: ") {
+			continue // Ignores any coordinate persistence from old snapshots, serf should re-converge
+
+		} else if line == "leave" {
+			add(snapshotLeaveType, &snapshotLeave{})
+
+		} else if strings.HasPrefix(line, "#") {
+			// Skip comment lines
+
+		} else {
+			logger.Printf("[WARN] serf: Unrecognized snapshot line: %v", line)
+		}
+	}
+	return records
+}
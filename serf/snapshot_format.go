@@ -0,0 +1,248 @@
+package serf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/serf/coordinate"
+)
+
+/*
+The snapshot file starts with a header that identifies the format version
+and the node that wrote it:
+
+  magic (8 bytes) | version (2 bytes) | name length (2 bytes) | name | crc32 (4 bytes)
+
+It is followed by a sequence of records, each framed as:
+
+  type (1 byte) | length (4 bytes) | msgpack body (length bytes) | crc32 (4 bytes)
+
+The checksums cover everything before them in the header or record. A
+record that is cut short or fails its checksum marks the end of the usable
+snapshot. This is almost always the torn tail of a write interrupted by a
+crash, so the file is truncated back to the last good record.
+
+Snapshots written by older versions of Serf use a line-oriented text
+format. Those are detected by the missing magic and are migrated to the
+current format when they are first opened.
+
+Stores other than the file store persist the same record frames, but
+without the header.
+*/
+
+const (
+	// snapshotMagic identifies a binary snapshot file
+	snapshotMagic = "serfsnap"
+
+	// SnapshotVersion is the version of the snapshot format written by
+	// this version of Serf
+	SnapshotVersion = 1
+
+	// snapshotMaxRecordSize bounds the size of a single record body, so a
+	// corrupted length can't cause a huge allocation
+	snapshotMaxRecordSize = 1024 * 1024
+)
+
+var (
+	// errSnapshotLegacy is returned when the file doesn't start with the
+	// magic and should be read with the legacy text parser
+	errSnapshotLegacy = errors.New("snapshot is in the legacy text format")
+
+	// errSnapshotTorn is returned when a record is incomplete or fails
+	// its checksum
+	errSnapshotTorn = errors.New("snapshot record is torn or corrupt")
+
+	// errSnapshotCorrupt is returned by readSnapshotRecord when a record
+	// was read in full but fails its checksum, so the records after it
+	// can still be read
+	errSnapshotCorrupt = errors.New("snapshot record is corrupt")
+)
+
+// snapshotRecordType is the type of a snapshot record
+type snapshotRecordType uint8
+
+const (
+	snapshotAliveType snapshotRecordType = iota + 1
+	snapshotNotAliveType
+	snapshotClockType
+	snapshotEventClockType
+	snapshotQueryClockType
+	snapshotLeaveType
+	snapshotCoordinateType
+	snapshotPeerCoordinateType
+)
+
+func (t snapshotRecordType) String() string {
+	switch t {
+	case snapshotAliveType:
+		return "alive"
+	case snapshotNotAliveType:
+		return "not-alive"
+	case snapshotClockType:
+		return "clock"
+	case snapshotEventClockType:
+		return "event-clock"
+	case snapshotQueryClockType:
+		return "query-clock"
+	case snapshotLeaveType:
+		return "leave"
+	case snapshotCoordinateType:
+		return "coordinate"
+	case snapshotPeerCoordinateType:
+		return "peer-coordinate"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// snapshotHeader is the decoded header of a snapshot file
+type snapshotHeader struct {
+	Version  uint16
+	NodeName string
+}
+
+// snapshotAlive records a member that is alive
+type snapshotAlive struct {
+	Name string
+	Addr string
+	Tags map[string]string
+}
+
+// snapshotNotAlive records a member that has failed or left
+type snapshotNotAlive struct {
+	Name   string
+	Status MemberStatus
+}
+
+// snapshotClock records one of the Lamport clocks
+type snapshotClock struct {
+	LTime LamportTime
+}
+
+// snapshotLeave records that this node left the cluster
+type snapshotLeave struct{}
+
+// snapshotCoordinateRecord records the local network coordinate, or the
+// cached coordinate of a peer if Name is set. Time is in Unix seconds.
+type snapshotCoordinateRecord struct {
+	Name  string
+	Time  int64
+	Coord *coordinate.Coordinate
+}
+
+// encodeSnapshotHeader returns the encoded header for a snapshot file
+func encodeSnapshotHeader(nodeName string) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(snapshotMagic)
+	binary.Write(buf, binary.BigEndian, uint16(SnapshotVersion))
+	binary.Write(buf, binary.BigEndian, uint16(len(nodeName)))
+	buf.WriteString(nodeName)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// readSnapshotHeader reads and verifies the header, returning its size.
+// errSnapshotLegacy is returned if the magic is missing.
+func readSnapshotHeader(r *bufio.Reader) (*snapshotHeader, int64, error) {
+	magic, err := r.Peek(len(snapshotMagic))
+	if err != nil || string(magic) != snapshotMagic {
+		return nil, 0, errSnapshotLegacy
+	}
+
+	fixed := make([]byte, len(snapshotMagic)+4)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, 0, fmt.Errorf("failed to read snapshot header: %v", err)
+	}
+	version := binary.BigEndian.Uint16(fixed[len(snapshotMagic):])
+	nameLen := binary.BigEndian.Uint16(fixed[len(snapshotMagic)+2:])
+
+	rest := make([]byte, int(nameLen)+4)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, 0, fmt.Errorf("failed to read snapshot header: %v", err)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(fixed)
+	crc.Write(rest[:nameLen])
+	if crc.Sum32() != binary.BigEndian.Uint32(rest[nameLen:]) {
+		return nil, 0, fmt.Errorf("snapshot header checksum mismatch")
+	}
+
+	header := &snapshotHeader{
+		Version:  version,
+		NodeName: string(rest[:nameLen]),
+	}
+	return header, int64(len(fixed) + len(rest)), nil
+}
+
+// encodeSnapshotRecord returns a framed and checksummed record
+func encodeSnapshotRecord(t snapshotRecordType, body interface{}) ([]byte, error) {
+	var encoded []byte
+	handle := codec.MsgpackHandle{}
+	if err := codec.NewEncoderBytes(&encoded, &handle).Encode(body); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(encoded)+9))
+	buf.WriteByte(uint8(t))
+	binary.Write(buf, binary.BigEndian, uint32(len(encoded)))
+	buf.Write(encoded)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// readSnapshotRecord reads and verifies the next record frame. io.EOF is
+// returned at a clean end of the file and errSnapshotTorn if the record
+// is incomplete. errSnapshotCorrupt is returned along with the frame if
+// it was read in full but fails its checksum.
+func readSnapshotRecord(r *bufio.Reader) ([]byte, error) {
+	prefix := make([]byte, 5)
+	n, err := io.ReadFull(r, prefix)
+	if err == io.EOF && n == 0 {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errSnapshotTorn
+	}
+
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > snapshotMaxRecordSize {
+		return nil, errSnapshotTorn
+	}
+	frame := make([]byte, len(prefix)+int(size)+4)
+	copy(frame, prefix)
+	if _, err := io.ReadFull(r, frame[len(prefix):]); err != nil {
+		return nil, errSnapshotTorn
+	}
+	if _, _, err := checkSnapshotRecord(frame); err != nil {
+		return frame, errSnapshotCorrupt
+	}
+	return frame, nil
+}
+
+// checkSnapshotRecord verifies the length and checksum of a record frame,
+// returning its type and body
+func checkSnapshotRecord(frame []byte) (snapshotRecordType, []byte, error) {
+	if len(frame) < 9 {
+		return 0, nil, errSnapshotTorn
+	}
+	size := binary.BigEndian.Uint32(frame[1:5])
+	if int64(size) != int64(len(frame)-9) {
+		return 0, nil, errSnapshotTorn
+	}
+	end := len(frame) - 4
+	if crc32.ChecksumIEEE(frame[:end]) != binary.BigEndian.Uint32(frame[end:]) {
+		return 0, nil, errSnapshotTorn
+	}
+	return snapshotRecordType(frame[0]), frame[5:end], nil
+}
+
+// decodeSnapshotRecord decodes the body of a record
+func decodeSnapshotRecord(body []byte, out interface{}) error {
+	handle := codec.MsgpackHandle{}
+	return codec.NewDecoderBytes(body, &handle).Decode(out)
+}
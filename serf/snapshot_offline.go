@@ -0,0 +1,124 @@
+package serf
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+)
+
+// SnapshotInfo describes the state recovered from a snapshot file, as an
+// agent would see it on start.
+type SnapshotInfo struct {
+	// Version is the format version of the file, or zero if it is in the
+	// legacy text format. NodeName is the node that wrote it, if known.
+	Version  uint16
+	NodeName string
+
+	// AliveNodes are the last known alive nodes, sorted by name
+	AliveNodes []*PreviousNode
+
+	LastClock      LamportTime
+	LastEventClock LamportTime
+	LastQueryClock LamportTime
+
+	// LeaveRecorded is true if the node left the cluster at some point
+	LeaveRecorded bool
+
+	// Size is the size of the file, of which TornBytes belong to a record
+	// left incomplete by a crash. CorruptRecords is the number of records
+	// before the end that failed their checksum and were skipped.
+	// CompactedSize is the size the file would have after compaction, and
+	// CompactThreshold is the size at which an agent compacts it.
+	Size             int64
+	TornBytes        int64
+	CorruptRecords   int
+	CompactedSize    int64
+	CompactThreshold int64
+}
+
+// openSnapshot opens a snapshot file for offline use and replays it,
+// without starting the goroutines that record new events.
+func openSnapshot(path string, readOnly bool, rejoinAfterLeave bool,
+	logger *log.Logger) (*Snapshotter, *FileSnapshotStore, error) {
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	// Don't create a snapshot that doesn't exist
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, err
+	}
+	store, err := openFileSnapshotStore(path, "", logger, readOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := newSnapshotter(store, snapshotSizeLimit, rejoinAfterLeave, logger)
+	if err := s.replay(); err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	return s, store, nil
+}
+
+// InspectSnapshot replays the snapshot at the given path without
+// modifying it. The rejoinAfterLeave flag should match the agent's, since
+// it determines whether a recorded leave clears the state.
+func InspectSnapshot(path string, rejoinAfterLeave bool, logger *log.Logger) (*SnapshotInfo, error) {
+	s, store, err := openSnapshot(path, true, rejoinAfterLeave, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	info := &SnapshotInfo{
+		NodeName:       store.nodeName,
+		LastClock:      s.lastClock,
+		LastEventClock: s.lastEventClock,
+		LastQueryClock: s.lastQueryClock,
+		LeaveRecorded:  s.leaveRecorded,
+		Size:           store.size,
+		TornBytes:      store.size - store.offset,
+		CorruptRecords: store.corrupt,
+	}
+	if store.header != nil {
+		info.Version = store.header.Version
+	}
+	for name, alive := range s.aliveNodes {
+		info.AliveNodes = append(info.AliveNodes, &PreviousNode{name, alive.Addr})
+	}
+	sort.Slice(info.AliveNodes, func(i, j int) bool {
+		return info.AliveNodes[i].Name < info.AliveNodes[j].Name
+	})
+
+	records, err := s.stateRecords()
+	if err != nil {
+		return nil, err
+	}
+	info.CompactedSize = int64(len(encodeSnapshotHeader(store.nodeName)))
+	for _, record := range records {
+		info.CompactedSize += int64(len(record))
+	}
+	info.CompactThreshold = s.snapshotMaxSize()
+	return info, nil
+}
+
+// CompactSnapshot rewrites the snapshot at the given path so it only
+// contains the state an agent with the same rejoinAfterLeave flag would
+// recover from it. The agent using the snapshot must not be running.
+func CompactSnapshot(path string, rejoinAfterLeave bool, logger *log.Logger) error {
+	s, store, err := openSnapshot(path, false, rejoinAfterLeave, logger)
+	if err != nil {
+		return err
+	}
+	err = s.compact()
+	store.Close()
+	return err
+}
+
+// ClearSnapshotLeave rewrites the snapshot at the given path without any
+// recorded leave, so the agent rejoins the previously known nodes on its
+// next start. The agent using the snapshot must not be running.
+func ClearSnapshotLeave(path string, logger *log.Logger) error {
+	return CompactSnapshot(path, true, logger)
+}
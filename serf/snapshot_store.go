@@ -0,0 +1,114 @@
+package serf
+
+import (
+	"sync"
+)
+
+// SnapshotStore is the storage used by the Snapshotter to persist its
+// records. Records are opaque, self-checksummed byte slices that must be
+// returned by Replay exactly as they were appended. FileSnapshotStore is
+// used when Config.SnapshotPath is set, and embedders can provide their
+// own store with Config.SnapshotStore.
+//
+// A store is only used by one Snapshotter at a time, which never calls
+// it concurrently.
+type SnapshotStore interface {
+	// Replay calls fn with each stored record, in the order they were
+	// appended. It is called once, before any other method. Replay
+	// stops and returns the error if fn returns one.
+	Replay(fn func(record []byte) error) error
+
+	// Append adds a record after the existing ones. It may be buffered
+	// until the next call to Flush.
+	Append(record []byte) error
+
+	// Flush writes out any buffered records. It is called periodically
+	// and doesn't need to make them durable.
+	Flush() error
+
+	// Sync makes the records written so far durable. It is called after
+	// a leave is recorded and before shutting down.
+	Sync() error
+
+	// Compact atomically replaces all stored records with the given
+	// ones, which capture the same state.
+	Compact(records [][]byte) error
+
+	// Close releases the store once the Snapshotter has shut down.
+	Close() error
+}
+
+// InmemSnapshotStore is a SnapshotStore that keeps the records in memory.
+// Close doesn't discard the records, so the store can be handed to a new
+// Snapshotter to simulate a restart, which is useful for tests.
+type InmemSnapshotStore struct {
+	records [][]byte
+	l       sync.Mutex
+}
+
+// NewInmemSnapshotStore returns an empty in-memory store
+func NewInmemSnapshotStore() *InmemSnapshotStore {
+	return &InmemSnapshotStore{}
+}
+
+// Replay is used to meet the SnapshotStore interface
+func (m *InmemSnapshotStore) Replay(fn func(record []byte) error) error {
+	for _, record := range m.Records() {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append is used to meet the SnapshotStore interface
+func (m *InmemSnapshotStore) Append(record []byte) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.records = append(m.records, copyRecord(record))
+	return nil
+}
+
+// Flush is used to meet the SnapshotStore interface
+func (m *InmemSnapshotStore) Flush() error {
+	return nil
+}
+
+// Sync is used to meet the SnapshotStore interface
+func (m *InmemSnapshotStore) Sync() error {
+	return nil
+}
+
+// Compact is used to meet the SnapshotStore interface
+func (m *InmemSnapshotStore) Compact(records [][]byte) error {
+	compacted := make([][]byte, 0, len(records))
+	for _, record := range records {
+		compacted = append(compacted, copyRecord(record))
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.records = compacted
+	return nil
+}
+
+// Close is used to meet the SnapshotStore interface
+func (m *InmemSnapshotStore) Close() error {
+	return nil
+}
+
+// Records returns a copy of the stored records
+func (m *InmemSnapshotStore) Records() [][]byte {
+	m.l.Lock()
+	defer m.l.Unlock()
+	records := make([][]byte, len(m.records))
+	copy(records, m.records)
+	return records
+}
+
+// copyRecord copies a record so the caller may reuse its buffer
+func copyRecord(record []byte) []byte {
+	c := make([]byte, len(record))
+	copy(c, record)
+	return c
+}
@@ -2,12 +2,17 @@ package serf
 
 import (
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/serf/coordinate"
 )
 
 func TestSnapshotter(t *testing.T) {
@@ -21,7 +26,7 @@ func TestSnapshotter(t *testing.T) {
 	outCh := make(chan Event, 64)
 	stopCh := make(chan struct{})
 	logger := log.New(os.Stderr, "", log.LstdFlags)
-	inCh, snap, err := NewSnapshotter(td+"snap", snapshotSizeLimit, false,
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
 		logger, clock, outCh, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -119,7 +124,7 @@ func TestSnapshotter(t *testing.T) {
 
 	// Open the snapshoter
 	stopCh = make(chan struct{})
-	_, snap, err = NewSnapshotter(td+"snap", snapshotSizeLimit, false,
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
 		logger, clock, outCh, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -154,7 +159,7 @@ func TestSnapshotter(t *testing.T) {
 	// Open the snapshotter, make sure nothing dies reading with coordinates
 	// disabled.
 	stopCh = make(chan struct{})
-	_, snap, err = NewSnapshotter(td+"snap", snapshotSizeLimit, false,
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
 		logger, clock, outCh, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -164,6 +169,189 @@ func TestSnapshotter(t *testing.T) {
 	snap.Wait()
 }
 
+func TestSnapshotter_coordinates(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	clock := new(LamportClock)
+	stopCh := make(chan struct{})
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, clock, nil, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	local := coordinate.NewCoordinate(coordinate.DefaultConfig())
+	local.Vec[0] = 0.25
+	peer := coordinate.NewCoordinate(coordinate.DefaultConfig())
+	peer.Vec[0] = 0.5
+	snap.SetCoordinateSource(func() (*coordinate.Coordinate, map[string]*coordinate.Coordinate) {
+		return local, map[string]*coordinate.Coordinate{
+			"foo": peer,
+			"bar": peer, // Not alive, should not be recorded
+		}
+	})
+
+	inCh <- MemberEvent{
+		Type:    EventMemberJoin,
+		Members: []Member{{Name: "foo", Addr: []byte{127, 0, 0, 1}, Port: 5000}},
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The coordinates are recorded on shutdown
+	close(stopCh)
+	snap.Wait()
+
+	stopCh = make(chan struct{})
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, clock, nil, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	restoredLocal, restoredPeers := snap.RecentCoordinates(time.Hour)
+	if !reflect.DeepEqual(restoredLocal, local) {
+		t.Fatalf("bad: %#v", restoredLocal)
+	}
+	if len(restoredPeers) != 1 || !reflect.DeepEqual(restoredPeers["foo"], peer) {
+		t.Fatalf("bad: %#v", restoredPeers)
+	}
+
+	close(stopCh)
+	snap.Wait()
+}
+
+func TestSnapshotter_coordinatesStale(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	coord := func(x float64) *coordinate.Coordinate {
+		c := coordinate.NewCoordinate(coordinate.DefaultConfig())
+		c.Vec[0] = x
+		return c
+	}
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	recent := time.Now().Unix()
+	type record struct {
+		t    snapshotRecordType
+		body interface{}
+	}
+	records := []record{
+		{snapshotAliveType, &snapshotAlive{Name: "foo", Addr: "127.0.0.1:5000"}},
+		{snapshotAliveType, &snapshotAlive{Name: "bar", Addr: "127.0.0.1:5001"}},
+		{snapshotCoordinateType, &snapshotCoordinateRecord{Time: old, Coord: coord(1)}},
+		{snapshotPeerCoordinateType, &snapshotCoordinateRecord{Name: "foo", Time: recent, Coord: coord(2)}},
+		{snapshotPeerCoordinateType, &snapshotCoordinateRecord{Name: "bar", Time: old, Coord: coord(3)}},
+	}
+	buf := encodeSnapshotHeader("node")
+	for _, r := range records {
+		rec, err := encodeSnapshotRecord(r.t, r.body)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf = append(buf, rec...)
+	}
+	if err := ioutil.WriteFile(td+"snap", buf, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	_, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), nil, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+
+	local, peers := snap.RecentCoordinates(time.Hour)
+	if local != nil {
+		t.Fatalf("bad: %#v", local)
+	}
+	if len(peers) != 1 || peers["foo"] == nil || peers["foo"].Vec[0] != 2 {
+		t.Fatalf("bad: %#v", peers)
+	}
+
+	// Without a cutoff everything should come back
+	local, peers = snap.RecentCoordinates(0)
+	if local == nil || local.Vec[0] != 1 {
+		t.Fatalf("bad: %#v", local)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("bad: %#v", peers)
+	}
+}
+
+func TestSnapshotter_coordinatesLeave(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	stopCh := make(chan struct{})
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), nil, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	local := coordinate.NewCoordinate(coordinate.DefaultConfig())
+	snap.SetCoordinateSource(func() (*coordinate.Coordinate, map[string]*coordinate.Coordinate) {
+		return local, nil
+	})
+	inCh <- MemberEvent{
+		Type:    EventMemberJoin,
+		Members: []Member{{Name: "foo", Addr: []byte{127, 0, 0, 1}, Port: 5000}},
+	}
+	for len(inCh) > 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Shut down once so the local coordinate is recorded, then reopen
+	// and leave
+	close(stopCh)
+	snap.Wait()
+	stopCh = make(chan struct{})
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), nil, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if local, _ := snap.RecentCoordinates(0); local == nil {
+		t.Fatalf("expected a restored local coordinate")
+	}
+	snap.Leave()
+	close(stopCh)
+	snap.Wait()
+
+	// The replayed leave should discard the old local coordinate
+	stopCh = make(chan struct{})
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), nil, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+	if local, peers := snap.RecentCoordinates(0); local != nil || len(peers) != 0 {
+		t.Fatalf("bad: %#v %#v", local, peers)
+	}
+}
+
 func TestSnapshotter_forceCompact(t *testing.T) {
 	td, err := ioutil.TempDir("", "serf")
 	if err != nil {
@@ -176,7 +364,7 @@ func TestSnapshotter_forceCompact(t *testing.T) {
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 
 	// Create a very low limit
-	inCh, snap, err := NewSnapshotter(td+"snap", 1024, false,
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", 1024, false,
 		logger, clock, nil, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -209,7 +397,7 @@ func TestSnapshotter_forceCompact(t *testing.T) {
 
 	// Open the snapshoter
 	stopCh = make(chan struct{})
-	_, snap, err = NewSnapshotter(td+"snap", snapshotSizeLimit, false,
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
 		logger, clock, nil, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -226,6 +414,15 @@ func TestSnapshotter_forceCompact(t *testing.T) {
 
 	close(stopCh)
 	snap.Wait()
+
+	// Compaction must not make the snapshot executable
+	fi, err := os.Stat(td + "snap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0111 != 0 {
+		t.Fatalf("bad mode: %v", fi.Mode())
+	}
 }
 
 func TestSnapshotter_leave(t *testing.T) {
@@ -238,7 +435,7 @@ func TestSnapshotter_leave(t *testing.T) {
 	clock := new(LamportClock)
 	stopCh := make(chan struct{})
 	logger := log.New(os.Stderr, "", log.LstdFlags)
-	inCh, snap, err := NewSnapshotter(td+"snap", snapshotSizeLimit, false,
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
 		logger, clock, nil, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -286,7 +483,7 @@ func TestSnapshotter_leave(t *testing.T) {
 
 	// Open the snapshoter
 	stopCh = make(chan struct{})
-	_, snap, err = NewSnapshotter(td+"snap", snapshotSizeLimit, false,
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
 		logger, clock, nil, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -319,7 +516,7 @@ func TestSnapshotter_leave_rejoin(t *testing.T) {
 	clock := new(LamportClock)
 	stopCh := make(chan struct{})
 	logger := log.New(os.Stderr, "", log.LstdFlags)
-	inCh, snap, err := NewSnapshotter(td+"snap", snapshotSizeLimit, true,
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, true,
 		logger, clock, nil, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -367,7 +564,7 @@ func TestSnapshotter_leave_rejoin(t *testing.T) {
 
 	// Open the snapshoter
 	stopCh = make(chan struct{})
-	_, snap, err = NewSnapshotter(td+"snap", snapshotSizeLimit, true,
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, true,
 		logger, clock, nil, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -404,7 +601,7 @@ func TestSnapshotter_slowDiskNotBlockingEventCh(t *testing.T) {
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 
 	outCh := make(chan Event, 1024)
-	inCh, snap, err := NewSnapshotter(td+"snap", snapshotSizeLimit, true,
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, true,
 		logger, clock, outCh, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -492,7 +689,7 @@ func TestSnapshotter_blockedUpstreamNotBlockingMemberlist(t *testing.T) {
 	// OutCh is unbuffered simulating a slow upstream
 	outCh := make(chan Event)
 
-	inCh, snap, err := NewSnapshotter(td+"snap", snapshotSizeLimit, true,
+	inCh, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, true,
 		logger, clock, outCh, stopCh)
 	if err != nil {
 		t.Fatalf("err: %v", err)
@@ -532,3 +729,439 @@ func TestSnapshotter_blockedUpstreamNotBlockingMemberlist(t *testing.T) {
 	close(stopCh)
 	snap.Wait()
 }
+
+func TestSnapshotter_legacyMigration(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	lines := []string{
+		"alive: foo 127.0.0.1:5000",
+		"alive: bar 127.0.0.2:5000",
+		"not-alive: bar",
+		"clock: 10",
+		"event-clock: 20",
+		"query-clock: 30",
+		`coordinate: {"Vec":[0,0,0,0,0,0,0,0],"Error":1.5,"Adjustment":0,"Height":0.00001}`,
+	}
+	if err := ioutil.WriteFile(td+"snap", []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	check := func(snap *Snapshotter) {
+		prev := snap.AliveNodes()
+		if len(prev) != 1 || prev[0].Name != "foo" || prev[0].Addr != "127.0.0.1:5000" {
+			t.Fatalf("bad: %#v", prev)
+		}
+		if snap.LastClock() != 10 || snap.LastEventClock() != 20 || snap.LastQueryClock() != 30 {
+			t.Fatalf("bad clocks: %d %d %d",
+				snap.LastClock(), snap.LastEventClock(), snap.LastQueryClock())
+		}
+		if local, _ := snap.RecentCoordinates(0); local != nil {
+			t.Fatalf("legacy coordinate should be ignored: %#v", local)
+		}
+	}
+
+	clock := new(LamportClock)
+	clock.Witness(10)
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	stopCh := make(chan struct{})
+	_, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, clock, make(chan Event, 64), stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	check(snap)
+	close(stopCh)
+	snap.Wait()
+
+	// The file should have been rewritten in the current format
+	buf, err := ioutil.ReadFile(td + "snap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.HasPrefix(string(buf), snapshotMagic) {
+		t.Fatalf("snapshot was not migrated: %q", buf)
+	}
+
+	stopCh = make(chan struct{})
+	_, snap, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, clock, make(chan Event, 64), stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+	check(snap)
+}
+
+func TestSnapshotter_tornTail(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	buf := encodeSnapshotHeader("node")
+	for _, name := range []string{"foo", "bar"} {
+		rec, err := encodeSnapshotRecord(snapshotAliveType,
+			&snapshotAlive{Name: name, Addr: "127.0.0.1:5000"})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf = append(buf, rec...)
+	}
+	good := len(buf)
+
+	// Append a record that was cut short by a crash
+	rec, err := encodeSnapshotRecord(snapshotClockType, &snapshotClock{100})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf = append(buf, rec[:len(rec)-2]...)
+	if err := ioutil.WriteFile(td+"snap", buf, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	stopCh := make(chan struct{})
+	_, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), make(chan Event, 64), stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+
+	if prev := snap.AliveNodes(); len(prev) != 2 {
+		t.Fatalf("bad: %#v", prev)
+	}
+	if snap.LastClock() != 0 {
+		t.Fatalf("bad: %d", snap.LastClock())
+	}
+	fi, err := os.Stat(td + "snap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fi.Size() != int64(good) {
+		t.Fatalf("bad size: %d, expected %d", fi.Size(), good)
+	}
+}
+
+func TestSnapshotter_corruptRecord(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	buf := encodeSnapshotHeader("node")
+	for _, name := range []string{"foo", "bar"} {
+		rec, err := encodeSnapshotRecord(snapshotAliveType,
+			&snapshotAlive{Name: name, Addr: "127.0.0.1:5000"})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf = append(buf, rec...)
+	}
+
+	// Flip a bit in the last record so its checksum fails
+	buf[len(buf)-6] ^= 0x01
+	if err := ioutil.WriteFile(td+"snap", buf, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	stopCh := make(chan struct{})
+	_, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), make(chan Event, 64), stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+
+	prev := snap.AliveNodes()
+	if len(prev) != 1 || prev[0].Name != "foo" {
+		t.Fatalf("bad: %#v", prev)
+	}
+}
+
+func TestSnapshotter_corruptRecordMidFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	buf := encodeSnapshotHeader("node")
+	var corrupt int
+	for _, name := range []string{"foo", "bar", "baz"} {
+		rec, err := encodeSnapshotRecord(snapshotAliveType,
+			&snapshotAlive{Name: name, Addr: "127.0.0.1:5000"})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		buf = append(buf, rec...)
+		if name == "bar" {
+			corrupt = len(buf) - 6
+		}
+	}
+	size := len(buf)
+
+	// Flip a bit in the middle record so its checksum fails
+	buf[corrupt] ^= 0x01
+	if err := ioutil.WriteFile(td+"snap", buf, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	stopCh := make(chan struct{})
+	_, snap, err := NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), make(chan Event, 64), stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+
+	// The records after the corrupt one are kept
+	prev := snap.AliveNodes()
+	if len(prev) != 2 {
+		t.Fatalf("bad: %#v", prev)
+	}
+	for _, n := range prev {
+		if n.Name == "bar" {
+			t.Fatalf("bad: %#v", prev)
+		}
+	}
+	fi, err := os.Stat(td + "snap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fi.Size() != int64(size) {
+		t.Fatalf("bad size: %d, expected %d", fi.Size(), size)
+	}
+
+	info, err := InspectSnapshot(td+"snap", false, logger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.CorruptRecords != 1 || info.TornBytes != 0 {
+		t.Fatalf("bad: %#v", info)
+	}
+}
+
+func TestSnapshotter_newerVersion(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	// Bump the version, keeping the header checksum valid
+	header := []byte(snapshotMagic)
+	header = append(header, 0, SnapshotVersion+1, 0, 4)
+	header = append(header, "node"...)
+	sum := crc32.ChecksumIEEE(header)
+	header = append(header, byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+	if err := ioutil.WriteFile(td+"snap", header, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	_, _, err = NewSnapshotter(td+"snap", "node", snapshotSizeLimit, false,
+		logger, new(LamportClock), make(chan Event, 64), make(chan struct{}))
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected version error, got: %v", err)
+	}
+}
+
+func TestInspectSnapshot(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	lines := []string{
+		"alive: foo 127.0.0.1:5000",
+		"alive: bar 127.0.0.2:5000",
+		"clock: 10",
+		"event-clock: 20",
+		"query-clock: 30",
+		"leave",
+		"alive: baz 127.0.0.3:5000",
+	}
+	contents := []byte(strings.Join(lines, "\n") + "\n")
+	if err := ioutil.WriteFile(td+"snap", contents, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	info, err := InspectSnapshot(td+"snap", false, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.Version != 0 || !info.LeaveRecorded {
+		t.Fatalf("bad: %#v", info)
+	}
+	if len(info.AliveNodes) != 1 || info.AliveNodes[0].Name != "baz" {
+		t.Fatalf("bad: %#v", info.AliveNodes)
+	}
+	if info.LastClock != 0 || info.Size != int64(len(contents)) || info.CompactedSize == 0 {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	// With rejoin the leave is ignored
+	info, err = InspectSnapshot(td+"snap", true, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(info.AliveNodes) != 3 || info.AliveNodes[0].Name != "bar" {
+		t.Fatalf("bad: %#v", info.AliveNodes)
+	}
+	if info.LastClock != 10 || info.LastEventClock != 20 || info.LastQueryClock != 30 {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	// Inspecting never modifies the file
+	buf, err := ioutil.ReadFile(td + "snap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(buf, contents) {
+		t.Fatalf("snapshot was modified: %q", buf)
+	}
+}
+
+func TestClearSnapshotLeave(t *testing.T) {
+	td, err := ioutil.TempDir("", "serf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	lines := []string{
+		"alive: foo 127.0.0.1:5000",
+		"clock: 10",
+		"leave",
+	}
+	if err := ioutil.WriteFile(td+"snap", []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ClearSnapshotLeave(td+"snap", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	info, err := InspectSnapshot(td+"snap", false, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info.Version != SnapshotVersion || info.LeaveRecorded {
+		t.Fatalf("bad: %#v", info)
+	}
+	if len(info.AliveNodes) != 1 || info.AliveNodes[0].Name != "foo" || info.LastClock != 10 {
+		t.Fatalf("bad: %#v", info)
+	}
+	if info.Size != info.CompactedSize {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	// Compacting without rejoin applies a recorded leave
+	if err := ioutil.WriteFile(td+"snap", []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := CompactSnapshot(td+"snap", false, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	info, err = InspectSnapshot(td+"snap", true, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(info.AliveNodes) != 0 || info.LastClock != 0 {
+		t.Fatalf("bad: %#v", info)
+	}
+}
+
+func TestSnapshotter_inmemStore(t *testing.T) {
+	store := NewInmemSnapshotStore()
+	clock := new(LamportClock)
+	outCh := make(chan Event, 64)
+	stopCh := make(chan struct{})
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	inCh, snap, err := NewSnapshotterWithStore(store, snapshotSizeLimit, false,
+		logger, clock, outCh, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	clock.Witness(100)
+	inCh <- UserEvent{LTime: 42, Name: "bar"}
+	inCh <- MemberEvent{
+		Type: EventMemberJoin,
+		Members: []Member{
+			{
+				Name: "foo",
+				Addr: []byte{127, 0, 0, 1},
+				Port: 5000,
+			},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-outCh:
+		case <-time.After(200 * time.Millisecond):
+			t.Fatalf("timeout")
+		}
+	}
+
+	// Close the snapshotter
+	close(stopCh)
+	snap.Wait()
+	if len(store.Records()) == 0 {
+		t.Fatalf("no records")
+	}
+
+	// Compacting keeps the same state
+	stopCh = make(chan struct{})
+	_, snap, err = NewSnapshotterWithStore(store, snapshotSizeLimit, false,
+		logger, clock, outCh, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := snap.compact(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(stopCh)
+	snap.Wait()
+
+	// Reopen with the same store
+	stopCh = make(chan struct{})
+	_, snap, err = NewSnapshotterWithStore(store, snapshotSizeLimit, false,
+		logger, clock, outCh, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		close(stopCh)
+		snap.Wait()
+	}()
+
+	if snap.LastClock() != 100 || snap.LastEventClock() != 42 {
+		t.Fatalf("bad clocks: %d %d", snap.LastClock(), snap.LastEventClock())
+	}
+	prev := snap.AliveNodes()
+	if len(prev) != 1 || prev[0].Name != "foo" || prev[0].Addr != "127.0.0.1:5000" {
+		t.Fatalf("bad: %#v", prev)
+	}
+}